@@ -0,0 +1,97 @@
+/*
+ *  Copyright (c) 2026, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+// Package bodyformat splits request bodies into the JSON documents that the
+// policies process independently, as selected by their bodyFormat parameter.
+package bodyformat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Body formats selected by the bodyFormat parameter.
+const (
+	// JSONObject processes the body as a single JSON document.
+	JSONObject = "json-object"
+	// JSONArray processes each element of a top-level JSON array as its own
+	// document.
+	JSONArray = "json-array"
+	// NDJSON processes each line of a newline-delimited JSON body as its own
+	// document.
+	NDJSON = "ndjson"
+)
+
+// Parse extracts the optional bodyFormat parameter, defaulting to JSONObject.
+func Parse(params map[string]interface{}) (string, error) {
+	formatRaw, ok := params["bodyFormat"]
+	if !ok {
+		return JSONObject, nil
+	}
+	format, ok := formatRaw.(string)
+	if !ok {
+		return "", fmt.Errorf("'bodyFormat' must be a string")
+	}
+	switch format = strings.ToLower(strings.TrimSpace(format)); format {
+	case JSONObject, JSONArray, NDJSON:
+		return format, nil
+	default:
+		return "", fmt.Errorf("'bodyFormat' must be one of [%s,%s,%s]", JSONObject, JSONArray, NDJSON)
+	}
+}
+
+// Split splits content into the documents that are processed independently
+// for format: the whole body for json-object, each element of the top-level
+// array for json-array, and each line for ndjson. Blank ndjson lines are
+// kept, so Join restores the original line layout; use IsBlank to skip them.
+func Split(content []byte, format string) ([][]byte, error) {
+	switch format {
+	case JSONArray:
+		var elements []json.RawMessage
+		if err := json.Unmarshal(content, &elements); err != nil {
+			return nil, fmt.Errorf("body is not a JSON array: %w", err)
+		}
+		documents := make([][]byte, len(elements))
+		for i, element := range elements {
+			documents[i] = element
+		}
+		return documents, nil
+	case NDJSON:
+		return bytes.Split(content, []byte("\n")), nil
+	default:
+		return [][]byte{content}, nil
+	}
+}
+
+// Join reassembles documents produced by Split for format.
+func Join(documents [][]byte, format string) []byte {
+	switch format {
+	case JSONArray:
+		joined := append([]byte("["), bytes.Join(documents, []byte(","))...)
+		return append(joined, ']')
+	default:
+		return bytes.Join(documents, []byte("\n"))
+	}
+}
+
+// IsBlank reports whether a document holds only whitespace, such as an empty
+// ndjson line.
+func IsBlank(document []byte) bool {
+	return len(bytes.TrimSpace(document)) == 0
+}
@@ -0,0 +1,106 @@
+package bodyformat
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  map[string]interface{}
+		want    string
+		wantErr string
+	}{
+		{name: "default", params: map[string]interface{}{}, want: JSONObject},
+		{name: "json array", params: map[string]interface{}{"bodyFormat": "json-array"}, want: JSONArray},
+		{name: "case and whitespace", params: map[string]interface{}{"bodyFormat": " NDJSON "}, want: NDJSON},
+		{name: "not a string", params: map[string]interface{}{"bodyFormat": 1}, wantErr: "'bodyFormat' must be a string"},
+		{name: "unknown", params: map[string]interface{}{"bodyFormat": "xml"}, wantErr: "'bodyFormat' must be one of"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.params)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("unexpected format: got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitJoin(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    string
+		content   string
+		documents []string
+		joined    string
+	}{
+		{
+			name:      "json object",
+			format:    JSONObject,
+			content:   `{"a":[1,2]}`,
+			documents: []string{`{"a":[1,2]}`},
+			joined:    `{"a":[1,2]}`,
+		},
+		{
+			name:      "json array",
+			format:    JSONArray,
+			content:   `[ {"a":1}, "b" ]`,
+			documents: []string{`{"a":1}`, `"b"`},
+			joined:    `[{"a":1},"b"]`,
+		},
+		{
+			name:      "ndjson keeps blank lines",
+			format:    NDJSON,
+			content:   "{\"a\":1}\n\n{\"b\":2}\n",
+			documents: []string{`{"a":1}`, "", `{"b":2}`, ""},
+			joined:    "{\"a\":1}\n\n{\"b\":2}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			documents, err := Split([]byte(tt.content), tt.format)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := make([]string, len(documents))
+			for i, document := range documents {
+				got[i] = string(document)
+			}
+			if !reflect.DeepEqual(got, tt.documents) {
+				t.Fatalf("unexpected documents: got %q, want %q", got, tt.documents)
+			}
+			if joined := string(Join(documents, tt.format)); joined != tt.joined {
+				t.Fatalf("unexpected joined body: got %q, want %q", joined, tt.joined)
+			}
+		})
+	}
+}
+
+func TestSplit_InvalidJSONArray(t *testing.T) {
+	if _, err := Split([]byte(`{"a":1}`), JSONArray); err == nil || !strings.Contains(err.Error(), "body is not a JSON array") {
+		t.Fatalf("expected JSON array error, got %v", err)
+	}
+}
+
+func TestIsBlank(t *testing.T) {
+	if !IsBlank([]byte(" \t\r")) {
+		t.Fatal("expected whitespace-only document to be blank")
+	}
+	if IsBlank([]byte(` {} `)) {
+		t.Fatal("expected JSON document not to be blank")
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/wso2/gateway-controllers/common
+
+go 1.26.1
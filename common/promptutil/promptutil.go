@@ -0,0 +1,75 @@
+/*
+ *  Copyright (c) 2026, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+// Package promptutil holds the placeholder substitution and JSON encoding
+// shared by the prompt policies, so that they escape substituted values the
+// same way.
+package promptutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// SubstituteMatches replaces every match of pattern in text in a single pass.
+// replace receives the submatch index pairs of a match, as returned by
+// FindAllStringSubmatchIndex, and returns the replacement and whether to use
+// it; the match is kept as-is otherwise. Substituted values are never scanned
+// again, so a value that itself looks like a placeholder is inserted literally.
+func SubstituteMatches(text string, pattern *regexp.Regexp, replace func(loc []int) (string, bool)) string {
+	matches := pattern.FindAllStringSubmatchIndex(text, -1)
+	if len(matches) == 0 {
+		return text
+	}
+	var builder strings.Builder
+	last := 0
+	for _, loc := range matches {
+		builder.WriteString(text[last:loc[0]])
+		if replacement, ok := replace(loc); ok {
+			builder.WriteString(replacement)
+		} else {
+			builder.WriteString(text[loc[0]:loc[1]])
+		}
+		last = loc[1]
+	}
+	builder.WriteString(text[last:])
+	return builder.String()
+}
+
+// MarshalJSON encodes v as compact JSON. Quotes, backslashes and control
+// characters in strings are escaped, using \n, \r and \t where they apply and
+// \u00XX otherwise, as are U+2028 and U+2029. '<', '>' and '&' are written
+// as-is, since prompts are not HTML.
+func MarshalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// JSONStringContent returns value escaped as by MarshalJSON, without the
+// surrounding quotes, for splicing into the text of a JSON string.
+func JSONStringContent(value string) string {
+	// Encoding a string never fails.
+	encoded, _ := MarshalJSON(value)
+	return string(encoded[1 : len(encoded)-1])
+}
@@ -0,0 +1,45 @@
+package promptutil
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/wso2/gateway-controllers/common/promptutil/promptutiltest"
+)
+
+func TestJSONStringContent(t *testing.T) {
+	for _, tt := range promptutiltest.EscapingCases {
+		t.Run(tt.Name, func(t *testing.T) {
+			if got := JSONStringContent(tt.Value); got != tt.Want {
+				t.Fatalf("unexpected escaped content: got %q, want %q", got, tt.Want)
+			}
+		})
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	for _, tt := range promptutiltest.EscapingCases {
+		t.Run(tt.Name, func(t *testing.T) {
+			got, err := MarshalJSON(map[string]interface{}{"text": tt.Value})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if want := `{"text":"` + tt.Want + `"}`; string(got) != want {
+				t.Fatalf("unexpected JSON: got %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestSubstituteMatches(t *testing.T) {
+	pattern := regexp.MustCompile(`<<(\w+)>>`)
+	values := map[string]string{"a": "<<b>>", "b": "B"}
+	text := "<<a>> <<b>> <<c>>"
+	got := SubstituteMatches(text, pattern, func(loc []int) (string, bool) {
+		value, ok := values[text[loc[2]:loc[3]]]
+		return value, ok
+	})
+	if want := "<<b>> B <<c>>"; got != want {
+		t.Fatalf("unexpected substitution: got %q, want %q", got, want)
+	}
+}
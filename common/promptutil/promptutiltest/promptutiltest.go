@@ -0,0 +1,41 @@
+/*
+ *  Copyright (c) 2026, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+// Package promptutiltest provides inputs for checking that a prompt policy
+// escapes substituted values as promptutil does.
+package promptutiltest
+
+// EscapingCase pairs substituted text with the JSON string content a prompt
+// policy must write for it.
+type EscapingCase struct {
+	Name  string
+	Value string
+	Want  string
+}
+
+// EscapingCases covers quotes, backslashes, control characters and the
+// characters that are written as-is.
+var EscapingCases = []EscapingCase{
+	{Name: "quotes", Value: `say "hi"`, Want: `say \"hi\"`},
+	{Name: "backslash", Value: `C:\dir`, Want: `C:\\dir`},
+	{Name: "newlines", Value: "one\ntwo\r\n", Want: `one\ntwo\r\n`},
+	{Name: "tab", Value: "a\tb", Want: `a\tb`},
+	{Name: "control characters", Value: "\x01\x1f", Want: `\u0001\u001f`},
+	{Name: "html characters", Value: "<b>&</b>", Want: "<b>&</b>"},
+	{Name: "line separators", Value: "a\u2028b\u2029", Want: `a\u2028b\u2029`},
+	{Name: "non-ascii", Value: "héllo 世界", Want: "héllo 世界"},
+}
@@ -19,38 +19,109 @@ package piimaskingregex
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
 
 	policy "github.com/wso2/api-platform/sdk/core/policy/v1alpha2"
 	utils "github.com/wso2/api-platform/sdk/core/utils"
+	"github.com/wso2/gateway-controllers/common/bodyformat"
 )
 
 const (
-	APIMInternalErrorCode     = 500
-	APIMInternalExceptionCode = 900967
-	TextCleanRegex            = "^\"|\"$"
-	MetadataKeyPIIEntities    = "piimaskingregex:pii_entities"
-	DefaultEmailEntityName    = "EMAIL"
-	DefaultPhoneEntityName    = "PHONE"
-	DefaultSSNEntityName      = "SSN"
-	DefaultJSONPath           = "$.messages[-1].content"
-	DefaultEmailRegex         = `(?i)\b[a-z0-9.!#$%&'*+/=?^_{|}~-]+@(?:[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])\b`
-	DefaultPhoneRegex         = `(?:\+?1[-.\s]?)?(?:\([2-9][0-9]{2}\)|[2-9][0-9]{2})[-.\s]?[2-9][0-9]{2}[-.\s]?[0-9]{4}\b`
-	DefaultSSNRegex           = `(?:00[1-9]|0[1-9][0-9]|[1-5][0-9]{2}|6(?:[0-57-9][0-9]|6[0-57-9])|[7-8][0-9]{2})[- ]?(?:0[1-9]|[1-9][0-9])[- ]?(?:000[1-9]|00[1-9][0-9]|0[1-9][0-9]{2}|[1-9][0-9]{3})\b`
+	APIMInternalErrorCode       = 500
+	APIMInternalExceptionCode   = 900967
+	TextCleanRegex              = "^\"|\"$"
+	MetadataKeyPIIEntities      = "piimaskingregex:pii_entities"
+	MetadataKeyPIICounts        = "piimaskingregex:counts"
+	MetadataKeyPIIAudit         = "piimaskingregex:audit"
+	DefaultEmailEntityName      = "EMAIL"
+	DefaultPhoneEntityName      = "PHONE"
+	DefaultSSNEntityName        = "SSN"
+	DefaultCreditCardEntityName = "CREDIT_CARD"
+	DefaultIPv4EntityName       = "IPV4"
+	DefaultIPv6EntityName       = "IPV6"
+	DefaultJSONPath             = "$.messages[-1].content"
+	DefaultMaxEntities          = 50
+	DefaultEmailRegex           = `(?i)\b[a-z0-9.!#$%&'*+/=?^_{|}~-]+@(?:[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])\b`
+	DefaultPhoneRegex           = `(?:\+?1[-.\s]?)?(?:\([2-9][0-9]{2}\)|[2-9][0-9]{2})[-.\s]?[2-9][0-9]{2}[-.\s]?[0-9]{4}\b`
+	DefaultSSNRegex             = `(?:00[1-9]|0[1-9][0-9]|[1-5][0-9]{2}|6(?:[0-57-9][0-9]|6[0-57-9])|[7-8][0-9]{2})[- ]?(?:0[1-9]|[1-9][0-9])[- ]?(?:000[1-9]|00[1-9][0-9]|0[1-9][0-9]{2}|[1-9][0-9]{3})\b`
+	// DefaultCreditCardRegex matches 13-19 digit sequences, optionally separated
+	// by single spaces or dashes. Matches are confirmed with a Luhn checksum.
+	DefaultCreditCardRegex = `\b(?:[0-9][ -]?){12,18}[0-9]\b`
+	// DefaultIPv4Regex matches dotted-quad addresses with each octet in 0-255.
+	DefaultIPv4Regex = `\b(?:(?:25[0-5]|2[0-4][0-9]|1[0-9]{2}|[1-9]?[0-9])\.){3}(?:25[0-5]|2[0-4][0-9]|1[0-9]{2}|[1-9]?[0-9])\b`
+	// DefaultIPv6Regex matches runs of hex digits, colons and dots holding at
+	// least two colons, including forms that start with "::" and those ending
+	// in a dotted IPv4 address. The address group is masked; the character
+	// before it only anchors the match. Matches are confirmed by parsing them
+	// as IPv6 addresses.
+	DefaultIPv6Regex = `(?i)(?:^|[^0-9a-z_:.])(?P<address>[0-9a-f.]*:[0-9a-f.]*:[0-9a-f:.]*)`
+
+	// Redaction styles for redactPII
+	RedactionStyleFixed            = "fixed"
+	RedactionStyleLengthPreserving = "lengthPreserving"
+	RedactionStyleTag              = "tag"
+
+	// Error handling modes for onError
+	OnErrorReject      = "reject"
+	OnErrorPassthrough = "passthrough"
+
+	// Error codes carried in the "errorCode" field of error responses, one
+	// per failure class. The numeric "code" field is kept for compatibility.
+	ErrorCodeBodyDecode      = "BODY_DECODE_FAILED"
+	ErrorCodeBodyEncode      = "BODY_ENCODE_FAILED"
+	ErrorCodeJSONPathInvalid = "JSONPATH_INVALID"
+	ErrorCodeInputTooLarge   = "INPUT_TOO_LARGE"
+	ErrorCodeMaskingFailed   = "MASKING_FAILED"
+
+	// Per-entity modes overriding redactPII
+	EntityModeMask   = "mask"
+	EntityModeRedact = "redact"
+
+	// DefaultPlaceholderFormat produces placeholders such as [EMAIL_0000]
+	DefaultPlaceholderFormat = "[{entity}_{id}]"
+	placeholderEntityToken   = "{entity}"
+	placeholderIDToken       = "{id}"
+
+	// UnrestoredPlaceholderFallback replaces placeholders left in a response
+	// when scrubUnrestoredPlaceholders is enabled
+	UnrestoredPlaceholderFallback = "[REDACTED]"
+
+	// metaKeyAccJsonBody accumulates plain JSON response chunks until end of stream
+	metaKeyAccJsonBody = "piimaskingregex:json_body"
 
 	// SSE constants for streaming responses
 	sseDataPrefix  = "data: "
 	sseDone        = "[DONE]"
 	sseEventPrefix = "event:"
+
+	// keyValueGroup names the value group of keyValueMode entity patterns.
+	keyValueGroup = "piiValue"
 )
 
-var textCleanRegexCompiled = regexp.MustCompile(TextCleanRegex)
+var (
+	textCleanRegexCompiled = regexp.MustCompile(TextCleanRegex)
+	// inlineFlagsRegex matches inline flag groups such as (?i), (?s-i) or (?i:.
+	inlineFlagsRegex = regexp.MustCompile(`\(\?([a-zA-Z]*)(?:-([a-zA-Z]*))?[:)]`)
+	// defaultPlaceholders is the compiled DefaultPlaceholderFormat.
+	defaultPlaceholders = mustPlaceholderFormat(DefaultPlaceholderFormat)
+)
 
 // PIIMaskingRegexPolicy implements regex-based PII masking
 type PIIMaskingRegexPolicy struct {
@@ -60,7 +131,87 @@ type PIIMaskingRegexPolicy struct {
 type PIIMaskingRegexPolicyParams struct {
 	PIIEntities map[string]*regexp.Regexp
 	JsonPath    string
-	RedactPII   bool
+	// JsonPaths lists every path masked in the request; a single empty path
+	// means the entire payload is processed as plain text.
+	JsonPaths []string
+	RedactPII bool
+	// HashPII replaces matches with a one-way <ENTITY>:<hex> token derived
+	// from an HMAC of the match keyed with HashSalt
+	HashPII  bool
+	HashSalt string
+	// PreserveLastN keeps the last N alphanumeric characters of each match
+	// visible, replacing the leading ones with '*'
+	PreserveLastN int
+	// MaxInputBytes rejects extracted content larger than this many bytes; 0
+	// disables the limit
+	MaxInputBytes int
+	// MaxEntities caps the number of custom PII entities
+	MaxEntities int
+	// RedactResponse redacts PII in response bodies, independently of RedactPII
+	RedactResponse bool
+	// AuditOnly runs detection and records mappings and counts in metadata
+	// without modifying the request or response
+	AuditOnly bool
+	// ScrubUnrestoredPlaceholders replaces this request's placeholders that
+	// remain in a buffered response after restoration with
+	// UnrestoredPlaceholderFallback
+	ScrubUnrestoredPlaceholders bool
+	// RedactionStyle controls the replacement used when RedactPII is enabled
+	RedactionStyle string
+	// DeterministicPlaceholders derives placeholder suffixes from a hash of the
+	// matched value so the same value maps to the same placeholder across requests.
+	DeterministicPlaceholders bool
+	// OnError selects whether processing errors reject the request or response
+	// (OnErrorReject) or pass it through unmodified (OnErrorPassthrough)
+	OnError string
+	// PlaceholderFormat is the template of generated placeholders; it contains
+	// exactly one {entity} and one {id} token
+	PlaceholderFormat string
+	// PerMessage masks the content of each message separately when a jsonPath
+	// resolves to an array of message objects
+	PerMessage bool
+	// Recursive masks every string leaf of the object or array at each
+	// jsonPath, or of the whole document when jsonPath is empty
+	Recursive bool
+	// SSE reassembles text/event-stream responses into complete events before
+	// restoring placeholders in their delta content
+	SSE bool
+	// SkipPaths lists JSONPaths left untouched by the recursive walk
+	SkipPaths []string
+	// BodyFormat selects the JSON documents of a request body that are masked:
+	// json-object, json-array or ndjson
+	BodyFormat string
+	// AuditRecord stores a JSON audit record of the processed PII, without
+	// plaintext values, in metadata under MetadataKeyPIIAudit
+	AuditRecord bool
+	// SummaryHeader names a response header set to the number of PII values
+	// masked or redacted in the request; empty disables the header
+	SummaryHeader string
+
+	// validators post-filter regex matches per entity. A validator returns
+	// the length of the longest prefix of a match that is PII, or 0 to discard
+	// the match; entities without one keep every match whole.
+	validators map[string]func(string) int
+	// allowlist holds trimmed values that are never masked, lower-cased when
+	// allowlistCaseInsensitive is set.
+	allowlist                map[string]struct{}
+	allowlistCaseInsensitive bool
+	// maskGroups maps an entity to the capture group index whose span is
+	// masked instead of the whole match.
+	maskGroups map[string]int
+	// placeholders is the compiled PlaceholderFormat.
+	placeholders *placeholderFormat
+	// entityModes overrides RedactPII per entity with EntityModeMask or
+	// EntityModeRedact.
+	entityModes map[string]string
+	// entityPriorities holds the configured priority of custom entities;
+	// entities without one have priority 0.
+	entityPriorities map[string]int
+	// skipPaths holds the normalized SkipPaths.
+	skipPaths map[string]struct{}
+	// entityMinLengths holds the minimum length in characters of the masked
+	// text of custom entities; shorter matches are ignored.
+	entityMinLengths map[string]int
 }
 
 // GetPolicy is the v1alpha2 factory entry point (loaded by v1alpha2 kernels).
@@ -80,22 +231,69 @@ func GetPolicy(
 	return p, nil
 }
 
-
-// Mode returns the processing mode for the PII masking regex policy.
+// Mode returns the processing mode for the PII masking regex policy. Request
+// headers are processed so that gzip-encoded bodies can be detected, and
+// response headers only when summaryHeader is set.
 func (p *PIIMaskingRegexPolicy) Mode() policy.ProcessingMode {
+	responseHeaderMode := policy.HeaderModeSkip
+	if p.params.SummaryHeader != "" {
+		responseHeaderMode = policy.HeaderModeProcess
+	}
 	return policy.ProcessingMode{
-		RequestHeaderMode:  policy.HeaderModeSkip,
+		RequestHeaderMode:  policy.HeaderModeProcess,
 		RequestBodyMode:    policy.BodyModeBuffer,
-		ResponseHeaderMode: policy.HeaderModeSkip,
+		ResponseHeaderMode: responseHeaderMode,
 		ResponseBodyMode:   policy.BodyModeStream,
 	}
 }
 
-// parseParams parses and validates parameters from map to struct.
+// Validate checks the policy configuration parameters and returns every
+// problem found, combined with errors.Join, instead of stopping at the first
+// one as GetPolicy does.
+func (p *PIIMaskingRegexPolicy) Validate(params map[string]interface{}) error {
+	_, errs := collectParams(params)
+	return errors.Join(errs...)
+}
+
+// parseParams parses and validates parameters from map to struct, returning
+// the first problem found.
 func parseParams(params map[string]interface{}) (PIIMaskingRegexPolicyParams, error) {
+	result, errs := collectParams(params)
+	if len(errs) > 0 {
+		return result, errs[0]
+	}
+	return result, nil
+}
+
+// collectParams parses parameters from map to struct, collecting every
+// validation problem in the order it is found. Parameters that fail
+// validation are left at their defaults so the remaining ones can still be
+// checked.
+func collectParams(params map[string]interface{}) (PIIMaskingRegexPolicyParams, []error) {
 	var result PIIMaskingRegexPolicyParams
+	var errs []error
+	fail := func(err error) {
+		errs = append(errs, err)
+	}
 	result.JsonPath = DefaultJSONPath
 	piiEntities := make(map[string]*regexp.Regexp)
+	maskGroups := make(map[string]int)
+	entityModes := make(map[string]string)
+	entityPriorities := make(map[string]int)
+	entityMinLengths := make(map[string]int)
+
+	// Extract optional maxEntities parameter before custom entities are parsed.
+	result.MaxEntities = DefaultMaxEntities
+	if maxEntitiesRaw, ok := params["maxEntities"]; ok {
+		maxEntities, err := extractInt(maxEntitiesRaw)
+		if err != nil {
+			fail(fmt.Errorf("'maxEntities' must be a number: %w", err))
+		} else if maxEntities < 1 {
+			fail(fmt.Errorf("'maxEntities' must be at least 1"))
+		} else {
+			result.MaxEntities = maxEntities
+		}
+	}
 
 	// Extract customPIIEntities parameter if provided.
 	piiEntitiesRaw, ok := params["customPIIEntities"]
@@ -105,7 +303,7 @@ func parseParams(params map[string]interface{}) (PIIMaskingRegexPolicyParams, er
 		switch v := piiEntitiesRaw.(type) {
 		case string:
 			if err := json.Unmarshal([]byte(v), &piiEntitiesArray); err != nil {
-				return result, fmt.Errorf("error unmarshaling PII entities: %w", err)
+				fail(fmt.Errorf("error unmarshaling PII entities: %w", err))
 			}
 		case []interface{}:
 			piiEntitiesArray = make([]map[string]interface{}, 0, len(v))
@@ -113,99 +311,581 @@ func parseParams(params map[string]interface{}) (PIIMaskingRegexPolicyParams, er
 				if itemMap, ok := item.(map[string]interface{}); ok {
 					piiEntitiesArray = append(piiEntitiesArray, itemMap)
 				} else {
-					return result, fmt.Errorf("'customPIIEntities[%d]' must be an object", idx)
+					fail(fmt.Errorf("'customPIIEntities[%d]' must be an object", idx))
 				}
 			}
 		default:
-			return result, fmt.Errorf("'customPIIEntities' must be an array or JSON string")
+			fail(fmt.Errorf("'customPIIEntities' must be an array or JSON string"))
+		}
+
+		if len(piiEntitiesArray) > result.MaxEntities {
+			fail(fmt.Errorf("'customPIIEntities' cannot contain more than %d entities (see 'maxEntities')", result.MaxEntities))
 		}
 
 		// Validate each custom PII entity.
 		for i, entityConfig := range piiEntitiesArray {
 			piiEntity, ok := entityConfig["piiEntity"].(string)
 			if !ok || strings.TrimSpace(piiEntity) == "" {
-				return result, fmt.Errorf("'customPIIEntities[%d].piiEntity' is required and must be a non-empty string", i)
+				fail(fmt.Errorf("'customPIIEntities[%d].piiEntity' is required and must be a non-empty string", i))
+				continue
 			}
 
 			normalizedPIIEntity := strings.ToUpper(strings.TrimSpace(piiEntity))
 			if !regexp.MustCompile(`^[A-Z_]+$`).MatchString(normalizedPIIEntity) {
-				return result, fmt.Errorf("'customPIIEntities[%d].piiEntity' must contain only letters and underscores", i)
+				fail(fmt.Errorf("'customPIIEntities[%d].piiEntity' must contain only letters and underscores", i))
+				continue
 			}
 
 			piiRegex, ok := entityConfig["piiRegex"].(string)
 			if !ok || piiRegex == "" {
-				return result, fmt.Errorf("'customPIIEntities[%d].piiRegex' is required and must be a non-empty string", i)
+				fail(fmt.Errorf("'customPIIEntities[%d].piiRegex' is required and must be a non-empty string", i))
+				continue
+			}
+
+			if caseInsensitiveRaw, ok := entityConfig["caseInsensitive"]; ok {
+				caseInsensitive, ok := caseInsensitiveRaw.(bool)
+				if !ok {
+					fail(fmt.Errorf("'customPIIEntities[%d].caseInsensitive' must be a boolean", i))
+					continue
+				}
+				if conflict := inlineCaseFlagConflict(piiRegex, caseInsensitive); conflict != "" {
+					fail(fmt.Errorf("'customPIIEntities[%d].caseInsensitive' conflicts with inline flag %q in 'piiRegex'", i, conflict))
+					continue
+				}
+				if caseInsensitive {
+					piiRegex = "(?i)" + piiRegex
+				}
+			}
+
+			// In keyValueMode piiRegex matches the key of key=value pairs and
+			// only the value is masked.
+			keyValueMode := false
+			if keyValueRaw, ok := entityConfig["keyValueMode"]; ok {
+				keyValueMode, ok = keyValueRaw.(bool)
+				if !ok {
+					fail(fmt.Errorf("'customPIIEntities[%d].keyValueMode' must be a boolean", i))
+					continue
+				}
+			}
+			if keyValueMode {
+				if _, ok := entityConfig["maskGroup"]; ok {
+					fail(fmt.Errorf("'customPIIEntities[%d].maskGroup' cannot be combined with 'keyValueMode'", i))
+					continue
+				}
+				keyPattern, err := regexp.Compile(piiRegex)
+				if err != nil {
+					fail(fmt.Errorf("'customPIIEntities[%d].piiRegex' is invalid: %w", i, err))
+					continue
+				}
+				if keyPattern.SubexpIndex(keyValueGroup) >= 0 {
+					fail(fmt.Errorf("'customPIIEntities[%d].piiRegex' must not define capture group %q in keyValueMode", i, keyValueGroup))
+					continue
+				}
+				piiRegex = keyValuePattern(piiRegex)
 			}
 
 			compiledPattern, err := regexp.Compile(piiRegex)
 			if err != nil {
-				return result, fmt.Errorf("'customPIIEntities[%d].piiRegex' is invalid: %w", i, err)
+				fail(fmt.Errorf("'customPIIEntities[%d].piiRegex' is invalid: %w", i, err))
+				continue
 			}
 
 			if _, exists := piiEntities[normalizedPIIEntity]; exists {
-				return result, fmt.Errorf("duplicate piiEntity: %q", normalizedPIIEntity)
+				fail(fmt.Errorf("duplicate piiEntity: %q", normalizedPIIEntity))
+				continue
 			}
 			piiEntities[normalizedPIIEntity] = compiledPattern
+
+			if maskGroupRaw, ok := entityConfig["maskGroup"]; ok {
+				maskGroup, ok := maskGroupRaw.(string)
+				if !ok || maskGroup == "" {
+					fail(fmt.Errorf("'customPIIEntities[%d].maskGroup' must be a non-empty string", i))
+				} else if groupIndex := compiledPattern.SubexpIndex(maskGroup); groupIndex < 0 {
+					fail(fmt.Errorf("'customPIIEntities[%d].maskGroup' %q is not a named capture group in 'piiRegex'", i, maskGroup))
+				} else {
+					maskGroups[normalizedPIIEntity] = groupIndex
+				}
+			}
+			if keyValueMode {
+				maskGroups[normalizedPIIEntity] = compiledPattern.SubexpIndex(keyValueGroup)
+			}
+
+			if priorityRaw, ok := entityConfig["priority"]; ok {
+				priority, err := extractInt(priorityRaw)
+				if err != nil {
+					fail(fmt.Errorf("'customPIIEntities[%d].priority' must be an integer: %w", i, err))
+				} else {
+					entityPriorities[normalizedPIIEntity] = priority
+				}
+			}
+
+			if minLengthRaw, ok := entityConfig["minLength"]; ok {
+				minLength, err := extractInt(minLengthRaw)
+				if err != nil {
+					fail(fmt.Errorf("'customPIIEntities[%d].minLength' must be an integer: %w", i, err))
+				} else if minLength < 0 {
+					fail(fmt.Errorf("'customPIIEntities[%d].minLength' cannot be negative", i))
+				} else if minLength > 0 {
+					entityMinLengths[normalizedPIIEntity] = minLength
+				}
+			}
+
+			if modeRaw, ok := entityConfig["mode"]; ok {
+				mode, err := parseEntityMode(modeRaw, fmt.Sprintf("customPIIEntities[%d].mode", i))
+				if err != nil {
+					fail(err)
+				} else {
+					entityModes[normalizedPIIEntity] = mode
+				}
+			}
+		}
+	}
+
+	// Extract optional builtins list, an alternative to the individual
+	// built-in entity toggles.
+	builtIns := make(map[string]bool)
+	if builtInsRaw, ok := params["builtins"]; ok {
+		entries, ok := builtInsRaw.([]interface{})
+		if !ok {
+			fail(fmt.Errorf("'builtins' must be an array of strings"))
+		}
+		for idx, entry := range entries {
+			name, ok := entry.(string)
+			entity := strings.ToUpper(strings.TrimSpace(name))
+			if !ok || !isBuiltInEntity(entity) {
+				fail(fmt.Errorf("'builtins[%d]' must be one of: %s, %s, %s, %s, %s, %s", idx,
+					DefaultEmailEntityName, DefaultPhoneEntityName, DefaultSSNEntityName,
+					DefaultCreditCardEntityName, DefaultIPv4EntityName, DefaultIPv6EntityName))
+				continue
+			}
+			if builtIns[entity] {
+				fail(fmt.Errorf("'builtins[%d]' duplicates built-in entity %q", idx, entity))
+				continue
+			}
+			builtIns[entity] = true
 		}
 	}
 
 	// Extract built-in entity toggles.
-	enableEmail, err := parseBoolParam(params, "email")
-	if err != nil {
-		return result, err
+	validators := make(map[string]func(string) int)
+	for _, builtIn := range []struct {
+		param     string
+		entity    string
+		validator func(string) int
+		maskGroup string
+	}{
+		{"email", DefaultEmailEntityName, nil, ""},
+		{"phone", DefaultPhoneEntityName, nil, ""},
+		{"ssn", DefaultSSNEntityName, nil, ""},
+		{"creditCard", DefaultCreditCardEntityName, luhnValidPrefix, ""},
+		{"ipv4", DefaultIPv4EntityName, nil, ""},
+		{"ipv6", DefaultIPv6EntityName, ipv6ValidPrefix, "address"},
+	} {
+		enabled, err := parseBoolParam(params, builtIn.param)
+		if err != nil {
+			fail(err)
+			continue
+		}
+		if enabled && builtIns[builtIn.entity] {
+			fail(fmt.Errorf("built-in entity %q is enabled by both '%s' and 'builtins'", builtIn.entity, builtIn.param))
+			continue
+		}
+		if !enabled && !builtIns[builtIn.entity] {
+			continue
+		}
+		if _, exists := piiEntities[builtIn.entity]; exists {
+			fail(fmt.Errorf("duplicate piiEntity: %q", builtIn.entity))
+			continue
+		}
+		piiEntities[builtIn.entity] = builtInPattern(builtIn.entity)
+		if builtIn.validator != nil {
+			validators[builtIn.entity] = builtIn.validator
+		}
+		if builtIn.maskGroup != "" {
+			maskGroups[builtIn.entity] = piiEntities[builtIn.entity].SubexpIndex(builtIn.maskGroup)
+		}
 	}
-	enablePhone, err := parseBoolParam(params, "phone")
-	if err != nil {
-		return result, err
+
+	if len(piiEntities) == 0 && len(errs) == 0 {
+		fail(fmt.Errorf("at least one PII detector must be configured using 'customPIIEntities', 'builtins' or one of 'email', 'phone', 'ssn', 'creditCard', 'ipv4', 'ipv6'"))
 	}
-	enableSSN, err := parseBoolParam(params, "ssn")
-	if err != nil {
-		return result, err
+	result.PIIEntities = piiEntities
+	result.validators = validators
+	result.maskGroups = maskGroups
+	result.entityPriorities = entityPriorities
+	result.entityMinLengths = entityMinLengths
+
+	// Extract optional entityModes parameter for built-in entities.
+	if entityModesRaw, ok := params["entityModes"]; ok {
+		modes, ok := entityModesRaw.(map[string]interface{})
+		if !ok {
+			fail(fmt.Errorf("'entityModes' must be an object"))
+		}
+		names := make([]string, 0, len(modes))
+		for name := range modes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			entity := strings.ToUpper(strings.TrimSpace(name))
+			if !isBuiltInEntity(entity) {
+				fail(fmt.Errorf("'entityModes' key %q is not a built-in entity; set 'mode' on the custom entity instead", name))
+				continue
+			}
+			if _, enabled := piiEntities[entity]; !enabled {
+				fail(fmt.Errorf("'entityModes' key %q refers to a built-in entity that is not enabled", name))
+				continue
+			}
+			mode, err := parseEntityMode(modes[name], "entityModes."+name)
+			if err != nil {
+				fail(err)
+				continue
+			}
+			entityModes[entity] = mode
+		}
 	}
+	result.entityModes = entityModes
 
-	if enableEmail {
-		if _, exists := piiEntities[DefaultEmailEntityName]; exists {
-			return result, fmt.Errorf("duplicate piiEntity: %q", DefaultEmailEntityName)
+	// Extract optional jsonPath parameter. Accepts a single path or an array of paths.
+	if jsonPathRaw, ok := params["jsonPath"]; ok {
+		switch v := jsonPathRaw.(type) {
+		case string:
+			// An empty path masks the entire payload as plain text.
+			result.JsonPath = strings.TrimSpace(v)
+		case []interface{}:
+			if len(v) == 0 {
+				fail(fmt.Errorf("'jsonPath' cannot be an empty array"))
+				break
+			}
+			jsonPaths := make([]string, 0, len(v))
+			for idx, item := range v {
+				jsonPath, ok := item.(string)
+				if !ok || strings.TrimSpace(jsonPath) == "" {
+					fail(fmt.Errorf("'jsonPath[%d]' must be a non-empty string", idx))
+					continue
+				}
+				jsonPaths = append(jsonPaths, jsonPath)
+			}
+			if len(jsonPaths) == len(v) {
+				result.JsonPaths = jsonPaths
+				result.JsonPath = jsonPaths[0]
+			}
+		default:
+			fail(fmt.Errorf("'jsonPath' must be a string or an array of strings"))
 		}
-		piiEntities[DefaultEmailEntityName] = regexp.MustCompile(DefaultEmailRegex)
 	}
-	if enablePhone {
-		if _, exists := piiEntities[DefaultPhoneEntityName]; exists {
-			return result, fmt.Errorf("duplicate piiEntity: %q", DefaultPhoneEntityName)
+	if result.JsonPaths == nil {
+		result.JsonPaths = []string{result.JsonPath}
+	}
+
+	// Extract optional redactionStyle parameter
+	result.RedactionStyle = RedactionStyleFixed
+	if styleRaw, ok := params["redactionStyle"]; ok {
+		style, ok := styleRaw.(string)
+		if !ok {
+			fail(fmt.Errorf("'redactionStyle' must be a string"))
+		} else {
+			switch style {
+			case RedactionStyleFixed, RedactionStyleLengthPreserving, RedactionStyleTag:
+				result.RedactionStyle = style
+			default:
+				fail(fmt.Errorf("'redactionStyle' must be one of: %s, %s, %s",
+					RedactionStyleFixed, RedactionStyleLengthPreserving, RedactionStyleTag))
+			}
 		}
-		piiEntities[DefaultPhoneEntityName] = regexp.MustCompile(DefaultPhoneRegex)
 	}
-	if enableSSN {
-		if _, exists := piiEntities[DefaultSSNEntityName]; exists {
-			return result, fmt.Errorf("duplicate piiEntity: %q", DefaultSSNEntityName)
+
+	// Extract optional allowlist and allowlistCaseInsensitive parameters
+	allowlistCaseInsensitive, err := parseBoolParam(params, "allowlistCaseInsensitive")
+	if err != nil {
+		fail(err)
+	}
+	result.allowlistCaseInsensitive = allowlistCaseInsensitive
+	if allowlistRaw, ok := params["allowlist"]; ok {
+		entries, ok := allowlistRaw.([]interface{})
+		if !ok {
+			fail(fmt.Errorf("'allowlist' must be an array of strings"))
+		}
+		result.allowlist = make(map[string]struct{}, len(entries))
+		for idx, entry := range entries {
+			value, ok := entry.(string)
+			if !ok || strings.TrimSpace(value) == "" {
+				fail(fmt.Errorf("'allowlist[%d]' must be a non-empty string", idx))
+				continue
+			}
+			result.allowlist[result.normalizeAllowlistValue(value)] = struct{}{}
 		}
-		piiEntities[DefaultSSNEntityName] = regexp.MustCompile(DefaultSSNRegex)
 	}
 
-	if len(piiEntities) == 0 {
-		return result, fmt.Errorf("at least one PII detector must be configured using 'customPIIEntities' or one of 'email', 'phone', 'ssn'")
+	// Extract optional preserveLastN parameter
+	if preserveRaw, ok := params["preserveLastN"]; ok {
+		preserveLastN, err := extractInt(preserveRaw)
+		if err != nil {
+			fail(fmt.Errorf("'preserveLastN' must be a number: %w", err))
+		} else if preserveLastN < 0 {
+			fail(fmt.Errorf("'preserveLastN' cannot be negative"))
+		} else {
+			result.PreserveLastN = preserveLastN
+		}
 	}
-	result.PIIEntities = piiEntities
 
-	// Extract optional jsonPath parameter
-	if jsonPathRaw, ok := params["jsonPath"]; ok {
-		if jsonPath, ok := jsonPathRaw.(string); ok {
-			result.JsonPath = jsonPath
+	// Extract optional deterministicPlaceholders parameter
+	deterministic, err := parseBoolParam(params, "deterministicPlaceholders")
+	if err != nil {
+		fail(err)
+	}
+	result.DeterministicPlaceholders = deterministic
+
+	// Extract optional onError parameter
+	result.OnError = OnErrorReject
+	if onErrorRaw, ok := params["onError"]; ok {
+		onError, ok := onErrorRaw.(string)
+		if !ok || (onError != OnErrorReject && onError != OnErrorPassthrough) {
+			fail(fmt.Errorf("'onError' must be one of: %s, %s", OnErrorReject, OnErrorPassthrough))
 		} else {
-			return result, fmt.Errorf("'jsonPath' must be a string")
+			result.OnError = onError
+		}
+	}
+
+	// Extract optional placeholderFormat parameter
+	result.PlaceholderFormat = DefaultPlaceholderFormat
+	if formatRaw, ok := params["placeholderFormat"]; ok {
+		format, ok := formatRaw.(string)
+		if !ok {
+			fail(fmt.Errorf("'placeholderFormat' must be a string"))
+		} else {
+			result.PlaceholderFormat = format
+		}
+	}
+	placeholders, err := newPlaceholderFormat(result.PlaceholderFormat)
+	if err != nil {
+		fail(err)
+	}
+	result.placeholders = placeholders
+
+	// Extract optional perMessage parameter
+	perMessage, err := parseBoolParam(params, "perMessage")
+	if err != nil {
+		fail(err)
+	}
+	result.PerMessage = perMessage
+
+	// Extract optional recursive parameter
+	recursive, err := parseBoolParam(params, "recursive")
+	if err != nil {
+		fail(err)
+	}
+	result.Recursive = recursive
+
+	// Extract optional sse parameter
+	sse, err := parseBoolParam(params, "sse")
+	if err != nil {
+		fail(err)
+	}
+	result.SSE = sse
+
+	// Extract optional skipPaths parameter
+	if skipPathsRaw, ok := params["skipPaths"]; ok {
+		entries, ok := skipPathsRaw.([]interface{})
+		if !ok {
+			fail(fmt.Errorf("'skipPaths' must be an array of strings"))
+		}
+		result.skipPaths = make(map[string]struct{}, len(entries))
+		for idx, entry := range entries {
+			skipPath, ok := entry.(string)
+			if !ok || strings.TrimSpace(skipPath) == "" {
+				fail(fmt.Errorf("'skipPaths[%d]' must be a non-empty string", idx))
+				continue
+			}
+			result.SkipPaths = append(result.SkipPaths, skipPath)
+			result.skipPaths[normalizeJSONPath(skipPath)] = struct{}{}
+		}
+		if len(entries) > 0 && !recursive {
+			fail(fmt.Errorf("'skipPaths' is only supported when 'recursive' is enabled"))
 		}
 	}
 
+	// Extract optional bodyFormat parameter
+	bodyFormat, err := bodyformat.Parse(params)
+	if err != nil {
+		fail(err)
+	}
+	result.BodyFormat = bodyFormat
+
 	// Extract optional redactPII parameter
 	if redactPIIRaw, ok := params["redactPII"]; ok {
 		if redactPII, ok := redactPIIRaw.(bool); ok {
 			result.RedactPII = redactPII
 		} else {
-			return result, fmt.Errorf("'redactPII' must be a boolean")
+			fail(fmt.Errorf("'redactPII' must be a boolean"))
 		}
 	}
 
-	return result, nil
+	// Extract optional maxInputBytes parameter
+	if maxInputBytesRaw, ok := params["maxInputBytes"]; ok {
+		maxInputBytes, err := extractInt(maxInputBytesRaw)
+		if err != nil {
+			fail(fmt.Errorf("'maxInputBytes' must be a number: %w", err))
+		} else if maxInputBytes < 0 {
+			fail(fmt.Errorf("'maxInputBytes' cannot be negative"))
+		} else {
+			result.MaxInputBytes = maxInputBytes
+		}
+	}
+
+	// Extract optional redactResponse parameter
+	redactResponse, err := parseBoolParam(params, "redactResponse")
+	if err != nil {
+		fail(err)
+	}
+	result.RedactResponse = redactResponse
+
+	// Extract optional auditOnly parameter
+	auditOnly, err := parseBoolParam(params, "auditOnly")
+	if err != nil {
+		fail(err)
+	}
+	if auditOnly && result.RedactResponse {
+		fail(fmt.Errorf("'auditOnly' and 'redactResponse' cannot both be enabled"))
+	} else {
+		result.AuditOnly = auditOnly
+	}
+
+	// Extract optional scrubUnrestoredPlaceholders parameter
+	scrubUnrestored, err := parseBoolParam(params, "scrubUnrestoredPlaceholders")
+	if err != nil {
+		fail(err)
+	}
+	result.ScrubUnrestoredPlaceholders = scrubUnrestored
+
+	// Extract optional hashPII and hashSalt parameters
+	hashPII, err := parseBoolParam(params, "hashPII")
+	if err != nil {
+		fail(err)
+	}
+	if hashPII {
+		hashSalt, ok := params["hashSalt"].(string)
+		if result.RedactPII {
+			fail(fmt.Errorf("'hashPII' and 'redactPII' cannot both be enabled"))
+		} else if !ok || hashSalt == "" {
+			fail(fmt.Errorf("'hashSalt' is required and must be a non-empty string when 'hashPII' is enabled"))
+		} else {
+			result.HashPII = true
+			result.HashSalt = hashSalt
+		}
+	}
+
+	// Extract optional auditRecord parameter
+	auditRecord, err := parseBoolParam(params, "auditRecord")
+	if err != nil {
+		fail(err)
+	}
+	if auditRecord {
+		// The record's hashes are only safe against brute force when keyed.
+		hashSalt, ok := params["hashSalt"].(string)
+		if !ok || hashSalt == "" {
+			fail(fmt.Errorf("'hashSalt' is required and must be a non-empty string when 'auditRecord' is enabled"))
+		} else {
+			result.AuditRecord = true
+			result.HashSalt = hashSalt
+		}
+	}
+
+	// Extract optional summaryHeader parameter
+	if summaryRaw, ok := params["summaryHeader"]; ok {
+		summaryHeader, ok := summaryRaw.(string)
+		summaryHeader = strings.TrimSpace(summaryHeader)
+		if !ok || !isHeaderToken(summaryHeader) {
+			fail(fmt.Errorf("'summaryHeader' must be a valid HTTP header name"))
+		} else {
+			result.SummaryHeader = summaryHeader
+		}
+	}
+
+	return result, errs
+}
+
+// isHeaderToken reports whether name is a non-empty RFC 9110 token, the
+// grammar HTTP field names must follow.
+func isHeaderToken(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) != -1:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// inlineCaseFlagConflict returns the first inline flag group in pattern that
+// contradicts the caseInsensitive setting: one clearing 'i' when it is true,
+// or one setting 'i' when it is false. It returns "" when there is none.
+func inlineCaseFlagConflict(pattern string, caseInsensitive bool) string {
+	for _, match := range inlineFlagsRegex.FindAllStringSubmatch(pattern, -1) {
+		setFlags, clearedFlags := match[1], match[2]
+		if caseInsensitive && strings.Contains(clearedFlags, "i") {
+			return match[0]
+		}
+		if !caseInsensitive && strings.Contains(setFlags, "i") {
+			return match[0]
+		}
+	}
+	return ""
+}
+
+// keyValuePattern builds the regex of a keyValueMode entity: keyPattern at a
+// word boundary followed by "=" and a value, captured in keyValueGroup, that
+// runs up to the next whitespace, "&", ";", "," or quote.
+func keyValuePattern(keyPattern string) string {
+	return `\b(?:` + keyPattern + `)=(?P<` + keyValueGroup + `>[^\s&;,"']+)`
+}
+
+// parseEntityMode validates a per-entity mode value.
+func parseEntityMode(raw interface{}, field string) (string, error) {
+	mode, ok := raw.(string)
+	if !ok || (mode != EntityModeMask && mode != EntityModeRedact) {
+		return "", fmt.Errorf("'%s' must be one of: %s, %s", field, EntityModeMask, EntityModeRedact)
+	}
+	return mode, nil
+}
+
+var (
+	builtInPatternsOnce sync.Once
+	// builtInPatterns holds the compiled built-in entity patterns, shared by
+	// every policy instance. A compiled regexp is safe for concurrent use.
+	builtInPatterns map[string]*regexp.Regexp
+)
+
+// builtInPattern returns the shared compiled pattern of a built-in entity,
+// compiling all built-in patterns on first use.
+func builtInPattern(entity string) *regexp.Regexp {
+	builtInPatternsOnce.Do(func() {
+		builtInPatterns = map[string]*regexp.Regexp{
+			DefaultEmailEntityName:      regexp.MustCompile(DefaultEmailRegex),
+			DefaultPhoneEntityName:      regexp.MustCompile(DefaultPhoneRegex),
+			DefaultSSNEntityName:        regexp.MustCompile(DefaultSSNRegex),
+			DefaultCreditCardEntityName: regexp.MustCompile(DefaultCreditCardRegex),
+			DefaultIPv4EntityName:       regexp.MustCompile(DefaultIPv4Regex),
+			DefaultIPv6EntityName:       regexp.MustCompile(DefaultIPv6Regex),
+		}
+	})
+	return builtInPatterns[entity]
+}
+
+// isBuiltInEntity reports whether entity names one of the built-in detectors.
+func isBuiltInEntity(entity string) bool {
+	switch entity {
+	case DefaultEmailEntityName, DefaultPhoneEntityName, DefaultSSNEntityName,
+		DefaultCreditCardEntityName, DefaultIPv4EntityName, DefaultIPv6EntityName:
+		return true
+	}
+	return false
 }
 
 func parseBoolParam(params map[string]interface{}, key string) (bool, error) {
@@ -220,190 +900,1072 @@ func parseBoolParam(params map[string]interface{}, key string) (bool, error) {
 	return val, nil
 }
 
-// maskPIIFromContent masks PII from content using regex patterns
-func (p *PIIMaskingRegexPolicy) maskPIIFromContent(content string, piiEntities map[string]*regexp.Regexp, metadata map[string]interface{}) (string, error) {
-	if content == "" {
-		return "", nil
+// extractInt safely extracts an integer from various types
+func extractInt(value interface{}) (int, error) {
+	switch v := value.(type) {
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case float64:
+		if v != float64(int(v)) {
+			return 0, fmt.Errorf("expected an integer but got %v", v)
+		}
+		return int(v), nil
+	case string:
+		parsed, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, err
+		}
+		if parsed != float64(int(parsed)) {
+			return 0, fmt.Errorf("expected an integer but got %v", v)
+		}
+		return int(parsed), nil
+	default:
+		return 0, fmt.Errorf("cannot convert %T to int", value)
 	}
+}
 
-	maskedContent := content
-	maskedPIIEntities := make(map[string]string)
-	counter := 0
-	// Pre-compile placeholder pattern for efficiency
-	placeholderPattern := regexp.MustCompile(`^\[[A-Z_]+_[0-9a-f]{4}\]$`)
+// isLuhnValid reports whether the digits in value pass the Luhn checksum.
+// Space and dash separators are ignored.
+func isLuhnValid(value string) bool {
+	sum := 0
+	digits := 0
+	double := false
+	for i := len(value) - 1; i >= 0; i-- {
+		c := value[i]
+		if c == ' ' || c == '-' {
+			continue
+		}
+		if c < '0' || c > '9' {
+			return false
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+		double = !double
+	}
+	return digits > 0 && sum%10 == 0
+}
 
-	// First pass: find all matches without replacing to avoid nested replacements
-	allMatches := make(map[string]string) // original -> placeholder
-	for key, pattern := range piiEntities {
-		matches := pattern.FindAllString(maskedContent, -1)
-		for _, match := range matches {
-			if _, exists := allMatches[match]; !exists && !placeholderPattern.MatchString(match) {
-				// Generate unique placeholder like [EMAIL_0000]
-				placeholder := fmt.Sprintf("[%s_%04x]", key, counter)
-				allMatches[match] = placeholder
-				maskedPIIEntities[match] = placeholder
-				counter++
+// luhnValidPrefix returns the length of the longest prefix of a credit card
+// candidate that holds at least 13 digits and passes the Luhn checksum, or 0
+// when there is none. Prefixes end before a separator, so a card number
+// followed by another digit group, such as a CVV, is still found.
+func luhnValidPrefix(match string) int {
+	digits := 0
+	for i := 0; i < len(match); i++ {
+		if match[i] >= '0' && match[i] <= '9' {
+			digits++
+		}
+	}
+	for end := len(match); end > 0 && digits >= 13; end-- {
+		if end < len(match) {
+			if c := match[end]; c >= '0' && c <= '9' {
+				digits--
+				continue
+			}
+			if match[end-1] < '0' || match[end-1] > '9' {
+				continue
 			}
 		}
+		if isLuhnValid(match[:end]) {
+			return end
+		}
 	}
+	return 0
+}
 
-	// Second pass: replace all matches
-	originals := make([]string, 0, len(allMatches))
-	for original := range allMatches {
-		originals = append(originals, original)
+// maxIPv6Length is the length of the longest textual IPv6 address, one with
+// eight groups written as an IPv4-mapped address.
+const maxIPv6Length = len("ffff:ffff:ffff:ffff:ffff:ffff:255.255.255.255")
+
+// ipv6ValidPrefix returns the length of the longest prefix of an IPv6
+// candidate that parses as an IPv6 address, or 0 when there is none. This
+// rejects times and MAC addresses that the candidate regex also matches, and
+// drops trailing characters such as a full stop.
+func ipv6ValidPrefix(match string) int {
+	for end := min(len(match), maxIPv6Length); end > 0; end-- {
+		if isIPv6Address(match[:end]) {
+			return end
+		}
 	}
-	sort.Slice(originals, func(i, j int) bool { return len(originals[i]) > len(originals[j]) })
-	for _, original := range originals {
-		maskedContent = strings.ReplaceAll(maskedContent, original, allMatches[original])
+	return 0
+}
+
+// isIPv6Address reports whether value parses as an IPv6 address, including
+// IPv4-mapped forms such as ::ffff:10.0.0.1.
+func isIPv6Address(value string) bool {
+	addr, err := netip.ParseAddr(value)
+	return err == nil && addr.Is6()
+}
+
+// validMatchLength returns the length of the part of a regex match for the
+// entity that is treated as PII, applying the entity's validator when one is
+// configured, or 0 when the match is discarded.
+func (p *PIIMaskingRegexPolicy) validMatchLength(entity, match string) int {
+	validator, ok := p.params.validators[entity]
+	if !ok {
+		return len(match)
 	}
+	return validator(match)
+}
+
+// placeholderFormat generates and recognizes placeholders from a template
+// such as "[{entity}_{id}]", where {id} is rendered as four hex digits.
+type placeholderFormat struct {
+	template string
+	// open and close are the literal text before the first token and after
+	// the last one, used to detect placeholders split across stream chunks.
+	open  string
+	close string
+	// exact matches a whole placeholder; token finds placeholders in content.
+	exact *regexp.Regexp
+	token *regexp.Regexp
+}
+
+// newPlaceholderFormat validates and compiles a placeholder template.
+func newPlaceholderFormat(template string) (*placeholderFormat, error) {
+	if strings.Count(template, placeholderEntityToken) != 1 || strings.Count(template, placeholderIDToken) != 1 {
+		return nil, fmt.Errorf("'placeholderFormat' must contain exactly one %s and one %s token", placeholderEntityToken, placeholderIDToken)
+	}
+	entityIdx := strings.Index(template, placeholderEntityToken)
+	idIdx := strings.Index(template, placeholderIDToken)
+	first, lastEnd := entityIdx, idIdx+len(placeholderIDToken)
+	if idIdx < entityIdx {
+		first, lastEnd = idIdx, entityIdx+len(placeholderEntityToken)
+	}
+	f := &placeholderFormat{
+		template: template,
+		open:     template[:first],
+		close:    template[lastEnd:],
+	}
+	if strings.TrimSpace(f.open) == "" || strings.TrimSpace(f.close) == "" {
+		return nil, fmt.Errorf("'placeholderFormat' must begin and end with non-whitespace text outside the %s and %s tokens", placeholderEntityToken, placeholderIDToken)
+	}
+
+	pattern := strings.NewReplacer(
+		regexp.QuoteMeta(placeholderEntityToken), `[A-Z][A-Z0-9_]*`,
+		regexp.QuoteMeta(placeholderIDToken), `[0-9a-f]{4,}`,
+	).Replace(regexp.QuoteMeta(template))
+	f.exact = regexp.MustCompile("^" + pattern + "$")
+	f.token = regexp.MustCompile(pattern)
+	return f, nil
+}
+
+func mustPlaceholderFormat(template string) *placeholderFormat {
+	f, err := newPlaceholderFormat(template)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// format renders the placeholder for entity with the given id.
+func (f *placeholderFormat) format(entity string, id int) string {
+	return f.formatID(entity, fmt.Sprintf("%04x", id))
+}
+
+// formatID renders the placeholder for entity with an already encoded id.
+func (f *placeholderFormat) formatID(entity, id string) string {
+	return strings.NewReplacer(
+		placeholderEntityToken, entity,
+		placeholderIDToken, id,
+	).Replace(f.template)
+}
+
+// endsInPartialPlaceholder reports whether content ends with an opening that
+// is not yet closed, or with the start of a multi-character opening, so the
+// rest of a placeholder may still follow.
+func (f *placeholderFormat) endsInPartialPlaceholder(content string) bool {
+	lastOpen := strings.LastIndex(content, f.open)
+	if lastOpen == -1 {
+		return endsWithPartialPrefix(content, f.open)
+	}
+	return !strings.Contains(content[lastOpen+len(f.open):], f.close)
+}
+
+// deterministic derives a placeholder id from the first four hex digits of
+// the SHA-256 of the matched value. If another value in the same request
+// already holds that placeholder, the id is extended two digits of the digest
+// at a time until a free one is found, so the value→placeholder mapping stays
+// bijective and the id still depends only on the value.
+func (f *placeholderFormat) deterministic(entity, match string, used map[string]struct{}) string {
+	sum := sha256.Sum256([]byte(match))
+	digest := hex.EncodeToString(sum[:])
+	for length := 4; length <= len(digest); length += 2 {
+		placeholder := f.formatID(entity, digest[:length])
+		if _, taken := used[placeholder]; !taken {
+			return placeholder
+		}
+	}
+	// Only a placeholder issued outside this policy can hold the full digest
+	// of a different value; disambiguate with a counter.
+	for suffix := 0; ; suffix++ {
+		placeholder := f.formatID(entity, fmt.Sprintf("%s%04x", digest, suffix))
+		if _, taken := used[placeholder]; !taken {
+			return placeholder
+		}
+	}
+}
+
+// piiDetections records the distinct original values detected per entity,
+// each with the placeholder or redaction that replaced it.
+type piiDetections map[string]map[string]string
+
+func (d piiDetections) add(entity, value, replacement string) {
+	if d[entity] == nil {
+		d[entity] = make(map[string]string)
+	}
+	d[entity][value] = replacement
+}
+
+// counts returns the number of distinct values detected per entity.
+func (d piiDetections) counts() map[string]int {
+	counts := make(map[string]int, len(d))
+	for entity, values := range d {
+		counts[entity] = len(values)
+	}
+	return counts
+}
+
+// auditRecord is the audit record of the PII processed in a request. It never
+// holds original values, only their hashes.
+type auditRecord struct {
+	Entities []auditEntity `json:"entities"`
+}
+
+type auditEntity struct {
+	Entity string      `json:"entity"`
+	Count  int         `json:"count"`
+	Items  []auditItem `json:"items"`
+}
+
+type auditItem struct {
+	Placeholder string `json:"placeholder"`
+	Hash        string `json:"hash"`
+}
+
+// auditRecord encodes the detections as a JSON audit record. Entities are
+// sorted by name and items by placeholder, then hash, so the record is
+// deterministic. Each hash is the hex HMAC-SHA256 of the original value keyed
+// with salt.
+func (d piiDetections) auditRecord(salt string) string {
+	record := auditRecord{Entities: make([]auditEntity, 0, len(d))}
+	for entity, values := range d {
+		items := make([]auditItem, 0, len(values))
+		for value, replacement := range values {
+			mac := hmac.New(sha256.New, []byte(salt))
+			mac.Write([]byte(value))
+			items = append(items, auditItem{Placeholder: replacement, Hash: hex.EncodeToString(mac.Sum(nil))})
+		}
+		sort.Slice(items, func(i, j int) bool {
+			if items[i].Placeholder != items[j].Placeholder {
+				return items[i].Placeholder < items[j].Placeholder
+			}
+			return items[i].Hash < items[j].Hash
+		})
+		record.Entities = append(record.Entities, auditEntity{Entity: entity, Count: len(items), Items: items})
+	}
+	sort.Slice(record.Entities, func(i, j int) bool {
+		return record.Entities[i].Entity < record.Entities[j].Entity
+	})
+	encoded, _ := json.Marshal(record)
+	return string(encoded)
+}
+
+// Match is a PII entity detected in content.
+type Match struct {
+	Entity string
+	// Start and End are the byte offsets of Value in content; End is exclusive.
+	Start int
+	End   int
+	Value string
+}
+
+// DetectPII returns the matches of entities in content in left-to-right order,
+// without modifying content. Overlapping matches are resolved so that the
+// longest wins, with ties broken by entity name and then by position.
+func DetectPII(content string, entities map[string]*regexp.Regexp) []Match {
+	return detectPII(content, entities, nil, nil)
+}
+
+// orderedEntities returns the names of entities by descending priority and
+// then by name. Detection iterates entities in this order so that results
+// never depend on map iteration order.
+func orderedEntities(entities map[string]*regexp.Regexp, priorities map[string]int) []string {
+	names := make([]string, 0, len(entities))
+	for entity := range entities {
+		names = append(names, entity)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if pi, pj := priorities[names[i]], priorities[names[j]]; pi != pj {
+			return pi > pj
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// spanSelector maps the submatch indices of a regex match for entity to the
+// span to report, or returns ok=false to discard the match.
+type spanSelector func(entity, content string, loc []int) (start, end int, ok bool)
+
+// detectPII implements DetectPII, narrowing or discarding each raw match with
+// selectSpan when it is non-nil. Overlaps are resolved in favor of the entity
+// with the higher priority before match length is considered.
+func detectPII(content string, entities map[string]*regexp.Regexp, priorities map[string]int, selectSpan spanSelector) []Match {
+	var candidates []Match
+	for _, entity := range orderedEntities(entities, priorities) {
+		for _, loc := range entities[entity].FindAllStringSubmatchIndex(content, -1) {
+			start, end := loc[0], loc[1]
+			if selectSpan != nil {
+				var ok bool
+				if start, end, ok = selectSpan(entity, content, loc); !ok {
+					continue
+				}
+			}
+			candidates = append(candidates, Match{Entity: entity, Start: start, End: end, Value: content[start:end]})
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if pi, pj := priorities[candidates[i].Entity], priorities[candidates[j].Entity]; pi != pj {
+			return pi > pj
+		}
+		li, lj := candidates[i].End-candidates[i].Start, candidates[j].End-candidates[j].Start
+		if li != lj {
+			return li > lj
+		}
+		if candidates[i].Entity != candidates[j].Entity {
+			return candidates[i].Entity < candidates[j].Entity
+		}
+		return candidates[i].Start < candidates[j].Start
+	})
+
+	var selected []Match
+	for _, candidate := range candidates {
+		overlaps := false
+		for _, chosen := range selected {
+			if candidate.Start < chosen.End && chosen.Start < candidate.End {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			selected = append(selected, candidate)
+		}
+	}
+
+	sort.Slice(selected, func(i, j int) bool { return selected[i].Start < selected[j].Start })
+	return selected
+}
+
+// maskTargetSpan is the spanSelector used by the policy: it narrows each
+// match to the configured mask group, if any, discards spans rejected by the
+// entity's validator and narrows the rest to the prefix the validator accepts,
+// and discards spans shorter than the entity's minLength.
+func (p *PIIMaskingRegexPolicy) maskTargetSpan(entity, content string, loc []int) (int, int, bool) {
+	start, end := loc[0], loc[1]
+	if group, hasGroup := p.params.maskGroups[entity]; hasGroup {
+		start, end = loc[2*group], loc[2*group+1]
+		if start < 0 || start == end {
+			// The mask group did not participate in this match.
+			return 0, 0, false
+		}
+	}
+	length := p.validMatchLength(entity, content[start:end])
+	if length == 0 {
+		return 0, 0, false
+	}
+	end = start + length
+	if minLength := p.params.entityMinLengths[entity]; minLength > 0 && utf8.RuneCountInString(content[start:end]) < minLength {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// resolvePIISpans detects the PII in content that the policy acts on. Matches
+// are validated and narrowed to their mask group before overlaps are
+// resolved. Allowlisted matches still take part in overlap resolution so that
+// no other entity masks part of them, but they are not returned.
+func (p *PIIMaskingRegexPolicy) resolvePIISpans(content string, piiEntities map[string]*regexp.Regexp) []Match {
+	matches := detectPII(content, piiEntities, p.params.entityPriorities, p.maskTargetSpan)
+	kept := matches[:0]
+	for _, match := range matches {
+		if !p.isAllowlisted(match.Value) {
+			kept = append(kept, match)
+		}
+	}
+	return kept
+}
+
+// normalizeAllowlistValue trims value and lower-cases it when the allowlist
+// is case-insensitive.
+func (params PIIMaskingRegexPolicyParams) normalizeAllowlistValue(value string) string {
+	value = strings.TrimSpace(value)
+	if params.allowlistCaseInsensitive {
+		value = strings.ToLower(value)
+	}
+	return value
+}
+
+// isAllowlisted reports whether a matched value must never be masked.
+func (p *PIIMaskingRegexPolicy) isAllowlisted(value string) bool {
+	if len(p.params.allowlist) == 0 {
+		return false
+	}
+	_, ok := p.params.allowlist[p.params.normalizeAllowlistValue(value)]
+	return ok
+}
+
+// rebuildWithSpans rebuilds content left to right, substituting each match
+// with the result of replace.
+func rebuildWithSpans(content string, matches []Match, replace func(Match) string) string {
+	var sb strings.Builder
+	last := 0
+	for _, match := range matches {
+		sb.WriteString(content[last:match.Start])
+		sb.WriteString(replace(match))
+		last = match.End
+	}
+	sb.WriteString(content[last:])
+	return sb.String()
+}
+
+// maskPIIFromContent masks PII from content using regex patterns
+func (p *PIIMaskingRegexPolicy) maskPIIFromContent(content string, piiEntities map[string]*regexp.Regexp, metadata map[string]interface{}, detected piiDetections) (string, error) {
+	if content == "" {
+		return "", nil
+	}
+
+	// Merge into any mapping already stored for this request (e.g. by an earlier
+	// jsonPath) so a value keeps one placeholder and placeholders never clash.
+	maskedPIIEntities, _ := metadata[MetadataKeyPIIEntities].(map[string]string)
+	if maskedPIIEntities == nil {
+		maskedPIIEntities = make(map[string]string)
+	}
+	counter := len(maskedPIIEntities)
+	usedPlaceholders := make(map[string]struct{}, len(maskedPIIEntities))
+	for _, placeholder := range maskedPIIEntities {
+		usedPlaceholders[placeholder] = struct{}{}
+	}
+
+	spans := p.resolvePIISpans(content, piiEntities)
+	if len(spans) == 0 {
+		return "", nil
+	}
+
+	replacementFor := func(span Match) string {
+		match := span.Value
+		if p.redactsEntity(span.Entity) {
+			// Redacted values are irreversible and never recorded for restoration.
+			return p.redactionFor(span.Entity, match)
+		}
+		if p.params.PreserveLastN > 0 {
+			// Partially masked values are not unique, so they are never
+			// recorded for restoration.
+			return partialMask(match, p.params.PreserveLastN)
+		}
+		if placeholder, known := maskedPIIEntities[match]; known {
+			return placeholder
+		}
+		// Generate unique placeholder like [EMAIL_0000]
+		var placeholder string
+		if p.params.DeterministicPlaceholders {
+			placeholder = p.params.placeholders.deterministic(span.Entity, match, usedPlaceholders)
+		} else {
+			placeholder = p.params.placeholders.format(span.Entity, counter)
+			counter++
+		}
+		usedPlaceholders[placeholder] = struct{}{}
+		maskedPIIEntities[match] = placeholder
+		return placeholder
+	}
+
+	changed := false
+	maskedContent := rebuildWithSpans(content, spans, func(span Match) string {
+		if p.params.placeholders.exact.MatchString(span.Value) {
+			// Already a placeholder, e.g. from an earlier masking policy.
+			return span.Value
+		}
+		changed = true
+		replacement := replacementFor(span)
+		detected.add(span.Entity, span.Value, replacement)
+		return replacement
+	})
 
 	// Store PII mappings in metadata for response restoration
 	if len(maskedPIIEntities) > 0 {
 		metadata[MetadataKeyPIIEntities] = maskedPIIEntities
 	}
 
-	if len(allMatches) > 0 {
+	if changed {
 		return maskedContent, nil
 	}
 
 	return "", nil
 }
 
-// redactPIIFromContent redacts PII from content using regex patterns
-func (p *PIIMaskingRegexPolicy) redactPIIFromContent(content string, piiEntities map[string]*regexp.Regexp) string {
-	if content == "" {
-		return ""
-	}
+// redactPIIFromContent redacts PII from content using regex patterns
+func (p *PIIMaskingRegexPolicy) redactPIIFromContent(content string, piiEntities map[string]*regexp.Regexp, detected piiDetections) string {
+	if content == "" {
+		return ""
+	}
+
+	spans := p.resolvePIISpans(content, piiEntities)
+	if len(spans) == 0 {
+		return ""
+	}
+
+	return rebuildWithSpans(content, spans, func(span Match) string {
+		replacement := p.redactionFor(span.Entity, span.Value)
+		detected.add(span.Entity, span.Value, replacement)
+		return replacement
+	})
+}
+
+// redactionFor returns the replacement for a redacted match according to the
+// configured redaction style.
+func (p *PIIMaskingRegexPolicy) redactionFor(entity, match string) string {
+	if p.params.HashPII {
+		return hashedToken(entity, match, p.params.HashSalt)
+	}
+	if p.params.PreserveLastN > 0 {
+		return partialMask(match, p.params.PreserveLastN)
+	}
+	switch p.params.RedactionStyle {
+	case RedactionStyleLengthPreserving:
+		return strings.Repeat("*", utf8.RuneCountInString(match))
+	case RedactionStyleTag:
+		return "[REDACTED_" + entity + "]"
+	default:
+		return "*****"
+	}
+}
+
+// hashedToken returns <entity>:<hex>, where hex is the first 8 bytes of the
+// HMAC-SHA256 of match keyed with salt.
+func hashedToken(entity, match, salt string) string {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(match))
+	return entity + ":" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// redactsEntity reports whether matches of entity are redacted (or hashed)
+// rather than masked with a restorable placeholder. A per-entity mode takes
+// precedence over the global redactPII and hashPII settings.
+func (p *PIIMaskingRegexPolicy) redactsEntity(entity string) bool {
+	if mode, ok := p.params.entityModes[entity]; ok {
+		return mode == EntityModeRedact
+	}
+	return p.params.RedactPII || p.params.HashPII
+}
+
+// redactsAllEntities reports whether no configured entity is masked.
+func (p *PIIMaskingRegexPolicy) redactsAllEntities() bool {
+	for entity := range p.params.PIIEntities {
+		if !p.redactsEntity(entity) {
+			return false
+		}
+	}
+	return true
+}
+
+// restoresResponses reports whether masked placeholders are restored in
+// responses. Redacted and hashed values are never restorable, and in audit-only
+// mode no placeholders reach the upstream.
+func (p *PIIMaskingRegexPolicy) restoresResponses() bool {
+	return !p.redactsAllEntities() && !p.params.AuditOnly
+}
+
+// partialMask replaces every alphanumeric character of match except the last
+// keep with '*', leaving separators in place. A match with no more than keep
+// alphanumeric characters is masked entirely so it is never revealed in full.
+func partialMask(match string, keep int) string {
+	runes := []rune(match)
+	alphanumerics := 0
+	for _, r := range runes {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			alphanumerics++
+		}
+	}
+	if alphanumerics <= keep {
+		keep = 0
+	}
+
+	seen := 0
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			continue
+		}
+		if seen < alphanumerics-keep {
+			runes[i] = '*'
+		}
+		seen++
+	}
+	return string(runes)
+}
+
+// restorePIIInResponse handles PII restoration in responses when redactPII is disabled
+func (p *PIIMaskingRegexPolicy) restorePIIInResponse(originalContent string, maskedPIIEntities map[string]string) string {
+	if len(maskedPIIEntities) == 0 {
+		return originalContent
+	}
+
+	restoreMap := make(map[string]string, len(maskedPIIEntities))
+	for original, placeholder := range maskedPIIEntities {
+		restoreMap[placeholder] = original
+	}
+	return newRestoreReplacer(restoreMap, nil).Replace(originalContent)
+}
+
+// newRestoreReplacer returns a replacer that substitutes each placeholder in
+// maskedMap (placeholder → original) with its original value, passed through
+// escape when non-nil. Content is rewritten in a single left-to-right pass, so
+// restored values are never rescanned and adjacent placeholders keep their
+// separators. Longer placeholders are tried first when one is a prefix of
+// another.
+func newRestoreReplacer(maskedMap map[string]string, escape func(string) string) *strings.Replacer {
+	placeholders := make([]string, 0, len(maskedMap))
+	for placeholder := range maskedMap {
+		placeholders = append(placeholders, placeholder)
+	}
+	sort.Slice(placeholders, func(i, j int) bool {
+		if len(placeholders[i]) != len(placeholders[j]) {
+			return len(placeholders[i]) > len(placeholders[j])
+		}
+		return placeholders[i] < placeholders[j]
+	})
+	oldnew := make([]string, 0, 2*len(placeholders))
+	for _, placeholder := range placeholders {
+		original := maskedMap[placeholder]
+		if escape != nil {
+			original = escape(original)
+		}
+		oldnew = append(oldnew, placeholder, original)
+	}
+	return strings.NewReplacer(oldnew...)
+}
+
+// maskedPathUpdate holds the masked content produced for one jsonPath.
+type maskedPathUpdate struct {
+	jsonPath        string
+	modifiedContent string
+}
+
+// updatePayloadWithMaskedContent updates the original payload by replacing the
+// extracted content at each updated jsonPath.
+func (p *PIIMaskingRegexPolicy) updatePayloadWithMaskedContent(originalPayload []byte, updates []maskedPathUpdate) []byte {
+	if len(updates) == 1 && updates[0].jsonPath == "" {
+		// If no JSONPath, the entire payload was processed, return the modified content
+		return []byte(updates[0].modifiedContent)
+	}
+
+	// If JSONPath is specified, update only the specific fields in the JSON structure
+	var jsonData map[string]interface{}
+	if err := json.Unmarshal(originalPayload, &jsonData); err != nil {
+		// Fallback to returning the modified content as-is
+		return []byte(updates[len(updates)-1].modifiedContent)
+	}
+
+	// Set the new values at the JSONPath locations
+	for _, update := range updates {
+		if err := utils.SetValueAtJSONPath(jsonData, update.jsonPath, update.modifiedContent); err != nil {
+			// Fallback to returning the original payload
+			return originalPayload
+		}
+	}
+
+	// Marshal back to JSON to get the full modified payload
+	updatedPayload, err := json.Marshal(jsonData)
+	if err != nil {
+		// Fallback to returning the original payload
+		return originalPayload
+	}
+
+	return updatedPayload
+}
+
+// OnRequestHeaders implements v2alpha.RequestHeaderPolicy.
+// PII masking operates on the body, so headers are passed through unchanged.
+func (p *PIIMaskingRegexPolicy) OnRequestHeaders(ctx context.Context, reqCtx *policy.RequestHeaderContext, params map[string]interface{}) policy.RequestHeaderAction {
+	return policy.UpstreamRequestHeaderModifications{}
+}
+
+// OnResponseHeaders implements v2alpha.ResponseHeaderPolicy. When
+// summaryHeader is set and PII was masked or redacted in the request, the
+// header is set to the number of distinct values affected; otherwise headers
+// are passed through unchanged.
+func (p *PIIMaskingRegexPolicy) OnResponseHeaders(ctx context.Context, respCtx *policy.ResponseHeaderContext, params map[string]interface{}) policy.ResponseHeaderAction {
+	if p.params.SummaryHeader == "" || p.params.AuditOnly || respCtx.SharedContext == nil {
+		return policy.DownstreamResponseHeaderModifications{}
+	}
+	counts, _ := respCtx.Metadata[MetadataKeyPIICounts].(map[string]int)
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	if total == 0 {
+		return policy.DownstreamResponseHeaderModifications{}
+	}
+	return policy.DownstreamResponseHeaderModifications{
+		HeadersToSet: map[string]string{p.params.SummaryHeader: strconv.Itoa(total)},
+	}
+}
+
+// OnRequestBody masks PII in the request body before forwarding to upstream.
+func (p *PIIMaskingRegexPolicy) OnRequestBody(ctx context.Context, reqCtx *policy.RequestContext, _ map[string]interface{}) policy.RequestAction {
+	return p.processRequestBody(reqCtx, nil)
+}
+
+// processRequestBody masks PII in the request body before forwarding to upstream.
+// Placeholders (e.g. [EMAIL_0000]) or redaction markers (*****) replace
+// detected PII. Placeholder→original mappings are stored in shared metadata
+// so processResponseBody can restore them.
+func (p *PIIMaskingRegexPolicy) processRequestBody(reqCtx *policy.RequestContext, params map[string]interface{}) policy.RequestAction {
+	if len(p.params.PIIEntities) == 0 {
+		return policy.UpstreamRequestModifications{}
+	}
+
+	if reqCtx.Body == nil || reqCtx.Body.Content == nil {
+		return policy.UpstreamRequestModifications{}
+	}
+	payload := reqCtx.Body.Content
+
+	// Gzip-encoded bodies are masked decompressed and re-compressed below;
+	// the Content-Encoding header is left unchanged.
+	gzipped := isGzipEncoded(reqCtx.Headers)
+	if gzipped {
+		decompressed, err := gunzip(payload)
+		if err != nil {
+			return p.requestError(ErrorCodeBodyDecode, fmt.Sprintf("error decompressing gzip request body: %v", err))
+		}
+		payload = decompressed
+	}
+
+	documents, err := bodyformat.Split(payload, p.params.BodyFormat)
+	if err != nil {
+		return p.requestError(ErrorCodeBodyDecode, fmt.Sprintf("error splitting request body: %v", err))
+	}
+	updates := make([][]maskedPathUpdate, len(documents))
+	detected := make(piiDetections)
+	for i, document := range documents {
+		if p.params.BodyFormat == bodyformat.NDJSON && bodyformat.IsBlank(document) {
+			continue
+		}
+		documentUpdates, errAction := p.maskDocument(reqCtx, document, detected)
+		if errAction != nil {
+			return errAction
+		}
+		updates[i] = documentUpdates
+	}
+
+	if len(detected) > 0 {
+		if reqCtx.Metadata == nil {
+			reqCtx.Metadata = make(map[string]interface{})
+		}
+		reqCtx.Metadata[MetadataKeyPIICounts] = detected.counts()
+		if p.params.AuditRecord {
+			reqCtx.Metadata[MetadataKeyPIIAudit] = detected.auditRecord(p.params.HashSalt)
+		}
+	}
+
+	if p.params.AuditOnly {
+		if len(detected) > 0 {
+			slog.Debug("PIIMaskingRegex: audit-only mode, request body left unchanged", "counts", detected.counts())
+		}
+		return policy.UpstreamRequestModifications{}
+	}
+
+	modified := false
+	for i, documentUpdates := range updates {
+		if len(documentUpdates) > 0 {
+			documents[i] = p.updatePayloadWithMaskedContent(documents[i], documentUpdates)
+			modified = true
+		}
+	}
+	if modified {
+		body := bodyformat.Join(documents, p.params.BodyFormat)
+		if gzipped {
+			compressed, err := gzipBytes(body)
+			if err != nil {
+				return p.requestError(ErrorCodeBodyEncode, fmt.Sprintf("error compressing masked request body: %v", err))
+			}
+			body = compressed
+		}
+		return policy.UpstreamRequestModifications{
+			Body: body,
+		}
+	}
+
+	return policy.UpstreamRequestModifications{}
+}
+
+// maskDocument masks PII at the content paths of a single document of the
+// request body, recording detections in detected. It returns the updates to
+// apply to the document, or an action when processing fails.
+func (p *PIIMaskingRegexPolicy) maskDocument(reqCtx *policy.RequestContext, document []byte, detected piiDetections) ([]maskedPathUpdate, policy.RequestAction) {
+	if p.params.Recursive {
+		if update, errAction, ok := p.maskDocumentRecursive(reqCtx, document, detected); ok {
+			if errAction != nil || update == nil {
+				return nil, errAction
+			}
+			return []maskedPathUpdate{*update}, nil
+		}
+	}
+
+	var updates []maskedPathUpdate
+	for _, jsonPath := range p.contentPaths(document) {
+		extractedValue, ok, err := extractStringFromPath(document, jsonPath)
+		if err != nil {
+			return nil, p.requestError(ErrorCodeJSONPathInvalid, fmt.Sprintf("error extracting value from JSONPath %q: %v", jsonPath, err))
+		}
+		if !ok {
+			// Value at path is not a scalar or a content-part array; skip masking.
+			continue
+		}
+
+		if p.params.MaxInputBytes > 0 && len(extractedValue) > p.params.MaxInputBytes {
+			return nil, p.requestError(ErrorCodeInputTooLarge, fmt.Sprintf("content at JSONPath %q is %d bytes, exceeding maxInputBytes %d",
+				jsonPath, len(extractedValue), p.params.MaxInputBytes))
+		}
 
-	maskedContent := content
-	foundAndMasked := false
+		if jsonPath != "" {
+			extractedValue = textCleanRegexCompiled.ReplaceAllString(extractedValue, "")
+			extractedValue = strings.TrimSpace(extractedValue)
+		}
 
-	for _, pattern := range piiEntities {
-		if pattern.MatchString(maskedContent) {
-			foundAndMasked = true
-			maskedContent = pattern.ReplaceAllString(maskedContent, "*****")
+		modifiedContent, err := p.maskContent(reqCtx, extractedValue, detected)
+		if err != nil {
+			return nil, p.requestError(ErrorCodeMaskingFailed, fmt.Sprintf("error masking PII: %v", err))
 		}
-	}
 
-	if foundAndMasked {
-		return maskedContent
+		if modifiedContent != "" && modifiedContent != extractedValue {
+			updates = append(updates, maskedPathUpdate{jsonPath: jsonPath, modifiedContent: modifiedContent})
+		}
 	}
-
-	return ""
+	return updates, nil
 }
 
-// restorePIIInResponse handles PII restoration in responses when redactPII is disabled
-func (p *PIIMaskingRegexPolicy) restorePIIInResponse(originalContent string, maskedPIIEntities map[string]string) string {
-	if len(maskedPIIEntities) == 0 {
-		return originalContent
+// maskContent masks or redacts the PII in a single value of the request body,
+// recording detections in detected. It returns "" when nothing was found.
+func (p *PIIMaskingRegexPolicy) maskContent(reqCtx *policy.RequestContext, content string, detected piiDetections) (string, error) {
+	if p.redactsAllEntities() {
+		return p.redactPIIFromContent(content, p.params.PIIEntities, detected), nil
 	}
+	if reqCtx.Metadata == nil {
+		reqCtx.Metadata = make(map[string]interface{})
+	}
+	return p.maskPIIFromContent(content, p.params.PIIEntities, reqCtx.Metadata, detected)
+}
 
-	transformedContent := originalContent
-
-	for original, placeholder := range maskedPIIEntities {
-		if strings.Contains(transformedContent, placeholder) {
-			transformedContent = strings.ReplaceAll(transformedContent, placeholder, original)
+// maskDocumentRecursive masks PII in every string leaf of the objects and
+// arrays at the configured jsonPaths, or of the whole document when jsonPath
+// is empty. Object keys are visited in sorted order so placeholders are
+// assigned deterministically, and all leaves share the request's placeholder
+// mapping. Non-string leaves are preserved as is. It returns the re-encoded
+// document as a whole-payload update, or nil when nothing changed. ok is false
+// when the document is not JSON and jsonPath is empty, in which case it is
+// masked as plain text instead.
+func (p *PIIMaskingRegexPolicy) maskDocumentRecursive(reqCtx *policy.RequestContext, document []byte, detected piiDetections) (update *maskedPathUpdate, errAction policy.RequestAction, ok bool) {
+	root, err := decodeJSONPreservingNumbers(document)
+	if err != nil {
+		if len(p.params.JsonPaths) == 1 && p.params.JsonPaths[0] == "" {
+			return nil, nil, false
 		}
+		return nil, p.requestError(ErrorCodeBodyDecode, fmt.Sprintf("error decoding request body: %v", err)), true
 	}
 
-	return transformedContent
-}
+	changed := false
+	for _, jsonPath := range p.params.JsonPaths {
+		if jsonPath == "" {
+			masked, rootChanged, errAction := p.maskStringLeaves(reqCtx, root, "$", detected)
+			if errAction != nil {
+				return nil, errAction, true
+			}
+			root = masked
+			changed = changed || rootChanged
+			continue
+		}
 
-// updatePayloadWithMaskedContent updates the original payload by replacing the extracted content
-func (p *PIIMaskingRegexPolicy) updatePayloadWithMaskedContent(originalPayload []byte, extractedValue, modifiedContent string, jsonPath string) []byte {
-	if jsonPath == "" {
-		// If no JSONPath, the entire payload was processed, return the modified content
-		return []byte(modifiedContent)
+		object, isObject := root.(map[string]interface{})
+		if !isObject {
+			return nil, p.requestError(ErrorCodeJSONPathInvalid, fmt.Sprintf("error extracting value from JSONPath %q: request body is not a JSON object", jsonPath)), true
+		}
+		target, err := utils.ExtractValueFromJsonpath(object, jsonPath)
+		if err != nil {
+			return nil, p.requestError(ErrorCodeJSONPathInvalid, fmt.Sprintf("error extracting value from JSONPath %q: %v", jsonPath, err)), true
+		}
+		masked, targetChanged, errAction := p.maskStringLeaves(reqCtx, target, normalizeJSONPath(jsonPath), detected)
+		if errAction != nil {
+			return nil, errAction, true
+		}
+		if !targetChanged {
+			continue
+		}
+		// Objects and arrays were updated in place; a string target is written back.
+		if text, isString := masked.(string); isString {
+			if err := utils.SetValueAtJSONPath(object, jsonPath, text); err != nil {
+				return nil, p.requestError(ErrorCodeMaskingFailed, fmt.Sprintf("error updating JSONPath %q: %v", jsonPath, err)), true
+			}
+		}
+		changed = true
 	}
-
-	// If JSONPath is specified, update only the specific field in the JSON structure
-	var jsonData map[string]interface{}
-	if err := json.Unmarshal(originalPayload, &jsonData); err != nil {
-		// Fallback to returning the modified content as-is
-		return []byte(modifiedContent)
+	if !changed {
+		return nil, nil, true
 	}
 
-	// Set the new value at the JSONPath location
-	err := utils.SetValueAtJSONPath(jsonData, jsonPath, modifiedContent)
+	encoded, err := json.Marshal(root)
 	if err != nil {
-		// Fallback to returning the original payload
-		return originalPayload
+		return nil, p.requestError(ErrorCodeBodyEncode, fmt.Sprintf("error encoding masked request body: %v", err)), true
 	}
+	return &maskedPathUpdate{modifiedContent: string(encoded)}, nil, true
+}
 
-	// Marshal back to JSON to get the full modified payload
-	updatedPayload, err := json.Marshal(jsonData)
-	if err != nil {
-		// Fallback to returning the original payload
-		return originalPayload
+// maskStringLeaves masks PII in every string leaf of value, visiting object
+// values and array elements at any depth. Objects and arrays are updated in
+// place. leafPath is the path of value, such as $.user.tags[0]; values at
+// skipPaths are returned verbatim along with everything beneath them. It
+// returns the masked value and whether it changed; non-string leaves are
+// returned as is.
+func (p *PIIMaskingRegexPolicy) maskStringLeaves(reqCtx *policy.RequestContext, value interface{}, leafPath string, detected piiDetections) (interface{}, bool, policy.RequestAction) {
+	if _, skip := p.params.skipPaths[leafPath]; skip {
+		return value, false, nil
+	}
+	switch v := value.(type) {
+	case string:
+		masked, errAction := p.maskLeaf(reqCtx, v, leafPath, detected)
+		if errAction != nil {
+			return nil, false, errAction
+		}
+		return masked, masked != v, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		changed := false
+		for _, key := range keys {
+			masked, childChanged, errAction := p.maskStringLeaves(reqCtx, v[key], leafPath+"."+key, detected)
+			if errAction != nil {
+				return nil, false, errAction
+			}
+			if childChanged {
+				v[key] = masked
+				changed = true
+			}
+		}
+		return v, changed, nil
+	case []interface{}:
+		changed := false
+		for i, element := range v {
+			masked, childChanged, errAction := p.maskStringLeaves(reqCtx, element, fmt.Sprintf("%s[%d]", leafPath, i), detected)
+			if errAction != nil {
+				return nil, false, errAction
+			}
+			if childChanged {
+				v[i] = masked
+				changed = true
+			}
+		}
+		return v, changed, nil
+	default:
+		return value, false, nil
 	}
-
-	return updatedPayload
 }
 
-// OnRequestHeaders implements v2alpha.RequestHeaderPolicy.
-// PII masking operates on the body, so headers are passed through unchanged.
-func (p *PIIMaskingRegexPolicy) OnRequestHeaders(ctx context.Context, reqCtx *policy.RequestHeaderContext, params map[string]interface{}) policy.RequestHeaderAction {
-	return policy.UpstreamRequestHeaderModifications{}
+// maskLeaf masks PII in a single string leaf, enforcing maxInputBytes. It
+// returns the leaf unchanged when nothing was found.
+func (p *PIIMaskingRegexPolicy) maskLeaf(reqCtx *policy.RequestContext, text, leafPath string, detected piiDetections) (string, policy.RequestAction) {
+	if p.params.MaxInputBytes > 0 && len(text) > p.params.MaxInputBytes {
+		return "", p.requestError(ErrorCodeInputTooLarge, fmt.Sprintf("content at %q is %d bytes, exceeding maxInputBytes %d",
+			leafPath, len(text), p.params.MaxInputBytes))
+	}
+	masked, err := p.maskContent(reqCtx, text, detected)
+	if err != nil {
+		return "", p.requestError(ErrorCodeMaskingFailed, fmt.Sprintf("error masking PII: %v", err))
+	}
+	if masked == "" {
+		return text, nil
+	}
+	return masked, nil
 }
 
-// OnResponseHeaders implements v2alpha.ResponseHeaderPolicy.
-// PII masking operates on the body, so headers are passed through unchanged.
-func (p *PIIMaskingRegexPolicy) OnResponseHeaders(ctx context.Context, respCtx *policy.ResponseHeaderContext, params map[string]interface{}) policy.ResponseHeaderAction {
-	return policy.DownstreamResponseHeaderModifications{}
+// normalizeJSONPath returns jsonPath with surrounding whitespace removed and a
+// leading "$." added when it is missing, so it can be compared with the paths
+// built by the recursive walk.
+func normalizeJSONPath(jsonPath string) string {
+	jsonPath = strings.TrimSpace(jsonPath)
+	if jsonPath == "$" || strings.HasPrefix(jsonPath, "$.") || strings.HasPrefix(jsonPath, "$[") {
+		return jsonPath
+	}
+	return "$." + jsonPath
 }
 
-// OnRequestBody masks PII in the request body before forwarding to upstream.
-func (p *PIIMaskingRegexPolicy) OnRequestBody(ctx context.Context, reqCtx *policy.RequestContext, _ map[string]interface{}) policy.RequestAction {
-	return p.processRequestBody(reqCtx, nil)
+// decodeJSONPreservingNumbers decodes a single JSON value, keeping numbers as
+// json.Number so they are re-encoded exactly as received.
+func decodeJSONPreservingNumbers(content []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after JSON value")
+	}
+	return value, nil
 }
 
-// processRequestBody masks PII in the request body before forwarding to upstream.
-// Placeholders (e.g. [EMAIL_0000]) or redaction markers (*****) replace
-// detected PII. Placeholder→original mappings are stored in shared metadata
-// so processResponseBody can restore them.
-func (p *PIIMaskingRegexPolicy) processRequestBody(reqCtx *policy.RequestContext, params map[string]interface{}) policy.RequestAction {
-	if len(p.params.PIIEntities) == 0 {
-		return policy.UpstreamRequestModifications{}
+// isGzipEncoded reports whether the request body is encoded with gzip alone.
+// Bodies with any other or additional content coding are left opaque.
+func isGzipEncoded(headers *policy.Headers) bool {
+	if headers == nil {
+		return false
 	}
-
-	if reqCtx.Body == nil || reqCtx.Body.Content == nil {
-		return policy.UpstreamRequestModifications{}
+	var encodings []string
+	for _, value := range headers.Get("content-encoding") {
+		for _, encoding := range strings.Split(value, ",") {
+			if encoding = strings.TrimSpace(encoding); encoding != "" && !strings.EqualFold(encoding, "identity") {
+				encodings = append(encodings, strings.ToLower(encoding))
+			}
+		}
 	}
-	payload := reqCtx.Body.Content
+	return len(encodings) == 1 && (encodings[0] == "gzip" || encodings[0] == "x-gzip")
+}
 
-	extractedValue, ok, err := extractStringFromPath(payload, p.params.JsonPath)
+// gunzip decompresses a gzip stream.
+func gunzip(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
 	if err != nil {
-		return p.buildErrorResponse(fmt.Sprintf("error extracting value from JSONPath: %v", err)).(policy.RequestAction)
-	}
-	if !ok {
-		// Value at path is not a scalar (e.g. multimodal content array); skip masking.
-		return policy.UpstreamRequestModifications{}
+		return nil, err
 	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
 
-	extractedValue = textCleanRegexCompiled.ReplaceAllString(extractedValue, "")
-	extractedValue = strings.TrimSpace(extractedValue)
-
-	var modifiedContent string
-	if p.params.RedactPII {
-		modifiedContent = p.redactPIIFromContent(extractedValue, p.params.PIIEntities)
-	} else {
-		if reqCtx.Metadata == nil {
-			reqCtx.Metadata = make(map[string]interface{})
-		}
-		modifiedContent, err = p.maskPIIFromContent(extractedValue, p.params.PIIEntities, reqCtx.Metadata)
-		if err != nil {
-			return p.buildErrorResponse(fmt.Sprintf("error masking PII: %v", err)).(policy.RequestAction)
-		}
+// gzipBytes compresses data as a gzip stream.
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
 	}
-
-	if modifiedContent != "" && modifiedContent != extractedValue {
-		modifiedPayload := p.updatePayloadWithMaskedContent(payload, extractedValue, modifiedContent, p.params.JsonPath)
-		return policy.UpstreamRequestModifications{
-			Body: modifiedPayload,
-		}
+	if err := writer.Close(); err != nil {
+		return nil, err
 	}
-
-	return policy.UpstreamRequestModifications{}
+	return buf.Bytes(), nil
 }
 
 // OnResponseBody restores PII placeholders in a buffered response body.
@@ -411,71 +1973,155 @@ func (p *PIIMaskingRegexPolicy) OnResponseBody(ctx context.Context, respCtx *pol
 	return p.processResponseBody(respCtx, nil)
 }
 
-// processResponseBody restores PII placeholders in a buffered response body.
+// processResponseBody redacts and restores PII in a buffered response body.
+//
+// When redactResponse is enabled, PII at the configured jsonPath is redacted
+// first, so that new PII from the upstream is removed while placeholders for
+// values the client sent are still restored afterwards.
 //
-// Two body formats are handled:
+// Two body formats are handled for restoration:
 //   - Plain JSON (non-streaming): choices[*].message.content
 //   - SSE-buffered (chunked transfer of streaming response that this chain could not
 //     process in streaming mode): multiple "data: {...}" lines, choices[*].delta.content.
 //     The same restoreSSEChunk logic used by OnResponseBodyChunk is reused here.
 func (p *PIIMaskingRegexPolicy) processResponseBody(respCtx *policy.ResponseContext, params map[string]interface{}) policy.ResponseAction {
-	if p.params.RedactPII {
+	if respCtx.ResponseBody == nil || respCtx.ResponseBody.Content == nil {
 		return policy.DownstreamResponseModifications{}
 	}
 
-	maskedPII, exists := respCtx.Metadata[MetadataKeyPIIEntities]
-	if !exists {
-		return policy.DownstreamResponseModifications{}
+	body := respCtx.ResponseBody.Content
+	redacted := false
+	if p.params.RedactResponse {
+		updated, err := p.redactResponsePayload(body)
+		if err != nil {
+			return p.responseError(ErrorCodeInputTooLarge, err.Error())
+		}
+		if updated != nil {
+			body = updated
+			redacted = true
+		}
 	}
 
-	maskedPIIMap, ok := maskedPII.(map[string]string)
-	if !ok || len(maskedPIIMap) == 0 {
-		return policy.DownstreamResponseModifications{}
+	modified := redacted
+	restoreMap := p.responseRestoreMap(respCtx.Metadata)
+	if restored := p.restoreResponsePayload(string(body), restoreMap); restored != nil {
+		body = restored
+		modified = true
+	}
+	if p.params.ScrubUnrestoredPlaceholders {
+		if scrubbed := scrubUnrestoredPlaceholders(body, restoreMap); scrubbed != nil {
+			body = scrubbed
+			modified = true
+		}
 	}
 
-	if respCtx.ResponseBody == nil || respCtx.ResponseBody.Content == nil {
-		return policy.DownstreamResponseModifications{}
+	if modified {
+		return policy.DownstreamResponseModifications{Body: body}
 	}
+	return policy.DownstreamResponseModifications{}
+}
 
-	bodyStr := string(respCtx.ResponseBody.Content)
+// scrubUnrestoredPlaceholders replaces any of this request's placeholders still
+// present in body with UnrestoredPlaceholderFallback, so they never reach the
+// client. restoreMap is placeholder → original. It returns nil when no
+// placeholder remained.
+func scrubUnrestoredPlaceholders(body []byte, restoreMap map[string]string) []byte {
+	scrubbed := body
+	changed := false
+	for placeholder := range restoreMap {
+		if bytes.Contains(scrubbed, []byte(placeholder)) {
+			scrubbed = bytes.ReplaceAll(scrubbed, []byte(placeholder), []byte(UnrestoredPlaceholderFallback))
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	slog.Debug("PIIMaskingRegex: Scrubbed unrestored placeholders from response")
+	return scrubbed
+}
 
+// responseRestoreMap returns the placeholder→original map for this request, or
+// nil when restoration is disabled or nothing was masked.
+func (p *PIIMaskingRegexPolicy) responseRestoreMap(metadata map[string]interface{}) map[string]string {
+	if !p.restoresResponses() {
+		return nil
+	}
+	maskedPIIMap, ok := metadata[MetadataKeyPIIEntities].(map[string]string)
+	if !ok || len(maskedPIIMap) == 0 {
+		return nil
+	}
 	// maskedPIIMap is keyed original→placeholder (set by maskPIIFromContent).
 	// The restore helpers expect placeholder→original, so invert before use.
-	restoreMap := invertStringMap(maskedPIIMap)
+	return invertStringMap(maskedPIIMap)
+}
+
+// restoreResponsePayload restores placeholders in a complete response body. It
+// returns nil when nothing was restored.
+func (p *PIIMaskingRegexPolicy) restoreResponsePayload(bodyStr string, restoreMap map[string]string) []byte {
+	if len(restoreMap) == 0 {
+		return nil
+	}
 
 	if isSSEChunk(bodyStr) {
 		// SSE-buffered: reuse the streaming restoration logic.
-		action := p.restoreSSEChunk(bodyStr, restoreMap)
-		if action.Body == nil {
-			return policy.DownstreamResponseModifications{}
-		}
-		return policy.DownstreamResponseModifications{Body: action.Body}
+		return p.restoreSSEChunk(bodyStr, restoreMap).Body
 	}
 
 	// Plain JSON buffered response: try OpenAI choices[*].message.content first,
 	// then fall back to raw placeholder replacement for generic JSON structures.
-	updatedJSON, changed := restoreInChoices(bodyStr, restoreMap, "message")
-	if changed {
-		return policy.DownstreamResponseModifications{Body: []byte(updatedJSON)}
+	// In recursive mode placeholders may be echoed in any field, so the whole
+	// body is restored.
+	if !p.params.Recursive {
+		updatedJSON, changed := p.restoreInChoices(bodyStr, restoreMap, "message")
+		if changed {
+			return []byte(updatedJSON)
+		}
 	}
 
 	// Fallback: restore placeholders directly in the raw JSON bytes.
-	action := p.restoreJSONChunk(bodyStr, restoreMap)
-	if action.Body != nil {
-		return policy.DownstreamResponseModifications{Body: action.Body}
+	return p.restoreJSONChunk(bodyStr, restoreMap).Body
+}
+
+// redactResponsePayload redacts PII at the configured jsonPath of a response
+// payload. Paths missing from the response are skipped. It returns nil when
+// nothing was redacted, and an error when content exceeds maxInputBytes.
+func (p *PIIMaskingRegexPolicy) redactResponsePayload(payload []byte) ([]byte, error) {
+	var updates []maskedPathUpdate
+	for _, jsonPath := range p.contentPaths(payload) {
+		extractedValue, ok, err := extractStringFromPath(payload, jsonPath)
+		if err != nil || !ok {
+			continue
+		}
+		if p.params.MaxInputBytes > 0 && len(extractedValue) > p.params.MaxInputBytes {
+			return nil, fmt.Errorf("response content at JSONPath %q is %d bytes, exceeding maxInputBytes %d",
+				jsonPath, len(extractedValue), p.params.MaxInputBytes)
+		}
+		if jsonPath != "" {
+			extractedValue = textCleanRegexCompiled.ReplaceAllString(extractedValue, "")
+			extractedValue = strings.TrimSpace(extractedValue)
+		}
+		redacted := p.redactPIIFromContent(extractedValue, p.params.PIIEntities, make(piiDetections))
+		if redacted != "" && redacted != extractedValue {
+			updates = append(updates, maskedPathUpdate{jsonPath: jsonPath, modifiedContent: redacted})
+		}
 	}
-	return policy.DownstreamResponseModifications{}
+	if len(updates) == 0 {
+		return nil, nil
+	}
+	return p.updatePayloadWithMaskedContent(payload, updates), nil
 }
 
 // NeedsMoreResponseData implements v2alpha.StreamingResponsePolicy.
-// Returns true when the accumulated SSE delta.content contains an unclosed '['
-// that may be a PII placeholder, so the kernel keeps buffering until ']' arrives
-// or 5 more SSE data lines have passed (whichever comes first).
+// Returns true when the accumulated SSE delta.content contains an unclosed
+// placeholder opening (e.g. '[' for the default format) that may be a PII
+// placeholder, so the kernel keeps buffering until the closing text arrives or
+// 5 more SSE data lines have passed (whichever comes first).
 //
 // For non-SSE (plain JSON) responses delivered via chunked transfer encoding,
 // accumulates until the full JSON body is complete and parseable.
 func (p *PIIMaskingRegexPolicy) NeedsMoreResponseData(accumulated []byte) bool {
-	if p.params.RedactPII {
+	if !p.restoresResponses() && !p.params.RedactResponse {
 		return false
 	}
 
@@ -484,58 +2130,128 @@ func (p *PIIMaskingRegexPolicy) NeedsMoreResponseData(accumulated []byte) bool {
 	if !isSSEChunk(s) {
 		return !json.Valid(bytes.TrimSpace(accumulated))
 	}
-
-	content, openBracketDataLineIdx, totalDataLines := extractSSEDeltaContentTracked(s)
-
-	lastOpen := strings.LastIndex(content, "[")
-	if lastOpen == -1 {
+	if !p.restoresResponses() {
+		// SSE responses are not redacted, so there is nothing to wait for.
 		return false
 	}
 
-	afterBracket := content[lastOpen+1:]
-	if strings.Contains(afterBracket, "]") {
+	placeholders := p.params.placeholders
+	content, openBracketDataLineIdx, totalDataLines := extractSSEDeltaContentTracked(s, placeholders.open)
+	if !placeholders.endsInPartialPlaceholder(content) {
 		return false
 	}
+	if !strings.Contains(content, placeholders.open) {
+		// Only part of a multi-character opening has arrived.
+		return true
+	}
 
-	// Unclosed '[' found — wait, but no more than 5 data lines after it.
+	// Unclosed opening found — wait, but no more than 5 data lines after it.
 	dataLinesAfterOpen := totalDataLines - openBracketDataLineIdx - 1
 	return dataLinesAfterOpen <= 5
 }
 
 // OnResponseBodyChunk implements v2alpha.StreamingResponsePolicy.
-// Restores masked PII in response chunks.
+// Restores masked PII in response chunks, and redacts plain JSON responses when
+// redactResponse is enabled.
 //
 // LLMs always use Transfer-Encoding: chunked, so this method handles two formats:
 //   - SSE streaming: lines prefixed with "data: ", restores in choices[*].delta.content
-//   - Full JSON (non-streaming, chunked transfer): restores in raw JSON bytes
+//   - Full JSON (non-streaming, chunked transfer): buffers until EndOfStream, then
+//     restores in the raw JSON bytes of the complete body
+//
+// When sse is enabled, text/event-stream responses are instead reassembled
+// into complete events by restoreEventStream.
 func (p *PIIMaskingRegexPolicy) OnResponseBodyChunk(ctx context.Context, respCtx *policy.ResponseStreamContext, chunk *policy.StreamBody, params map[string]interface{}) policy.StreamingResponseAction {
-	if p.params.RedactPII {
+	if chunk == nil || (len(chunk.Chunk) == 0 && !chunk.EndOfStream) {
 		return policy.ForwardResponseChunk{}
 	}
-	if chunk == nil || len(chunk.Chunk) == 0 {
+
+	restoreMap := p.responseRestoreMap(respCtx.Metadata)
+	if restoreMap == nil && !p.params.RedactResponse {
 		return policy.ForwardResponseChunk{}
 	}
 
-	maskedPII, exists := respCtx.Metadata[MetadataKeyPIIEntities]
-	if !exists {
-		return policy.ForwardResponseChunk{}
+	if respCtx.Metadata == nil {
+		respCtx.Metadata = make(map[string]interface{})
 	}
-	maskedPIIMap, ok := maskedPII.(map[string]string)
-	if !ok || len(maskedPIIMap) == 0 {
-		return policy.ForwardResponseChunk{}
+	if p.params.SSE && isEventStream(respCtx.ResponseHeaders) {
+		if restoreMap == nil {
+			return policy.ForwardResponseChunk{}
+		}
+		return p.scrubStreamingAction(p.restoreEventStream(respCtx, chunk, restoreMap), chunk.Chunk, restoreMap)
 	}
-
 	chunkStr := string(chunk.Chunk)
 
-	// maskedPIIMap is keyed original→placeholder (set by maskPIIFromContent).
-	// The restore helpers expect placeholder→original, so invert before use.
-	restoreMap := invertStringMap(maskedPIIMap)
-
 	// Detect format: SSE responses have lines starting with "data: "
 	if isSSEChunk(chunkStr) {
-		return p.restoreSSEChunk(chunkStr, restoreMap)
+		if restoreMap == nil {
+			return policy.ForwardResponseChunk{}
+		}
+		return p.scrubStreamingAction(p.restoreSSEChunk(chunkStr, restoreMap), chunk.Chunk, restoreMap)
+	}
+	return p.scrubStreamingAction(p.restoreBufferedJSONChunk(respCtx, chunkStr, chunk.EndOfStream, restoreMap), chunk.Chunk, restoreMap)
+}
+
+// scrubStreamingAction applies scrubUnrestoredPlaceholders, when enabled, to
+// the body a streaming action forwards: its replacement body, or chunk when
+// the chunk is forwarded unchanged. Chunks held back are forwarded later, in
+// restored form, and scrubbed then.
+func (p *PIIMaskingRegexPolicy) scrubStreamingAction(action policy.StreamingResponseAction, chunk []byte, restoreMap map[string]string) policy.StreamingResponseAction {
+	if !p.params.ScrubUnrestoredPlaceholders || len(restoreMap) == 0 {
+		return action
 	}
-	return p.restoreJSONChunk(chunkStr, restoreMap)
+	forward, ok := action.(policy.ForwardResponseChunk)
+	if !ok {
+		return action
+	}
+	body := forward.Body
+	if body == nil {
+		body = chunk
+	}
+	if scrubbed := scrubUnrestoredPlaceholders(body, restoreMap); scrubbed != nil {
+		forward.Body = scrubbed
+	}
+	return forward
+}
+
+// restoreBufferedJSONChunk holds back plain JSON chunks until the end of the
+// stream and then redacts (when redactResponse is enabled) and restores
+// placeholders on the complete body, so a placeholder split across chunk
+// boundaries (e.g. "[EMA" + "IL_0000]") is still restored.
+func (p *PIIMaskingRegexPolicy) restoreBufferedJSONChunk(respCtx *policy.ResponseStreamContext, chunkStr string, endOfStream bool, maskedMap map[string]string) policy.StreamingResponseAction {
+	prev, _ := respCtx.Metadata[metaKeyAccJsonBody].(string)
+	full := prev + chunkStr
+	if !endOfStream {
+		respCtx.Metadata[metaKeyAccJsonBody] = full
+		// A non-nil empty body suppresses the chunk until the stream completes.
+		return policy.ForwardResponseChunk{Body: []byte{}}
+	}
+	delete(respCtx.Metadata, metaKeyAccJsonBody)
+	if full == "" {
+		return policy.ForwardResponseChunk{}
+	}
+
+	body := full
+	if p.params.RedactResponse {
+		redacted, err := p.redactResponsePayload([]byte(full))
+		if err != nil {
+			return p.streamError(ErrorCodeInputTooLarge, err.Error(), full)
+		}
+		if redacted != nil {
+			body = string(redacted)
+		}
+	}
+	if len(maskedMap) > 0 {
+		if action := p.restoreJSONChunk(body, maskedMap); action.Body != nil {
+			return action
+		}
+	}
+	if prev != "" || body != full {
+		// Earlier chunks were held back or the body was redacted, so the
+		// complete body must be emitted.
+		return policy.ForwardResponseChunk{Body: []byte(body)}
+	}
+	return policy.ForwardResponseChunk{}
 }
 
 // ─── SSE / Streaming helpers ─────────────────────────────────────────────────
@@ -590,7 +2306,7 @@ func (p *PIIMaskingRegexPolicy) restoreSSEChunk(chunkStr string, maskedMap map[s
 		sb.WriteString(cl.content)
 	}
 	fullContent := sb.String()
-	restoredContent := restore(fullContent, maskedMap)
+	restoredContent := p.restore(fullContent, maskedMap)
 
 	if restoredContent == fullContent {
 		return policy.ForwardResponseChunk{}
@@ -693,20 +2409,13 @@ func updateDeltaContentInLine(line, newContent string) string {
 // Placeholders are replaced directly in the raw JSON bytes so that key order,
 // whitespace, and any trailing newline from the LLM are preserved exactly.
 func (p *PIIMaskingRegexPolicy) restoreJSONChunk(chunkStr string, maskedMap map[string]string) policy.ForwardResponseChunk {
-	result := chunkStr
-	for placeholder, original := range maskedMap {
-		if !strings.Contains(result, placeholder) {
-			continue
-		}
+	p.logUnknownPlaceholders(chunkStr, maskedMap)
+	result := newRestoreReplacer(maskedMap, func(original string) string {
 		// JSON-encode the replacement so special characters (", \, etc.) are
 		// properly escaped. Strip the surrounding quotes that json.Marshal adds.
-		encodedBytes, err := json.Marshal(original)
-		if err != nil {
-			continue
-		}
-		escapedOriginal := string(encodedBytes[1 : len(encodedBytes)-1])
-		result = strings.ReplaceAll(result, placeholder, escapedOriginal)
-	}
+		encodedBytes, _ := json.Marshal(original)
+		return string(encodedBytes[1 : len(encodedBytes)-1])
+	}).Replace(chunkStr)
 	if result == chunkStr {
 		return policy.ForwardResponseChunk{}
 	}
@@ -716,7 +2425,7 @@ func (p *PIIMaskingRegexPolicy) restoreJSONChunk(chunkStr string, maskedMap map[
 // restoreInChoices parses a JSON string, restores PII placeholders in
 // choices[*].<choiceKey>.content, and returns the updated JSON.
 // choiceKey is "message" for non-streaming or "delta" for streaming.
-func restoreInChoices(jsonStr string, maskedMap map[string]string, choiceKey string) (string, bool) {
+func (p *PIIMaskingRegexPolicy) restoreInChoices(jsonStr string, maskedMap map[string]string, choiceKey string) (string, bool) {
 	var data map[string]interface{}
 	if err := json.Unmarshal([]byte(jsonStr), &data); err != nil {
 		return jsonStr, false
@@ -745,11 +2454,26 @@ func restoreInChoices(jsonStr string, maskedMap map[string]string, choiceKey str
 		if !ok {
 			continue
 		}
+		if parts, ok := sub["content"].([]interface{}); ok {
+			// Content-part array: restore the text of each text part.
+			for _, partRaw := range parts {
+				part, ok := partRaw.(map[string]interface{})
+				if !ok || !isTextPart(part) {
+					continue
+				}
+				text := part["text"].(string)
+				if restored := p.restore(text, maskedMap); restored != text {
+					part["text"] = restored
+					modified = true
+				}
+			}
+			continue
+		}
 		content, ok := sub["content"].(string)
 		if !ok || content == "" {
 			continue
 		}
-		restored := restore(content, maskedMap)
+		restored := p.restore(content, maskedMap)
 		if restored != content {
 			sub["content"] = restored
 			modified = true
@@ -767,14 +2491,26 @@ func restoreInChoices(jsonStr string, maskedMap map[string]string, choiceKey str
 	return string(updatedBytes), true
 }
 
+// endsWithPartialPrefix reports whether content ends with a non-empty proper
+// prefix of marker.
+func endsWithPartialPrefix(content, marker string) bool {
+	for n := len(marker) - 1; n > 0; n-- {
+		if strings.HasSuffix(content, marker[:n]) {
+			return true
+		}
+	}
+	return false
+}
+
 // extractSSEDeltaContentTracked concatenates choices[*].delta.content from all
 // complete SSE data lines in the accumulated buffer. It returns:
 //   - the concatenated content string
-//   - the 0-based data-line index of the last line that contributed a '[' character
+//   - the 0-based data-line index of the last line that completed an
+//     occurrence of open
 //   - the total number of complete SSE data lines processed
 //
 // TODO (Set Jsonstreaming path)
-func extractSSEDeltaContentTracked(s string) (string, int, int) {
+func extractSSEDeltaContentTracked(s, open string) (string, int, int) {
 	var sb strings.Builder
 	totalDataLines := 0
 	lastOpenBracketDataLine := 0
@@ -809,10 +2545,15 @@ func extractSSEDeltaContentTracked(s string) (string, int, int) {
 				lineContent += content
 			}
 		}
-		if strings.Contains(lineContent, "[") {
-			lastOpenBracketDataLine = totalDataLines
+		// Look back far enough to catch an opening split across lines.
+		from := sb.Len() - len(open) + 1
+		if from < 0 {
+			from = 0
 		}
 		sb.WriteString(lineContent)
+		if lineContent != "" && strings.Contains(sb.String()[from:], open) {
+			lastOpenBracketDataLine = totalDataLines
+		}
 		totalDataLines++
 	}
 	return sb.String(), lastOpenBracketDataLine, totalDataLines
@@ -827,14 +2568,133 @@ func invertStringMap(m map[string]string) map[string]string {
 	return inv
 }
 
+// logUnknownPlaceholders logs placeholder-shaped tokens in content that were
+// not generated for this request. Such tokens are never restored, so an
+// upstream cannot obtain originals by injecting guessed placeholders.
+func (p *PIIMaskingRegexPolicy) logUnknownPlaceholders(content string, maskedMap map[string]string) {
+	if !slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	for _, token := range p.params.placeholders.token.FindAllString(content, -1) {
+		if _, known := maskedMap[token]; !known {
+			slog.Debug("PIIMaskingRegex: Ignoring unknown placeholder in response", "placeholder", token)
+		}
+	}
+}
+
 // restore replaces placeholders with their original values.
 // maskedMap is placeholder → original.
-func restore(content string, maskedMap map[string]string) string {
-	result := content
-	for placeholder, original := range maskedMap {
-		result = strings.ReplaceAll(result, placeholder, original)
+func (p *PIIMaskingRegexPolicy) restore(content string, maskedMap map[string]string) string {
+	p.logUnknownPlaceholders(content, maskedMap)
+	return newRestoreReplacer(maskedMap, nil).Replace(content)
+}
+
+// contentPaths returns the paths of the values to process in payload for the
+// configured jsonPaths, expanding message arrays when perMessage is enabled and
+// content-part arrays.
+func (p *PIIMaskingRegexPolicy) contentPaths(payload []byte) []string {
+	jsonPaths := p.params.JsonPaths
+	if p.params.PerMessage {
+		jsonPaths = expandMessagePaths(payload, jsonPaths)
+	}
+	return expandTextPartPaths(payload, jsonPaths)
+}
+
+// expandMessagePaths replaces each path that resolves to an array of message
+// objects with the paths of their content fields, e.g. $.messages[0].content,
+// so that each message is processed on its own. Messages without a content
+// field are left out. Other paths are returned unchanged.
+func expandMessagePaths(payload []byte, jsonPaths []string) []string {
+	if len(jsonPaths) == 1 && jsonPaths[0] == "" {
+		return jsonPaths
+	}
+	var jsonData map[string]interface{}
+	if err := json.Unmarshal(payload, &jsonData); err != nil {
+		// Leave error reporting to extractStringFromPath.
+		return jsonPaths
+	}
+
+	expanded := make([]string, 0, len(jsonPaths))
+	for _, jsonPath := range jsonPaths {
+		raw, err := utils.ExtractValueFromJsonpath(jsonData, jsonPath)
+		if err != nil {
+			expanded = append(expanded, jsonPath)
+			continue
+		}
+		messages, ok := raw.([]interface{})
+		if !ok || !isMessageArray(messages) {
+			expanded = append(expanded, jsonPath)
+			continue
+		}
+		for i, messageRaw := range messages {
+			message, ok := messageRaw.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if _, ok := message["content"]; ok {
+				expanded = append(expanded, fmt.Sprintf("%s[%d].content", jsonPath, i))
+			}
+		}
+	}
+	return expanded
+}
+
+// isMessageArray reports whether any element of items is an object with a
+// content field.
+func isMessageArray(items []interface{}) bool {
+	for _, item := range items {
+		if message, ok := item.(map[string]interface{}); ok {
+			if _, ok := message["content"]; ok {
+				return true
+			}
+		}
 	}
-	return result
+	return false
+}
+
+// expandTextPartPaths returns the paths to mask for the configured jsonPaths.
+// A path that resolves to an array of typed content parts
+// ({"type":"text","text":"..."}) is replaced by the paths of the text fields of
+// its text parts, e.g. $.messages[-1].content[0].text; non-text parts such as
+// images or tool calls are left out. Other paths are returned unchanged.
+func expandTextPartPaths(payload []byte, jsonPaths []string) []string {
+	if len(jsonPaths) == 1 && jsonPaths[0] == "" {
+		return jsonPaths
+	}
+	var jsonData map[string]interface{}
+	if err := json.Unmarshal(payload, &jsonData); err != nil {
+		// Leave error reporting to extractStringFromPath.
+		return jsonPaths
+	}
+
+	expanded := make([]string, 0, len(jsonPaths))
+	for _, jsonPath := range jsonPaths {
+		raw, err := utils.ExtractValueFromJsonpath(jsonData, jsonPath)
+		if err != nil {
+			expanded = append(expanded, jsonPath)
+			continue
+		}
+		parts, ok := raw.([]interface{})
+		if !ok {
+			expanded = append(expanded, jsonPath)
+			continue
+		}
+		for i, partRaw := range parts {
+			if part, ok := partRaw.(map[string]interface{}); ok && isTextPart(part) {
+				expanded = append(expanded, fmt.Sprintf("%s[%d].text", jsonPath, i))
+			}
+		}
+	}
+	return expanded
+}
+
+// isTextPart reports whether a content part is a text part with a string text.
+func isTextPart(part map[string]interface{}) bool {
+	if partType, _ := part["type"].(string); partType != "text" {
+		return false
+	}
+	_, ok := part["text"].(string)
+	return ok
 }
 
 // extractStringFromPath extracts the value at jsonPath from payload as a string.
@@ -865,15 +2725,51 @@ func extractStringFromPath(payload []byte, jsonPath string) (string, bool, error
 	}
 }
 
-func (p *PIIMaskingRegexPolicy) buildErrorResponse(reason string) interface{} {
+// requestError returns the action for a request processing error: an error
+// response when onError is reject, or the unmodified request when it is
+// passthrough.
+func (p *PIIMaskingRegexPolicy) requestError(code, reason string) policy.RequestAction {
+	if p.params.OnError == OnErrorPassthrough {
+		slog.Warn("PIIMaskingRegex: passing request through unmasked after error", "code", code, "reason", reason)
+		return policy.UpstreamRequestModifications{}
+	}
+	return p.buildErrorResponse(code, reason).(policy.RequestAction)
+}
+
+// responseError is the response counterpart of requestError for buffered
+// response bodies.
+func (p *PIIMaskingRegexPolicy) responseError(code, reason string) policy.ResponseAction {
+	if p.params.OnError == OnErrorPassthrough {
+		slog.Warn("PIIMaskingRegex: passing response through unmodified after error", "code", code, "reason", reason)
+		return policy.DownstreamResponseModifications{}
+	}
+	return p.buildErrorResponse(code, reason).(policy.ResponseAction)
+}
+
+// streamError handles an error at the end of a streamed response whose held
+// back body is original. Response headers are already committed, so reject
+// closes the stream with the error body in place of the response, while
+// passthrough forwards the original body.
+func (p *PIIMaskingRegexPolicy) streamError(code, reason, original string) policy.StreamingResponseAction {
+	if p.params.OnError == OnErrorPassthrough {
+		slog.Warn("PIIMaskingRegex: passing response through unmodified after error", "code", code, "reason", reason)
+		return policy.ForwardResponseChunk{Body: []byte(original)}
+	}
+	return policy.TerminateResponseChunk{Body: p.buildErrorResponse(code, reason).(policy.ImmediateResponse).Body}
+}
+
+// buildErrorResponse builds the error response for a processing failure of
+// the given error code class.
+func (p *PIIMaskingRegexPolicy) buildErrorResponse(code, reason string) interface{} {
 	responseBody := map[string]interface{}{
-		"code":    APIMInternalExceptionCode,
-		"message": "Error occurred during pii-masking-regex mediation: " + reason,
+		"code":      APIMInternalExceptionCode,
+		"errorCode": code,
+		"message":   "Error occurred during pii-masking-regex mediation: " + reason,
 	}
 
 	bodyBytes, err := json.Marshal(responseBody)
 	if err != nil {
-		bodyBytes = []byte(fmt.Sprintf(`{"code":%d,"type":"PII_MASKING_REGEX","message":"Internal error"}`, APIMInternalExceptionCode))
+		bodyBytes = []byte(fmt.Sprintf(`{"code":%d,"errorCode":%q,"type":"PII_MASKING_REGEX","message":"Internal error"}`, APIMInternalExceptionCode, code))
 	}
 
 	// For PII masking, errors typically occur in request phase, but return as ImmediateResponse
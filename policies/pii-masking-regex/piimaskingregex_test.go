@@ -2,12 +2,17 @@ package piimaskingregex
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"reflect"
 	"regexp"
 	"strings"
 	"testing"
 
 	policy "github.com/wso2/api-platform/sdk/core/policy/v1alpha2"
+	"github.com/wso2/gateway-controllers/common/bodyformat"
 )
 
 func TestPIIMaskingRegexPolicy_GetPolicy_ParseErrors(t *testing.T) {
@@ -60,6 +65,113 @@ func TestPIIMaskingRegexPolicy_GetPolicy_ParseErrors(t *testing.T) {
 			},
 			wantErrContain: "'customPIIEntities[0].piiRegex' is invalid",
 		},
+		{
+			name: "custom priority not an integer",
+			params: map[string]interface{}{
+				"customPIIEntities": []interface{}{
+					map[string]interface{}{"piiEntity": "TICKET", "piiRegex": "T-[0-9]+", "priority": "high"},
+				},
+			},
+			wantErrContain: "'customPIIEntities[0].priority' must be an integer",
+		},
+		{
+			name: "custom minLength negative",
+			params: map[string]interface{}{
+				"customPIIEntities": []interface{}{
+					map[string]interface{}{"piiEntity": "NUMBER", "piiRegex": "[0-9]+", "minLength": -1},
+				},
+			},
+			wantErrContain: "'customPIIEntities[0].minLength' cannot be negative",
+		},
+		{
+			name: "custom minLength not an integer",
+			params: map[string]interface{}{
+				"customPIIEntities": []interface{}{
+					map[string]interface{}{"piiEntity": "NUMBER", "piiRegex": "[0-9]+", "minLength": 2.5},
+				},
+			},
+			wantErrContain: "'customPIIEntities[0].minLength' must be an integer",
+		},
+		{
+			name: "skipPaths without recursive",
+			params: map[string]interface{}{
+				"email":     true,
+				"skipPaths": []interface{}{"$.system_prompt"},
+			},
+			wantErrContain: "'skipPaths' is only supported when 'recursive' is enabled",
+		},
+		{
+			name: "skipPaths entry empty",
+			params: map[string]interface{}{
+				"email":     true,
+				"recursive": true,
+				"skipPaths": []interface{}{" "},
+			},
+			wantErrContain: "'skipPaths[0]' must be a non-empty string",
+		},
+		{
+			name: "keyValueMode wrong type",
+			params: map[string]interface{}{
+				"customPIIEntities": []interface{}{
+					map[string]interface{}{"piiEntity": "EMAIL", "piiRegex": "email", "keyValueMode": "yes"},
+				},
+			},
+			wantErrContain: "'customPIIEntities[0].keyValueMode' must be a boolean",
+		},
+		{
+			name: "keyValueMode with maskGroup",
+			params: map[string]interface{}{
+				"customPIIEntities": []interface{}{
+					map[string]interface{}{"piiEntity": "EMAIL", "piiRegex": "(?P<key>email)", "keyValueMode": true, "maskGroup": "key"},
+				},
+			},
+			wantErrContain: "'customPIIEntities[0].maskGroup' cannot be combined with 'keyValueMode'",
+		},
+		{
+			name: "keyValueMode invalid key pattern",
+			params: map[string]interface{}{
+				"customPIIEntities": []interface{}{
+					map[string]interface{}{"piiEntity": "EMAIL", "piiRegex": "email(", "keyValueMode": true},
+				},
+			},
+			wantErrContain: "'customPIIEntities[0].piiRegex' is invalid",
+		},
+		{
+			name: "keyValueMode reserved group name",
+			params: map[string]interface{}{
+				"customPIIEntities": []interface{}{
+					map[string]interface{}{"piiEntity": "EMAIL", "piiRegex": "(?P<piiValue>email)", "keyValueMode": true},
+				},
+			},
+			wantErrContain: `'customPIIEntities[0].piiRegex' must not define capture group "piiValue" in keyValueMode`,
+		},
+		{
+			name: "custom maskGroup not in regex",
+			params: map[string]interface{}{
+				"customPIIEntities": []interface{}{
+					map[string]interface{}{"piiEntity": "EMAIL", "piiRegex": "(?P<local>[a-z]+)@x\\.com", "maskGroup": "user"},
+				},
+			},
+			wantErrContain: `'customPIIEntities[0].maskGroup' "user" is not a named capture group in 'piiRegex'`,
+		},
+		{
+			name: "custom caseInsensitive conflicts with inline flag",
+			params: map[string]interface{}{
+				"customPIIEntities": []interface{}{
+					map[string]interface{}{"piiEntity": "CODE", "piiRegex": "(?-i:code)-[0-9]+", "caseInsensitive": true},
+				},
+			},
+			wantErrContain: `'customPIIEntities[0].caseInsensitive' conflicts with inline flag "(?-i:"`,
+		},
+		{
+			name: "custom caseInsensitive false conflicts with inline flag",
+			params: map[string]interface{}{
+				"customPIIEntities": []interface{}{
+					map[string]interface{}{"piiEntity": "CODE", "piiRegex": "(?i)code-[0-9]+", "caseInsensitive": false},
+				},
+			},
+			wantErrContain: `conflicts with inline flag "(?i)"`,
+		},
 		{
 			name: "duplicate custom piiEntity",
 			params: map[string]interface{}{
@@ -87,13 +199,123 @@ func TestPIIMaskingRegexPolicy_GetPolicy_ParseErrors(t *testing.T) {
 			},
 			wantErrContain: "'email' must be a boolean",
 		},
+		{
+			name: "creditCard wrong type",
+			params: map[string]interface{}{
+				"creditCard": 1,
+			},
+			wantErrContain: "'creditCard' must be a boolean",
+		},
+		{
+			name: "duplicate builtin creditCard and custom",
+			params: map[string]interface{}{
+				"customPIIEntities": []interface{}{
+					map[string]interface{}{"piiEntity": "credit_card", "piiRegex": "[0-9]+"},
+				},
+				"creditCard": true,
+			},
+			wantErrContain: `duplicate piiEntity: "CREDIT_CARD"`,
+		},
+		{
+			name: "ipv4 wrong type",
+			params: map[string]interface{}{
+				"ipv4": "yes",
+			},
+			wantErrContain: "'ipv4' must be a boolean",
+		},
 		{
 			name: "jsonPath wrong type",
 			params: map[string]interface{}{
 				"email":    true,
 				"jsonPath": false,
 			},
-			wantErrContain: "'jsonPath' must be a string",
+			wantErrContain: "'jsonPath' must be a string or an array of strings",
+		},
+		{
+			name: "redactionStyle invalid",
+			params: map[string]interface{}{
+				"email":          true,
+				"redactionStyle": "blur",
+			},
+			wantErrContain: "'redactionStyle' must be one of: fixed, lengthPreserving, tag",
+		},
+		{
+			name: "preserveLastN negative",
+			params: map[string]interface{}{
+				"ssn":           true,
+				"preserveLastN": -1,
+			},
+			wantErrContain: "'preserveLastN' cannot be negative",
+		},
+		{
+			name: "hashPII with redactPII",
+			params: map[string]interface{}{
+				"email":     true,
+				"redactPII": true,
+				"hashPII":   true,
+				"hashSalt":  "salt",
+			},
+			wantErrContain: "'hashPII' and 'redactPII' cannot both be enabled",
+		},
+		{
+			name: "hashPII without hashSalt",
+			params: map[string]interface{}{
+				"email":   true,
+				"hashPII": true,
+			},
+			wantErrContain: "'hashSalt' is required",
+		},
+		{
+			name: "auditRecord without hashSalt",
+			params: map[string]interface{}{
+				"email":       true,
+				"auditRecord": true,
+			},
+			wantErrContain: "'hashSalt' is required and must be a non-empty string when 'auditRecord' is enabled",
+		},
+		{
+			name: "allowlist wrong type",
+			params: map[string]interface{}{
+				"email":     true,
+				"allowlist": "support@example.com",
+			},
+			wantErrContain: "'allowlist' must be an array of strings",
+		},
+		{
+			name: "customPIIEntities exceeds maxEntities",
+			params: map[string]interface{}{
+				"customPIIEntities": []interface{}{
+					map[string]interface{}{"piiEntity": "A", "piiRegex": "a+"},
+					map[string]interface{}{"piiEntity": "B", "piiRegex": "b+"},
+				},
+				"maxEntities": 1,
+			},
+			wantErrContain: "'customPIIEntities' cannot contain more than 1 entities",
+		},
+		{
+			name: "auditOnly with redactResponse",
+			params: map[string]interface{}{
+				"email":          true,
+				"auditOnly":      true,
+				"redactResponse": true,
+			},
+			wantErrContain: "'auditOnly' and 'redactResponse' cannot both be enabled",
+		},
+		{
+			name: "maxInputBytes negative",
+			params: map[string]interface{}{
+				"email":         true,
+				"maxInputBytes": -5,
+			},
+			wantErrContain: "'maxInputBytes' cannot be negative",
+		},
+		{
+			name: "jsonPath array with empty entry",
+			params: map[string]interface{}{
+				"email":    true,
+				"jsonPath": []interface{}{"$.a", ""},
+			},
+			wantErrContain: "'jsonPath[1]' must be a non-empty string",
 		},
 		{
 			name: "redactPII wrong type",
@@ -103,153 +325,1983 @@ func TestPIIMaskingRegexPolicy_GetPolicy_ParseErrors(t *testing.T) {
 			},
 			wantErrContain: "'redactPII' must be a boolean",
 		},
+		{
+			name: "custom mode invalid",
+			params: map[string]interface{}{
+				"customPIIEntities": []interface{}{
+					map[string]interface{}{"piiEntity": "CODE", "piiRegex": "code-[0-9]+", "mode": "hash"},
+				},
+			},
+			wantErrContain: "'customPIIEntities[0].mode' must be one of: mask, redact",
+		},
+		{
+			name: "placeholderFormat missing id",
+			params: map[string]interface{}{
+				"email":             true,
+				"placeholderFormat": "<<{entity}>>",
+			},
+			wantErrContain: "'placeholderFormat' must contain exactly one {entity} and one {id} token",
+		},
+		{
+			name: "placeholderFormat without closing text",
+			params: map[string]interface{}{
+				"email":             true,
+				"placeholderFormat": "<<{entity}:{id}",
+			},
+			wantErrContain: "'placeholderFormat' must begin and end with non-whitespace text",
+		},
+		{
+			name: "onError invalid",
+			params: map[string]interface{}{
+				"email":   true,
+				"onError": "ignore",
+			},
+			wantErrContain: "'onError' must be one of: reject, passthrough",
+		},
+		{
+			name: "entityModes key not built-in",
+			params: map[string]interface{}{
+				"customPIIEntities": []interface{}{
+					map[string]interface{}{"piiEntity": "CODE", "piiRegex": "code-[0-9]+"},
+				},
+				"entityModes": map[string]interface{}{"CODE": "redact"},
+			},
+			wantErrContain: `'entityModes' key "CODE" is not a built-in entity`,
+		},
+		{
+			name: "bodyFormat invalid",
+			params: map[string]interface{}{
+				"email":      true,
+				"bodyFormat": "xml",
+			},
+			wantErrContain: "'bodyFormat' must be one of [json-object,json-array,ndjson]",
+		},
+		{
+			name: "summaryHeader invalid",
+			params: map[string]interface{}{
+				"email":         true,
+				"summaryHeader": "X PII",
+			},
+			wantErrContain: "'summaryHeader' must be a valid HTTP header name",
+		},
+		{
+			name: "entityModes built-in not enabled",
+			params: map[string]interface{}{
+				"email":       true,
+				"entityModes": map[string]interface{}{"SSN": "redact"},
+			},
+			wantErrContain: `'entityModes' key "SSN" refers to a built-in entity that is not enabled`,
+		},
+		{
+			name:           "builtins wrong type",
+			params:         map[string]interface{}{"builtins": "EMAIL"},
+			wantErrContain: "'builtins' must be an array of strings",
+		},
+		{
+			name:           "builtins unknown entity",
+			params:         map[string]interface{}{"builtins": []interface{}{"EMAIL", "PASSPORT"}},
+			wantErrContain: "'builtins[1]' must be one of: EMAIL, PHONE, SSN, CREDIT_CARD, IPV4, IPV6",
+		},
+		{
+			name:           "builtins repeated entity",
+			params:         map[string]interface{}{"builtins": []interface{}{"EMAIL", "email"}},
+			wantErrContain: `'builtins[1]' duplicates built-in entity "EMAIL"`,
+		},
+		{
+			name: "builtins and toggle enable same detector",
+			params: map[string]interface{}{
+				"builtins": []interface{}{"PHONE"},
+				"phone":    true,
+			},
+			wantErrContain: `built-in entity "PHONE" is enabled by both 'phone' and 'builtins'`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := GetPolicy(policy.PolicyMetadata{}, tt.params)
+			if err == nil {
+				t.Fatalf("expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.wantErrContain) {
+				t.Fatalf("error mismatch: got %q, want contain %q", err.Error(), tt.wantErrContain)
+			}
+		})
+	}
+}
+
+func TestPIIMaskingRegexPolicy_Validate_CollectsAllErrors(t *testing.T) {
+	params := map[string]interface{}{
+		"customPIIEntities": []interface{}{
+			map[string]interface{}{"piiEntity": "ORDER", "piiRegex": "("},
+			map[string]interface{}{"piiEntity": "EMAIL", "piiRegex": "x"},
+			map[string]interface{}{"piiEntity": "email", "piiRegex": "y"},
+		},
+		"redactPII":      "yes",
+		"redactionStyle": "blur",
+	}
+
+	err := (&PIIMaskingRegexPolicy{}).Validate(params)
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	for _, want := range []string{
+		"'customPIIEntities[0].piiRegex' is invalid",
+		`duplicate piiEntity: "EMAIL"`,
+		"'redactPII' must be a boolean",
+		"'redactionStyle' must be one of",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected error to contain %q, got %q", want, err.Error())
+		}
+	}
+
+	// GetPolicy still stops at the first problem.
+	_, err = GetPolicy(policy.PolicyMetadata{}, params)
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	if !strings.Contains(err.Error(), "'customPIIEntities[0].piiRegex' is invalid") ||
+		strings.Contains(err.Error(), "redactPII") {
+		t.Fatalf("expected only the first error from GetPolicy, got %q", err.Error())
+	}
+}
+
+func TestPIIMaskingRegexPolicy_Validate_ValidConfiguration(t *testing.T) {
+	err := (&PIIMaskingRegexPolicy{}).Validate(map[string]interface{}{"email": true})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestPIIMaskingRegexPolicy_GetPolicy_DefaultsAndBuiltins(t *testing.T) {
+	p := mustGetPIIPolicy(t, map[string]interface{}{
+		"email": true,
+		"phone": true,
+		"ssn":   true,
+	})
+
+	if got := p.params.JsonPath; got != "$.messages[-1].content" {
+		t.Fatalf("expected default jsonPath '$.messages[-1].content', got %q", got)
+	}
+	if p.params.RedactPII {
+		t.Fatalf("expected default redactPII=false")
+	}
+	if len(p.params.PIIEntities) != 3 {
+		t.Fatalf("expected 3 built-in detectors, got %d", len(p.params.PIIEntities))
+	}
+}
+
+func TestPIIMaskingRegexPolicy_GetPolicy_BuiltinsList(t *testing.T) {
+	p := mustGetPIIPolicy(t, map[string]interface{}{
+		"builtins":   []interface{}{"EMAIL", "credit_card"},
+		"ssn":        true,
+		"creditCard": false,
+	})
+
+	for _, entity := range []string{"EMAIL", "CREDIT_CARD", "SSN"} {
+		if _, ok := p.params.PIIEntities[entity]; !ok {
+			t.Fatalf("expected %s detector to be enabled", entity)
+		}
+	}
+	if len(p.params.PIIEntities) != 3 {
+		t.Fatalf("expected 3 built-in detectors, got %d", len(p.params.PIIEntities))
+	}
+	if p.params.validators["CREDIT_CARD"] == nil {
+		t.Fatalf("expected Luhn validation for CREDIT_CARD enabled via builtins")
+	}
+}
+
+func TestPIIMaskingRegexPolicy_GetPolicy_CustomJSONString(t *testing.T) {
+	p := mustGetPIIPolicy(t, map[string]interface{}{
+		"customPIIEntities": `[{"piiEntity":"ORDER_ID","piiRegex":"ORD-[0-9]+"}]`,
+		"jsonPath":          "$.content",
+		"redactPII":         true,
+	})
+
+	if got := p.params.JsonPath; got != "$.content" {
+		t.Fatalf("expected custom jsonPath, got %q", got)
+	}
+	if !p.params.RedactPII {
+		t.Fatalf("expected redactPII=true")
+	}
+	if _, ok := p.params.PIIEntities["ORDER_ID"]; !ok {
+		t.Fatalf("expected ORDER_ID custom detector")
+	}
+}
+
+func TestPIIMaskingRegexPolicy_GetPolicy_CustomEntityName_IsNormalizedToUppercase(t *testing.T) {
+	p := mustGetPIIPolicy(t, map[string]interface{}{
+		"customPIIEntities": []interface{}{
+			map[string]interface{}{"piiEntity": "order_id", "piiRegex": "ORD-[0-9]+"},
+		},
+	})
+
+	if _, ok := p.params.PIIEntities["ORDER_ID"]; !ok {
+		t.Fatalf("expected lowercase custom entity name to be normalized to ORDER_ID")
+	}
+}
+
+func TestPIIMaskingRegexPolicy_GetPolicy_CustomEntityCaseInsensitive(t *testing.T) {
+	p := mustGetPIIPolicy(t, map[string]interface{}{
+		"customPIIEntities": []interface{}{
+			map[string]interface{}{"piiEntity": "ORDER_ID", "piiRegex": "ord-[0-9]+", "caseInsensitive": true},
+			map[string]interface{}{"piiEntity": "TICKET", "piiRegex": "tkt-[0-9]+"},
+		},
+	})
+
+	if !p.params.PIIEntities["ORDER_ID"].MatchString("ORD-42") {
+		t.Fatalf("expected caseInsensitive entity to match regardless of case")
+	}
+	if p.params.PIIEntities["TICKET"].MatchString("TKT-42") {
+		t.Fatalf("expected entity without caseInsensitive to stay case-sensitive")
+	}
+}
+
+func TestPIIMaskingRegexPolicy_OnRequest_MaskAndStoreMetadata(t *testing.T) {
+	p := mustGetPIIPolicy(t, map[string]interface{}{
+		"email": true,
+	})
+
+	ctx := piiRequestContext(`{"messages":[{"content":"Contact me at a.user@example.com please"}]}`)
+	action := p.OnRequestBody(context.Background(), ctx, nil)
+	mods := mustPIIRequestMods(t, action)
+	if len(mods.Body) == 0 {
+		t.Fatalf("expected modified body")
+	}
+
+	out := decodeJSONMapPII(t, mods.Body)
+	msg := mustGetLastMessageContent(t, out)
+	matched, err := regexp.MatchString(`\[EMAIL_[0-9a-f]{4}\]`, msg)
+	if err != nil {
+		t.Fatalf("failed regex match: %v", err)
+	}
+	if !matched {
+		t.Fatalf("expected masked email placeholder, got %q", msg)
+	}
+
+	metaVal, exists := ctx.Metadata[MetadataKeyPIIEntities]
+	if !exists {
+		t.Fatalf("expected pii metadata to be stored")
+	}
+	mapping, ok := metaVal.(map[string]string)
+	if !ok || len(mapping) == 0 {
+		t.Fatalf("expected pii metadata mapping, got %T", metaVal)
+	}
+}
+
+func TestPIIMaskingRegexPolicy_OnRequest_CreditCardLuhn(t *testing.T) {
+	p := mustGetPIIPolicy(t, map[string]interface{}{
+		"creditCard": true,
+	})
+
+	ctx := piiRequestContext(`{"messages":[{"content":"card 4111 1111 1111 1111 and order 1234567890123"}]}`)
+	mods := mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	msg := mustGetLastMessageContent(t, decodeJSONMapPII(t, mods.Body))
+
+	if strings.Contains(msg, "4111 1111 1111 1111") {
+		t.Fatalf("expected Luhn-valid card number to be masked, got %q", msg)
+	}
+	if !regexp.MustCompile(`\[CREDIT_CARD_[0-9a-f]{4}\]`).MatchString(msg) {
+		t.Fatalf("expected CREDIT_CARD placeholder, got %q", msg)
+	}
+	if !strings.Contains(msg, "1234567890123") {
+		t.Fatalf("expected Luhn-invalid number to be left untouched, got %q", msg)
+	}
+
+	// A card number followed by another digit group, such as a CVV, is still
+	// found: the longest Luhn-valid prefix of the match is masked.
+	for _, tt := range []struct{ content, rest string }{
+		{content: "card 4111 1111 1111 1111 123 thanks", rest: " 123 thanks"},
+		{content: "card 4111-1111-1111-1111-12 thanks", rest: "-12 thanks"},
+	} {
+		ctx = piiRequestContext(`{"messages":[{"content":"` + tt.content + `"}]}`)
+		mods = mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+		msg = mustGetLastMessageContent(t, decodeJSONMapPII(t, mods.Body))
+		if !regexp.MustCompile(`^card \[CREDIT_CARD_[0-9a-f]{4}\]` + regexp.QuoteMeta(tt.rest) + `$`).MatchString(msg) {
+			t.Fatalf("expected card number before trailing digits to be masked, got %q", msg)
+		}
+	}
+
+	pRedact := mustGetPIIPolicy(t, map[string]interface{}{
+		"creditCard": true,
+		"redactPII":  true,
+	})
+	ctx = piiRequestContext(`{"messages":[{"content":"order 1234567890123 only"}]}`)
+	mods = mustPIIRequestMods(t, pRedact.OnRequestBody(context.Background(), ctx, nil))
+	if mods.Body != nil {
+		t.Fatalf("expected no modifications for Luhn-invalid number, got body=%s", string(mods.Body))
+	}
+}
+
+func TestPIIMaskingRegexPolicy_OnRequest_IPAddresses(t *testing.T) {
+	p := mustGetPIIPolicy(t, map[string]interface{}{
+		"ipv4": true,
+		"ipv6": true,
+	})
+
+	ctx := piiRequestContext(`{"messages":[{"content":"hosts 192.168.1.10 and 2001:db8::1, not 999.1.1.1 at 10:30:00"}]}`)
+	mods := mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	msg := mustGetLastMessageContent(t, decodeJSONMapPII(t, mods.Body))
+
+	if !regexp.MustCompile(`hosts \[IPV4_[0-9a-f]{4}\] and \[IPV6_[0-9a-f]{4}\],`).MatchString(msg) {
+		t.Fatalf("expected IPv4 and IPv6 placeholders, got %q", msg)
+	}
+	if !strings.Contains(msg, "not 999.1.1.1 at 10:30:00") {
+		t.Fatalf("expected invalid address and time to be left untouched, got %q", msg)
+	}
+
+	mapping, ok := ctx.Metadata[MetadataKeyPIIEntities].(map[string]string)
+	if !ok || len(mapping) != 2 {
+		t.Fatalf("expected two restoration mappings, got %v", ctx.Metadata[MetadataKeyPIIEntities])
+	}
+
+	p = mustGetPIIPolicy(t, map[string]interface{}{"ipv6": true, "redactPII": true, "redactionStyle": "tag"})
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{content: "::1", want: "[REDACTED_IPV6]"},
+		{content: "loopback ::1.", want: "loopback [REDACTED_IPV6]."},
+		{content: "mapped ::ffff:10.0.0.1 here", want: "mapped [REDACTED_IPV6] here"},
+		{content: "(::ffff:192.168.1.10)", want: "([REDACTED_IPV6])"},
+		{content: "link fe80::1, full 2001:db8:0:0:0:0:2:1", want: "link [REDACTED_IPV6], full [REDACTED_IPV6]"},
+		{content: "call Foo::add or std::cout at 10:30:00", want: "call Foo::add or std::cout at 10:30:00"},
+		{content: "mac 00:1a:2b:3c:4d:5e", want: "mac 00:1a:2b:3c:4d:5e"},
+	}
+	for _, tt := range tests {
+		ctx := piiRequestContext(`{"messages":[{"content":"` + tt.content + `"}]}`)
+		action := p.OnRequestBody(context.Background(), ctx, nil)
+		got := tt.content
+		if mods := mustPIIRequestMods(t, action); mods.Body != nil {
+			got = mustGetLastMessageContent(t, decodeJSONMapPII(t, mods.Body))
+		}
+		if got != tt.want {
+			t.Fatalf("%q: got %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestPIIMaskingRegexPolicy_OnRequest_DeterministicPlaceholders(t *testing.T) {
+	p := mustGetPIIPolicy(t, map[string]interface{}{
+		"email":                     true,
+		"deterministicPlaceholders": true,
+	})
+
+	mask := func(body string) map[string]string {
+		t.Helper()
+		ctx := piiRequestContext(body)
+		mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+		mapping, ok := ctx.Metadata[MetadataKeyPIIEntities].(map[string]string)
+		if !ok {
+			t.Fatalf("expected pii metadata mapping, got %T", ctx.Metadata[MetadataKeyPIIEntities])
+		}
+		return mapping
+	}
+
+	first := mask(`{"messages":[{"content":"mail a.user@example.com or b.user@example.com"}]}`)
+	second := mask(`{"messages":[{"content":"again a.user@example.com, a.user@example.com"}]}`)
+
+	if len(second) != 1 {
+		t.Fatalf("expected one mapping for a repeated value, got %v", second)
+	}
+	if first["a.user@example.com"] != second["a.user@example.com"] {
+		t.Fatalf("expected stable placeholder across requests, got %q and %q",
+			first["a.user@example.com"], second["a.user@example.com"])
+	}
+	if first["a.user@example.com"] == first["b.user@example.com"] {
+		t.Fatalf("expected distinct placeholders for distinct values, got %v", first)
+	}
+
+	used := map[string]struct{}{}
+	placeholder := defaultPlaceholders.deterministic("EMAIL", "x@example.com", used)
+	used[placeholder] = struct{}{}
+	if collided := defaultPlaceholders.deterministic("EMAIL", "x@example.com", used); collided == placeholder {
+		t.Fatalf("expected a taken placeholder to be skipped, got %q twice", collided)
+	}
+}
+
+func TestPIIMaskingRegexPolicy_DeterministicPlaceholderCollision(t *testing.T) {
+	// The SHA-256 digests of these values share their first five hex digits
+	// (40d71d... and 40d714...).
+	const first, second = "user4@example.com", "user329@example.com"
+
+	p := mustGetPIIPolicy(t, map[string]interface{}{
+		"email":                     true,
+		"deterministicPlaceholders": true,
+	})
+
+	for _, order := range [][2]string{{first, second}, {second, first}} {
+		ctx := piiRequestContext(`{"messages":[{"content":"` + order[0] + `, ` + order[1] + `"}]}`)
+		mods := mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+		mapping := ctx.Metadata[MetadataKeyPIIEntities].(map[string]string)
+
+		want := map[string]string{order[0]: "[EMAIL_40d7]"}
+		if order[0] == first {
+			want[order[1]] = "[EMAIL_40d714]"
+		} else {
+			want[order[1]] = "[EMAIL_40d71d]"
+		}
+		if !reflect.DeepEqual(mapping, want) {
+			t.Fatalf("unexpected mapping: got %v, want %v", mapping, want)
+		}
+		if got := mustGetLastMessageContent(t, decodeJSONMapPII(t, mods.Body)); got != want[order[0]]+", "+want[order[1]] {
+			t.Fatalf("unexpected masked content: %q", got)
+		}
+
+		respCtx := &policy.ResponseContext{
+			SharedContext: ctx.SharedContext,
+			ResponseBody: &policy.Body{
+				Content: []byte(`{"choices":[{"message":{"content":"` + want[order[1]] + ` then ` + want[order[0]] + `"}}]}`),
+				Present: true,
+			},
+		}
+		respMods, ok := p.OnResponseBody(context.Background(), respCtx, nil).(policy.DownstreamResponseModifications)
+		if !ok || respMods.Body == nil {
+			t.Fatalf("expected response to be restored")
+		}
+		choice := decodeJSONMapPII(t, respMods.Body)["choices"].([]interface{})[0].(map[string]interface{})
+		if got, want := choice["message"].(map[string]interface{})["content"], order[1]+" then "+order[0]; got != want {
+			t.Fatalf("unexpected restored content: got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestPIIMaskingRegexPolicy_Recursive(t *testing.T) {
+	body := `{"user":{"email":"a@x.com","age":42,"id":12345678901234567890,"tags":["b@x.com",true,null,[" a@x.com"]]},` +
+		`"messages":[{"role":"user","content":"mail a@x.com"}]}`
+
+	tests := []struct {
+		name     string
+		params   map[string]interface{}
+		body     string
+		wantBody string
+	}{
+		{
+			name:   "whole document",
+			params: map[string]interface{}{"jsonPath": ""},
+			body:   body,
+			wantBody: `{"messages":[{"content":"mail [EMAIL_0000]","role":"user"}],` +
+				`"user":{"age":42,"email":"[EMAIL_0000]","id":12345678901234567890,"tags":["[EMAIL_0001]",true,null,[" [EMAIL_0000]"]]}}`,
+		},
+		{
+			name:   "object at jsonPath",
+			params: map[string]interface{}{"jsonPath": "$.user"},
+			body:   body,
+			wantBody: `{"messages":[{"content":"mail a@x.com","role":"user"}],` +
+				`"user":{"age":42,"email":"[EMAIL_0000]","id":12345678901234567890,"tags":["[EMAIL_0001]",true,null,[" [EMAIL_0000]"]]}}`,
+		},
+		{
+			name:   "string at jsonPath",
+			params: map[string]interface{}{"jsonPath": "$.user.email"},
+			body:   body,
+			wantBody: `{"messages":[{"content":"mail a@x.com","role":"user"}],` +
+				`"user":{"age":42,"email":"[EMAIL_0000]","id":12345678901234567890,"tags":["b@x.com",true,null,[" a@x.com"]]}}`,
+		},
+		{
+			name:   "redact",
+			params: map[string]interface{}{"jsonPath": "$.user.tags", "redactPII": true},
+			body:   body,
+			wantBody: `{"messages":[{"content":"mail a@x.com","role":"user"}],` +
+				`"user":{"age":42,"email":"a@x.com","id":12345678901234567890,"tags":["*****",true,null,[" *****"]]}}`,
+		},
+		{
+			name:     "non-JSON body is masked as text",
+			params:   map[string]interface{}{"jsonPath": ""},
+			body:     "contact a@x.com",
+			wantBody: "contact [EMAIL_0000]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]interface{}{"email": true, "recursive": true}
+			for k, v := range tt.params {
+				params[k] = v
+			}
+			p := mustGetPIIPolicy(t, params)
+			ctx := piiRequestContext(tt.body)
+			mods := mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+			if got := string(mods.Body); got != tt.wantBody {
+				t.Fatalf("unexpected body:\ngot  %s\nwant %s", got, tt.wantBody)
+			}
+		})
+	}
+
+	p := mustGetPIIPolicy(t, map[string]interface{}{"email": true, "recursive": true, "jsonPath": ""})
+	ctx := piiRequestContext(body)
+	mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	respCtx := &policy.ResponseContext{
+		SharedContext: ctx.SharedContext,
+		ResponseBody: &policy.Body{
+			Content: []byte(`{"choices":[{"message":{"content":"to [EMAIL_0001]"}}],"echo":{"to":["[EMAIL_0000]"]}}`),
+			Present: true,
+		},
+	}
+	respMods, ok := p.OnResponseBody(context.Background(), respCtx, nil).(policy.DownstreamResponseModifications)
+	if !ok || respMods.Body == nil {
+		t.Fatalf("expected response to be restored")
+	}
+	if got, want := string(respMods.Body), `{"choices":[{"message":{"content":"to b@x.com"}}],"echo":{"to":["a@x.com"]}}`; got != want {
+		t.Fatalf("unexpected restored body: got %s, want %s", got, want)
+	}
+}
+
+func TestPIIMaskingRegexPolicy_Recursive_SkipPaths(t *testing.T) {
+	body := `{"system_prompt":"escalate to ops@x.com","user":{"email":"a@x.com","notes":["ops@x.com","b@x.com"]}}`
+
+	tests := []struct {
+		name      string
+		jsonPath  string
+		skipPaths []interface{}
+		wantBody  string
+	}{
+		{
+			name:      "skipped field kept verbatim",
+			skipPaths: []interface{}{"$.system_prompt"},
+			wantBody:  `{"system_prompt":"escalate to ops@x.com","user":{"email":"[EMAIL_0000]","notes":["[EMAIL_0001]","[EMAIL_0002]"]}}`,
+		},
+		{
+			name:      "skipped object and array element",
+			skipPaths: []interface{}{"system_prompt", "$.user.notes[1]"},
+			wantBody:  `{"system_prompt":"escalate to ops@x.com","user":{"email":"[EMAIL_0000]","notes":["[EMAIL_0001]","b@x.com"]}}`,
+		},
+		{
+			name:      "skip path below jsonPath",
+			jsonPath:  "$.user",
+			skipPaths: []interface{}{"$.user.email"},
+			wantBody:  `{"system_prompt":"escalate to ops@x.com","user":{"email":"a@x.com","notes":["[EMAIL_0000]","[EMAIL_0001]"]}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := mustGetPIIPolicy(t, map[string]interface{}{
+				"email":     true,
+				"recursive": true,
+				"jsonPath":  tt.jsonPath,
+				"skipPaths": tt.skipPaths,
+			})
+			ctx := piiRequestContext(body)
+			mods := mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+			if got := string(mods.Body); got != tt.wantBody {
+				t.Fatalf("unexpected body:\ngot  %s\nwant %s", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestPIIMaskingRegexPolicy_AdjacentMatches(t *testing.T) {
+	body := `{"messages":[{"content":"a@x.com,a@x.com.uk;a@x.com, a@x.com.uk"}]}`
+
+	tests := []struct {
+		name   string
+		params map[string]interface{}
+		want   string
+	}{
+		{
+			name:   "mask",
+			params: map[string]interface{}{"email": true},
+			want:   "[EMAIL_0000],[EMAIL_0001];[EMAIL_0000], [EMAIL_0001]",
+		},
+		{
+			name:   "redact",
+			params: map[string]interface{}{"email": true, "redactPII": true},
+			want:   "*****,*****;*****, *****",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := mustGetPIIPolicy(t, tt.params)
+			ctx := piiRequestContext(body)
+			mods := mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+			if got := mustGetLastMessageContent(t, decodeJSONMapPII(t, mods.Body)); got != tt.want {
+				t.Fatalf("unexpected masked content: got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	p := mustGetPIIPolicy(t, map[string]interface{}{"email": true})
+	ctx := piiRequestContext(body)
+	mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	respCtx := &policy.ResponseContext{
+		SharedContext: ctx.SharedContext,
+		ResponseBody: &policy.Body{
+			Content: []byte(`{"choices":[{"message":{"content":"[EMAIL_0001],[EMAIL_0000][EMAIL_0001]"}}]}`),
+			Present: true,
+		},
+	}
+	respMods, ok := p.OnResponseBody(context.Background(), respCtx, nil).(policy.DownstreamResponseModifications)
+	if !ok || respMods.Body == nil {
+		t.Fatalf("expected response to be restored")
+	}
+	choice := decodeJSONMapPII(t, respMods.Body)["choices"].([]interface{})[0].(map[string]interface{})
+	if got, want := choice["message"].(map[string]interface{})["content"], "a@x.com.uk,a@x.coma@x.com.uk"; got != want {
+		t.Fatalf("unexpected restored content: got %q, want %q", got, want)
+	}
+
+	// Restored values are never rescanned, even when an original looks like
+	// another placeholder.
+	maskedMap := map[string]string{"[EMAIL_0000]": "[EMAIL_0001]", "[EMAIL_0001]": "b@x.com"}
+	for i := 0; i < 20; i++ {
+		if got, want := p.restore("[EMAIL_0000],[EMAIL_0001]", maskedMap), "[EMAIL_0001],b@x.com"; got != want {
+			t.Fatalf("unexpected restored content: got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestPIIMaskingRegexPolicy_PlaceholderFormat(t *testing.T) {
+	p := mustGetPIIPolicy(t, map[string]interface{}{
+		"email":             true,
+		"placeholderFormat": "<<{entity}:{id}>>",
+	})
+
+	ctx := piiRequestContext(`{"messages":[{"content":"mail a.user@example.com or <<EMAIL:0000>>"}]}`)
+	mods := mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	msg := mustGetLastMessageContent(t, decodeJSONMapPII(t, mods.Body))
+	if got, want := msg, "mail <<EMAIL:0000>> or <<EMAIL:0000>>"; got != want {
+		t.Fatalf("unexpected masked content: got %q, want %q", got, want)
+	}
+
+	respCtx := &policy.ResponseContext{
+		SharedContext: ctx.SharedContext,
+		ResponseBody: &policy.Body{
+			Content: []byte(`{"choices":[{"message":{"content":"Sent to <<EMAIL:0000>>, not [EMAIL_0000] or <<EMAIL:0001>>"}}]}`),
+			Present: true,
+		},
+	}
+	respMods, ok := p.OnResponseBody(context.Background(), respCtx, nil).(policy.DownstreamResponseModifications)
+	if !ok || respMods.Body == nil {
+		t.Fatalf("expected response to be restored")
+	}
+	choice := decodeJSONMapPII(t, respMods.Body)["choices"].([]interface{})[0].(map[string]interface{})
+	if got, want := choice["message"].(map[string]interface{})["content"], "Sent to a.user@example.com, not [EMAIL_0000] or <<EMAIL:0001>>"; got != want {
+		t.Fatalf("unexpected restored content: got %q, want %q", got, want)
+	}
+
+	sse := func(content string) string {
+		return `data: {"choices":[{"delta":{"content":"` + content + `"}}]}` + "\n"
+	}
+	if !p.NeedsMoreResponseData([]byte(sse("Sent to <"))) {
+		t.Fatalf("expected a partial opening to be held back")
+	}
+	if !p.NeedsMoreResponseData([]byte(sse("Sent to <") + sse("<EMAIL:00"))) {
+		t.Fatalf("expected an unclosed placeholder to be held back")
+	}
+	if p.NeedsMoreResponseData([]byte(sse("Sent to <") + sse("<EMAIL:0000>>"))) {
+		t.Fatalf("expected a complete placeholder not to be held back")
+	}
+	if p.NeedsMoreResponseData([]byte(sse("Sent to [EMAIL"))) {
+		t.Fatalf("expected the default opening to be ignored")
+	}
+}
+
+func TestPIIMaskingRegexPolicy_OnRequest_MaskGroup(t *testing.T) {
+	entities := []interface{}{
+		map[string]interface{}{
+			"piiEntity": "EMAIL_USER",
+			"piiRegex":  `\b(?P<local>[a-z.]+)@example\.com\b`,
+			"maskGroup": "local",
+		},
+	}
+
+	p := mustGetPIIPolicy(t, map[string]interface{}{"customPIIEntities": entities})
+	ctx := piiRequestContext(`{"messages":[{"content":"mail a.user@example.com today"}]}`)
+	mods := mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	msg := mustGetLastMessageContent(t, decodeJSONMapPII(t, mods.Body))
+	if !regexp.MustCompile(`^mail \[EMAIL_USER_[0-9a-f]{4}\]@example\.com today$`).MatchString(msg) {
+		t.Fatalf("expected only the local part to be masked, got %q", msg)
+	}
+	mapping := ctx.Metadata[MetadataKeyPIIEntities].(map[string]string)
+	if _, ok := mapping["a.user"]; !ok || len(mapping) != 1 {
+		t.Fatalf("expected mapping for the masked group only, got %v", mapping)
+	}
+
+	pRedact := mustGetPIIPolicy(t, map[string]interface{}{"customPIIEntities": entities, "redactPII": true})
+	ctx = piiRequestContext(`{"messages":[{"content":"mail a.user@example.com today"}]}`)
+	mods = mustPIIRequestMods(t, pRedact.OnRequestBody(context.Background(), ctx, nil))
+	if got, want := mustGetLastMessageContent(t, decodeJSONMapPII(t, mods.Body)), "mail *****@example.com today"; got != want {
+		t.Fatalf("unexpected redacted content: got %q, want %q", got, want)
+	}
+}
+
+func TestPIIMaskingRegexPolicy_OnRequest_EntityMinLength(t *testing.T) {
+	number := func(extra map[string]interface{}) map[string]interface{} {
+		entity := map[string]interface{}{"piiEntity": "NUMBER", "piiRegex": `\d[\d ]*\d`, "minLength": 6}
+		for k, v := range extra {
+			entity[k] = v
+		}
+		return entity
+	}
+
+	tests := []struct {
+		name   string
+		params map[string]interface{}
+		body   string
+		want   string
+	}{
+		{
+			name:   "short matches ignored",
+			params: map[string]interface{}{"customPIIEntities": []interface{}{number(nil)}},
+			body:   "pin 1234, account 12345678",
+			want:   "pin 1234, account [NUMBER_0000]",
+		},
+		{
+			name:   "zero disables the filter",
+			params: map[string]interface{}{"customPIIEntities": []interface{}{number(map[string]interface{}{"minLength": 0})}},
+			body:   "pin 1234, account 12345678",
+			want:   "pin [NUMBER_0000], account [NUMBER_0001]",
+		},
+		{
+			name: "measured on the mask group",
+			params: map[string]interface{}{"customPIIEntities": []interface{}{
+				number(map[string]interface{}{"piiRegex": `acct-(?P<id>\d+)`, "maskGroup": "id"}),
+			}},
+			body: "acct-1234 and acct-123456",
+			want: "acct-1234 and acct-[NUMBER_0000]",
+		},
+		{
+			name: "partial masking applies to kept matches",
+			params: map[string]interface{}{
+				"customPIIEntities": []interface{}{number(nil)},
+				"preserveLastN":     4,
+			},
+			body: "pin 1234, account 12345678",
+			want: "pin 1234, account ****5678",
+		},
+		{
+			name: "filtered match does not shadow a credit card",
+			params: map[string]interface{}{
+				"customPIIEntities": []interface{}{number(map[string]interface{}{"minLength": 20, "priority": 1})},
+				"creditCard":        true,
+			},
+			body: "card 4111 1111 1111 1111",
+			want: "card [CREDIT_CARD_0000]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := mustGetPIIPolicy(t, tt.params)
+			ctx := piiRequestContext(`{"messages":[{"content":"` + tt.body + `"}]}`)
+			mods := mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+			got := tt.body
+			if mods.Body != nil {
+				got = mustGetLastMessageContent(t, decodeJSONMapPII(t, mods.Body))
+			}
+			if got != tt.want {
+				t.Fatalf("unexpected content: got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPIIMaskingRegexPolicy_OnRequest_EntityPriority(t *testing.T) {
+	p := mustGetPIIPolicy(t, map[string]interface{}{
+		"phone": true,
+		"customPIIEntities": []interface{}{
+			// Shorter than the phone number, but its priority wins the overlap.
+			map[string]interface{}{"piiEntity": "LINE", "piiRegex": `[0-9]{4}\b`, "priority": 1},
+		},
+		"redactPII":      true,
+		"redactionStyle": "tag",
+	})
+
+	ctx := piiRequestContext(`{"messages":[{"content":"call 415-555-2671"}]}`)
+	mods := mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	if got, want := mustGetLastMessageContent(t, decodeJSONMapPII(t, mods.Body)), "call 415-555-[REDACTED_LINE]"; got != want {
+		t.Fatalf("unexpected redacted content: got %q, want %q", got, want)
+	}
+}
+
+func TestPIIMaskingRegexPolicy_OnRequest_DeterministicPlaceholderAssignment(t *testing.T) {
+	params := map[string]interface{}{
+		"email": true,
+		"phone": true,
+		"ssn":   true,
+		"customPIIEntities": []interface{}{
+			map[string]interface{}{"piiEntity": "ACCOUNT_B", "piiRegex": `ACC-[0-9]+`},
+			map[string]interface{}{"piiEntity": "ACCOUNT_A", "piiRegex": `ACC-[0-9]+`},
+			map[string]interface{}{"piiEntity": "TICKET", "piiRegex": `T-[0-9]+`, "priority": 2},
+		},
+	}
+	body := `{"messages":[{"content":"ACC-1 a@example.com T-9 415-555-2671 ACC-1 123-45-6789 b@example.com"}]}`
+
+	var want string
+	for i := 0; i < 200; i++ {
+		p := mustGetPIIPolicy(t, params)
+		ctx := piiRequestContext(body)
+		mods := mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+		got := mustGetLastMessageContent(t, decodeJSONMapPII(t, mods.Body))
+		if i == 0 {
+			want = got
+			continue
+		}
+		if got != want {
+			t.Fatalf("iteration %d: placeholder assignment changed: got %q, want %q", i, got, want)
+		}
+	}
+	if !strings.HasPrefix(want, "[ACCOUNT_A_0000] [EMAIL_0001] [TICKET_0002]") {
+		t.Fatalf("unexpected placeholder assignment: %q", want)
+	}
+}
+
+func TestPIIMaskingRegexPolicy_OnRequest_KeyValueMode(t *testing.T) {
+	p := mustGetPIIPolicy(t, map[string]interface{}{
+		"customPIIEntities": []interface{}{
+			map[string]interface{}{
+				"piiEntity":       "CONTACT",
+				"piiRegex":        "e-?mail|phone",
+				"keyValueMode":    true,
+				"caseInsensitive": true,
+			},
+		},
+		"redactPII":      true,
+		"redactionStyle": "tag",
+	})
+
+	ctx := piiRequestContext(`{"messages":[{"content":"see ?Email=a.user@example.com&phone=555-0100; user_email=x, mail=y"}]}`)
+	mods := mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	got := mustGetLastMessageContent(t, decodeJSONMapPII(t, mods.Body))
+	want := "see ?Email=[REDACTED_CONTACT]&phone=[REDACTED_CONTACT]; user_email=x, mail=y"
+	if got != want {
+		t.Fatalf("unexpected redacted content: got %q, want %q", got, want)
+	}
+}
+
+func TestPIIMaskingRegexPolicy_OnRequest_OverlappingEntities(t *testing.T) {
+	p := mustGetPIIPolicy(t, map[string]interface{}{
+		"phone": true,
+		"customPIIEntities": []interface{}{
+			// Overlaps the phone number but is shorter, so PHONE wins.
+			map[string]interface{}{"piiEntity": "DIGITS", "piiRegex": `[0-9]{4}\b`},
+			// Same span as ACCOUNT_B; ties are broken by entity name.
+			map[string]interface{}{"piiEntity": "ACCOUNT_B", "piiRegex": `ACC-[0-9]+`},
+			map[string]interface{}{"piiEntity": "ACCOUNT_A", "piiRegex": `ACC-[0-9]+`},
+		},
+		"redactPII":      true,
+		"redactionStyle": "tag",
+	})
+
+	ctx := piiRequestContext(`{"messages":[{"content":"call 415-555-2671 re ACC-77 pin 9876"}]}`)
+	mods := mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	got := mustGetLastMessageContent(t, decodeJSONMapPII(t, mods.Body))
+	want := "call [REDACTED_PHONE] re [REDACTED_ACCOUNT_A] pin [REDACTED_DIGITS]"
+	if got != want {
+		t.Fatalf("unexpected redacted content: got %q, want %q", got, want)
+	}
+
+	pMask := mustGetPIIPolicy(t, map[string]interface{}{
+		"phone": true,
+		"customPIIEntities": []interface{}{
+			map[string]interface{}{"piiEntity": "DIGITS", "piiRegex": `[0-9]{4}\b`},
+		},
+	})
+	ctx = piiRequestContext(`{"messages":[{"content":"call 415-555-2671 pin 9876"}]}`)
+	mods = mustPIIRequestMods(t, pMask.OnRequestBody(context.Background(), ctx, nil))
+	got = mustGetLastMessageContent(t, decodeJSONMapPII(t, mods.Body))
+	if got != "call [PHONE_0000] pin [DIGITS_0001]" {
+		t.Fatalf("unexpected masked content: got %q", got)
+	}
+	mapping := ctx.Metadata[MetadataKeyPIIEntities].(map[string]string)
+	if mapping["415-555-2671"] != "[PHONE_0000]" || mapping["9876"] != "[DIGITS_0001]" || len(mapping) != 2 {
+		t.Fatalf("unexpected mapping: %v", mapping)
+	}
+}
+
+func TestPIIMaskingRegexPolicy_OnRequest_RedactMode(t *testing.T) {
+	p := mustGetPIIPolicy(t, map[string]interface{}{
+		"email":     true,
+		"redactPII": true,
+	})
+
+	ctx := piiRequestContext(`{"messages":[{"content":"email a.user@example.com"}]}`)
+	action := p.OnRequestBody(context.Background(), ctx, nil)
+	mods := mustPIIRequestMods(t, action)
+	out := decodeJSONMapPII(t, mods.Body)
+	msg := mustGetLastMessageContent(t, out)
+	if !strings.Contains(msg, "*****") {
+		t.Fatalf("expected redacted content, got %q", msg)
+	}
+	if _, exists := ctx.Metadata[MetadataKeyPIIEntities]; exists {
+		t.Fatalf("did not expect metadata mapping in redact mode")
+	}
+}
+
+func TestDetectPII(t *testing.T) {
+	entities := map[string]*regexp.Regexp{
+		DefaultEmailEntityName: regexp.MustCompile(DefaultEmailRegex),
+		"DIGITS":               regexp.MustCompile(`[0-9]+`),
+		"HANDLE":               regexp.MustCompile(`[a-z]+`),
+	}
+	content := "mail a.user@example.com id 42"
+
+	got := DetectPII(content, entities)
+	want := []Match{
+		{Entity: "HANDLE", Start: 0, End: 4, Value: "mail"},
+		{Entity: DefaultEmailEntityName, Start: 5, End: 23, Value: "a.user@example.com"},
+		{Entity: "HANDLE", Start: 24, End: 26, Value: "id"},
+		{Entity: "DIGITS", Start: 27, End: 29, Value: "42"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected matches:\n got %+v\nwant %+v", got, want)
+	}
+	if content != "mail a.user@example.com id 42" {
+		t.Fatalf("content was modified")
+	}
+	if matches := DetectPII("nothing here!", map[string]*regexp.Regexp{"DIGITS": entities["DIGITS"]}); matches != nil {
+		t.Fatalf("expected no matches, got %+v", matches)
+	}
+}
+
+func TestPIIMaskingRegexPolicy_OnRequest_PerMessage(t *testing.T) {
+	p := mustGetPIIPolicy(t, map[string]interface{}{
+		"email":      true,
+		"phone":      true,
+		"jsonPath":   "$.messages",
+		"perMessage": true,
+	})
+
+	ctx := piiRequestContext(`{"messages":[` +
+		`{"role":"system","content":"reply to a.user@example.com"},` +
+		`{"role":"assistant","tool_calls":[]},` +
+		`{"role":"user","content":[{"type":"text","text":"call 415-555-2671"},{"type":"image_url","image_url":{"url":"x"}}]},` +
+		`{"role":"user","content":"cc a.user@example.com"}]}`)
+	mods := mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	out := decodeJSONMapPII(t, mods.Body)
+	messages := out["messages"].([]interface{})
+	if got := messages[0].(map[string]interface{})["content"]; got != "reply to [EMAIL_0000]" {
+		t.Fatalf("unexpected first message: %v", got)
+	}
+	if _, exists := messages[1].(map[string]interface{})["content"]; exists {
+		t.Fatalf("expected message without content to be left untouched")
+	}
+	parts := messages[2].(map[string]interface{})["content"].([]interface{})
+	if got := parts[0].(map[string]interface{})["text"]; got != "call [PHONE_0001]" {
+		t.Fatalf("unexpected text part: %v", got)
+	}
+	if got := messages[3].(map[string]interface{})["content"]; got != "cc [EMAIL_0000]" {
+		t.Fatalf("unexpected last message: %v", got)
+	}
+	mapping := ctx.Metadata[MetadataKeyPIIEntities].(map[string]string)
+	if len(mapping) != 2 {
+		t.Fatalf("expected one shared mapping for all messages, got %v", mapping)
+	}
+}
+
+func TestPIIMaskingRegexPolicy_OnRequest_PerMessageDisabled(t *testing.T) {
+	p := mustGetPIIPolicy(t, map[string]interface{}{
+		"email":    true,
+		"jsonPath": "$.messages",
+	})
+
+	ctx := piiRequestContext(`{"messages":[{"role":"user","content":"a.user@example.com"}]}`)
+	mods := mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	if mods.Body != nil {
+		t.Fatalf("expected message array to be skipped without perMessage, got %s", string(mods.Body))
+	}
+}
+
+func TestPIIMaskingRegexPolicy_OnRequest_PerEntityModes(t *testing.T) {
+	p := mustGetPIIPolicy(t, map[string]interface{}{
+		"email":       true,
+		"ssn":         true,
+		"entityModes": map[string]interface{}{"ssn": "redact"},
+		"customPIIEntities": []interface{}{
+			map[string]interface{}{"piiEntity": "CODE", "piiRegex": "code-[0-9]+", "mode": "redact"},
+		},
+	})
+
+	ctx := piiRequestContext(`{"messages":[{"content":"email a.user@example.com ssn 123-45-6789 code-42"}]}`)
+	action := p.OnRequestBody(context.Background(), ctx, nil)
+	mods := mustPIIRequestMods(t, action)
+	out := decodeJSONMapPII(t, mods.Body)
+	msg := mustGetLastMessageContent(t, out)
+	if got, want := msg, "email [EMAIL_0000] ssn ***** *****"; got != want {
+		t.Fatalf("unexpected content: got %q, want %q", got, want)
+	}
+	mapping := ctx.Metadata[MetadataKeyPIIEntities].(map[string]string)
+	if len(mapping) != 1 || mapping["a.user@example.com"] != "[EMAIL_0000]" {
+		t.Fatalf("expected only the masked email in metadata, got %v", mapping)
+	}
+
+	respCtx := &policy.ResponseContext{
+		SharedContext: ctx.SharedContext,
+		ResponseBody: &policy.Body{
+			Content: []byte(`{"answer":"Found [EMAIL_0000] and *****"}`),
+			Present: true,
+		},
+	}
+	respMods, ok := p.OnResponseBody(context.Background(), respCtx, nil).(policy.DownstreamResponseModifications)
+	if !ok {
+		t.Fatalf("expected DownstreamResponseModifications")
+	}
+	if got, want := string(respMods.Body), `{"answer":"Found a.user@example.com and *****"}`; got != want {
+		t.Fatalf("unexpected restored body: got %s, want %s", got, want)
+	}
+}
+
+func TestPIIMaskingRegexPolicy_OnRequest_EntityModeMaskOverridesRedactPII(t *testing.T) {
+	p := mustGetPIIPolicy(t, map[string]interface{}{
+		"email":       true,
+		"ssn":         true,
+		"redactPII":   true,
+		"entityModes": map[string]interface{}{"EMAIL": "mask"},
+	})
+
+	ctx := piiRequestContext(`{"messages":[{"content":"a.user@example.com 123-45-6789"}]}`)
+	mods := mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	msg := mustGetLastMessageContent(t, decodeJSONMapPII(t, mods.Body))
+	if got, want := msg, "[EMAIL_0000] *****"; got != want {
+		t.Fatalf("unexpected content: got %q, want %q", got, want)
+	}
+	if !p.restoresResponses() {
+		t.Fatalf("expected responses to be restored when an entity is masked")
+	}
+}
+
+func TestPIIMaskingRegexPolicy_OnRequest_RedactionStyles(t *testing.T) {
+	tests := []struct {
+		style string
+		want  string
+	}{
+		{style: "fixed", want: "email ***** now"},
+		{style: "lengthPreserving", want: "email " + strings.Repeat("*", len("a.user@example.com")) + " now"},
+		{style: "tag", want: "email [REDACTED_EMAIL] now"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.style, func(t *testing.T) {
+			p := mustGetPIIPolicy(t, map[string]interface{}{
+				"email":          true,
+				"redactPII":      true,
+				"redactionStyle": tt.style,
+			})
+			ctx := piiRequestContext(`{"messages":[{"content":"email a.user@example.com now"}]}`)
+			mods := mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+			if got := mustGetLastMessageContent(t, decodeJSONMapPII(t, mods.Body)); got != tt.want {
+				t.Fatalf("unexpected redacted content: got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPIIMaskingRegexPolicy_OnRequest_PreserveLastN(t *testing.T) {
+	for _, redact := range []bool{false, true} {
+		p := mustGetPIIPolicy(t, map[string]interface{}{
+			"ssn":           true,
+			"redactPII":     redact,
+			"preserveLastN": 4,
+		})
+		ctx := piiRequestContext(`{"messages":[{"content":"ssn 123-45-6789 on file"}]}`)
+		mods := mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+		if got, want := mustGetLastMessageContent(t, decodeJSONMapPII(t, mods.Body)), "ssn ***-**-6789 on file"; got != want {
+			t.Fatalf("redactPII=%v: unexpected content: got %q, want %q", redact, got, want)
+		}
+		if _, exists := ctx.Metadata[MetadataKeyPIIEntities]; exists {
+			t.Fatalf("redactPII=%v: expected partially masked values not to be stored for restoration", redact)
+		}
+	}
+
+	if got := partialMask("12-3", 4); got != "**-*" {
+		t.Fatalf("expected short match to be fully masked, got %q", got)
+	}
+}
+
+func TestPIIMaskingRegexPolicy_HashMode(t *testing.T) {
+	p := mustGetPIIPolicy(t, map[string]interface{}{
+		"email":    true,
+		"hashPII":  true,
+		"hashSalt": "s3cret",
+	})
+
+	ctx := piiRequestContext(`{"messages":[{"content":"a.user@example.com and a.user@example.com"}]}`)
+	mods := mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	msg := mustGetLastMessageContent(t, decodeJSONMapPII(t, mods.Body))
+
+	token := hashedToken("EMAIL", "a.user@example.com", "s3cret")
+	if !regexp.MustCompile(`^EMAIL:[0-9a-f]{16}$`).MatchString(token) {
+		t.Fatalf("unexpected token format: %q", token)
+	}
+	if want := token + " and " + token; msg != want {
+		t.Fatalf("unexpected hashed content: got %q, want %q", msg, want)
+	}
+	if hashedToken("EMAIL", "a.user@example.com", "other") == token {
+		t.Fatalf("expected token to depend on hashSalt")
+	}
+	if _, exists := ctx.Metadata[MetadataKeyPIIEntities]; exists {
+		t.Fatalf("did not expect a restoration mapping in hash mode")
+	}
+
+	respCtx := &policy.ResponseContext{
+		SharedContext: &policy.SharedContext{
+			Metadata: map[string]interface{}{
+				MetadataKeyPIIEntities: map[string]string{"a.user@example.com": "[EMAIL_0000]"},
+			},
+		},
+		ResponseBody: &policy.Body{Content: []byte(`{"x":"[EMAIL_0000]"}`), Present: true},
+	}
+	resp, ok := p.OnResponseBody(context.Background(), respCtx, nil).(policy.DownstreamResponseModifications)
+	if !ok || resp.Body != nil {
+		t.Fatalf("expected no response restoration in hash mode, got %#v", resp)
+	}
+}
+
+func TestPIIMaskingRegexPolicy_OnRequest_EntityCounts(t *testing.T) {
+	body := `{"messages":[{"content":"a.user@example.com, b.user@example.com, a.user@example.com or 415-555-2671"}]}`
+
+	for _, redact := range []bool{false, true} {
+		p := mustGetPIIPolicy(t, map[string]interface{}{
+			"email":     true,
+			"phone":     true,
+			"redactPII": redact,
+		})
+		ctx := piiRequestContext(body)
+		mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+
+		counts, ok := ctx.Metadata[MetadataKeyPIICounts].(map[string]int)
+		if !ok {
+			t.Fatalf("redactPII=%v: expected counts metadata, got %T", redact, ctx.Metadata[MetadataKeyPIICounts])
+		}
+		if counts["EMAIL"] != 2 || counts["PHONE"] != 1 || len(counts) != 2 {
+			t.Fatalf("redactPII=%v: unexpected counts: %v", redact, counts)
+		}
+	}
+
+	p := mustGetPIIPolicy(t, map[string]interface{}{"email": true})
+	ctx := piiRequestContext(`{"messages":[{"content":"no pii here"}]}`)
+	mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	if _, exists := ctx.Metadata[MetadataKeyPIICounts]; exists {
+		t.Fatalf("expected counts to be omitted when nothing matched")
+	}
+}
+
+func TestPIIMaskingRegexPolicy_OnRequest_AuditRecord(t *testing.T) {
+	body := `{"messages":[{"content":"b.user@example.com, a.user@example.com, b.user@example.com or 415-555-2671"}]}`
+	digest := func(value string) string {
+		mac := hmac.New(sha256.New, []byte("audit-salt"))
+		mac.Write([]byte(value))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+
+	tests := []struct {
+		name   string
+		params map[string]interface{}
+		want   string
+	}{
+		{
+			name:   "mask",
+			params: map[string]interface{}{},
+			want: `{"entities":[` +
+				`{"entity":"EMAIL","count":2,"items":[{"placeholder":"[EMAIL_0000]","hash":"` + digest("b.user@example.com") + `"},{"placeholder":"[EMAIL_0001]","hash":"` + digest("a.user@example.com") + `"}]},` +
+				`{"entity":"PHONE","count":1,"items":[{"placeholder":"[PHONE_0002]","hash":"` + digest("415-555-2671") + `"}]}]}`,
+		},
+		{
+			name:   "redact",
+			params: map[string]interface{}{"redactPII": true},
+			want: `{"entities":[` +
+				`{"entity":"EMAIL","count":2,"items":[{"placeholder":"*****","hash":"` + digest("a.user@example.com") + `"},{"placeholder":"*****","hash":"` + digest("b.user@example.com") + `"}]},` +
+				`{"entity":"PHONE","count":1,"items":[{"placeholder":"*****","hash":"` + digest("415-555-2671") + `"}]}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]interface{}{"email": true, "phone": true, "auditRecord": true, "hashSalt": "audit-salt"}
+			for k, v := range tt.params {
+				params[k] = v
+			}
+			p := mustGetPIIPolicy(t, params)
+
+			for i := 0; i < 20; i++ {
+				ctx := piiRequestContext(body)
+				mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+
+				record, ok := ctx.Metadata[MetadataKeyPIIAudit].(string)
+				if !ok {
+					t.Fatalf("expected audit record in metadata, got %T", ctx.Metadata[MetadataKeyPIIAudit])
+				}
+				if record != tt.want {
+					t.Fatalf("unexpected audit record:\ngot  %s\nwant %s", record, tt.want)
+				}
+				for _, plaintext := range []string{"a.user@example.com", "b.user@example.com", "415-555-2671"} {
+					if strings.Contains(record, plaintext) {
+						t.Fatalf("audit record leaks plaintext %q: %s", plaintext, record)
+					}
+				}
+			}
+		})
+	}
+
+	p := mustGetPIIPolicy(t, map[string]interface{}{"email": true})
+	ctx := piiRequestContext(body)
+	mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	if _, exists := ctx.Metadata[MetadataKeyPIIAudit]; exists {
+		t.Fatalf("expected no audit record unless auditRecord is enabled")
+	}
+}
+
+func TestPIIMaskingRegexPolicy_OnRequest_Allowlist(t *testing.T) {
+	body := `{"messages":[{"content":"write to Support@Example.com or a.user@example.com"}]}`
+
+	p := mustGetPIIPolicy(t, map[string]interface{}{
+		"email":     true,
+		"allowlist": []interface{}{" support@example.com "},
+	})
+	ctx := piiRequestContext(body)
+	mods := mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	if got := mustGetLastMessageContent(t, decodeJSONMapPII(t, mods.Body)); got != "write to [EMAIL_0000] or [EMAIL_0001]" {
+		t.Fatalf("expected case-sensitive allowlist not to match, got %q", got)
+	}
+
+	p = mustGetPIIPolicy(t, map[string]interface{}{
+		"email":                    true,
+		"allowlist":                []interface{}{" support@example.com "},
+		"allowlistCaseInsensitive": true,
+	})
+	ctx = piiRequestContext(body)
+	mods = mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	if got, want := mustGetLastMessageContent(t, decodeJSONMapPII(t, mods.Body)), "write to Support@Example.com or [EMAIL_0000]"; got != want {
+		t.Fatalf("unexpected masked content: got %q, want %q", got, want)
+	}
+	mapping := ctx.Metadata[MetadataKeyPIIEntities].(map[string]string)
+	if _, exists := mapping["Support@Example.com"]; exists || len(mapping) != 1 {
+		t.Fatalf("expected allowlisted value to be absent from the mapping, got %v", mapping)
+	}
+}
+
+func TestPIIMaskingRegexPolicy_OnRequest_MaxInputBytes(t *testing.T) {
+	p := mustGetPIIPolicy(t, map[string]interface{}{
+		"email":         true,
+		"maxInputBytes": 20,
+	})
+
+	ctx := piiRequestContext(`{"messages":[{"content":"a@example.com"}]}`)
+	mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+
+	ctx = piiRequestContext(`{"messages":[{"content":"contact a.user@example.com today"}]}`)
+	resp, ok := p.OnRequestBody(context.Background(), ctx, nil).(policy.ImmediateResponse)
+	if !ok {
+		t.Fatalf("expected ImmediateResponse for oversized content")
+	}
+	if !strings.Contains(string(resp.Body), "exceeding maxInputBytes 20") {
+		t.Fatalf("unexpected error body: %s", string(resp.Body))
+	}
+	assertPIIErrorCode(t, resp.Body, ErrorCodeInputTooLarge)
+}
+
+func TestPIIMaskingRegexPolicy_OnError(t *testing.T) {
+	tests := []struct {
+		name        string
+		onError     string
+		wantPassed  bool
+		wantMessage string
+	}{
+		{name: "default rejects", wantMessage: "error extracting value from JSONPath"},
+		{name: "reject", onError: OnErrorReject, wantMessage: "error extracting value from JSONPath"},
+		{name: "passthrough", onError: OnErrorPassthrough, wantPassed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]interface{}{
+				"email":          true,
+				"maxInputBytes":  20,
+				"redactResponse": true,
+			}
+			if tt.onError != "" {
+				params["onError"] = tt.onError
+			}
+			p := mustGetPIIPolicy(t, params)
+
+			reqAction := p.OnRequestBody(context.Background(), piiRequestContext(`not json a.user@example.com`), nil)
+			respCtx := &policy.ResponseContext{
+				SharedContext: &policy.SharedContext{Metadata: map[string]interface{}{}},
+				ResponseBody: &policy.Body{
+					Content: []byte(`{"messages":[{"content":"contact a.user@example.com today"}]}`),
+					Present: true,
+				},
+			}
+			respAction := p.OnResponseBody(context.Background(), respCtx, nil)
+
+			if tt.wantPassed {
+				if mods, ok := reqAction.(policy.UpstreamRequestModifications); !ok || mods.Body != nil {
+					t.Fatalf("expected request to pass through unmodified, got %#v", reqAction)
+				}
+				if mods, ok := respAction.(policy.DownstreamResponseModifications); !ok || mods.Body != nil {
+					t.Fatalf("expected response to pass through unmodified, got %#v", respAction)
+				}
+				return
+			}
+			resp, ok := reqAction.(policy.ImmediateResponse)
+			if !ok || !strings.Contains(string(resp.Body), tt.wantMessage) {
+				t.Fatalf("expected request error response, got %#v", reqAction)
+			}
+			resp, ok = respAction.(policy.ImmediateResponse)
+			if !ok || !strings.Contains(string(resp.Body), "exceeding maxInputBytes 20") {
+				t.Fatalf("expected response error response, got %#v", respAction)
+			}
+			assertPIIErrorCode(t, resp.Body, ErrorCodeInputTooLarge)
+		})
+	}
+}
+
+func TestPIIMaskingRegexPolicy_OnResponseBodyChunk_OnError(t *testing.T) {
+	for _, onError := range []string{OnErrorReject, OnErrorPassthrough} {
+		t.Run(onError, func(t *testing.T) {
+			p := mustGetPIIPolicy(t, map[string]interface{}{
+				"email":          true,
+				"maxInputBytes":  20,
+				"redactResponse": true,
+				"onError":        onError,
+			})
+			body := `{"messages":[{"content":"contact a.user@example.com today"}]}`
+			respCtx := &policy.ResponseStreamContext{
+				SharedContext: &policy.SharedContext{Metadata: map[string]interface{}{}},
+			}
+			action := p.OnResponseBodyChunk(context.Background(), respCtx,
+				&policy.StreamBody{Chunk: []byte(body), EndOfStream: true}, nil)
+
+			switch a := action.(type) {
+			case policy.TerminateResponseChunk:
+				if onError != OnErrorReject || !strings.Contains(string(a.Body), "exceeding maxInputBytes 20") {
+					t.Fatalf("unexpected terminate chunk for %s: %s", onError, string(a.Body))
+				}
+			case policy.ForwardResponseChunk:
+				if onError != OnErrorPassthrough || string(a.Body) != body {
+					t.Fatalf("unexpected forwarded chunk for %s: %s", onError, string(a.Body))
+				}
+			default:
+				t.Fatalf("unexpected action %T", action)
+			}
+		})
+	}
+}
+
+func TestPIIMaskingRegexPolicy_OnRequest_GzipBody(t *testing.T) {
+	p := mustGetPIIPolicy(t, map[string]interface{}{
+		"email": true,
+	})
+	if p.Mode().RequestHeaderMode != policy.HeaderModeProcess {
+		t.Fatalf("expected request headers to be processed")
+	}
+
+	compressed, err := gzipBytes([]byte(`{"messages":[{"content":"mail a.user@example.com"}]}`))
+	if err != nil {
+		t.Fatalf("failed to compress body: %v", err)
+	}
+	ctx := piiRequestContext("")
+	ctx.Body = &policy.Body{Content: compressed, Present: true}
+	ctx.Headers = policy.NewHeaders(map[string][]string{"Content-Encoding": {"gzip"}})
+
+	mods := mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	decompressed, err := gunzip(mods.Body)
+	if err != nil {
+		t.Fatalf("expected gzip-encoded masked body: %v", err)
+	}
+	if msg := mustGetLastMessageContent(t, decodeJSONMapPII(t, decompressed)); msg != "mail [EMAIL_0000]" {
+		t.Fatalf("unexpected masked content: %q", msg)
+	}
+
+	ctx = piiRequestContext("")
+	ctx.Body = &policy.Body{Content: []byte(`{"messages":[{"content":"not gzip"}]}`), Present: true}
+	ctx.Headers = policy.NewHeaders(map[string][]string{"Content-Encoding": {"gzip"}})
+	resp, ok := p.OnRequestBody(context.Background(), ctx, nil).(policy.ImmediateResponse)
+	if !ok || !strings.Contains(string(resp.Body), "error decompressing gzip request body") {
+		t.Fatalf("expected error response for malformed gzip, got %#v", resp)
+	}
+	assertPIIErrorCode(t, resp.Body, ErrorCodeBodyDecode)
+
+	ctx = piiRequestContext(`{"messages":[{"content":"mail a.user@example.com"}]}`)
+	ctx.Headers = policy.NewHeaders(map[string][]string{"Content-Encoding": {"gzip, br"}})
+	mods = mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	if _, err := gunzip(mods.Body); err == nil {
+		t.Fatalf("expected bodies with stacked encodings not to be re-compressed")
+	}
+}
+
+func TestPIIMaskingRegexPolicy_OnRequest_BodyFormat(t *testing.T) {
+	tests := []struct {
+		name       string
+		bodyFormat string
+		body       string
+		want       string
+	}{
+		{
+			name:       "json-array masks each element",
+			bodyFormat: bodyformat.JSONArray,
+			body:       `[{"prompt":"mail a@example.com"},{"prompt":"mail b@example.com"}]`,
+			want:       `[{"prompt":"mail [EMAIL_0000]"},{"prompt":"mail [EMAIL_0001]"}]`,
+		},
+		{
+			name:       "ndjson masks each line and keeps blank lines",
+			bodyFormat: bodyformat.NDJSON,
+			body:       "{\"prompt\":\"mail a@example.com\"}\n\n{\"prompt\":\"mail a@example.com\"}\n",
+			want:       "{\"prompt\":\"mail [EMAIL_0000]\"}\n\n{\"prompt\":\"mail [EMAIL_0000]\"}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := mustGetPIIPolicy(t, map[string]interface{}{
+				"email":      true,
+				"jsonPath":   "$.prompt",
+				"bodyFormat": tt.bodyFormat,
+			})
+
+			ctx := piiRequestContext(tt.body)
+			mods := mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+			if got := string(mods.Body); got != tt.want {
+				t.Fatalf("unexpected body: got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	p := mustGetPIIPolicy(t, map[string]interface{}{
+		"email":      true,
+		"jsonPath":   "$.prompt",
+		"bodyFormat": bodyformat.JSONArray,
+	})
+	resp, ok := p.OnRequestBody(context.Background(), piiRequestContext(`{"prompt":"a@example.com"}`), nil).(policy.ImmediateResponse)
+	if !ok || !strings.Contains(string(resp.Body), "body is not a JSON array") {
+		t.Fatalf("expected error response for non-array body, got %#v", resp)
+	}
+	assertPIIErrorCode(t, resp.Body, ErrorCodeBodyDecode)
+}
+
+func TestPIIMaskingRegexPolicy_OnRequest_NoMatch_NoOp(t *testing.T) {
+	p := mustGetPIIPolicy(t, map[string]interface{}{
+		"email": true,
+	})
+
+	ctx := piiRequestContext(`{"messages":[{"content":"no pii here"}]}`)
+	action := p.OnRequestBody(context.Background(), ctx, nil)
+	mods := mustPIIRequestMods(t, action)
+	if mods.Body != nil {
+		t.Fatalf("expected no modifications when no pii match, got body=%s", string(mods.Body))
+	}
+}
+
+func TestPIIMaskingRegexPolicy_OnRequest_JSONPathError(t *testing.T) {
+	p := mustGetPIIPolicy(t, map[string]interface{}{
+		"email":    true,
+		"jsonPath": "$.missing.value",
+	})
+
+	ctx := piiRequestContext(`{"messages":"a.user@example.com"}`)
+	action := p.OnRequestBody(context.Background(), ctx, nil)
+	resp, ok := action.(policy.ImmediateResponse)
+	if !ok {
+		t.Fatalf("expected ImmediateResponse for extraction error, got %T", action)
+	}
+	if resp.StatusCode != APIMInternalErrorCode {
+		t.Fatalf("unexpected status code: %d", resp.StatusCode)
+	}
+	assertPIIErrorCode(t, resp.Body, ErrorCodeJSONPathInvalid)
+}
+
+func TestPIIMaskingRegexPolicy_OnRequest_MultipleJSONPaths(t *testing.T) {
+	p := mustGetPIIPolicy(t, map[string]interface{}{
+		"email":    true,
+		"jsonPath": []interface{}{"$.system", "$.messages[-1].content"},
+	})
+
+	ctx := piiRequestContext(`{"system":"owner a.user@example.com","messages":[{"content":"ask b.user@example.com or a.user@example.com"}]}`)
+	mods := mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	out := decodeJSONMapPII(t, mods.Body)
+
+	mapping, ok := ctx.Metadata[MetadataKeyPIIEntities].(map[string]string)
+	if !ok || len(mapping) != 2 {
+		t.Fatalf("expected merged mapping with two entries, got %v", ctx.Metadata[MetadataKeyPIIEntities])
+	}
+	if mapping["a.user@example.com"] == mapping["b.user@example.com"] {
+		t.Fatalf("expected distinct placeholders across paths, got %v", mapping)
+	}
+	if got, want := out["system"], "owner "+mapping["a.user@example.com"]; got != want {
+		t.Fatalf("unexpected system value: got %v, want %q", got, want)
+	}
+	want := "ask " + mapping["b.user@example.com"] + " or " + mapping["a.user@example.com"]
+	if got := mustGetLastMessageContent(t, out); got != want {
+		t.Fatalf("unexpected message content: got %q, want %q", got, want)
+	}
+}
+
+func TestPIIMaskingRegexPolicy_OnRequest_MultipleJSONPaths_ErrorIncludesPath(t *testing.T) {
+	p := mustGetPIIPolicy(t, map[string]interface{}{
+		"email":    true,
+		"jsonPath": []interface{}{"$.messages[-1].content", "$.missing.value"},
+	})
+
+	ctx := piiRequestContext(`{"messages":[{"content":"a.user@example.com"}]}`)
+	resp, ok := p.OnRequestBody(context.Background(), ctx, nil).(policy.ImmediateResponse)
+	if !ok {
+		t.Fatalf("expected ImmediateResponse for extraction error")
+	}
+	if !strings.Contains(string(resp.Body), `$.missing.value`) {
+		t.Fatalf("expected failing path in error message, got %s", string(resp.Body))
+	}
+}
+
+func TestPIIMaskingRegexPolicy_OnRequest_EmptyJSONPath_MasksPlainTextBody(t *testing.T) {
+	p := mustGetPIIPolicy(t, map[string]interface{}{
+		"email":    true,
+		"jsonPath": "",
+	})
+
+	ctx := piiRequestContext("Reach me at a.user@example.com, thanks.\n")
+	mods := mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	if got, want := string(mods.Body), "Reach me at [EMAIL_0000], thanks.\n"; got != want {
+		t.Fatalf("unexpected masked body: got %q, want %q", got, want)
+	}
+
+	respCtx := &policy.ResponseContext{
+		SharedContext: ctx.SharedContext,
+		ResponseBody:  &policy.Body{Content: []byte("Noted [EMAIL_0000]."), Present: true},
+	}
+	resp, ok := p.OnResponseBody(context.Background(), respCtx, nil).(policy.DownstreamResponseModifications)
+	if !ok {
+		t.Fatalf("expected DownstreamResponseModifications")
+	}
+	if got, want := string(resp.Body), "Noted a.user@example.com."; got != want {
+		t.Fatalf("unexpected restored body: got %q, want %q", got, want)
+	}
+}
+
+func TestPIIMaskingRegexPolicy_OnResponseHeaders_SummaryHeader(t *testing.T) {
+	tests := []struct {
+		name       string
+		params     map[string]interface{}
+		body       string
+		wantHeader string
+	}{
+		{
+			name:       "masked values are counted",
+			params:     map[string]interface{}{"email": true, "phone": true, "summaryHeader": "X-PII-Redacted"},
+			body:       `{"messages":[{"content":"a@example.com, b@example.com, a@example.com and 415-555-2671"}]}`,
+			wantHeader: "3",
+		},
+		{
+			name:       "redacted values are counted",
+			params:     map[string]interface{}{"email": true, "redactPII": true, "summaryHeader": "X-PII-Redacted"},
+			body:       `{"messages":[{"content":"mail a@example.com"}]}`,
+			wantHeader: "1",
+		},
+		{
+			name:   "omitted when nothing was masked",
+			params: map[string]interface{}{"email": true, "summaryHeader": "X-PII-Redacted"},
+			body:   `{"messages":[{"content":"nothing to see"}]}`,
+		},
+		{
+			name:   "omitted in audit-only mode",
+			params: map[string]interface{}{"email": true, "auditOnly": true, "summaryHeader": "X-PII-Redacted"},
+			body:   `{"messages":[{"content":"mail a@example.com"}]}`,
+		},
+		{
+			name:   "disabled by default",
+			params: map[string]interface{}{"email": true},
+			body:   `{"messages":[{"content":"mail a@example.com"}]}`,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := GetPolicy(policy.PolicyMetadata{}, tt.params)
-			if err == nil {
-				t.Fatalf("expected error, got nil")
+			p := mustGetPIIPolicy(t, tt.params)
+			_, enabled := tt.params["summaryHeader"]
+			if got := p.Mode().ResponseHeaderMode == policy.HeaderModeProcess; got != enabled {
+				t.Fatalf("expected response header processing %v, got %v", enabled, got)
 			}
-			if !strings.Contains(err.Error(), tt.wantErrContain) {
-				t.Fatalf("error mismatch: got %q, want contain %q", err.Error(), tt.wantErrContain)
+
+			ctx := piiRequestContext(tt.body)
+			mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+
+			respCtx := &policy.ResponseHeaderContext{SharedContext: ctx.SharedContext}
+			mods, ok := p.OnResponseHeaders(context.Background(), respCtx, nil).(policy.DownstreamResponseHeaderModifications)
+			if !ok {
+				t.Fatalf("expected DownstreamResponseHeaderModifications")
+			}
+			if tt.wantHeader == "" {
+				if len(mods.HeadersToSet) != 0 {
+					t.Fatalf("expected no headers to be set, got %v", mods.HeadersToSet)
+				}
+				return
+			}
+			if got := mods.HeadersToSet["X-PII-Redacted"]; got != tt.wantHeader {
+				t.Fatalf("unexpected summary header: got %q, want %q (headers %v)", got, tt.wantHeader, mods.HeadersToSet)
 			}
 		})
 	}
 }
 
-func TestPIIMaskingRegexPolicy_GetPolicy_DefaultsAndBuiltins(t *testing.T) {
+func TestPIIMaskingRegexPolicy_OnRequest_AuditOnly(t *testing.T) {
 	p := mustGetPIIPolicy(t, map[string]interface{}{
-		"email": true,
-		"phone": true,
-		"ssn":   true,
+		"email":     true,
+		"auditOnly": true,
 	})
 
-	if got := p.params.JsonPath; got != "$.messages[-1].content" {
-		t.Fatalf("expected default jsonPath '$.messages[-1].content', got %q", got)
+	ctx := piiRequestContext(`{"messages":[{"content":"mail a.user@example.com"}]}`)
+	mods := mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	if mods.Body != nil {
+		t.Fatalf("expected no body change in audit-only mode, got %s", string(mods.Body))
 	}
-	if p.params.RedactPII {
-		t.Fatalf("expected default redactPII=false")
+
+	mapping, ok := ctx.Metadata[MetadataKeyPIIEntities].(map[string]string)
+	if !ok || mapping["a.user@example.com"] != "[EMAIL_0000]" {
+		t.Fatalf("expected would-be mapping in metadata, got %v", ctx.Metadata[MetadataKeyPIIEntities])
 	}
-	if len(p.params.PIIEntities) != 3 {
-		t.Fatalf("expected 3 built-in detectors, got %d", len(p.params.PIIEntities))
+	counts, ok := ctx.Metadata[MetadataKeyPIICounts].(map[string]int)
+	if !ok || counts["EMAIL"] != 1 {
+		t.Fatalf("expected counts in metadata, got %v", ctx.Metadata[MetadataKeyPIICounts])
 	}
-}
-
-func TestPIIMaskingRegexPolicy_GetPolicy_CustomJSONString(t *testing.T) {
-	p := mustGetPIIPolicy(t, map[string]interface{}{
-		"customPIIEntities": `[{"piiEntity":"ORDER_ID","piiRegex":"ORD-[0-9]+"}]`,
-		"jsonPath":          "$.content",
-		"redactPII":         true,
-	})
 
-	if got := p.params.JsonPath; got != "$.content" {
-		t.Fatalf("expected custom jsonPath, got %q", got)
-	}
-	if !p.params.RedactPII {
-		t.Fatalf("expected redactPII=true")
+	respCtx := &policy.ResponseContext{
+		SharedContext: ctx.SharedContext,
+		ResponseBody:  &policy.Body{Content: []byte(`{"answer":"[EMAIL_0000]"}`), Present: true},
 	}
-	if _, ok := p.params.PIIEntities["ORDER_ID"]; !ok {
-		t.Fatalf("expected ORDER_ID custom detector")
+	resp := p.OnResponseBody(context.Background(), respCtx, nil).(policy.DownstreamResponseModifications)
+	if resp.Body != nil {
+		t.Fatalf("expected response untouched in audit-only mode, got %s", string(resp.Body))
 	}
 }
 
-func TestPIIMaskingRegexPolicy_GetPolicy_CustomEntityName_IsNormalizedToUppercase(t *testing.T) {
+func TestPIIMaskingRegexPolicy_OnResponse_RestoreMaskedPII(t *testing.T) {
 	p := mustGetPIIPolicy(t, map[string]interface{}{
-		"customPIIEntities": []interface{}{
-			map[string]interface{}{"piiEntity": "order_id", "piiRegex": "ORD-[0-9]+"},
-		},
+		"email": true,
 	})
 
-	if _, ok := p.params.PIIEntities["ORDER_ID"]; !ok {
-		t.Fatalf("expected lowercase custom entity name to be normalized to ORDER_ID")
+	ctx := &policy.ResponseContext{
+		SharedContext: &policy.SharedContext{
+			RequestID: "req-id",
+			Metadata: map[string]interface{}{
+				MetadataKeyPIIEntities: map[string]string{
+					"a.user@example.com": "[EMAIL_0000]",
+				},
+			},
+		},
+		ResponseBody: &policy.Body{
+			Content: []byte(`{"answer":"Found [EMAIL_0000]"}`),
+			Present: true,
+		},
+	}
+	action := p.OnResponseBody(context.Background(), ctx, nil)
+	mods, ok := action.(policy.DownstreamResponseModifications)
+	if !ok {
+		t.Fatalf("expected DownstreamResponseModifications, got %T", action)
+	}
+	if !strings.Contains(string(mods.Body), "a.user@example.com") {
+		t.Fatalf("expected placeholder to be restored, got %s", string(mods.Body))
 	}
 }
 
-func TestPIIMaskingRegexPolicy_OnRequest_MaskAndStoreMetadata(t *testing.T) {
+func TestPIIMaskingRegexPolicy_OnResponseBodyChunk_SplitPlaceholder(t *testing.T) {
 	p := mustGetPIIPolicy(t, map[string]interface{}{
 		"email": true,
 	})
 
-	ctx := piiRequestContext(`{"messages":[{"content":"Contact me at a.user@example.com please"}]}`)
-	action := p.OnRequestBody(context.Background(), ctx, nil)
-	mods := mustPIIRequestMods(t, action)
-	if len(mods.Body) == 0 {
-		t.Fatalf("expected modified body")
+	respCtx := &policy.ResponseStreamContext{
+		SharedContext: &policy.SharedContext{
+			RequestID: "req-id",
+			Metadata: map[string]interface{}{
+				MetadataKeyPIIEntities: map[string]string{
+					"a.user@example.com": "[EMAIL_0000]",
+				},
+			},
+		},
 	}
 
-	out := decodeJSONMapPII(t, mods.Body)
-	msg := mustGetLastMessageContent(t, out)
-	matched, err := regexp.MatchString(`\[EMAIL_[0-9a-f]{4}\]`, msg)
-	if err != nil {
-		t.Fatalf("failed regex match: %v", err)
-	}
-	if !matched {
-		t.Fatalf("expected masked email placeholder, got %q", msg)
+	first := p.OnResponseBodyChunk(context.Background(), respCtx,
+		&policy.StreamBody{Chunk: []byte(`{"answer":"Found [EMA`), Index: 0}, nil)
+	fwd, ok := first.(policy.ForwardResponseChunk)
+	if !ok || fwd.Body == nil || len(fwd.Body) != 0 {
+		t.Fatalf("expected first chunk to be held back, got %#v", first)
 	}
 
-	metaVal, exists := ctx.Metadata[MetadataKeyPIIEntities]
-	if !exists {
-		t.Fatalf("expected pii metadata to be stored")
+	last := p.OnResponseBodyChunk(context.Background(), respCtx,
+		&policy.StreamBody{Chunk: []byte(`IL_0000]"}`), EndOfStream: true, Index: 1}, nil)
+	fwd, ok = last.(policy.ForwardResponseChunk)
+	if !ok {
+		t.Fatalf("expected ForwardResponseChunk, got %T", last)
 	}
-	mapping, ok := metaVal.(map[string]string)
-	if !ok || len(mapping) == 0 {
-		t.Fatalf("expected pii metadata mapping, got %T", metaVal)
+	if got, want := string(fwd.Body), `{"answer":"Found a.user@example.com"}`; got != want {
+		t.Fatalf("unexpected restored body: got %s, want %s", got, want)
+	}
+	if _, exists := respCtx.Metadata[metaKeyAccJsonBody]; exists {
+		t.Fatalf("expected accumulated body to be cleared at end of stream")
 	}
 }
 
-func TestPIIMaskingRegexPolicy_OnRequest_RedactMode(t *testing.T) {
-	p := mustGetPIIPolicy(t, map[string]interface{}{
-		"email":     true,
-		"redactPII": true,
-	})
-
-	ctx := piiRequestContext(`{"messages":[{"content":"email a.user@example.com"}]}`)
-	action := p.OnRequestBody(context.Background(), ctx, nil)
-	mods := mustPIIRequestMods(t, action)
-	out := decodeJSONMapPII(t, mods.Body)
-	msg := mustGetLastMessageContent(t, out)
-	if !strings.Contains(msg, "*****") {
-		t.Fatalf("expected redacted content, got %q", msg)
+func TestPIIMaskingRegexPolicy_OnResponseBodyChunk_SSE(t *testing.T) {
+	delta := func(content string) string {
+		return `data: {"choices":[{"delta":{"content":"` + content + `"}}]}`
 	}
-	if _, exists := ctx.Metadata[MetadataKeyPIIEntities]; exists {
-		t.Fatalf("did not expect metadata mapping in redact mode")
+	newStreamContext := func(contentType string) *policy.ResponseStreamContext {
+		return &policy.ResponseStreamContext{
+			SharedContext: &policy.SharedContext{
+				RequestID: "req-id",
+				Metadata: map[string]interface{}{
+					MetadataKeyPIIEntities: map[string]string{"a.user@example.com": "[EMAIL_0000]"},
+				},
+			},
+			ResponseHeaders: policy.NewHeaders(map[string][]string{"Content-Type": {contentType}}),
+		}
+	}
+	send := func(t *testing.T, p *PIIMaskingRegexPolicy, respCtx *policy.ResponseStreamContext, chunk string, endOfStream bool) string {
+		t.Helper()
+		action := p.OnResponseBodyChunk(context.Background(), respCtx, &policy.StreamBody{Chunk: []byte(chunk), EndOfStream: endOfStream}, nil)
+		fwd, ok := action.(policy.ForwardResponseChunk)
+		if !ok {
+			t.Fatalf("expected ForwardResponseChunk, got %T", action)
+		}
+		if fwd.Body == nil {
+			return chunk
+		}
+		return string(fwd.Body)
 	}
+
+	p := mustGetPIIPolicy(t, map[string]interface{}{"email": true, "sse": true})
+
+	t.Run("events and placeholders split across chunks", func(t *testing.T) {
+		respCtx := newStreamContext("text/event-stream; charset=utf-8")
+
+		if got := send(t, p, respCtx, "id: 1\r\n"+delta("Mail [EMA")+"\r\n\r\n"+`data: {"choices":[{"del`, false); got != "" {
+			t.Fatalf("expected events to be held back, got %q", got)
+		}
+		got := send(t, p, respCtx, `ta":{"content":"IL_0000] now"}}]}`+"\n\n: ping\n\n"+
+			`data: {"choices":[{"delta":{},"finish_reason":"stop"}]}`+"\n\ndata: [DONE]\n\n", false)
+		want := "id: 1\n" + delta("Mail a.user@example.com now") + "\n\n" +
+			": ping\n\n" +
+			`data: {"choices":[{"delta":{},"finish_reason":"stop"}]}` + "\n\n" +
+			"data: [DONE]\n\n"
+		if got != want {
+			t.Fatalf("unexpected events:\ngot  %q\nwant %q", got, want)
+		}
+		if got := send(t, p, respCtx, "", true); got != "" {
+			t.Fatalf("expected nothing left at end of stream, got %q", got)
+		}
+		if _, exists := respCtx.Metadata[metaKeyPendingSSE]; exists {
+			t.Fatalf("expected pending events to be cleared at end of stream")
+		}
+	})
+
+	t.Run("keep-alive forwarded while a placeholder is held", func(t *testing.T) {
+		respCtx := newStreamContext("text/event-stream")
+		if got := send(t, p, respCtx, delta("to [EMAIL_")+"\n\n: ping\n\n", false); got != ": ping\n\n" {
+			t.Fatalf("expected only the keep-alive to be forwarded, got %q", got)
+		}
+		if got, want := send(t, p, respCtx, delta("0000]")+"\n\n", false), delta("to a.user@example.com")+"\n\n"; got != want {
+			t.Fatalf("unexpected events:\ngot  %q\nwant %q", got, want)
+		}
+	})
+
+	t.Run("unterminated final event is framed at end of stream", func(t *testing.T) {
+		respCtx := newStreamContext("text/event-stream")
+		if got, want := send(t, p, respCtx, delta("hi [EMAIL_0000]"), true), delta("hi a.user@example.com")+"\n\n"; got != want {
+			t.Fatalf("unexpected events:\ngot  %q\nwant %q", got, want)
+		}
+	})
+
+	t.Run("unclosed opening released after the hold limit", func(t *testing.T) {
+		respCtx := newStreamContext("text/event-stream")
+		chunk := delta("a [") + "\n\n"
+		for i := 0; i < maxHeldSSEEvents; i++ {
+			chunk += delta("x") + "\n\n"
+		}
+		if got := send(t, p, respCtx, chunk, false); got != chunk {
+			t.Fatalf("expected events to be released unchanged, got %q", got)
+		}
+	})
+
+	t.Run("other content types use the default handling", func(t *testing.T) {
+		respCtx := newStreamContext("application/json")
+		if got := send(t, p, respCtx, `{"answer":"[EMA`, false); got != "" {
+			t.Fatalf("expected JSON chunk to be held back, got %q", got)
+		}
+		if got, want := send(t, p, respCtx, `IL_0000]"}`, true), `{"answer":"a.user@example.com"}`; got != want {
+			t.Fatalf("unexpected body: got %s, want %s", got, want)
+		}
+	})
 }
 
-func TestPIIMaskingRegexPolicy_OnRequest_NoMatch_NoOp(t *testing.T) {
+func TestPIIMaskingRegexPolicy_OnResponse_UnknownPlaceholderUntouched(t *testing.T) {
 	p := mustGetPIIPolicy(t, map[string]interface{}{
 		"email": true,
 	})
 
-	ctx := piiRequestContext(`{"messages":[{"content":"no pii here"}]}`)
-	action := p.OnRequestBody(context.Background(), ctx, nil)
-	mods := mustPIIRequestMods(t, action)
-	if mods.Body != nil {
-		t.Fatalf("expected no modifications when no pii match, got body=%s", string(mods.Body))
+	ctx := &policy.ResponseContext{
+		SharedContext: &policy.SharedContext{
+			RequestID: "req-id",
+			Metadata: map[string]interface{}{
+				MetadataKeyPIIEntities: map[string]string{
+					"a.user@example.com": "[EMAIL_0000]",
+				},
+			},
+		},
+		ResponseBody: &policy.Body{
+			Content: []byte(`{"answer":"[EMAIL_0000] and [EMAIL_9999]"}`),
+			Present: true,
+		},
+	}
+	mods, ok := p.OnResponseBody(context.Background(), ctx, nil).(policy.DownstreamResponseModifications)
+	if !ok {
+		t.Fatalf("expected DownstreamResponseModifications")
+	}
+	if got, want := string(mods.Body), `{"answer":"a.user@example.com and [EMAIL_9999]"}`; got != want {
+		t.Fatalf("unexpected restored body: got %s, want %s", got, want)
 	}
 }
 
-func TestPIIMaskingRegexPolicy_OnRequest_JSONPathError(t *testing.T) {
+func TestPIIMaskingRegexPolicy_OnResponse_ScrubUnrestoredPlaceholders(t *testing.T) {
+	newCtx := func() *policy.ResponseContext {
+		return &policy.ResponseContext{
+			SharedContext: &policy.SharedContext{
+				RequestID: "req-id",
+				Metadata: map[string]interface{}{
+					MetadataKeyPIIEntities: map[string]string{
+						"a.user@example.com": "[EMAIL_0000]",
+						"555-123-4567":       "[PHONE_0001]",
+					},
+				},
+			},
+			// Only choices[*].message.content is restored; the placeholder in
+			// "summary" is left behind.
+			ResponseBody: &policy.Body{
+				Content: []byte(`{"choices":[{"message":{"content":"Mail [EMAIL_0000]"}}],"summary":"Call [PHONE_0001] or [EMAIL_9999]"}`),
+				Present: true,
+			},
+		}
+	}
+
 	p := mustGetPIIPolicy(t, map[string]interface{}{
-		"email":    true,
-		"jsonPath": "$.missing.value",
+		"email":                       true,
+		"phone":                       true,
+		"scrubUnrestoredPlaceholders": true,
 	})
+	mods, ok := p.OnResponseBody(context.Background(), newCtx(), nil).(policy.DownstreamResponseModifications)
+	if !ok {
+		t.Fatalf("expected DownstreamResponseModifications")
+	}
+	want := `{"choices":[{"message":{"content":"Mail a.user@example.com"}}],"summary":"Call [REDACTED] or [EMAIL_9999]"}`
+	if got := string(mods.Body); got != want {
+		t.Fatalf("unexpected scrubbed body: got %s, want %s", got, want)
+	}
 
-	ctx := piiRequestContext(`{"messages":"a.user@example.com"}`)
-	action := p.OnRequestBody(context.Background(), ctx, nil)
-	resp, ok := action.(policy.ImmediateResponse)
+	// Disabled by default: the leftover placeholder is forwarded unchanged.
+	p = mustGetPIIPolicy(t, map[string]interface{}{
+		"email": true,
+		"phone": true,
+	})
+	mods, ok = p.OnResponseBody(context.Background(), newCtx(), nil).(policy.DownstreamResponseModifications)
 	if !ok {
-		t.Fatalf("expected ImmediateResponse for extraction error, got %T", action)
+		t.Fatalf("expected DownstreamResponseModifications")
 	}
-	if resp.StatusCode != APIMInternalErrorCode {
-		t.Fatalf("unexpected status code: %d", resp.StatusCode)
+	if !strings.Contains(string(mods.Body), "[PHONE_0001]") {
+		t.Fatalf("expected placeholder to be left in place by default, got %s", string(mods.Body))
 	}
 }
 
-func TestPIIMaskingRegexPolicy_OnResponse_RestoreMaskedPII(t *testing.T) {
+func TestPIIMaskingRegexPolicy_OnResponseBodyChunk_ScrubUnrestoredPlaceholders(t *testing.T) {
+	newStreamContext := func() *policy.ResponseStreamContext {
+		return &policy.ResponseStreamContext{
+			SharedContext: &policy.SharedContext{
+				RequestID: "req-id",
+				Metadata: map[string]interface{}{
+					MetadataKeyPIIEntities: map[string]string{
+						"a.user@example.com": "[EMAIL_0000]",
+						"555-123-4567":       "[PHONE_0001]",
+					},
+				},
+			},
+			ResponseHeaders: policy.NewHeaders(map[string][]string{"Content-Type": {"text/event-stream"}}),
+		}
+	}
+	send := func(t *testing.T, p *PIIMaskingRegexPolicy, respCtx *policy.ResponseStreamContext, chunks ...string) string {
+		t.Helper()
+		var out strings.Builder
+		for i, chunk := range chunks {
+			action := p.OnResponseBodyChunk(context.Background(), respCtx,
+				&policy.StreamBody{Chunk: []byte(chunk), EndOfStream: i == len(chunks)-1, Index: uint64(i)}, nil)
+			fwd, ok := action.(policy.ForwardResponseChunk)
+			if !ok {
+				t.Fatalf("expected ForwardResponseChunk, got %T", action)
+			}
+			if fwd.Body == nil {
+				out.WriteString(chunk)
+			} else {
+				out.Write(fwd.Body)
+			}
+		}
+		return out.String()
+	}
+
+	// Only delta.content is restored in streamed events; the placeholder in
+	// "summary" is left behind unless scrubbed.
+	sseChunks := []string{
+		`data: {"choices":[{"delta":{"content":"Mail [EMA`,
+		`IL_0000]"}}]}` + "\n\n" + `data: {"choices":[{"delta":{}}],"summary":"Call [PHONE_0001]"}` + "\n\n",
+		"data: [DONE]\n\n",
+	}
+	sseWant := `data: {"choices":[{"delta":{"content":"Mail a.user@example.com"}}]}` + "\n\n" +
+		`data: {"choices":[{"delta":{}}],"summary":"Call [REDACTED]"}` + "\n\n" +
+		"data: [DONE]\n\n"
+
+	tests := []struct {
+		name   string
+		params map[string]interface{}
+		chunks []string
+		want   string
+	}{
+		{
+			name:   "event stream",
+			params: map[string]interface{}{"sse": true},
+			chunks: sseChunks,
+			want:   sseWant,
+		},
+		{
+			name:   "data lines",
+			params: map[string]interface{}{},
+			chunks: []string{
+				`data: {"choices":[{"delta":{"content":"Mail [EMAIL_0000]"}}]}` + "\n\n",
+				`data: {"choices":[{"delta":{}}],"summary":"Call [PHONE_0001]"}` + "\n\n",
+				"data: [DONE]\n\n",
+			},
+			want: sseWant,
+		},
+		{
+			name:   "buffered JSON",
+			params: map[string]interface{}{},
+			chunks: []string{`{"answer":"Mail [EMAIL_0000]",`, `"summary":"Call [PHONE_0001]"}`},
+			want:   `{"answer":"Mail a.user@example.com","summary":"Call 555-123-4567"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]interface{}{
+				"email":                       true,
+				"phone":                       true,
+				"scrubUnrestoredPlaceholders": true,
+			}
+			for k, v := range tt.params {
+				params[k] = v
+			}
+			p := mustGetPIIPolicy(t, params)
+			if got := send(t, p, newStreamContext(), tt.chunks...); got != tt.want {
+				t.Fatalf("unexpected streamed body:\ngot  %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+
+	// Disabled by default: the leftover placeholder is streamed unchanged.
+	p := mustGetPIIPolicy(t, map[string]interface{}{"email": true, "phone": true, "sse": true})
+	if got := send(t, p, newStreamContext(), sseChunks...); !strings.Contains(got, "[PHONE_0001]") {
+		t.Fatalf("expected placeholder to be left in place by default, got %q", got)
+	}
+}
+
+func TestPIIMaskingRegexPolicy_OnResponse_RedactResponse(t *testing.T) {
 	p := mustGetPIIPolicy(t, map[string]interface{}{
-		"email": true,
+		"email":          true,
+		"jsonPath":       "$.answer",
+		"redactResponse": true,
 	})
 
 	ctx := &policy.ResponseContext{
@@ -262,17 +2314,24 @@ func TestPIIMaskingRegexPolicy_OnResponse_RestoreMaskedPII(t *testing.T) {
 			},
 		},
 		ResponseBody: &policy.Body{
-			Content: []byte(`{"answer":"Found [EMAIL_0000]"}`),
+			Content: []byte(`{"answer":"[EMAIL_0000] knows b.user@example.com"}`),
 			Present: true,
 		},
 	}
-	action := p.OnResponseBody(context.Background(), ctx, nil)
-	mods, ok := action.(policy.DownstreamResponseModifications)
+	mods, ok := p.OnResponseBody(context.Background(), ctx, nil).(policy.DownstreamResponseModifications)
 	if !ok {
-		t.Fatalf("expected DownstreamResponseModifications, got %T", action)
+		t.Fatalf("expected DownstreamResponseModifications")
 	}
-	if !strings.Contains(string(mods.Body), "a.user@example.com") {
-		t.Fatalf("expected placeholder to be restored, got %s", string(mods.Body))
+	if got, want := decodeJSONMapPII(t, mods.Body)["answer"], "a.user@example.com knows *****"; got != want {
+		t.Fatalf("unexpected response answer: got %v, want %q", got, want)
+	}
+
+	// Redaction also applies without any restoration mapping.
+	ctx.Metadata = map[string]interface{}{}
+	ctx.ResponseBody.Content = []byte(`{"answer":"mail c.user@example.com"}`)
+	mods = p.OnResponseBody(context.Background(), ctx, nil).(policy.DownstreamResponseModifications)
+	if got, want := decodeJSONMapPII(t, mods.Body)["answer"], "mail *****"; got != want {
+		t.Fatalf("unexpected response answer: got %v, want %q", got, want)
 	}
 }
 
@@ -364,6 +2423,18 @@ func decodeJSONMapPII(t *testing.T, body []byte) map[string]interface{} {
 	return m
 }
 
+// assertPIIErrorCode checks the error codes of an error response body.
+func assertPIIErrorCode(t *testing.T, body []byte, wantErrorCode string) {
+	t.Helper()
+	payload := decodeJSONMapPII(t, body)
+	if got := payload["code"]; got != float64(APIMInternalExceptionCode) {
+		t.Fatalf("unexpected code: got %v, want %d", got, APIMInternalExceptionCode)
+	}
+	if got := payload["errorCode"]; got != wantErrorCode {
+		t.Fatalf("unexpected errorCode: got %v, want %q", got, wantErrorCode)
+	}
+}
+
 func mustGetLastMessageContent(t *testing.T, payload map[string]interface{}) string {
 	t.Helper()
 	messages, ok := payload["messages"].([]interface{})
@@ -383,3 +2454,113 @@ func mustGetLastMessageContent(t *testing.T, payload map[string]interface{}) str
 
 	return content
 }
+
+func TestPIIMaskingRegexPolicy_OnRequest_ContentPartArray(t *testing.T) {
+	p := mustGetPIIPolicy(t, map[string]interface{}{
+		"email": true,
+	})
+
+	ctx := piiRequestContext(`{"messages":[{"role":"user","content":[` +
+		`{"type":"text","text":"Mail a.user@example.com"},` +
+		`{"type":"image_url","image_url":{"url":"https://example.com/a.user@example.com.png"}},` +
+		`{"type":"text","text":"no pii here"}]}]}`)
+	mods := mustPIIRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	if mods.Body == nil {
+		t.Fatalf("expected request body to be masked")
+	}
+
+	payload := decodeJSONMapPII(t, mods.Body)
+	messages := payload["messages"].([]interface{})
+	parts := messages[0].(map[string]interface{})["content"].([]interface{})
+	if len(parts) != 3 {
+		t.Fatalf("expected three content parts, got %d", len(parts))
+	}
+
+	first := parts[0].(map[string]interface{})["text"].(string)
+	if strings.Contains(first, "a.user@example.com") || !defaultPlaceholders.token.MatchString(first) {
+		t.Fatalf("expected text part to be masked, got %q", first)
+	}
+	image := parts[1].(map[string]interface{})["image_url"].(map[string]interface{})["url"].(string)
+	if image != "https://example.com/a.user@example.com.png" {
+		t.Fatalf("expected image part to be untouched, got %q", image)
+	}
+	if third := parts[2].(map[string]interface{})["text"].(string); third != "no pii here" {
+		t.Fatalf("expected text part without PII to be unchanged, got %q", third)
+	}
+
+	// The recorded mapping restores a content-part array in the response.
+	mapping, ok := ctx.Metadata[MetadataKeyPIIEntities].(map[string]string)
+	if !ok || mapping["a.user@example.com"] == "" {
+		t.Fatalf("expected restoration mapping, got %v", ctx.Metadata[MetadataKeyPIIEntities])
+	}
+	placeholder := mapping["a.user@example.com"]
+	respCtx := &policy.ResponseContext{
+		SharedContext: ctx.SharedContext,
+		ResponseBody: &policy.Body{
+			Content: []byte(`{"choices":[{"message":{"role":"assistant","content":[` +
+				`{"type":"text","text":"Sent to ` + placeholder + `"},{"type":"image_url","image_url":{"url":"` + placeholder + `"}}]}}]}`),
+			Present: true,
+		},
+	}
+	respMods, ok := p.OnResponseBody(context.Background(), respCtx, nil).(policy.DownstreamResponseModifications)
+	if !ok {
+		t.Fatalf("expected DownstreamResponseModifications")
+	}
+	response := decodeJSONMapPII(t, respMods.Body)
+	message := response["choices"].([]interface{})[0].(map[string]interface{})["message"].(map[string]interface{})
+	respParts := message["content"].([]interface{})
+	if got := respParts[0].(map[string]interface{})["text"]; got != "Sent to a.user@example.com" {
+		t.Fatalf("expected text part to be restored, got %v", got)
+	}
+	if got := respParts[1].(map[string]interface{})["image_url"].(map[string]interface{})["url"]; got != placeholder {
+		t.Fatalf("expected non-text part to be untouched, got %v", got)
+	}
+}
+
+func TestPIIMaskingRegexPolicy_SharedBuiltInPatterns(t *testing.T) {
+	params := map[string]interface{}{
+		"email": true,
+		"customPIIEntities": []interface{}{
+			map[string]interface{}{"piiEntity": "TICKET", "piiRegex": "T-[0-9]+"},
+		},
+	}
+	first := mustGetPIIPolicy(t, params)
+	second := mustGetPIIPolicy(t, params)
+
+	if first.params.PIIEntities["EMAIL"] != second.params.PIIEntities["EMAIL"] {
+		t.Fatalf("expected built-in patterns to be shared across instances")
+	}
+	if first.params.PIIEntities["TICKET"] == second.params.PIIEntities["TICKET"] {
+		t.Fatalf("expected custom patterns to be compiled per instance")
+	}
+}
+
+func BenchmarkPIIMaskingRegexPolicy_GetPolicy(b *testing.B) {
+	params := map[string]interface{}{
+		"builtins": []interface{}{"EMAIL", "PHONE", "SSN", "CREDIT_CARD", "IPV4", "IPV6"},
+	}
+	builtInRegexes := []string{
+		DefaultEmailRegex, DefaultPhoneRegex, DefaultSSNRegex,
+		DefaultCreditCardRegex, DefaultIPv4Regex, DefaultIPv6Regex,
+	}
+
+	b.Run("SharedBuiltIns", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := GetPolicy(policy.PolicyMetadata{}, params); err != nil {
+				b.Fatalf("failed to create policy: %v", err)
+			}
+		}
+	})
+	// PerInstanceCompile adds the compilation each instance performed before
+	// built-in patterns were shared, as a baseline.
+	b.Run("PerInstanceCompile", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := GetPolicy(policy.PolicyMetadata{}, params); err != nil {
+				b.Fatalf("failed to create policy: %v", err)
+			}
+			for _, pattern := range builtInRegexes {
+				regexp.MustCompile(pattern)
+			}
+		}
+	})
+}
@@ -0,0 +1,182 @@
+/*
+ *  Copyright (c) 2026, WSO2 LLC. (http://www.wso2.org) All Rights Reserved.
+ *
+ *  Licensed under the Apache License, Version 2.0 (the "License");
+ *  you may not use this file except in compliance with the License.
+ *  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *  Unless required by applicable law or agreed to in writing, software
+ *  distributed under the License is distributed on an "AS IS" BASIS,
+ *  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *  See the License for the specific language governing permissions and
+ *  limitations under the License.
+ *
+ */
+
+package piimaskingregex
+
+import (
+	"mime"
+	"strings"
+
+	policy "github.com/wso2/api-platform/sdk/core/policy/v1alpha2"
+)
+
+const (
+	// metaKeyPendingSSE holds event stream text not yet forwarded in sse mode:
+	// content events held back for an unclosed placeholder, followed by an
+	// incomplete trailing event.
+	metaKeyPendingSSE = "piimaskingregex:sse_pending"
+
+	// maxHeldSSEEvents bounds how many content events are held back waiting
+	// for the rest of a placeholder.
+	maxHeldSSEEvents = 5
+
+	// sseEventStreamMediaType is the content type of server-sent events.
+	sseEventStreamMediaType = "text/event-stream"
+)
+
+// sseEvent is a single server-sent event.
+type sseEvent struct {
+	// raw is the event as received, without its terminating blank line.
+	raw string
+	// fields are the event's other lines, such as event:, id: or comments.
+	fields []string
+	// data is the value of the event's data lines joined with "\n".
+	data string
+}
+
+// parseSSEEvent splits the lines of a single event into its data and other
+// fields. A single space after "data:" is not part of the value.
+func parseSSEEvent(raw string) sseEvent {
+	event := sseEvent{raw: raw}
+	var data []string
+	for _, line := range strings.Split(raw, "\n") {
+		if value, ok := strings.CutPrefix(line, "data:"); ok {
+			data = append(data, strings.TrimPrefix(value, " "))
+			continue
+		}
+		event.fields = append(event.fields, line)
+	}
+	event.data = strings.Join(data, "\n")
+	return event
+}
+
+// frame renders the event with data in place of its original data, followed
+// by the blank line that terminates it.
+func (e sseEvent) frame(data string) string {
+	var sb strings.Builder
+	for _, field := range e.fields {
+		sb.WriteString(field)
+		sb.WriteString("\n")
+	}
+	for _, line := range strings.Split(data, "\n") {
+		sb.WriteString(sseDataPrefix)
+		sb.WriteString(line)
+		sb.WriteString("\n")
+	}
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+// isEventStream reports whether the response content type is text/event-stream.
+func isEventStream(headers *policy.Headers) bool {
+	values := headers.Get("content-type")
+	if len(values) == 0 {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(values[0])
+	return err == nil && mediaType == sseEventStreamMediaType
+}
+
+// restoreEventStream restores placeholders in a text/event-stream response
+// when sse is enabled. Chunks are reassembled into complete events, so events
+// and data lines split across chunks are handled. The delta.content of
+// consecutive content events is restored together while it ends in an
+// unclosed placeholder, so a placeholder split across events is restored too;
+// such events are held back, up to maxHeldSSEEvents, until the placeholder is
+// closed. Events are re-emitted with "\n" line endings and a terminating blank
+// line. When a placeholder spans events, the first one carries the restored
+// content and the rest are dropped.
+func (p *PIIMaskingRegexPolicy) restoreEventStream(respCtx *policy.ResponseStreamContext, chunk *policy.StreamBody, restoreMap map[string]string) policy.StreamingResponseAction {
+	pending, _ := respCtx.Metadata[metaKeyPendingSSE].(string)
+	buffered := strings.ReplaceAll(pending+string(chunk.Chunk), "\r\n", "\n")
+
+	rawEvents := strings.Split(buffered, "\n\n")
+	tail := rawEvents[len(rawEvents)-1]
+	rawEvents = rawEvents[:len(rawEvents)-1]
+	if chunk.EndOfStream {
+		// The final event may lack its terminating blank line.
+		rawEvents = append(rawEvents, strings.TrimSuffix(tail, "\n"))
+		tail = ""
+	}
+
+	var out strings.Builder
+	var group []sseEvent
+	var contents []string
+	flush := func() {
+		p.writeSSEGroup(&out, group, contents, restoreMap)
+		group, contents = nil, nil
+	}
+	for _, raw := range rawEvents {
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+		event := parseSSEEvent(raw)
+		if event.data == "" {
+			// Events without data, such as keep-alive comments, carry no
+			// content and are forwarded ahead of any held events.
+			out.WriteString(raw + "\n\n")
+			continue
+		}
+		content := extractFirstDeltaContent(event.data)
+		if content == "" {
+			flush()
+			out.WriteString(raw + "\n\n")
+			continue
+		}
+		group = append(group, event)
+		contents = append(contents, content)
+		if !p.params.placeholders.endsInPartialPlaceholder(strings.Join(contents, "")) || len(group) > maxHeldSSEEvents {
+			flush()
+		}
+	}
+
+	held := ""
+	if chunk.EndOfStream {
+		flush()
+	} else {
+		for _, event := range group {
+			held += event.raw + "\n\n"
+		}
+	}
+	if held+tail == "" {
+		delete(respCtx.Metadata, metaKeyPendingSSE)
+	} else {
+		respCtx.Metadata[metaKeyPendingSSE] = held + tail
+	}
+
+	// A non-nil empty body suppresses the chunk while events are held back.
+	return policy.ForwardResponseChunk{Body: []byte(out.String())}
+}
+
+// writeSSEGroup writes consecutive content events whose delta.content values
+// are restored together. Events are written unchanged when nothing was
+// restored.
+func (p *PIIMaskingRegexPolicy) writeSSEGroup(out *strings.Builder, group []sseEvent, contents []string, restoreMap map[string]string) {
+	if len(group) == 0 {
+		return
+	}
+	combined := strings.Join(contents, "")
+	restored := p.restore(combined, restoreMap)
+	if restored == combined {
+		for _, event := range group {
+			out.WriteString(event.raw + "\n\n")
+		}
+		return
+	}
+	data := strings.TrimPrefix(replaceContentInSSELine(sseDataPrefix+group[0].data, contents[0], restored), sseDataPrefix)
+	out.WriteString(group[0].frame(data))
+}
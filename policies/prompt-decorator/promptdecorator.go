@@ -22,27 +22,100 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"mime"
+	"path"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
 	policy "github.com/wso2/api-platform/sdk/core/policy/v1alpha2"
 	utils "github.com/wso2/api-platform/sdk/core/utils"
+	"github.com/wso2/gateway-controllers/common/bodyformat"
+	"github.com/wso2/gateway-controllers/common/promptutil"
 )
 
-var arrayIndexRegex = regexp.MustCompile(`^([a-zA-Z0-9_]+)\[(-?\d+)\]$`)
+var (
+	arrayIndexRegex = regexp.MustCompile(`^([a-zA-Z0-9_]+)\[(-?\d+)\]$`)
+	// headerPlaceholderRegex matches [[header:Name]] placeholders resolved from
+	// request headers.
+	headerPlaceholderRegex = regexp.MustCompile(`\[\[header:([A-Za-z0-9_-]+)\]\]`)
+	// pathTokenRegex matches {{$.path}} tokens resolved from the request payload.
+	pathTokenRegex = regexp.MustCompile(`\{\{\s*(\$[^{}]*?)\s*\}\}`)
+	// decorationPlaceholderRegex matches either placeholder form so both are
+	// resolved in one pass.
+	decorationPlaceholderRegex = regexp.MustCompile(headerPlaceholderRegex.String() + `|` + pathTokenRegex.String())
+	// jsonPointerKeyRegex matches JSON pointer tokens that translate to a
+	// JSONPath key: anything but the '.', '[' and ']' the dotted JSONPath
+	// grammar reserves.
+	jsonPointerKeyRegex = regexp.MustCompile(`^[^.\[\]]+$`)
+	// jsonPointerIndexedKeyRegex matches keys that an index may follow; the
+	// name[index] JSONPath form only accepts letters, digits and underscores.
+	jsonPointerIndexedKeyRegex = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+	// jsonPointerIndexRegex matches JSON pointer tokens that translate to an
+	// array index; negative indices count from the end.
+	jsonPointerIndexRegex = regexp.MustCompile(`^-?\d+$`)
+)
 
 const (
 	defaultTextDecorationJSONPath     = "$.messages[-1].content"
 	defaultMessagesDecorationJSONPath = "$.messages"
+	defaultResponseTextJSONPath       = "$.choices[-1].message.content"
+	defaultSeparator                  = " "
+
+	OnUnresolvedPlaceholderKeep  = "keep"
+	OnUnresolvedPlaceholderEmpty = "empty"
+	OnUnresolvedPlaceholderError = "error"
+
+	OnMissingPathError = "error"
+	OnMissingPathEmpty = "empty"
+
+	OnEmptyBodyError       = "error"
+	OnEmptyBodyPassthrough = "passthrough"
+
+	OnBodyTooLargeError       = "error"
+	OnBodyTooLargePassthrough = "passthrough"
+
+	PathSyntaxJSONPath    = "jsonpath"
+	PathSyntaxJSONPointer = "jsonpointer"
+
+	// Error codes carried in the "code" field of PROMPT_DECORATOR_ERROR
+	// responses, one per failure class.
+	ErrorCodeBodyEmpty             = "BODY_EMPTY"
+	ErrorCodeBodyTooLarge          = "BODY_TOO_LARGE"
+	ErrorCodePayloadInvalid        = "PAYLOAD_INVALID"
+	ErrorCodePayloadMarshal        = "PAYLOAD_MARSHAL_FAILED"
+	ErrorCodePlaceholderUnresolved = "PLACEHOLDER_UNRESOLVED"
+	ErrorCodeJSONPathInvalid       = "JSONPATH_INVALID"
+	ErrorCodeJSONPathUpdate        = "JSONPATH_UPDATE_FAILED"
+	ErrorCodeTargetMismatch        = "TARGET_CONFIG_MISMATCH"
+	ErrorCodeTargetTypeInvalid     = "TARGET_TYPE_INVALID"
+	ErrorCodeDecorationInvalid     = "DECORATION_INVALID"
+	ErrorCodeInsertIndexOutOfRange = "INSERT_INDEX_OUT_OF_RANGE"
+	ErrorCodeMessageLimitExceeded  = "MESSAGE_LIMIT_EXCEEDED"
+
+	// MetadataKeyDecorations holds a summary of each decoration applied to a
+	// request: its jsonPath, mode, append flag and number of messages added.
+	MetadataKeyDecorations = "promptdecorator:decorations"
+
+	// metaKeyRequestTokenValues holds the request payload values of the
+	// {{$.path}} tokens used by response decoration, keyed by JSONPath.
+	metaKeyRequestTokenValues = "promptdecorator:request_token_values"
+
+	// Decoration modes reported in MetadataKeyDecorations
+	decorationModeString       = "string"
+	decorationModeStringArray  = "string_array"
+	decorationModeContentParts = "content_parts"
+	decorationModeArray        = "array"
 )
 
-var validDecoratorRoles = map[string]struct{}{
-	"system":    {},
-	"user":      {},
-	"assistant": {},
-	"tool":      {},
-}
+// defaultDecoratorRoles are the roles accepted in decoration messages when
+// allowedRoles is not configured.
+var defaultDecoratorRoles = []string{"system", "user", "assistant", "tool"}
+
+// decorationRolePriority orders decoration messages when groupByRole is set.
+// Roles not listed sort after these, in config order.
+var decorationRolePriority = map[string]int{"system": 0, "user": 1, "assistant": 2, "tool": 3}
 
 // PromptDecoratorPolicy implements prompt decoration by applying custom decorations
 type PromptDecoratorPolicy struct {
@@ -52,6 +125,8 @@ type PromptDecoratorPolicy struct {
 type Decoration struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+	// ToolCallID is required for, and only allowed on, tool-role messages.
+	ToolCallID string `json:"tool_call_id,omitempty"`
 }
 
 type PromptDecoratorConfig struct {
@@ -59,10 +134,117 @@ type PromptDecoratorConfig struct {
 	Messages []Decoration `json:"messages,omitempty"`
 }
 
+// DecorationSpec is one entry of an array-form promptDecoratorConfig, pairing a
+// decoration with its own target. Append defaults to the top-level append
+// parameter when unset.
+type DecorationSpec struct {
+	PromptDecoratorConfig
+	JsonPath string `json:"jsonPath,omitempty"`
+	Append   *bool  `json:"append,omitempty"`
+}
+
+// decorationTarget is a validated decoration together with where to apply it.
+type decorationTarget struct {
+	config   PromptDecoratorConfig
+	jsonPath string
+	append   bool
+	// tokenValues, when non-nil, supplies {{$.path}} token values by JSONPath
+	// instead of the decorated payload. Response decoration uses the values
+	// captured from the request.
+	tokenValues map[string]interface{}
+}
+
+// modelOverride is a decoration config selected for requests whose $.model
+// equals pattern or matches it as a glob.
+type modelOverride struct {
+	pattern string
+	targets []decorationTarget
+}
+
+// decorationOutcome summarizes a decoration applied to a payload.
+type decorationOutcome struct {
+	jsonPath      string
+	mode          string
+	append        bool
+	messagesAdded int
+}
+
 type PromptDecoratorPolicyParams struct {
 	PromptDecoratorConfig PromptDecoratorConfig
 	JsonPath              string
 	Append                bool
+	// PathSyntax is the syntax of the configured paths: jsonpath or
+	// jsonpointer. JSON pointers are translated to JSONPath when parsed, so
+	// JsonPath and the other path fields always hold JSONPath expressions.
+	PathSyntax string
+	// Separator joins text decorations and the original string content
+	Separator string
+	// SkipIfPathExists skips decoration when this JSONPath resolves to a
+	// non-empty value in the request payload
+	SkipIfPathExists string
+	// InsertIndex, when set, places decoration messages at this position of the
+	// messages array instead of prepending or appending. Negative values count
+	// from the end.
+	InsertIndex *int
+	// ApplyToResponse applies the decoration to response bodies at
+	// ResponseJsonPath, in addition to requests
+	ApplyToResponse  bool
+	ResponseJsonPath string
+	// MaxMessages, when positive, caps the decorated messages array by trimming
+	// the oldest non-decoration messages
+	MaxMessages int
+	// PreserveRoleCase keeps the configured casing of decoration message roles
+	// instead of lowercasing them
+	PreserveRoleCase bool
+	// Deduplicate skips decoration messages whose role and content already
+	// appear in the target array
+	Deduplicate bool
+	// CreateMissing creates a target path that is absent from the payload,
+	// including missing intermediate objects, instead of returning an error
+	CreateMissing bool
+	// GroupByRole stable-sorts decoration messages by role priority (system,
+	// user, assistant, tool) before they are inserted
+	GroupByRole bool
+	// RemoveRoles lists normalized roles whose existing messages are removed
+	// from the target array before decoration messages are inserted
+	RemoveRoles []string
+	// OnUnresolvedPlaceholder controls how [[header:Name]] placeholders for
+	// absent headers are handled: keep, empty or error
+	OnUnresolvedPlaceholder string
+	// OnMissingPath controls how {{$.path}} tokens that do not resolve in the
+	// request payload are handled: error or empty
+	OnMissingPath string
+	// OnEmptyBody controls how requests without a body are handled: error or
+	// passthrough
+	OnEmptyBody string
+	// MaxBodyBytes, when positive, is the largest request body that is parsed
+	// and decorated
+	MaxBodyBytes int
+	// OnBodyTooLarge controls how request bodies larger than MaxBodyBytes are
+	// handled: error or passthrough
+	OnBodyTooLarge string
+	// BodyFormat selects the JSON documents of a body that are decorated:
+	// json-object, json-array or ndjson
+	BodyFormat string
+	// ForceJSON decorates requests regardless of their Content-Type instead of
+	// passing through requests that do not declare a JSON content type
+	ForceJSON bool
+
+	// targets are the decorations applied to requests, in order. A single
+	// promptDecoratorConfig yields one target.
+	targets []decorationTarget
+	// modelOverrides replace targets for requests whose $.model matches, in
+	// pattern order.
+	modelOverrides []modelOverride
+	// usesHeaderPlaceholders is true when the decoration references request
+	// headers, which requires header processing.
+	usesHeaderPlaceholders bool
+	// usesPathTokens is true when the decoration references request payload
+	// values through {{$.path}} tokens.
+	usesPathTokens bool
+	// responsePathTokens are the JSONPaths of the {{$.path}} tokens in the
+	// response decoration, whose values are captured from the request.
+	responsePathTokens []string
 }
 
 // GetPolicy is the v1alpha2 factory entry point (loaded by v1alpha2 kernels).
@@ -86,14 +268,31 @@ func GetPolicy(
 
 // Mode returns the processing mode for the prompt decorator policy.
 func (p *PromptDecoratorPolicy) Mode() policy.ProcessingMode {
+	// Request headers are needed for the Content-Type check unless forceJSON
+	// is set, and for [[header:Name]] placeholders.
+	requestHeaderMode := policy.HeaderModeSkip
+	if !p.params.ForceJSON || p.params.usesHeaderPlaceholders {
+		requestHeaderMode = policy.HeaderModeProcess
+	}
+	responseBodyMode := policy.BodyModeSkip
+	if p.params.ApplyToResponse {
+		responseBodyMode = policy.BodyModeBuffer
+	}
 	return policy.ProcessingMode{
-		RequestHeaderMode:  policy.HeaderModeSkip,
+		RequestHeaderMode:  requestHeaderMode,
 		RequestBodyMode:    policy.BodyModeBuffer,
 		ResponseHeaderMode: policy.HeaderModeSkip,
-		ResponseBodyMode:   policy.BodyModeSkip,
+		ResponseBodyMode:   responseBodyMode,
 	}
 }
 
+// Validate validates the policy configuration parameters without constructing
+// a policy instance. It runs the same checks as GetPolicy.
+func (p *PromptDecoratorPolicy) Validate(params map[string]interface{}) error {
+	_, err := parseParams(params)
+	return err
+}
+
 // parseParams parses and validates parameters from map to struct
 func parseParams(params map[string]interface{}) (PromptDecoratorPolicyParams, error) {
 	var result PromptDecoratorPolicyParams
@@ -104,143 +303,715 @@ func parseParams(params map[string]interface{}) (PromptDecoratorPolicyParams, er
 		return result, fmt.Errorf("'promptDecoratorConfig' parameter is required")
 	}
 
-	var promptDecoratorConfig PromptDecoratorConfig
-	switch v := promptDecoratorConfigRaw.(type) {
-	case string:
-		if err := json.Unmarshal([]byte(v), &promptDecoratorConfig); err != nil {
-			return result, fmt.Errorf("error unmarshaling promptDecoratorConfig: %w", err)
+	specs, isArray, err := unmarshalDecoratorConfig(promptDecoratorConfigRaw)
+	if err != nil {
+		return result, err
+	}
+
+	// Extract optional pathSyntax parameter, which governs how every
+	// configured path below is read.
+	result.PathSyntax = PathSyntaxJSONPath
+	if syntaxRaw, ok := params["pathSyntax"]; ok {
+		syntax, ok := syntaxRaw.(string)
+		if !ok {
+			return result, fmt.Errorf("'pathSyntax' must be a string")
 		}
-	case map[string]interface{}:
-		// Convert map to JSON and back to struct
-		jsonBytes, err := json.Marshal(v)
-		if err != nil {
-			return result, fmt.Errorf("error marshaling promptDecoratorConfig: %w", err)
+		syntax = strings.ToLower(strings.TrimSpace(syntax))
+		switch syntax {
+		case PathSyntaxJSONPath, PathSyntaxJSONPointer:
+			result.PathSyntax = syntax
+		default:
+			return result, fmt.Errorf("'pathSyntax' must be one of [jsonpath,jsonpointer]")
 		}
-		if err := json.Unmarshal(jsonBytes, &promptDecoratorConfig); err != nil {
-			return result, fmt.Errorf("error unmarshaling promptDecoratorConfig: %w", err)
+	}
+	toJSONPath := func(name, path string) (string, error) {
+		path = strings.TrimSpace(path)
+		if result.PathSyntax != PathSyntaxJSONPointer || path == "" {
+			return path, nil
 		}
-	default:
-		return result, fmt.Errorf("'promptDecoratorConfig' must be a JSON string or object")
+		translated, err := jsonPointerToJSONPath(path)
+		if err != nil {
+			return "", fmt.Errorf("'%s' is not a valid JSON pointer: %w", name, err)
+		}
+		return translated, nil
 	}
 
-	textConfigured := promptDecoratorConfig.Text != nil
-	messagesConfigured := len(promptDecoratorConfig.Messages) > 0
+	// Extract optional jsonPath parameter. If omitted (or empty), select default
+	// based on promptDecoratorConfig type.
+	if jsonPathRaw, ok := params["jsonPath"]; ok {
+		jsonPath, ok := jsonPathRaw.(string)
+		if !ok {
+			return result, fmt.Errorf("'jsonPath' must be a string")
+		}
+		if strings.TrimSpace(jsonPath) != "" {
+			if result.JsonPath, err = toJSONPath("jsonPath", jsonPath); err != nil {
+				return result, err
+			}
+		}
+	}
 
-	if textConfigured && messagesConfigured {
-		return result, fmt.Errorf("'promptDecoratorConfig' must define exactly one of 'text' or 'messages'")
+	// Extract optional append parameter. For an array of decoration specs it is
+	// the default for entries that do not set their own.
+	if appendRaw, ok := params["append"]; ok {
+		if appendVal, ok := appendRaw.(bool); ok {
+			result.Append = appendVal
+		} else {
+			return result, fmt.Errorf("'append' must be a boolean")
+		}
 	}
 
-	if !textConfigured && !messagesConfigured {
-		return result, fmt.Errorf("'promptDecoratorConfig' must define one of 'text' or 'messages'")
+	// Extract optional allowedRoles and preserveRoleCase parameters, which
+	// govern role validation below.
+	allowedRoles := defaultDecoratorRoles
+	if allowedRolesRaw, ok := params["allowedRoles"]; ok {
+		rolesArray, ok := allowedRolesRaw.([]interface{})
+		if !ok || len(rolesArray) == 0 {
+			return result, fmt.Errorf("'allowedRoles' must be a non-empty array of strings")
+		}
+		allowedRoles = make([]string, 0, len(rolesArray))
+		for i, roleRaw := range rolesArray {
+			role, ok := roleRaw.(string)
+			if !ok || strings.TrimSpace(role) == "" {
+				return result, fmt.Errorf("'allowedRoles[%d]' must be a non-empty string", i)
+			}
+			allowedRoles = append(allowedRoles, strings.ToLower(strings.TrimSpace(role)))
+		}
+	}
+	if preserveRaw, ok := params["preserveRoleCase"]; ok {
+		if preserveVal, ok := preserveRaw.(bool); ok {
+			result.PreserveRoleCase = preserveVal
+		} else {
+			return result, fmt.Errorf("'preserveRoleCase' must be a boolean")
+		}
 	}
 
-	if textConfigured {
-		if strings.TrimSpace(*promptDecoratorConfig.Text) == "" {
-			return result, fmt.Errorf("'promptDecoratorConfig.text' must be a non-empty string")
+	// buildTargets validates decoration specs and resolves the path and
+	// append mode of each. name prefixes error messages.
+	buildTargets := func(specs []DecorationSpec, isArray bool, name string) ([]decorationTarget, error) {
+		if isArray && result.JsonPath != "" {
+			return nil, fmt.Errorf("'jsonPath' must be set on each entry when '%s' is an array", name)
 		}
+		targets := make([]decorationTarget, 0, len(specs))
+		for i := range specs {
+			entryName := name
+			if isArray {
+				entryName = fmt.Sprintf("%s[%d]", name, i)
+			}
+			if err := validateDecoratorConfig(&specs[i].PromptDecoratorConfig, entryName, allowedRoles, result.PreserveRoleCase); err != nil {
+				return nil, err
+			}
+
+			entryPath, err := toJSONPath(entryName+".jsonPath", specs[i].JsonPath)
+			if err != nil {
+				return nil, err
+			}
+			target := decorationTarget{
+				config:   specs[i].PromptDecoratorConfig,
+				jsonPath: entryPath,
+				append:   result.Append,
+			}
+			if !isArray {
+				target.jsonPath = result.JsonPath
+			}
+			if specs[i].Append != nil {
+				target.append = *specs[i].Append
+			}
+			if target.jsonPath == "" {
+				if target.config.Text != nil {
+					target.jsonPath = defaultTextDecorationJSONPath
+				} else {
+					target.jsonPath = defaultMessagesDecorationJSONPath
+				}
+			}
+			targets = append(targets, target)
+		}
+		return targets, nil
 	}
 
-	if messagesConfigured {
-		for i, msg := range promptDecoratorConfig.Messages {
-			role := strings.ToLower(strings.TrimSpace(msg.Role))
-			if role == "" {
-				return result, fmt.Errorf("'promptDecoratorConfig.messages[%d].role' must be a non-empty string", i)
+	if result.targets, err = buildTargets(specs, isArray, "promptDecoratorConfig"); err != nil {
+		return result, err
+	}
+	textConfigured := false
+	for _, target := range result.targets {
+		textConfigured = textConfigured || target.config.Text != nil
+	}
+
+	// Extract optional modelOverrides parameter. Each value is validated like
+	// promptDecoratorConfig.
+	if overridesRaw, ok := params["modelOverrides"]; ok {
+		overrides, ok := overridesRaw.(map[string]interface{})
+		if !ok {
+			return result, fmt.Errorf("'modelOverrides' must be an object")
+		}
+		patterns := make([]string, 0, len(overrides))
+		for pattern := range overrides {
+			patterns = append(patterns, pattern)
+		}
+		slices.Sort(patterns)
+		for _, pattern := range patterns {
+			name := "modelOverrides." + pattern
+			if strings.TrimSpace(pattern) == "" {
+				return result, fmt.Errorf("'modelOverrides' keys must be non-empty model names")
+			}
+			if _, err := path.Match(pattern, ""); err != nil {
+				return result, fmt.Errorf("'%s' key is not a valid glob pattern: %w", name, err)
 			}
-			if _, ok := validDecoratorRoles[role]; !ok {
-				return result, fmt.Errorf("'promptDecoratorConfig.messages[%d].role' must be one of [system,user,assistant,tool]", i)
+			overrideSpecs, overrideIsArray, err := unmarshalDecoratorConfig(overrides[pattern])
+			if err != nil {
+				return result, fmt.Errorf("'%s': %w", name, err)
 			}
-			if strings.TrimSpace(msg.Content) == "" {
-				return result, fmt.Errorf("'promptDecoratorConfig.messages[%d].content' must be a non-empty string", i)
+			targets, err := buildTargets(overrideSpecs, overrideIsArray, name)
+			if err != nil {
+				return result, err
 			}
-			// Normalize role to keep output consistent.
-			promptDecoratorConfig.Messages[i].Role = role
+			result.modelOverrides = append(result.modelOverrides, modelOverride{pattern: pattern, targets: targets})
 		}
 	}
 
-	result.PromptDecoratorConfig = promptDecoratorConfig
+	// requireMessages rejects a messages-only option unless the base targets
+	// and every model override decorate messages, since the option applies to
+	// whichever of them a request selects.
+	requireMessages := func(param string) error {
+		if !hasMessagesTarget(result.targets) {
+			return fmt.Errorf("'%s' is only supported with 'promptDecoratorConfig.messages'", param)
+		}
+		for _, override := range result.modelOverrides {
+			if !hasMessagesTarget(override.targets) {
+				return fmt.Errorf("'%s' is only supported with 'modelOverrides.%s.messages'", param, override.pattern)
+			}
+		}
+		return nil
+	}
 
-	// Extract optional jsonPath parameter. If omitted (or empty), select default
-	// based on promptDecoratorConfig type.
-	if jsonPathRaw, ok := params["jsonPath"]; ok {
-		jsonPath, ok := jsonPathRaw.(string)
+	if !isArray {
+		result.PromptDecoratorConfig = result.targets[0].config
+		result.JsonPath = result.targets[0].jsonPath
+	}
+
+	// Extract optional separator parameter. An empty separator is allowed.
+	result.Separator = defaultSeparator
+	if separatorRaw, ok := params["separator"]; ok {
+		separator, ok := separatorRaw.(string)
 		if !ok {
-			return result, fmt.Errorf("'jsonPath' must be a string")
+			return result, fmt.Errorf("'separator' must be a string")
 		}
-		if strings.TrimSpace(jsonPath) != "" {
-			result.JsonPath = jsonPath
+		result.Separator = separator
+	}
+
+	// Extract optional insertIndex parameter
+	if insertIndexRaw, ok := params["insertIndex"]; ok {
+		insertIndex, err := extractInt(insertIndexRaw)
+		if err != nil {
+			return result, fmt.Errorf("'insertIndex' must be an integer: %w", err)
 		}
-	} else {
-		// jsonPath not provided
+		if err := requireMessages("insertIndex"); err != nil {
+			return result, err
+		}
+		result.InsertIndex = &insertIndex
 	}
 
-	if result.JsonPath == "" {
-		if textConfigured {
-			result.JsonPath = defaultTextDecorationJSONPath
+	// Extract optional maxMessages parameter
+	if maxMessagesRaw, ok := params["maxMessages"]; ok {
+		maxMessages, err := extractInt(maxMessagesRaw)
+		if err != nil {
+			return result, fmt.Errorf("'maxMessages' must be an integer: %w", err)
+		}
+		if maxMessages < 1 {
+			return result, fmt.Errorf("'maxMessages' must be at least 1")
+		}
+		result.MaxMessages = maxMessages
+	}
+
+	// Extract optional deduplicate parameter
+	if deduplicateRaw, ok := params["deduplicate"]; ok {
+		if deduplicate, ok := deduplicateRaw.(bool); ok {
+			result.Deduplicate = deduplicate
 		} else {
-			result.JsonPath = defaultMessagesDecorationJSONPath
+			return result, fmt.Errorf("'deduplicate' must be a boolean")
 		}
 	}
 
-	// Extract optional append parameter
-	if appendRaw, ok := params["append"]; ok {
-		if appendVal, ok := appendRaw.(bool); ok {
-			result.Append = appendVal
+	// Extract optional createMissing parameter
+	if createRaw, ok := params["createMissing"]; ok {
+		createMissing, ok := createRaw.(bool)
+		if !ok {
+			return result, fmt.Errorf("'createMissing' must be a boolean")
+		}
+		result.CreateMissing = createMissing
+	}
+
+	// Extract optional groupByRole parameter
+	if groupRaw, ok := params["groupByRole"]; ok {
+		groupByRole, ok := groupRaw.(bool)
+		if !ok {
+			return result, fmt.Errorf("'groupByRole' must be a boolean")
+		}
+		if groupByRole {
+			if err := requireMessages("groupByRole"); err != nil {
+				return result, err
+			}
+		}
+		result.GroupByRole = groupByRole
+	}
+
+	// Extract optional removeRoles parameter
+	if removeRolesRaw, ok := params["removeRoles"]; ok {
+		rolesArray, ok := removeRolesRaw.([]interface{})
+		if !ok {
+			return result, fmt.Errorf("'removeRoles' must be an array of strings")
+		}
+		if err := requireMessages("removeRoles"); err != nil {
+			return result, err
+		}
+		result.RemoveRoles = make([]string, 0, len(rolesArray))
+		for i, roleRaw := range rolesArray {
+			role, ok := roleRaw.(string)
+			if !ok || strings.TrimSpace(role) == "" {
+				return result, fmt.Errorf("'removeRoles[%d]' must be a non-empty string", i)
+			}
+			result.RemoveRoles = append(result.RemoveRoles, strings.ToLower(strings.TrimSpace(role)))
+		}
+	}
+
+	// Extract optional skipIfPathExists parameter
+	if skipRaw, ok := params["skipIfPathExists"]; ok {
+		skipPath, ok := skipRaw.(string)
+		if !ok {
+			return result, fmt.Errorf("'skipIfPathExists' must be a string")
+		}
+		if result.SkipIfPathExists, err = toJSONPath("skipIfPathExists", skipPath); err != nil {
+			return result, err
+		}
+	}
+
+	// Extract optional applyToResponse and responseJsonPath parameters
+	if applyRaw, ok := params["applyToResponse"]; ok {
+		if applyVal, ok := applyRaw.(bool); ok {
+			result.ApplyToResponse = applyVal
 		} else {
-			return result, fmt.Errorf("'append' must be a boolean")
+			return result, fmt.Errorf("'applyToResponse' must be a boolean")
+		}
+	}
+	if responsePathRaw, ok := params["responseJsonPath"]; ok {
+		responsePath, ok := responsePathRaw.(string)
+		if !ok {
+			return result, fmt.Errorf("'responseJsonPath' must be a string")
+		}
+		if result.ResponseJsonPath, err = toJSONPath("responseJsonPath", responsePath); err != nil {
+			return result, err
+		}
+	}
+	if result.ApplyToResponse && isArray {
+		return result, fmt.Errorf("'applyToResponse' is not supported when 'promptDecoratorConfig' is an array")
+	}
+	if result.ApplyToResponse && result.ResponseJsonPath == "" {
+		if !textConfigured {
+			return result, fmt.Errorf("'responseJsonPath' is required when 'applyToResponse' is used with 'promptDecoratorConfig.messages'")
+		}
+		result.ResponseJsonPath = defaultResponseTextJSONPath
+	}
+	if result.ApplyToResponse {
+		result.responsePathTokens = pathTokens(result.PromptDecoratorConfig)
+	}
+
+	// Extract optional onUnresolvedPlaceholder parameter
+	result.OnUnresolvedPlaceholder = OnUnresolvedPlaceholderKeep
+	if valRaw, ok := params["onUnresolvedPlaceholder"]; ok {
+		val, ok := valRaw.(string)
+		if !ok {
+			return result, fmt.Errorf("'onUnresolvedPlaceholder' must be a string")
+		}
+		val = strings.ToLower(strings.TrimSpace(val))
+		switch val {
+		case OnUnresolvedPlaceholderKeep, OnUnresolvedPlaceholderEmpty, OnUnresolvedPlaceholderError:
+			result.OnUnresolvedPlaceholder = val
+		default:
+			return result, fmt.Errorf("'onUnresolvedPlaceholder' must be one of [keep,empty,error]")
+		}
+	}
+
+	// Extract optional onMissingPath parameter
+	result.OnMissingPath = OnMissingPathError
+	if valRaw, ok := params["onMissingPath"]; ok {
+		val, ok := valRaw.(string)
+		if !ok {
+			return result, fmt.Errorf("'onMissingPath' must be a string")
+		}
+		val = strings.ToLower(strings.TrimSpace(val))
+		switch val {
+		case OnMissingPathError, OnMissingPathEmpty:
+			result.OnMissingPath = val
+		default:
+			return result, fmt.Errorf("'onMissingPath' must be one of [error,empty]")
+		}
+	}
+
+	// Extract optional onEmptyBody parameter
+	result.OnEmptyBody = OnEmptyBodyError
+	if valRaw, ok := params["onEmptyBody"]; ok {
+		val, ok := valRaw.(string)
+		if !ok {
+			return result, fmt.Errorf("'onEmptyBody' must be a string")
+		}
+		val = strings.ToLower(strings.TrimSpace(val))
+		switch val {
+		case OnEmptyBodyError, OnEmptyBodyPassthrough:
+			result.OnEmptyBody = val
+		default:
+			return result, fmt.Errorf("'onEmptyBody' must be one of [error,passthrough]")
+		}
+	}
+
+	// Extract optional maxBodyBytes and onBodyTooLarge parameters
+	if maxBodyRaw, ok := params["maxBodyBytes"]; ok {
+		maxBodyBytes, err := extractInt(maxBodyRaw)
+		if err != nil {
+			return result, fmt.Errorf("'maxBodyBytes' must be an integer: %w", err)
+		}
+		if maxBodyBytes < 1 {
+			return result, fmt.Errorf("'maxBodyBytes' must be at least 1")
+		}
+		result.MaxBodyBytes = maxBodyBytes
+	}
+	result.OnBodyTooLarge = OnBodyTooLargeError
+	if valRaw, ok := params["onBodyTooLarge"]; ok {
+		val, ok := valRaw.(string)
+		if !ok {
+			return result, fmt.Errorf("'onBodyTooLarge' must be a string")
+		}
+		val = strings.ToLower(strings.TrimSpace(val))
+		switch val {
+		case OnBodyTooLargeError, OnBodyTooLargePassthrough:
+			result.OnBodyTooLarge = val
+		default:
+			return result, fmt.Errorf("'onBodyTooLarge' must be one of [error,passthrough]")
+		}
+	}
+
+	// Extract optional bodyFormat parameter
+	bodyFormat, err := bodyformat.Parse(params)
+	if err != nil {
+		return result, err
+	}
+	result.BodyFormat = bodyFormat
+
+	// Extract optional forceJSON parameter
+	if forceRaw, ok := params["forceJSON"]; ok {
+		forceJSON, ok := forceRaw.(bool)
+		if !ok {
+			return result, fmt.Errorf("'forceJSON' must be a boolean")
+		}
+		result.ForceJSON = forceJSON
+	}
+
+	allTargets := result.targets
+	for _, override := range result.modelOverrides {
+		allTargets = append(slices.Clip(allTargets), override.targets...)
+	}
+	var decorationTexts []string
+	for _, target := range allTargets {
+		if target.config.Text != nil {
+			decorationTexts = append(decorationTexts, *target.config.Text)
+		}
+		for _, msg := range target.config.Messages {
+			decorationTexts = append(decorationTexts, msg.Content)
+		}
+	}
+	for _, text := range decorationTexts {
+		if headerPlaceholderRegex.MatchString(text) {
+			result.usesHeaderPlaceholders = true
+		}
+		if pathTokenRegex.MatchString(text) {
+			result.usesPathTokens = true
 		}
 	}
 
 	return result, nil
 }
 
-// createDecorationMessages creates decoration messages from promptDecoratorConfig.messages.
-func (p *PromptDecoratorPolicy) createDecorationMessages() ([]map[string]interface{}, error) {
-	if len(p.params.PromptDecoratorConfig.Messages) == 0 {
-		return nil, fmt.Errorf("promptDecoratorConfig.messages must be provided for chat prompt decoration")
+// pathTokens returns the distinct JSONPaths of the {{$.path}} tokens in a
+// decoration config, in sorted order.
+func pathTokens(config PromptDecoratorConfig) []string {
+	texts := make([]string, 0, len(config.Messages)+1)
+	if config.Text != nil {
+		texts = append(texts, *config.Text)
+	}
+	for _, msg := range config.Messages {
+		texts = append(texts, msg.Content)
+	}
+	var paths []string
+	for _, text := range texts {
+		for _, match := range pathTokenRegex.FindAllStringSubmatch(text, -1) {
+			paths = append(paths, match[1])
+		}
 	}
+	slices.Sort(paths)
+	return slices.Compact(paths)
+}
 
-	decorationMessages := make([]map[string]interface{}, 0, len(p.params.PromptDecoratorConfig.Messages))
-	for _, item := range p.params.PromptDecoratorConfig.Messages {
-		decorationMessages = append(decorationMessages, map[string]interface{}{
-			"role":    item.Role,
-			"content": item.Content,
-		})
+// jsonPointerToJSONPath translates an RFC 6901 JSON pointer such as
+// /messages/-1/content to the equivalent JSONPath, $.messages[-1].content.
+// Numeric tokens index the array named by the preceding token, negative
+// values counting from the end, and "-" selects the last element, so an
+// object key made only of digits cannot be addressed. Keys may contain any
+// character but '.', '[' and ']'; a key followed by an index may only contain
+// letters, digits and underscores.
+func jsonPointerToJSONPath(pointer string) (string, error) {
+	if !strings.HasPrefix(pointer, "/") {
+		return "", fmt.Errorf("%q must start with '/'", pointer)
 	}
-	return decorationMessages, nil
+	var sb strings.Builder
+	sb.WriteString("$")
+	lastKey := ""
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		if token == "-" {
+			token = "-1"
+		}
+		if jsonPointerIndexRegex.MatchString(token) {
+			if lastKey == "" {
+				return "", fmt.Errorf("index %q in %q must follow an object key", token, pointer)
+			}
+			if !jsonPointerIndexedKeyRegex.MatchString(lastKey) {
+				return "", fmt.Errorf("key %q indexed by %q in %q must contain only letters, digits and underscores", lastKey, token, pointer)
+			}
+			sb.WriteString("[" + token + "]")
+			lastKey = ""
+			continue
+		}
+		if !jsonPointerKeyRegex.MatchString(token) {
+			return "", fmt.Errorf("token %q in %q must be non-empty and cannot contain '.', '[' or ']'", token, pointer)
+		}
+		sb.WriteString("." + token)
+		lastKey = token
+	}
+	return sb.String(), nil
 }
 
-// navigatePath navigates through a JSON structure using a key (which may contain array indices)
-func (p *PromptDecoratorPolicy) navigatePath(current interface{}, key string) interface{} {
-	if matches := arrayIndexRegex.FindStringSubmatch(key); len(matches) == 3 {
-		arrayName := matches[1]
-		idxStr := matches[2]
-		idx, err := strconv.Atoi(idxStr)
+// unmarshalDecoratorConfig decodes promptDecoratorConfig, which is either a
+// single decoration config or an array of decoration specs, given as a JSON
+// string or as structured data. It reports whether the array form was used.
+func unmarshalDecoratorConfig(raw interface{}) ([]DecorationSpec, bool, error) {
+	var jsonBytes []byte
+	switch v := raw.(type) {
+	case string:
+		jsonBytes = []byte(v)
+	case map[string]interface{}, []interface{}:
+		// Convert to JSON and back to struct
+		marshaled, err := json.Marshal(v)
+		if err != nil {
+			return nil, false, fmt.Errorf("error marshaling promptDecoratorConfig: %w", err)
+		}
+		jsonBytes = marshaled
+	default:
+		return nil, false, fmt.Errorf("'promptDecoratorConfig' must be a JSON string, object or array")
+	}
+
+	if trimmed := strings.TrimSpace(string(jsonBytes)); strings.HasPrefix(trimmed, "[") {
+		var specs []DecorationSpec
+		if err := json.Unmarshal(jsonBytes, &specs); err != nil {
+			return nil, false, fmt.Errorf("error unmarshaling promptDecoratorConfig: %w", err)
+		}
+		if len(specs) == 0 {
+			return nil, false, fmt.Errorf("'promptDecoratorConfig' array must not be empty")
+		}
+		return specs, true, nil
+	}
+
+	var config PromptDecoratorConfig
+	if err := json.Unmarshal(jsonBytes, &config); err != nil {
+		return nil, false, fmt.Errorf("error unmarshaling promptDecoratorConfig: %w", err)
+	}
+	return []DecorationSpec{{PromptDecoratorConfig: config}}, false, nil
+}
+
+// hasMessagesTarget reports whether any of targets decorates messages.
+func hasMessagesTarget(targets []decorationTarget) bool {
+	for _, target := range targets {
+		if len(target.config.Messages) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// validateDecoratorConfig validates a decoration config against allowedRoles
+// (lowercase) and normalizes its message roles, lowercasing them unless
+// preserveRoleCase is set. name prefixes error messages, for example
+// "promptDecoratorConfig[1]".
+func validateDecoratorConfig(config *PromptDecoratorConfig, name string, allowedRoles []string, preserveRoleCase bool) error {
+	textConfigured := config.Text != nil
+	messagesConfigured := len(config.Messages) > 0
+
+	if textConfigured && messagesConfigured {
+		return fmt.Errorf("'%s' must define exactly one of 'text' or 'messages'", name)
+	}
+
+	if !textConfigured && !messagesConfigured {
+		return fmt.Errorf("'%s' must define one of 'text' or 'messages'", name)
+	}
+
+	if textConfigured {
+		if strings.TrimSpace(*config.Text) == "" {
+			return fmt.Errorf("'%s.text' must be a non-empty string", name)
+		}
+	}
+
+	for i, msg := range config.Messages {
+		role := strings.TrimSpace(msg.Role)
+		if role == "" {
+			return fmt.Errorf("'%s.messages[%d].role' must be a non-empty string", name, i)
+		}
+		if !slices.Contains(allowedRoles, strings.ToLower(role)) {
+			return fmt.Errorf("'%s.messages[%d].role' must be one of [%s]", name, i, strings.Join(allowedRoles, ","))
+		}
+		if strings.TrimSpace(msg.Content) == "" {
+			return fmt.Errorf("'%s.messages[%d].content' must be a non-empty string", name, i)
+		}
+		// Tool messages answer a specific tool call, so they must identify it.
+		toolCallID := strings.TrimSpace(msg.ToolCallID)
+		if strings.EqualFold(role, "tool") {
+			if toolCallID == "" {
+				return fmt.Errorf("'%s.messages[%d].tool_call_id' is required for role 'tool'", name, i)
+			}
+		} else if toolCallID != "" {
+			return fmt.Errorf("'%s.messages[%d].tool_call_id' is only supported for role 'tool'", name, i)
+		}
+		config.Messages[i].ToolCallID = toolCallID
+		// Normalize role to keep output consistent.
+		if !preserveRoleCase {
+			role = strings.ToLower(role)
+		}
+		config.Messages[i].Role = role
+	}
+	return nil
+}
+
+// extractInt safely extracts an integer from various types
+func extractInt(value interface{}) (int, error) {
+	switch v := value.(type) {
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case float64:
+		if v != float64(int(v)) {
+			return 0, fmt.Errorf("expected an integer but got %v", v)
+		}
+		return int(v), nil
+	case string:
+		parsed, err := strconv.ParseFloat(v, 64)
 		if err != nil {
-			return nil
+			return 0, err
 		}
+		if parsed != float64(int(parsed)) {
+			return 0, fmt.Errorf("expected an integer but got %v", v)
+		}
+		return int(parsed), nil
+	default:
+		return 0, fmt.Errorf("cannot convert %T to int", value)
+	}
+}
 
-		if node, ok := current.(map[string]interface{}); ok {
-			if arrVal, exists := node[arrayName]; exists {
-				if arr, ok := arrVal.([]interface{}); ok {
-					if idx < 0 {
-						idx = len(arr) + idx
-					}
-					if idx < 0 || idx >= len(arr) {
-						return nil
-					}
-					return arr[idx]
+// resolvePlaceholders returns a copy of target.config with [[header:Name]]
+// placeholders replaced by the first value of the named request header and
+// {{$.path}} tokens replaced by the value at that JSONPath in the request
+// payload, or in target.tokenValues when set. Both are resolved in a single
+// pass so resolved values are never themselves treated as placeholders. On
+// failure it returns the error reason along with the error.
+func (p *PromptDecoratorPolicy) resolvePlaceholders(headers *policy.Headers, payloadData map[string]interface{}, target decorationTarget) (PromptDecoratorConfig, string, error) {
+	config := target.config
+	if !p.params.usesHeaderPlaceholders && !p.params.usesPathTokens {
+		return config, "", nil
+	}
+
+	var unresolvedHeaders, missingPaths []string
+	resolve := func(text string) string {
+		return promptutil.SubstituteMatches(text, decorationPlaceholderRegex, func(loc []int) (string, bool) {
+			if loc[2] >= 0 {
+				name := text[loc[2]:loc[3]]
+				if values := headers.Get(name); len(values) > 0 {
+					return values[0], true
 				}
+				unresolvedHeaders = append(unresolvedHeaders, name)
+				return "", p.params.OnUnresolvedPlaceholder == OnUnresolvedPlaceholderEmpty
+			}
+
+			jsonPath := text[loc[4]:loc[5]]
+			if target.tokenValues != nil {
+				value, ok := target.tokenValues[jsonPath]
+				if !ok {
+					missingPaths = append(missingPaths, jsonPath)
+					return "", true
+				}
+				return stringifyTokenValue(value), true
+			}
+			value, err := utils.ExtractValueFromJsonpath(payloadData, jsonPath)
+			if err != nil {
+				missingPaths = append(missingPaths, jsonPath)
+				return "", true
 			}
+			return stringifyTokenValue(value), true
+		})
+	}
+
+	if config.Text != nil {
+		text := resolve(*config.Text)
+		config.Text = &text
+	}
+	if len(config.Messages) > 0 {
+		messages := make([]Decoration, len(config.Messages))
+		for i, msg := range config.Messages {
+			messages[i] = Decoration{Role: msg.Role, Content: resolve(msg.Content), ToolCallID: msg.ToolCallID}
 		}
-		return nil
+		config.Messages = messages
 	}
 
-	if node, ok := current.(map[string]interface{}); ok {
-		if val, exists := node[key]; exists {
-			return val
+	if len(unresolvedHeaders) > 0 && p.params.OnUnresolvedPlaceholder == OnUnresolvedPlaceholderError {
+		slices.Sort(unresolvedHeaders)
+		unresolvedHeaders = slices.Compact(unresolvedHeaders)
+		return config, "Unresolved header placeholders", fmt.Errorf("missing request headers: %s", strings.Join(unresolvedHeaders, ","))
+	}
+	if len(missingPaths) > 0 && p.params.OnMissingPath == OnMissingPathError {
+		slices.Sort(missingPaths)
+		missingPaths = slices.Compact(missingPaths)
+		return config, "Unresolved JSONPath tokens", fmt.Errorf("paths not found in request payload: %s", strings.Join(missingPaths, ","))
+	}
+	return config, "", nil
+}
+
+// stringifyTokenValue converts a value resolved from a {{$.path}} token to
+// text. Strings are used as-is; other values are rendered as JSON, which sorts
+// object keys so the output is deterministic.
+func stringifyTokenValue(value interface{}) string {
+	if str, ok := value.(string); ok {
+		return str
+	}
+	encoded, err := promptutil.MarshalJSON(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(encoded)
+}
+
+// createDecorationMessages creates decoration messages from promptDecoratorConfig.messages.
+func (p *PromptDecoratorPolicy) createDecorationMessages(config PromptDecoratorConfig) ([]map[string]interface{}, error) {
+	if len(config.Messages) == 0 {
+		return nil, fmt.Errorf("promptDecoratorConfig.messages must be provided for chat prompt decoration")
+	}
+
+	decorationMessages := make([]map[string]interface{}, 0, len(config.Messages))
+	for _, item := range config.Messages {
+		message := map[string]interface{}{
+			"role":    item.Role,
+			"content": item.Content,
 		}
+		if item.ToolCallID != "" {
+			message["tool_call_id"] = item.ToolCallID
+		}
+		decorationMessages = append(decorationMessages, message)
 	}
-	return nil
+	return decorationMessages, nil
 }
 
 // setValueAtPath sets a value at a path (key may contain array indices)
@@ -279,12 +1050,65 @@ func (p *PromptDecoratorPolicy) setValueAtPath(current interface{}, key string,
 	return fmt.Errorf("invalid structure for key: %s", key)
 }
 
+// OnRequestHeaders implements RequestHeaderPolicy. Headers are only processed so
+// that [[header:Name]] placeholders can be resolved in OnRequestBody.
+func (p *PromptDecoratorPolicy) OnRequestHeaders(ctx context.Context, reqCtx *policy.RequestHeaderContext, _ map[string]interface{}) policy.RequestHeaderAction {
+	return policy.UpstreamRequestHeaderModifications{}
+}
+
 // OnRequestBody decorates the request body.
 func (p *PromptDecoratorPolicy) OnRequestBody(ctx context.Context, reqCtx *policy.RequestContext, _ map[string]interface{}) policy.RequestAction {
 	return p.processRequestBody(reqCtx)
 }
 
+// OnResponseBody decorates successful response bodies when applyToResponse is
+// enabled. Empty bodies and error responses are passed through unchanged.
+// {{$.path}} tokens resolve against the request payload, using the values
+// captured by OnRequestBody, never against the response.
+func (p *PromptDecoratorPolicy) OnResponseBody(ctx context.Context, respCtx *policy.ResponseContext, _ map[string]interface{}) policy.ResponseAction {
+	if !p.params.ApplyToResponse {
+		return policy.DownstreamResponseModifications{}
+	}
+	if respCtx.ResponseBody == nil || len(respCtx.ResponseBody.Content) == 0 {
+		return policy.DownstreamResponseModifications{}
+	}
+	if respCtx.ResponseStatus >= 300 {
+		slog.Debug("PromptDecorator: Skipping decoration of non-success response", "status", respCtx.ResponseStatus)
+		return policy.DownstreamResponseModifications{}
+	}
+
+	tokenValues := map[string]interface{}{}
+	if respCtx.SharedContext != nil {
+		if captured, ok := respCtx.Metadata[metaKeyRequestTokenValues].(map[string]interface{}); ok {
+			tokenValues = captured
+		}
+	}
+	target := decorationTarget{
+		config:      p.params.PromptDecoratorConfig,
+		jsonPath:    p.params.ResponseJsonPath,
+		append:      p.params.Append,
+		tokenValues: tokenValues,
+	}
+	action, _ := p.decorateBody(respCtx.ResponseBody.Content, respCtx.RequestHeaders, []decorationTarget{target}, nil, "")
+	switch v := action.(type) {
+	case policy.ImmediateResponse:
+		return v
+	case policy.UpstreamRequestModifications:
+		return policy.DownstreamResponseModifications{Body: v.Body}
+	default:
+		return policy.DownstreamResponseModifications{}
+	}
+}
+
 func (p *PromptDecoratorPolicy) processRequestBody(reqCtx *policy.RequestContext) policy.RequestAction {
+	// Leave non-JSON traffic, such as form posts, on mixed routes untouched.
+	if !p.params.ForceJSON {
+		if contentType := reqCtx.Headers.Get("content-type"); len(contentType) > 0 && !isJSONContentType(contentType[0]) {
+			slog.Debug("PromptDecorator: Passing through request with non-JSON content type", "contentType", contentType[0])
+			return policy.UpstreamRequestModifications{}
+		}
+	}
+
 	var content []byte
 	if reqCtx.Body != nil {
 		content = reqCtx.Body.Content
@@ -292,51 +1116,256 @@ func (p *PromptDecoratorPolicy) processRequestBody(reqCtx *policy.RequestContext
 
 	// Check for empty or nil content before unmarshaling
 	if reqCtx.Body == nil || len(content) == 0 {
-		return p.buildErrorResponse("Empty request body", nil)
+		if p.params.OnEmptyBody == OnEmptyBodyPassthrough {
+			slog.Debug("PromptDecorator: Passing through request with empty body")
+			return policy.UpstreamRequestModifications{}
+		}
+		return p.buildErrorResponse(ErrorCodeBodyEmpty, "Empty request body", nil)
+	}
+
+	// Reject oversized bodies before they are parsed.
+	if p.params.MaxBodyBytes > 0 && len(content) > p.params.MaxBodyBytes {
+		if p.params.OnBodyTooLarge == OnBodyTooLargePassthrough {
+			slog.Debug("PromptDecorator: Passing through oversized request body", "size", len(content), "maxBodyBytes", p.params.MaxBodyBytes)
+			return policy.UpstreamRequestModifications{}
+		}
+		return p.buildErrorResponse(ErrorCodeBodyTooLarge, "Request body too large", fmt.Errorf("%d bytes exceeds maxBodyBytes %d", len(content), p.params.MaxBodyBytes))
+	}
+
+	p.captureRequestTokenValues(reqCtx, content)
+
+	action, outcomes := p.decorateBody(content, reqCtx.Headers, p.params.targets, p.params.modelOverrides, p.params.SkipIfPathExists)
+	if _, ok := action.(policy.UpstreamRequestModifications); ok && len(outcomes) > 0 {
+		p.recordDecorations(reqCtx, outcomes)
+	}
+	return action
+}
+
+// captureRequestTokenValues stores the request payload values of the
+// {{$.path}} tokens used by response decoration in metadata, before the request
+// is decorated. Tokens that do not resolve, or a body that is not a JSON
+// object, leave no value and are handled by onMissingPath in the response.
+func (p *PromptDecoratorPolicy) captureRequestTokenValues(reqCtx *policy.RequestContext, content []byte) {
+	if len(p.params.responsePathTokens) == 0 || reqCtx.SharedContext == nil {
+		return
+	}
+	var payloadData map[string]interface{}
+	if err := json.Unmarshal(content, &payloadData); err != nil {
+		return
+	}
+	values := make(map[string]interface{}, len(p.params.responsePathTokens))
+	for _, jsonPath := range p.params.responsePathTokens {
+		if value, err := utils.ExtractValueFromJsonpath(payloadData, jsonPath); err == nil {
+			values[jsonPath] = value
+		}
+	}
+	if reqCtx.Metadata == nil {
+		reqCtx.Metadata = make(map[string]interface{})
+	}
+	reqCtx.Metadata[metaKeyRequestTokenValues] = values
+}
+
+// selectModelTargets returns the targets of the override for the payload's
+// string $.model: an override whose pattern equals the model wins, otherwise
+// the first glob pattern, in sorted order, that matches it. It returns targets
+// when no override matches.
+func selectModelTargets(payloadData map[string]interface{}, targets []decorationTarget, overrides []modelOverride) []decorationTarget {
+	if len(overrides) == 0 {
+		return targets
+	}
+	model, ok := payloadData["model"].(string)
+	if !ok {
+		return targets
+	}
+	for _, override := range overrides {
+		if override.pattern == model {
+			return override.targets
+		}
+	}
+	for _, override := range overrides {
+		if matched, _ := path.Match(override.pattern, model); matched {
+			return override.targets
+		}
+	}
+	return targets
+}
+
+// isJSONContentType reports whether a Content-Type header value declares JSON:
+// application/json, a structured +json type, or newline-delimited JSON.
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch mediaType {
+	case "application/json", "application/x-ndjson", "application/ndjson", "application/jsonl":
+		return true
+	}
+	return strings.HasSuffix(mediaType, "+json")
+}
+
+// recordDecorations stores a summary of the applied decorations in request
+// metadata under MetadataKeyDecorations and logs it.
+func (p *PromptDecoratorPolicy) recordDecorations(reqCtx *policy.RequestContext, outcomes []decorationOutcome) {
+	summary := make([]map[string]interface{}, 0, len(outcomes))
+	for _, outcome := range outcomes {
+		summary = append(summary, map[string]interface{}{
+			"jsonPath":      outcome.jsonPath,
+			"mode":          outcome.mode,
+			"append":        outcome.append,
+			"messagesAdded": outcome.messagesAdded,
+		})
+	}
+	if reqCtx.Metadata == nil {
+		reqCtx.Metadata = make(map[string]interface{})
+	}
+	reqCtx.Metadata[MetadataKeyDecorations] = summary
+	slog.Info("PromptDecorator: Decorated request", "decorations", summary)
+}
+
+// decorateBody decorates each JSON document of a body according to
+// bodyFormat and returns the outcomes of all documents. The body is left
+// unmodified when no document changed.
+func (p *PromptDecoratorPolicy) decorateBody(content []byte, headers *policy.Headers, targets []decorationTarget, overrides []modelOverride, skipIfPathExists string) (policy.RequestAction, []decorationOutcome) {
+	if p.params.BodyFormat == bodyformat.JSONObject {
+		return p.decoratePayload(content, headers, targets, overrides, skipIfPathExists)
 	}
 
+	documents, err := bodyformat.Split(content, p.params.BodyFormat)
+	if err != nil {
+		slog.Debug("PromptDecorator: Error splitting body", "bodyFormat", p.params.BodyFormat, "error", err)
+		return p.buildErrorResponse(ErrorCodePayloadInvalid, "Error parsing JSON payload", err), nil
+	}
+	var outcomes []decorationOutcome
+	modified := false
+	for i, document := range documents {
+		if bodyformat.IsBlank(document) {
+			continue
+		}
+		action, documentOutcomes := p.decoratePayload(document, headers, targets, overrides, skipIfPathExists)
+		mods, ok := action.(policy.UpstreamRequestModifications)
+		if !ok {
+			return action, nil
+		}
+		if mods.Body != nil {
+			documents[i] = mods.Body
+			modified = true
+		}
+		outcomes = append(outcomes, documentOutcomes...)
+	}
+	if !modified {
+		return policy.UpstreamRequestModifications{}, nil
+	}
+	return policy.UpstreamRequestModifications{
+		Body: bodyformat.Join(documents, p.params.BodyFormat),
+	}, outcomes
+}
+
+// decoratePayload applies the decoration config at jsonPath of a JSON payload
+// and returns the outcome of each applied target. The targets of the first
+// override matching the payload's $.model replace targets. Decoration is
+// skipped when skipIfPathExists resolves to a non-empty value.
+func (p *PromptDecoratorPolicy) decoratePayload(content []byte, headers *policy.Headers, targets []decorationTarget, overrides []modelOverride, skipIfPathExists string) (policy.RequestAction, []decorationOutcome) {
 	// Parse JSON payload
 	var payloadData map[string]interface{}
 	if err := json.Unmarshal(content, &payloadData); err != nil {
 		slog.Debug("PromptDecorator: Error parsing JSON payload", "error", err)
-		return p.buildErrorResponse("Error parsing JSON payload", err)
+		return p.buildErrorResponse(ErrorCodePayloadInvalid, "Error parsing JSON payload", err), nil
+	}
+
+	if shouldSkip(payloadData, skipIfPathExists) {
+		slog.Debug("PromptDecorator: Skipping decoration, predicate path has a value", "skipIfPathExists", skipIfPathExists)
+		return policy.UpstreamRequestModifications{}, nil
+	}
+
+	targets = selectModelTargets(payloadData, targets, overrides)
+
+	// Targets are applied in sequence against the same payload, which is
+	// marshaled once at the end.
+	outcomes := make([]decorationOutcome, 0, len(targets))
+	for _, target := range targets {
+		outcome, errResp := p.applyDecoration(payloadData, headers, target)
+		if errResp != nil {
+			return errResp, nil
+		}
+		outcomes = append(outcomes, outcome)
+	}
+
+	updatedPayload, err := promptutil.MarshalJSON(payloadData)
+	if err != nil {
+		slog.Debug("PromptDecorator: Error marshaling updated JSON payload", "error", err)
+		return p.buildErrorResponse(ErrorCodePayloadMarshal, "Error marshaling updated JSON payload", err), nil
+	}
+
+	return policy.UpstreamRequestModifications{
+		Body: updatedPayload,
+	}, outcomes
+}
+
+// applyDecoration applies a single decoration target to payloadData in place.
+// It returns the outcome and a nil action on success, or an error response.
+func (p *PromptDecoratorPolicy) applyDecoration(payloadData map[string]interface{}, headers *policy.Headers, target decorationTarget) (decorationOutcome, policy.RequestAction) {
+	outcome := decorationOutcome{jsonPath: target.jsonPath, append: target.append}
+	config, reason, err := p.resolvePlaceholders(headers, payloadData, target)
+	if err != nil {
+		slog.Debug("PromptDecorator: Error resolving placeholders", "reason", reason, "error", err)
+		return outcome, p.buildErrorResponse(ErrorCodePlaceholderUnresolved, reason, err)
 	}
+	target.config = config
+	jsonPath := target.jsonPath
 
 	// Extract value using JSONPath
-	extractedValue, err := utils.ExtractValueFromJsonpath(payloadData, p.params.JsonPath)
+	extractedValue, err := utils.ExtractValueFromJsonpath(payloadData, jsonPath)
+	if err != nil && p.params.CreateMissing {
+		if created, createErr := createMissingParents(payloadData, jsonPath); createErr != nil {
+			slog.Debug("PromptDecorator: Cannot create missing JSONPath", "jsonPath", jsonPath, "error", createErr)
+		} else if created {
+			return p.decorateMissingTarget(payloadData, target, outcome)
+		}
+	}
 	if err != nil {
-		slog.Debug("PromptDecorator: Error extracting value from JSONPath", "jsonPath", p.params.JsonPath, "error", err)
-		return p.buildErrorResponse("Error extracting value from JSONPath", err)
+		slog.Debug("PromptDecorator: Error extracting value from JSONPath", "jsonPath", jsonPath, "error", err)
+		return outcome, p.buildErrorResponse(ErrorCodeJSONPathInvalid, "Error extracting value from JSONPath", err)
 	}
 
 	// Check if we're decorating a string content field or an array of messages
 	switch v := extractedValue.(type) {
 	case string:
 		// Decorating a content string (for example, $.messages[-1].content)
-		if p.params.PromptDecoratorConfig.Text == nil {
-			return p.buildErrorResponse(
+		if config.Text == nil {
+			return outcome, p.buildErrorResponse(
+				ErrorCodeTargetMismatch,
 				"Invalid configuration for string target",
 				fmt.Errorf("use promptDecoratorConfig.text when jsonPath resolves to a string"),
 			)
 		}
-		decorationStr := *p.params.PromptDecoratorConfig.Text
+		updatedContent := p.decorateText(target, v)
 
-		// Apply decoration (prepend or append)
-		var updatedContent string
-		if p.params.Append {
-			updatedContent = v + " " + decorationStr
-		} else {
-			updatedContent = decorationStr + " " + v
-		}
-
-		slog.Debug("PromptDecorator: Applied string decoration", "jsonPath", p.params.JsonPath, "append", p.params.Append, "originalLength", len(v), "updatedLength", len(updatedContent))
+		slog.Debug("PromptDecorator: Applied string decoration", "jsonPath", jsonPath, "append", target.append, "originalLength", len(v), "updatedLength", len(updatedContent))
 		// Update the content field
-		return p.updateStringAtPath(payloadData, p.params.JsonPath, updatedContent)
+		outcome.mode = decorationModeString
+		return outcome, p.updateStringAtPath(payloadData, jsonPath, updatedContent)
 
 	case []interface{}:
+		if isStringArray(v) {
+			// Decorating each element of an array of prompt strings (for example, $.prompts)
+			outcome.mode = decorationModeStringArray
+			return outcome, p.decorateStringArray(payloadData, target, v)
+		}
+		if config.Text != nil && isContentPartArray(v) {
+			// Decorating an OpenAI-style array of content parts (for example,
+			// $.messages[-1].content with multimodal input)
+			outcome.mode = decorationModeContentParts
+			return outcome, p.decorateContentParts(payloadData, target, v)
+		}
+		if containsString(v) {
+			return outcome, p.buildErrorResponse(ErrorCodeTargetTypeInvalid, "Array contains mixed element types", fmt.Errorf("expected all elements to be strings or all to be message objects"))
+		}
+
 		// Decorating an array of messages (for example, $.messages)
-		if len(p.params.PromptDecoratorConfig.Messages) == 0 {
-			return p.buildErrorResponse(
+		if len(config.Messages) == 0 {
+			return outcome, p.buildErrorResponse(
+				ErrorCodeTargetMismatch,
 				"Invalid configuration for messages target",
 				fmt.Errorf("use promptDecoratorConfig.messages when jsonPath resolves to an array"),
 			)
@@ -353,71 +1382,355 @@ func (p *PromptDecoratorPolicy) processRequestBody(reqCtx *policy.RequestContext
 				elementType := fmt.Sprintf("%T", item)
 				elementValue := fmt.Sprintf("%v", item)
 				malformedEntries = append(malformedEntries, fmt.Sprintf("index %d: type=%s, value=%s", i, elementType, elementValue))
-				slog.Debug("PromptDecorator: Non-map element detected in messages array", "jsonPath", p.params.JsonPath, "index", i, "type", elementType, "value", elementValue)
+				slog.Debug("PromptDecorator: Non-map element detected in messages array", "jsonPath", jsonPath, "index", i, "type", elementType, "value", elementValue)
 			}
 		}
 
 		// If malformed entries found, return error without modifying the slice
 		if len(malformedEntries) > 0 {
 			errorDetails := fmt.Sprintf("malformed entries at %s", strings.Join(malformedEntries, "; "))
-			return p.buildErrorResponse("Array contains non-map elements", fmt.Errorf("%s", errorDetails))
+			return outcome, p.buildErrorResponse(ErrorCodeTargetTypeInvalid, "Array contains non-map elements", fmt.Errorf("%s", errorDetails))
 		}
 
-		// Create decoration messages from decoration config
-		decorationMessages, err := p.createDecorationMessages()
-		if err != nil {
-			slog.Debug("PromptDecorator: Error creating decoration messages", "error", err)
-			return p.buildErrorResponse("Error creating decoration messages", err)
-		}
-
-		// Apply decoration (prepend or append)
-		var updatedMessages []map[string]interface{}
-		if p.params.Append {
-			updatedMessages = append(messages, decorationMessages...)
-		} else {
-			updatedMessages = append(decorationMessages, messages...)
-		}
-
-		slog.Debug("PromptDecorator: Applied array decoration", "jsonPath", p.params.JsonPath, "append", p.params.Append, "originalCount", len(messages), "decorationCount", len(decorationMessages), "updatedCount", len(updatedMessages))
-		// Update the messages array
-		return p.updateArrayAtPath(payloadData, p.params.JsonPath, updatedMessages)
+		outcome.mode = decorationModeArray
+		added, errResp := p.decorateMessages(payloadData, target, messages)
+		outcome.messagesAdded = added
+		return outcome, errResp
 
 	case []map[string]interface{}:
 		// Already in the right format
-		if len(p.params.PromptDecoratorConfig.Messages) == 0 {
-			return p.buildErrorResponse(
+		if len(config.Messages) == 0 {
+			return outcome, p.buildErrorResponse(
+				ErrorCodeTargetMismatch,
 				"Invalid configuration for messages target",
 				fmt.Errorf("use promptDecoratorConfig.messages when jsonPath resolves to an array"),
 			)
 		}
 		messages := v
 
-		// Create decoration messages from decoration config
-		decorationMessages, err := p.createDecorationMessages()
-		if err != nil {
-			slog.Debug("PromptDecorator: Error creating decoration messages", "error", err)
-			return p.buildErrorResponse("Error creating decoration messages", err)
+		outcome.mode = decorationModeArray
+		added, errResp := p.decorateMessages(payloadData, target, messages)
+		outcome.messagesAdded = added
+		return outcome, errResp
+
+	default:
+		slog.Debug("PromptDecorator: Invalid extracted value type", "type", fmt.Sprintf("%T", extractedValue))
+		return outcome, p.buildErrorResponse(ErrorCodeTargetTypeInvalid, "Extracted value must be a string or an array of message objects", fmt.Errorf("unexpected type: %T", extractedValue))
+	}
+}
+
+// decorateMissingTarget sets the decoration at a target path that was absent
+// from the payload: the decoration text for text decorations, or an array of
+// the decoration messages for message decorations.
+func (p *PromptDecoratorPolicy) decorateMissingTarget(payloadData map[string]interface{}, target decorationTarget, outcome decorationOutcome) (decorationOutcome, policy.RequestAction) {
+	slog.Debug("PromptDecorator: Creating missing JSONPath", "jsonPath", target.jsonPath)
+	if target.config.Text != nil {
+		outcome.mode = decorationModeString
+		return outcome, p.updateStringAtPath(payloadData, target.jsonPath, *target.config.Text)
+	}
+	outcome.mode = decorationModeArray
+	added, errResp := p.decorateMessages(payloadData, target, nil)
+	outcome.messagesAdded = added
+	return outcome, errResp
+}
+
+// createMissingParents creates the intermediate objects of jsonPath that are
+// absent from root so that a value can be set at jsonPath. It reports whether
+// the final key of jsonPath is absent and can therefore be created. Array
+// elements are never created, and an existing non-object value on the path is
+// an error.
+func createMissingParents(root map[string]interface{}, jsonPath string) (bool, error) {
+	path := strings.TrimPrefix(jsonPath, "$.")
+	if path == "" || path == jsonPath {
+		return false, fmt.Errorf("unsupported JSONPath: %s", jsonPath)
+	}
+	segments := strings.Split(path, ".")
+	current := root
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		if arrayIndexRegex.MatchString(segment) {
+			if last {
+				return false, nil
+			}
+			value, err := utils.ExtractValueFromJsonpath(current, "$."+segment)
+			if err != nil {
+				return false, err
+			}
+			next, ok := value.(map[string]interface{})
+			if !ok {
+				return false, fmt.Errorf("not an object: %s", segment)
+			}
+			current = next
+			continue
 		}
 
-		// Apply decoration (prepend or append)
-		var updatedMessages []map[string]interface{}
-		if p.params.Append {
-			updatedMessages = append(messages, decorationMessages...)
-		} else {
-			updatedMessages = append(decorationMessages, messages...)
+		value, exists := current[segment]
+		if last {
+			return !exists, nil
+		}
+		if !exists {
+			child := make(map[string]interface{})
+			current[segment] = child
+			current = child
+			continue
+		}
+		next, ok := value.(map[string]interface{})
+		if !ok {
+			return false, fmt.Errorf("not an object: %s", segment)
 		}
+		current = next
+	}
+	return false, nil
+}
 
-		slog.Debug("PromptDecorator: Applied array decoration", "jsonPath", p.params.JsonPath, "append", p.params.Append, "originalCount", len(messages), "decorationCount", len(decorationMessages), "updatedCount", len(updatedMessages))
-		// Update the messages array
-		return p.updateArrayAtPath(payloadData, p.params.JsonPath, updatedMessages)
+// decorateMessages inserts the target's decoration messages into messages and
+// writes the result back to the target's jsonPath. It returns the number of
+// decoration messages added.
+func (p *PromptDecoratorPolicy) decorateMessages(payloadData map[string]interface{}, target decorationTarget, messages []map[string]interface{}) (int, policy.RequestAction) {
+	// Create decoration messages from decoration config
+	decorationMessages, err := p.createDecorationMessages(target.config)
+	if err != nil {
+		slog.Debug("PromptDecorator: Error creating decoration messages", "error", err)
+		return 0, p.buildErrorResponse(ErrorCodeDecorationInvalid, "Error creating decoration messages", err)
+	}
+	if len(p.params.RemoveRoles) > 0 {
+		messages = withoutRoles(messages, p.params.RemoveRoles)
+	}
+	if p.params.Deduplicate {
+		decorationMessages = withoutDuplicateMessages(messages, decorationMessages)
+	}
+	if p.params.GroupByRole {
+		sortByRolePriority(decorationMessages)
+	}
 
+	// Apply decoration (at insertIndex, or prepend or append)
+	idx := 0
+	switch {
+	case p.params.InsertIndex != nil:
+		idx = *p.params.InsertIndex
+		if idx < 0 {
+			idx = len(messages) + idx
+		}
+		if idx < 0 || idx > len(messages) {
+			return 0, p.buildErrorResponse(ErrorCodeInsertIndexOutOfRange, "Insert index out of range", fmt.Errorf("index %d for %d messages", *p.params.InsertIndex, len(messages)))
+		}
+	case target.append:
+		idx = len(messages)
+	}
+	before, after := messages[:idx], messages[idx:]
+
+	// Enforce maxMessages by dropping the oldest original messages; injected
+	// decoration messages are always kept.
+	if p.params.MaxMessages > 0 {
+		excess := len(messages) + len(decorationMessages) - p.params.MaxMessages
+		if excess > len(messages) {
+			return 0, p.buildErrorResponse(ErrorCodeMessageLimitExceeded, "Message limit exceeded", fmt.Errorf("%d decoration messages exceed maxMessages %d", len(decorationMessages), p.params.MaxMessages))
+		}
+		if excess > 0 {
+			dropBefore := min(excess, len(before))
+			before = before[dropBefore:]
+			after = after[excess-dropBefore:]
+			slog.Debug("PromptDecorator: Trimmed messages to maxMessages", "maxMessages", p.params.MaxMessages, "dropped", excess)
+		}
+	}
+
+	updatedMessages := make([]map[string]interface{}, 0, len(before)+len(decorationMessages)+len(after))
+	updatedMessages = append(updatedMessages, before...)
+	updatedMessages = append(updatedMessages, decorationMessages...)
+	updatedMessages = append(updatedMessages, after...)
+
+	slog.Debug("PromptDecorator: Applied array decoration", "jsonPath", target.jsonPath, "append", target.append, "originalCount", len(messages), "decorationCount", len(decorationMessages), "updatedCount", len(updatedMessages))
+	// Update the messages array
+	return len(decorationMessages), p.updateArrayAtPath(payloadData, target.jsonPath, updatedMessages)
+}
+
+// shouldSkip reports whether the skipIfPathExists predicate resolves to a
+// non-empty value. A path that cannot be resolved does not skip decoration.
+func shouldSkip(payloadData map[string]interface{}, skipIfPathExists string) bool {
+	if skipIfPathExists == "" {
+		return false
+	}
+	value, err := utils.ExtractValueFromJsonpath(payloadData, skipIfPathExists)
+	if err != nil {
+		return false
+	}
+	switch v := value.(type) {
+	case nil:
+		return false
+	case string:
+		return strings.TrimSpace(v) != ""
+	case []interface{}:
+		return len(v) > 0
+	case map[string]interface{}:
+		return len(v) > 0
 	default:
-		slog.Debug("PromptDecorator: Invalid extracted value type", "type", fmt.Sprintf("%T", extractedValue))
-		return p.buildErrorResponse("Extracted value must be a string or an array of message objects", fmt.Errorf("unexpected type: %T", extractedValue))
+		return true
+	}
+}
+
+// decorateText prepends or appends the target's text decoration to content,
+// joined by the configured separator.
+func (p *PromptDecoratorPolicy) decorateText(target decorationTarget, content string) string {
+	decorationStr := *target.config.Text
+	if target.append {
+		return content + p.params.Separator + decorationStr
+	}
+	return decorationStr + p.params.Separator + content
+}
+
+// decorateStringArray applies the text decoration to every element of an
+// array of strings.
+func (p *PromptDecoratorPolicy) decorateStringArray(payloadData map[string]interface{}, target decorationTarget, values []interface{}) policy.RequestAction {
+	if target.config.Text == nil {
+		return p.buildErrorResponse(
+			ErrorCodeTargetMismatch,
+			"Invalid configuration for string array target",
+			fmt.Errorf("use promptDecoratorConfig.text when jsonPath resolves to an array of strings"),
+		)
+	}
+
+	updated := make([]interface{}, len(values))
+	for i, item := range values {
+		updated[i] = p.decorateText(target, item.(string))
+	}
+
+	slog.Debug("PromptDecorator: Applied string array decoration", "jsonPath", target.jsonPath, "append", target.append, "count", len(updated))
+	return p.updateValueAtPath(payloadData, target.jsonPath, updated)
+}
+
+// decorateContentParts adds the text decoration to an array of content parts
+// as a separate {"type":"text"} part, before the first part or after the last.
+func (p *PromptDecoratorPolicy) decorateContentParts(payloadData map[string]interface{}, target decorationTarget, parts []interface{}) policy.RequestAction {
+	decorationPart := map[string]interface{}{"type": "text", "text": *target.config.Text}
+
+	updated := make([]interface{}, 0, len(parts)+1)
+	if !target.append {
+		updated = append(updated, decorationPart)
+	}
+	updated = append(updated, parts...)
+	if target.append {
+		updated = append(updated, decorationPart)
+	}
+
+	slog.Debug("PromptDecorator: Applied content part decoration", "jsonPath", target.jsonPath, "append", target.append, "originalCount", len(parts))
+	return p.updateValueAtPath(payloadData, target.jsonPath, updated)
+}
+
+// isContentPartArray reports whether values is non-empty and holds only
+// content part objects, which carry a string "type" and no "role".
+func isContentPartArray(values []interface{}) bool {
+	if len(values) == 0 {
+		return false
+	}
+	for _, item := range values {
+		part, ok := item.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if _, ok := part["type"].(string); !ok {
+			return false
+		}
+		if _, ok := part["role"]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+// sortByRolePriority stable-sorts messages by decorationRolePriority, comparing
+// lowercased roles. Messages with other roles keep their order after them.
+func sortByRolePriority(messages []map[string]interface{}) {
+	priority := func(msg map[string]interface{}) int {
+		role, _ := msg["role"].(string)
+		if rank, ok := decorationRolePriority[strings.ToLower(role)]; ok {
+			return rank
+		}
+		return len(decorationRolePriority)
+	}
+	slices.SortStableFunc(messages, func(a, b map[string]interface{}) int {
+		return priority(a) - priority(b)
+	})
+}
+
+// withoutRoles returns the messages whose role, lowercased and trimmed, is not
+// in roles. Messages without a string role are kept.
+func withoutRoles(messages []map[string]interface{}, roles []string) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(messages))
+	for _, msg := range messages {
+		if role, ok := msg["role"].(string); ok && slices.Contains(roles, strings.ToLower(strings.TrimSpace(role))) {
+			continue
+		}
+		result = append(result, msg)
+	}
+	if removed := len(messages) - len(result); removed > 0 {
+		slog.Debug("PromptDecorator: Removed messages by role", "roles", roles, "removed", removed)
+	}
+	return result
+}
+
+// withoutDuplicateMessages returns the decoration messages that do not already
+// appear in messages, comparing lowercased roles and trimmed content.
+func withoutDuplicateMessages(messages, decorations []map[string]interface{}) []map[string]interface{} {
+	existing := make(map[[2]string]struct{}, len(messages))
+	for _, msg := range messages {
+		if key, ok := messageKey(msg); ok {
+			existing[key] = struct{}{}
+		}
+	}
+
+	result := make([]map[string]interface{}, 0, len(decorations))
+	for _, decoration := range decorations {
+		if key, ok := messageKey(decoration); ok {
+			if _, dup := existing[key]; dup {
+				slog.Debug("PromptDecorator: Skipping duplicate decoration message", "role", key[0])
+				continue
+			}
+		}
+		result = append(result, decoration)
+	}
+	return result
+}
+
+// messageKey returns the normalized role and content of a message. Messages
+// without string role and content (for example multimodal content) have no key.
+func messageKey(msg map[string]interface{}) ([2]string, bool) {
+	role, ok := msg["role"].(string)
+	if !ok {
+		return [2]string{}, false
+	}
+	content, ok := msg["content"].(string)
+	if !ok {
+		return [2]string{}, false
 	}
+	return [2]string{strings.ToLower(strings.TrimSpace(role)), strings.TrimSpace(content)}, true
 }
 
-func (p *PromptDecoratorPolicy) buildErrorResponse(reason string, validationError error) policy.RequestAction {
+// isStringArray reports whether values is non-empty and holds only strings.
+func isStringArray(values []interface{}) bool {
+	if len(values) == 0 {
+		return false
+	}
+	for _, item := range values {
+		if _, ok := item.(string); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// containsString reports whether any element of values is a string.
+func containsString(values []interface{}) bool {
+	for _, item := range values {
+		if _, ok := item.(string); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// buildErrorResponse builds the PROMPT_DECORATOR_ERROR immediate response with
+// the given error code.
+func (p *PromptDecoratorPolicy) buildErrorResponse(code string, reason string, validationError error) policy.RequestAction {
 	errorMessage := reason
 	if validationError != nil {
 		errorMessage = fmt.Sprintf("%s: %v", reason, validationError)
@@ -425,12 +1738,13 @@ func (p *PromptDecoratorPolicy) buildErrorResponse(reason string, validationErro
 
 	responseBody := map[string]interface{}{
 		"type":    "PROMPT_DECORATOR_ERROR",
+		"code":    code,
 		"message": errorMessage,
 	}
 
 	bodyBytes, err := json.Marshal(responseBody)
 	if err != nil {
-		bodyBytes = []byte(`{"type":"PROMPT_DECORATOR_ERROR","message":"Internal error"}`)
+		bodyBytes = []byte(`{"type":"PROMPT_DECORATOR_ERROR","code":"` + code + `","message":"Internal error"}`)
 	}
 
 	return policy.ImmediateResponse{
@@ -443,87 +1757,64 @@ func (p *PromptDecoratorPolicy) buildErrorResponse(reason string, validationErro
 }
 
 func (p *PromptDecoratorPolicy) updateArrayAtPath(payloadData map[string]interface{}, jsonPath string, value []map[string]interface{}) policy.RequestAction {
-	path := jsonPath
-	if strings.HasPrefix(path, "$.") {
-		path = strings.TrimPrefix(path, "$.")
-	}
-	if path == "" {
-		return p.buildErrorResponse("Invalid JSONPath", fmt.Errorf("empty path"))
-	}
-
-	pathComponents := strings.Split(path, ".")
-	current := interface{}(payloadData)
-
-	// Navigate to parent
-	for i := 0; i < len(pathComponents)-1; i++ {
-		key := pathComponents[i]
-		current = p.navigatePath(current, key)
-		if current == nil {
-			slog.Debug("PromptDecorator: Error navigating JSONPath", "jsonPath", jsonPath, "key", key)
-			return p.buildErrorResponse("Error navigating JSONPath", fmt.Errorf("key not found: %s", key))
-		}
-	}
-
 	// Convert []map[string]interface{} to []interface{}
 	valueInterface := make([]interface{}, len(value))
 	for i, v := range value {
 		valueInterface[i] = v
 	}
-
-	// Update final key
-	finalKey := pathComponents[len(pathComponents)-1]
-	if err := p.setValueAtPath(current, finalKey, valueInterface); err != nil {
-		slog.Debug("PromptDecorator: Error updating JSONPath", "jsonPath", jsonPath, "error", err)
-		return p.buildErrorResponse("Error updating JSONPath", err)
-	}
-
-	updatedPayload, err := json.Marshal(payloadData)
-	if err != nil {
-		slog.Debug("PromptDecorator: Error marshaling updated JSON payload", "error", err)
-		return p.buildErrorResponse("Error marshaling updated JSON payload", err)
-	}
-
-	return policy.UpstreamRequestModifications{
-		Body: updatedPayload,
-	}
+	return p.updateValueAtPath(payloadData, jsonPath, valueInterface)
 }
 
+// updateStringAtPath sets a string value at jsonPath with the shared
+// utils.SetValueAtJSONPath, so string targets follow the same path grammar as
+// other policies. It returns nil on success, or an error response.
 func (p *PromptDecoratorPolicy) updateStringAtPath(payloadData map[string]interface{}, jsonPath string, value string) policy.RequestAction {
-	path := jsonPath
-	if strings.HasPrefix(path, "$.") {
-		path = strings.TrimPrefix(path, "$.")
-	}
-	if path == "" {
-		return p.buildErrorResponse("Invalid JSONPath", fmt.Errorf("empty path"))
+	parentPath, _, errResp := p.splitUpdatePath(jsonPath)
+	if errResp != nil {
+		return errResp
 	}
-
-	pathComponents := strings.Split(path, ".")
-	current := interface{}(payloadData)
-
-	// Navigate to parent
-	for i := 0; i < len(pathComponents)-1; i++ {
-		key := pathComponents[i]
-		current = p.navigatePath(current, key)
-		if current == nil {
-			slog.Debug("PromptDecorator: Error navigating JSONPath", "jsonPath", jsonPath, "key", key)
-			return p.buildErrorResponse("Error navigating JSONPath", fmt.Errorf("key not found: %s", key))
+	if err := utils.SetValueAtJSONPath(payloadData, jsonPath, value); err != nil {
+		// Report a missing parent as a navigation error, as for other targets.
+		if _, navErr := utils.ExtractValueFromJsonpath(payloadData, parentPath); navErr != nil {
+			slog.Debug("PromptDecorator: Error navigating JSONPath", "jsonPath", jsonPath, "error", navErr)
+			return p.buildErrorResponse(ErrorCodeJSONPathInvalid, "Error navigating JSONPath", navErr)
 		}
-	}
-
-	// Update final key
-	finalKey := pathComponents[len(pathComponents)-1]
-	if err := p.setValueAtPath(current, finalKey, value); err != nil {
 		slog.Debug("PromptDecorator: Error updating JSONPath", "jsonPath", jsonPath, "error", err)
-		return p.buildErrorResponse("Error updating JSONPath", err)
+		return p.buildErrorResponse(ErrorCodeJSONPathUpdate, "Error updating JSONPath", err)
 	}
+	return nil
+}
 
-	updatedPayload, err := json.Marshal(payloadData)
+// updateValueAtPath sets a non-string value at jsonPath in payloadData.
+// utils.SetValueAtJSONPath only writes strings, so the parent is located with
+// utils.ExtractValueFromJsonpath and only the final key is set here. It
+// returns nil on success, or an error response.
+func (p *PromptDecoratorPolicy) updateValueAtPath(payloadData map[string]interface{}, jsonPath string, value interface{}) policy.RequestAction {
+	parentPath, finalKey, errResp := p.splitUpdatePath(jsonPath)
+	if errResp != nil {
+		return errResp
+	}
+	parent, err := utils.ExtractValueFromJsonpath(payloadData, parentPath)
 	if err != nil {
-		slog.Debug("PromptDecorator: Error marshaling updated JSON payload", "error", err)
-		return p.buildErrorResponse("Error marshaling updated JSON payload", err)
+		slog.Debug("PromptDecorator: Error navigating JSONPath", "jsonPath", jsonPath, "error", err)
+		return p.buildErrorResponse(ErrorCodeJSONPathInvalid, "Error navigating JSONPath", err)
 	}
+	if err := p.setValueAtPath(parent, finalKey, value); err != nil {
+		slog.Debug("PromptDecorator: Error updating JSONPath", "jsonPath", jsonPath, "error", err)
+		return p.buildErrorResponse(ErrorCodeJSONPathUpdate, "Error updating JSONPath", err)
+	}
+	return nil
+}
 
-	return policy.UpstreamRequestModifications{
-		Body: updatedPayload,
+// splitUpdatePath splits jsonPath into the JSONPath of the parent of its
+// target and the final key, which may carry an array index.
+func (p *PromptDecoratorPolicy) splitUpdatePath(jsonPath string) (string, string, policy.RequestAction) {
+	path := strings.TrimPrefix(jsonPath, "$.")
+	if path == "" {
+		return "", "", p.buildErrorResponse(ErrorCodeJSONPathInvalid, "Invalid JSONPath", fmt.Errorf("empty path"))
+	}
+	if idx := strings.LastIndex(path, "."); idx >= 0 {
+		return "$." + path[:idx], path[idx+1:], nil
 	}
+	return "$", path, nil
 }
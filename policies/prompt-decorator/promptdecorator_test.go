@@ -4,18 +4,20 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"reflect"
 	"strings"
 	"sync"
 	"testing"
 
 	policy "github.com/wso2/api-platform/sdk/core/policy/v1alpha2"
+	"github.com/wso2/gateway-controllers/common/promptutil/promptutiltest"
 )
 
 func TestPromptDecoratorPolicy_Mode(t *testing.T) {
 	p := &PromptDecoratorPolicy{}
 	got := p.Mode()
 	want := policy.ProcessingMode{
-		RequestHeaderMode:  policy.HeaderModeSkip,
+		RequestHeaderMode:  policy.HeaderModeProcess,
 		RequestBodyMode:    policy.BodyModeBuffer,
 		ResponseHeaderMode: policy.HeaderModeSkip,
 		ResponseBodyMode:   policy.BodyModeSkip,
@@ -69,6 +71,33 @@ func TestPromptDecoratorPolicy_GetPolicy_MessagesConfig_DefaultsAndRoleNormaliza
 	}
 }
 
+func TestPromptDecoratorPolicy_GetPolicy_AllowedRolesAndPreserveRoleCase(t *testing.T) {
+	p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+		"promptDecoratorConfig": map[string]interface{}{
+			"messages": []interface{}{
+				map[string]interface{}{"role": "Developer", "content": "Follow the style guide."},
+			},
+		},
+		"allowedRoles":     []interface{}{"developer", "user"},
+		"preserveRoleCase": true,
+	})
+	if got := p.params.PromptDecoratorConfig.Messages[0].Role; got != "Developer" {
+		t.Fatalf("expected role casing preserved, got %q", got)
+	}
+
+	p = mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+		"promptDecoratorConfig": map[string]interface{}{
+			"messages": []interface{}{
+				map[string]interface{}{"role": "Developer", "content": "Follow the style guide."},
+			},
+		},
+		"allowedRoles": []interface{}{"DEVELOPER"},
+	})
+	if got := p.params.PromptDecoratorConfig.Messages[0].Role; got != "developer" {
+		t.Fatalf("expected normalized role 'developer', got %q", got)
+	}
+}
+
 func TestPromptDecoratorPolicy_GetPolicy_ConfigFromJSONString(t *testing.T) {
 	p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
 		"promptDecoratorConfig": `{"text":"Use markdown."}`,
@@ -102,7 +131,7 @@ func TestPromptDecoratorPolicy_GetPolicy_InvalidParams(t *testing.T) {
 			params: map[string]interface{}{
 				"promptDecoratorConfig": 123,
 			},
-			wantErrContain: "'promptDecoratorConfig' must be a JSON string or object",
+			wantErrContain: "'promptDecoratorConfig' must be a JSON string, object or array",
 		},
 		{
 			name: "promptDecoratorConfig malformed json string",
@@ -161,6 +190,28 @@ func TestPromptDecoratorPolicy_GetPolicy_InvalidParams(t *testing.T) {
 			},
 			wantErrContain: "'promptDecoratorConfig.messages[0].role' must be one of [system,user,assistant,tool]",
 		},
+		{
+			name: "tool role without tool_call_id",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{
+					"messages": []interface{}{
+						map[string]interface{}{"role": "Tool", "content": "x"},
+					},
+				},
+			},
+			wantErrContain: "'promptDecoratorConfig.messages[0].tool_call_id' is required for role 'tool'",
+		},
+		{
+			name: "tool_call_id on non-tool role",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{
+					"messages": []interface{}{
+						map[string]interface{}{"role": "user", "content": "x", "tool_call_id": "call_1"},
+					},
+				},
+			},
+			wantErrContain: "'promptDecoratorConfig.messages[0].tool_call_id' is only supported for role 'tool'",
+		},
 		{
 			name: "messages content empty",
 			params: map[string]interface{}{
@@ -172,6 +223,73 @@ func TestPromptDecoratorPolicy_GetPolicy_InvalidParams(t *testing.T) {
 			},
 			wantErrContain: "'promptDecoratorConfig.messages[0].content' must be a non-empty string",
 		},
+		{
+			name: "promptDecoratorConfig empty array",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": []interface{}{},
+			},
+			wantErrContain: "'promptDecoratorConfig' array must not be empty",
+		},
+		{
+			name: "promptDecoratorConfig array entry invalid",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": []interface{}{
+					map[string]interface{}{"text": "x"},
+					map[string]interface{}{
+						"messages": []interface{}{
+							map[string]interface{}{"role": "moderator", "content": "x"},
+						},
+					},
+				},
+			},
+			wantErrContain: "'promptDecoratorConfig[1].messages[0].role' must be one of [system,user,assistant,tool]",
+		},
+		{
+			name: "promptDecoratorConfig array with top-level jsonPath",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": []interface{}{
+					map[string]interface{}{"text": "x"},
+				},
+				"jsonPath": "$.prompt",
+			},
+			wantErrContain: "'jsonPath' must be set on each entry when 'promptDecoratorConfig' is an array",
+		},
+		{
+			name: "allowedRoles empty",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{"text": "x"},
+				"allowedRoles":          []interface{}{},
+			},
+			wantErrContain: "'allowedRoles' must be a non-empty array of strings",
+		},
+		{
+			name: "allowedRoles entry blank",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{"text": "x"},
+				"allowedRoles":          []interface{}{"developer", " "},
+			},
+			wantErrContain: "'allowedRoles[1]' must be a non-empty string",
+		},
+		{
+			name: "role outside allowedRoles",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{
+					"messages": []interface{}{
+						map[string]interface{}{"role": "system", "content": "x"},
+					},
+				},
+				"allowedRoles": []interface{}{"developer", "user"},
+			},
+			wantErrContain: "'promptDecoratorConfig.messages[0].role' must be one of [developer,user]",
+		},
+		{
+			name: "preserveRoleCase wrong type",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{"text": "x"},
+				"preserveRoleCase":      "yes",
+			},
+			wantErrContain: "'preserveRoleCase' must be a boolean",
+		},
 		{
 			name: "jsonPath wrong type",
 			params: map[string]interface{}{
@@ -182,6 +300,288 @@ func TestPromptDecoratorPolicy_GetPolicy_InvalidParams(t *testing.T) {
 			},
 			wantErrContain: "'jsonPath' must be a string",
 		},
+		{
+			name: "onUnresolvedPlaceholder invalid",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{
+					"text": "x",
+				},
+				"onUnresolvedPlaceholder": "drop",
+			},
+			wantErrContain: "'onUnresolvedPlaceholder' must be one of [keep,empty,error]",
+		},
+		{
+			name: "insertIndex not an integer",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{
+					"messages": []interface{}{
+						map[string]interface{}{"role": "system", "content": "x"},
+					},
+				},
+				"insertIndex": 1.5,
+			},
+			wantErrContain: "'insertIndex' must be an integer",
+		},
+		{
+			name: "insertIndex with text config",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{
+					"text": "x",
+				},
+				"insertIndex": 1,
+			},
+			wantErrContain: "'insertIndex' is only supported with 'promptDecoratorConfig.messages'",
+		},
+		{
+			name: "maxMessages less than one",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{
+					"text": "x",
+				},
+				"maxMessages": 0,
+			},
+			wantErrContain: "'maxMessages' must be at least 1",
+		},
+		{
+			name: "separator wrong type",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{"text": "x"},
+				"separator":             1,
+			},
+			wantErrContain: "'separator' must be a string",
+		},
+		{
+			name: "deduplicate wrong type",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{
+					"text": "x",
+				},
+				"deduplicate": "yes",
+			},
+			wantErrContain: "'deduplicate' must be a boolean",
+		},
+		{
+			name: "pathSyntax invalid",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{"text": "x"},
+				"pathSyntax":            "xpath",
+			},
+			wantErrContain: "'pathSyntax' must be one of [jsonpath,jsonpointer]",
+		},
+		{
+			name: "json pointer without leading slash",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{"text": "x"},
+				"pathSyntax":            "jsonpointer",
+				"jsonPath":              "$.messages[-1].content",
+			},
+			wantErrContain: "'jsonPath' is not a valid JSON pointer",
+		},
+		{
+			name: "json pointer index without array key",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": []interface{}{
+					map[string]interface{}{"text": "x", "jsonPath": "/messages/0/1"},
+				},
+				"pathSyntax": "jsonpointer",
+			},
+			wantErrContain: `'promptDecoratorConfig[0].jsonPath' is not a valid JSON pointer: index "1" in "/messages/0/1" must follow an object key`,
+		},
+		{
+			name: "groupByRole with text decoration",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{"text": "x"},
+				"groupByRole":           true,
+			},
+			wantErrContain: "'groupByRole' is only supported with 'promptDecoratorConfig.messages'",
+		},
+		{
+			name: "removeRoles with text decoration",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{"text": "x"},
+				"removeRoles":           []interface{}{"system"},
+			},
+			wantErrContain: "'removeRoles' is only supported with 'promptDecoratorConfig.messages'",
+		},
+		{
+			name: "removeRoles empty entry",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{
+					"messages": []interface{}{map[string]interface{}{"role": "system", "content": "x"}},
+				},
+				"removeRoles": []interface{}{"system", " "},
+			},
+			wantErrContain: "'removeRoles[1]' must be a non-empty string",
+		},
+		{
+			name: "applyToResponse wrong type",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{
+					"text": "x",
+				},
+				"applyToResponse": "true",
+			},
+			wantErrContain: "'applyToResponse' must be a boolean",
+		},
+		{
+			name: "applyToResponse with messages requires responseJsonPath",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{
+					"messages": []interface{}{
+						map[string]interface{}{"role": "system", "content": "x"},
+					},
+				},
+				"applyToResponse": true,
+			},
+			wantErrContain: "'responseJsonPath' is required when 'applyToResponse' is used with 'promptDecoratorConfig.messages'",
+		},
+		{
+			name: "onMissingPath invalid",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{
+					"text": "x",
+				},
+				"onMissingPath": "keep",
+			},
+			wantErrContain: "'onMissingPath' must be one of [error,empty]",
+		},
+		{
+			name: "onEmptyBody invalid",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{
+					"text": "x",
+				},
+				"onEmptyBody": "ignore",
+			},
+			wantErrContain: "'onEmptyBody' must be one of [error,passthrough]",
+		},
+		{
+			name: "maxBodyBytes zero",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{"text": "x"},
+				"maxBodyBytes":          0,
+			},
+			wantErrContain: "'maxBodyBytes' must be at least 1",
+		},
+		{
+			name: "onBodyTooLarge invalid",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{"text": "x"},
+				"onBodyTooLarge":        "truncate",
+			},
+			wantErrContain: "'onBodyTooLarge' must be one of [error,passthrough]",
+		},
+		{
+			name: "bodyFormat invalid",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{"text": "x"},
+				"bodyFormat":            "xml",
+			},
+			wantErrContain: "'bodyFormat' must be one of [json-object,json-array,ndjson]",
+		},
+		{
+			name: "modelOverrides not an object",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{"text": "x"},
+				"modelOverrides":        []interface{}{"gpt-4o"},
+			},
+			wantErrContain: "'modelOverrides' must be an object",
+		},
+		{
+			name: "modelOverrides invalid glob",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{"text": "x"},
+				"modelOverrides": map[string]interface{}{
+					"gpt-[4": map[string]interface{}{"text": "y"},
+				},
+			},
+			wantErrContain: "'modelOverrides.gpt-[4' key is not a valid glob pattern",
+		},
+		{
+			name: "modelOverrides invalid config",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{"text": "x"},
+				"modelOverrides": map[string]interface{}{
+					"gpt-4o": map[string]interface{}{
+						"messages": []interface{}{
+							map[string]interface{}{"role": "moderator", "content": "y"},
+						},
+					},
+				},
+			},
+			wantErrContain: "'modelOverrides.gpt-4o.messages[0].role' must be one of [system,user,assistant,tool]",
+		},
+		{
+			name: "insertIndex with text model override",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{
+					"messages": []interface{}{map[string]interface{}{"role": "system", "content": "x"}},
+				},
+				"modelOverrides": map[string]interface{}{
+					"claude-*": map[string]interface{}{"text": "y"},
+				},
+				"insertIndex": 1,
+			},
+			wantErrContain: "'insertIndex' is only supported with 'modelOverrides.claude-*.messages'",
+		},
+		{
+			name: "groupByRole with text model override",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{
+					"messages": []interface{}{map[string]interface{}{"role": "system", "content": "x"}},
+				},
+				"modelOverrides": map[string]interface{}{
+					"claude-*": []interface{}{
+						map[string]interface{}{"text": "y", "jsonPath": "$.prompt"},
+					},
+				},
+				"groupByRole": true,
+			},
+			wantErrContain: "'groupByRole' is only supported with 'modelOverrides.claude-*.messages'",
+		},
+		{
+			name: "removeRoles with text model override",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{
+					"messages": []interface{}{map[string]interface{}{"role": "system", "content": "x"}},
+				},
+				"modelOverrides": map[string]interface{}{
+					"gpt-*":    map[string]interface{}{"messages": []interface{}{map[string]interface{}{"role": "system", "content": "y"}}},
+					"claude-*": map[string]interface{}{"text": "y"},
+				},
+				"removeRoles": []interface{}{"system"},
+			},
+			wantErrContain: "'removeRoles' is only supported with 'modelOverrides.claude-*.messages'",
+		},
+		{
+			name: "insertIndex with text base and messages model override",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{"text": "x"},
+				"modelOverrides": map[string]interface{}{
+					"gpt-*": map[string]interface{}{"messages": []interface{}{map[string]interface{}{"role": "system", "content": "y"}}},
+				},
+				"insertIndex": 1,
+			},
+			wantErrContain: "'insertIndex' is only supported with 'promptDecoratorConfig.messages'",
+		},
+		{
+			name: "forceJSON wrong type",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{"text": "x"},
+				"forceJSON":             "yes",
+			},
+			wantErrContain: "'forceJSON' must be a boolean",
+		},
+		{
+			name: "skipIfPathExists wrong type",
+			params: map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{
+					"text": "x",
+				},
+				"skipIfPathExists": 1,
+			},
+			wantErrContain: "'skipIfPathExists' must be a string",
+		},
 		{
 			name: "append wrong type",
 			params: map[string]interface{}{
@@ -203,6 +603,14 @@ func TestPromptDecoratorPolicy_GetPolicy_InvalidParams(t *testing.T) {
 			if !strings.Contains(err.Error(), tt.wantErrContain) {
 				t.Fatalf("error mismatch: got %q, want contain %q", err.Error(), tt.wantErrContain)
 			}
+
+			err = (&PromptDecoratorPolicy{}).Validate(tt.params)
+			if err == nil {
+				t.Fatalf("expected Validate error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.wantErrContain) {
+				t.Fatalf("Validate error mismatch: got %q, want contain %q", err.Error(), tt.wantErrContain)
+			}
 		})
 	}
 }
@@ -231,142 +639,1114 @@ func TestPromptDecoratorPolicy_OnRequest_TextDefaultPath_Prepend(t *testing.T) {
 	}
 }
 
-func TestPromptDecoratorPolicy_OnRequest_TextDefaultPath_Append(t *testing.T) {
+func TestPromptDecoratorPolicy_OnRequest_TextDefaultPath_Append(t *testing.T) {
+	p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+		"promptDecoratorConfig": map[string]interface{}{
+			"text": "in bullet points.",
+		},
+		"append": true,
+	})
+
+	ctx := newRequestContextWithBody(`{"messages":[{"role":"user","content":"Explain TCP"}]}`)
+	action := p.OnRequestBody(context.Background(), ctx, nil)
+	mods := mustRequestMods(t, action)
+
+	payload := decodeJSONMap(t, mods.Body)
+	messages := mustMessages(t, payload["messages"])
+	if got := messages[0]["content"]; got != "Explain TCP in bullet points." {
+		t.Fatalf("unexpected decorated content: got %q", got)
+	}
+}
+
+func TestPromptDecoratorPolicy_OnRequest_TextSeparator(t *testing.T) {
+	tests := []struct {
+		name      string
+		separator interface{}
+		append    bool
+		want      string
+	}{
+		{name: "default prepend", separator: nil, want: "Rules. Explain TCP"},
+		{name: "newline prepend", separator: "\n", want: "Rules.\nExplain TCP"},
+		{name: "newline append", separator: "\n\n", append: true, want: "Explain TCP\n\nRules."},
+		{name: "empty append", separator: "", append: true, want: "Explain TCPRules."},
+		{name: "custom prepend", separator: " | ", want: "Rules. | Explain TCP"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{"text": "Rules."},
+				"append":                tt.append,
+			}
+			if tt.separator != nil {
+				params["separator"] = tt.separator
+			}
+			p := mustGetPromptDecoratorPolicy(t, params)
+
+			ctx := newRequestContextWithBody(`{"messages":[{"role":"user","content":"Explain TCP"}]}`)
+			mods := mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+			messages := mustMessages(t, decodeJSONMap(t, mods.Body)["messages"])
+			if got := messages[0]["content"]; got != tt.want {
+				t.Fatalf("unexpected content: got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromptDecoratorPolicy_OnRequest_TextCustomPath(t *testing.T) {
+	p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+		"promptDecoratorConfig": map[string]interface{}{
+			"text": "Use JSON output.",
+		},
+		"jsonPath": "$.input.prompt",
+	})
+
+	ctx := newRequestContextWithBody(`{
+		"input":{"prompt":"Create a plan"},
+		"messages":[{"role":"user","content":"unchanged"}]
+	}`)
+	action := p.OnRequestBody(context.Background(), ctx, nil)
+	mods := mustRequestMods(t, action)
+
+	payload := decodeJSONMap(t, mods.Body)
+	input, ok := payload["input"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected input object, got %T", payload["input"])
+	}
+	if got := input["prompt"]; got != "Use JSON output. Create a plan" {
+		t.Fatalf("unexpected prompt: got %v", got)
+	}
+}
+
+func TestPromptDecoratorPolicy_OnRequest_MessagesDefaultPath_Prepend(t *testing.T) {
+	p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+		"promptDecoratorConfig": map[string]interface{}{
+			"messages": []interface{}{
+				map[string]interface{}{"role": "system", "content": "You are concise."},
+				map[string]interface{}{"role": "assistant", "content": "Acknowledge briefly."},
+			},
+		},
+	})
+
+	ctx := newRequestContextWithBody(`{
+		"messages":[
+			{"role":"user","content":"Hello"},
+			{"role":"assistant","content":"Hi"}
+		]
+	}`)
+	action := p.OnRequestBody(context.Background(), ctx, nil)
+	mods := mustRequestMods(t, action)
+
+	payload := decodeJSONMap(t, mods.Body)
+	messages := mustMessages(t, payload["messages"])
+	if len(messages) != 4 {
+		t.Fatalf("expected 4 messages after prepend, got %d", len(messages))
+	}
+	if got := messages[0]["role"]; got != "system" {
+		t.Fatalf("unexpected first role: %v", got)
+	}
+	if got := messages[0]["content"]; got != "You are concise." {
+		t.Fatalf("unexpected first content: %v", got)
+	}
+	if got := messages[2]["content"]; got != "Hello" {
+		t.Fatalf("expected original messages to remain in order after prepended decorations")
+	}
+}
+
+func TestPromptDecoratorPolicy_OnRequest_MessagesDefaultPath_Append(t *testing.T) {
+	p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+		"promptDecoratorConfig": map[string]interface{}{
+			"messages": []interface{}{
+				map[string]interface{}{"role": "tool", "content": "trace-id=123", "tool_call_id": "call_1"},
+			},
+		},
+		"append": true,
+	})
+
+	ctx := newRequestContextWithBody(`{"messages":[{"role":"user","content":"hello"}]}`)
+	action := p.OnRequestBody(context.Background(), ctx, nil)
+	mods := mustRequestMods(t, action)
+
+	payload := decodeJSONMap(t, mods.Body)
+	messages := mustMessages(t, payload["messages"])
+	if len(messages) != 2 {
+		t.Fatalf("expected 2 messages after append, got %d", len(messages))
+	}
+	if got := messages[1]["role"]; got != "tool" {
+		t.Fatalf("unexpected appended role: %v", got)
+	}
+	if got := messages[1]["content"]; got != "trace-id=123" {
+		t.Fatalf("unexpected appended content: %v", got)
+	}
+	if got := messages[1]["tool_call_id"]; got != "call_1" {
+		t.Fatalf("unexpected appended tool_call_id: %v", got)
+	}
+	if _, ok := messages[0]["tool_call_id"]; ok {
+		t.Fatalf("expected existing messages to be unchanged, got %v", messages[0])
+	}
+}
+
+func TestPromptDecoratorPolicy_OnRequest_MessagesCustomPath(t *testing.T) {
+	p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+		"promptDecoratorConfig": map[string]interface{}{
+			"messages": []interface{}{
+				map[string]interface{}{"role": "system", "content": "Only decorate history."},
+			},
+		},
+		"jsonPath": "$.conversation.history",
+	})
+
+	ctx := newRequestContextWithBody(`{
+		"conversation":{"history":[{"role":"user","content":"hello"}]},
+		"messages":[{"role":"user","content":"keep me"}]
+	}`)
+	action := p.OnRequestBody(context.Background(), ctx, nil)
+	mods := mustRequestMods(t, action)
+
+	payload := decodeJSONMap(t, mods.Body)
+	conversation := payload["conversation"].(map[string]interface{})
+	history := mustMessages(t, conversation["history"])
+	if len(history) != 2 {
+		t.Fatalf("expected history length 2, got %d", len(history))
+	}
+	if got := history[0]["content"]; got != "Only decorate history." {
+		t.Fatalf("unexpected history decoration: %v", got)
+	}
+	messages := mustMessages(t, payload["messages"])
+	if got := messages[0]["content"]; got != "keep me" {
+		t.Fatalf("non-target array should remain unchanged, got %v", got)
+	}
+}
+
+func TestPromptDecoratorPolicy_OnRequest_SkipIfPathExists(t *testing.T) {
+	p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+		"promptDecoratorConfig": map[string]interface{}{
+			"messages": []interface{}{
+				map[string]interface{}{"role": "system", "content": "You are helpful."},
+			},
+		},
+		"skipIfPathExists": "$.system",
+	})
+
+	tests := []struct {
+		name     string
+		body     string
+		wantSkip bool
+	}{
+		{name: "non-empty value skips", body: `{"system":"already set","messages":[]}`, wantSkip: true},
+		{name: "empty string decorates", body: `{"system":"  ","messages":[]}`, wantSkip: false},
+		{name: "missing path decorates", body: `{"messages":[]}`, wantSkip: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := newRequestContextWithBody(tt.body)
+			mods := mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+			if tt.wantSkip {
+				if mods.Body != nil {
+					t.Fatalf("expected body unmodified, got %s", string(mods.Body))
+				}
+				return
+			}
+			messages := mustMessages(t, decodeJSONMap(t, mods.Body)["messages"])
+			if len(messages) != 1 || messages[0]["role"] != "system" {
+				t.Fatalf("expected injected system message, got %v", messages)
+			}
+		})
+	}
+}
+
+func TestPromptDecoratorPolicy_HeaderPlaceholders_EnableHeaderMode(t *testing.T) {
+	p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+		"promptDecoratorConfig": map[string]interface{}{
+			"messages": []interface{}{
+				map[string]interface{}{"role": "system", "content": "You are serving tenant [[header:X-Tenant]]."},
+			},
+		},
+	})
+	if got := p.Mode().RequestHeaderMode; got != policy.HeaderModeProcess {
+		t.Fatalf("expected header processing for header placeholders, got %v", got)
+	}
+
+	plain := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+		"promptDecoratorConfig": map[string]interface{}{"text": "Be concise [[tenant]]."},
+		"forceJSON":             true,
+	})
+	if got := plain.Mode().RequestHeaderMode; got != policy.HeaderModeSkip {
+		t.Fatalf("expected header processing skipped without header placeholders, got %v", got)
+	}
+}
+
+func TestPromptDecoratorPolicy_OnRequest_ModelOverridesWithMessageOptions(t *testing.T) {
+	p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+		"promptDecoratorConfig": map[string]interface{}{
+			"messages": []interface{}{
+				map[string]interface{}{"role": "system", "content": "base"},
+			},
+		},
+		"modelOverrides": map[string]interface{}{
+			"gpt-*": map[string]interface{}{
+				"messages": []interface{}{
+					map[string]interface{}{"role": "system", "content": "gpt family"},
+				},
+			},
+		},
+		"insertIndex": 1,
+	})
+
+	tests := []struct {
+		name       string
+		model      string
+		wantSystem string
+	}{
+		{name: "override target", model: "gpt-4o", wantSystem: "gpt family"},
+		{name: "base target", model: "llama-3", wantSystem: "base"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := newRequestContextWithBody(`{"model":"` + tt.model + `","messages":[{"role":"user","content":"a"},{"role":"user","content":"b"}]}`)
+			messages := mustMessages(t, decodeJSONMap(t, mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil)).Body)["messages"])
+			if len(messages) != 3 || messages[1]["role"] != "system" || messages[1]["content"] != tt.wantSystem {
+				t.Fatalf("expected system message %q at index 1, got %v", tt.wantSystem, messages)
+			}
+		})
+	}
+}
+
+func TestPromptDecoratorPolicy_OnRequest_ModelOverrides(t *testing.T) {
+	p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+		"promptDecoratorConfig": map[string]interface{}{
+			"messages": []interface{}{
+				map[string]interface{}{"role": "system", "content": "base"},
+			},
+		},
+		"modelOverrides": map[string]interface{}{
+			"gpt-4o": map[string]interface{}{
+				"messages": []interface{}{
+					map[string]interface{}{"role": "system", "content": "exact"},
+				},
+			},
+			"gpt-*": map[string]interface{}{
+				"messages": []interface{}{
+					map[string]interface{}{"role": "system", "content": "gpt family"},
+				},
+			},
+			"claude-*": map[string]interface{}{"text": "claude text"},
+		},
+	})
+
+	tests := []struct {
+		name         string
+		model        string
+		wantSystem   string
+		wantLastText string
+	}{
+		{name: "exact match wins over glob", model: `"gpt-4o"`, wantSystem: "exact", wantLastText: "hello"},
+		{name: "glob match", model: `"gpt-3.5-turbo"`, wantSystem: "gpt family", wantLastText: "hello"},
+		{name: "glob match with text config", model: `"claude-3-opus"`, wantLastText: "claude text hello"},
+		{name: "unmatched model uses base", model: `"llama-3"`, wantSystem: "base", wantLastText: "hello"},
+		{name: "non-string model uses base", model: `42`, wantSystem: "base", wantLastText: "hello"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := newRequestContextWithBody(`{"model":` + tt.model + `,"messages":[{"role":"user","content":"hello"}]}`)
+			messages := mustMessages(t, decodeJSONMap(t, mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil)).Body)["messages"])
+			if tt.wantSystem == "" {
+				if len(messages) != 1 {
+					t.Fatalf("expected no injected messages, got %v", messages)
+				}
+			} else if len(messages) != 2 || messages[0]["role"] != "system" || messages[0]["content"] != tt.wantSystem {
+				t.Fatalf("expected injected system message %q, got %v", tt.wantSystem, messages)
+			}
+			if got := messages[len(messages)-1]["content"]; got != tt.wantLastText {
+				t.Fatalf("unexpected last message content: got %v, want %q", got, tt.wantLastText)
+			}
+		})
+	}
+
+	// Without a model field the base decoration applies.
+	ctx := newRequestContextWithBody(`{"messages":[{"role":"user","content":"hello"}]}`)
+	messages := mustMessages(t, decodeJSONMap(t, mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil)).Body)["messages"])
+	if len(messages) != 2 || messages[0]["content"] != "base" {
+		t.Fatalf("expected base decoration without a model, got %v", messages)
+	}
+}
+
+func TestPromptDecoratorPolicy_OnRequest_ContentType(t *testing.T) {
+	body := `{"messages":[{"role":"user","content":"hello"}]}`
+	tests := []struct {
+		name          string
+		contentType   string
+		forceJSON     bool
+		wantDecorated bool
+	}{
+		{name: "json", contentType: "application/json; charset=utf-8", wantDecorated: true},
+		{name: "structured json suffix", contentType: "application/vnd.api+json", wantDecorated: true},
+		{name: "ndjson", contentType: "application/x-ndjson", wantDecorated: true},
+		{name: "missing content type", wantDecorated: true},
+		{name: "form encoded passes through", contentType: "application/x-www-form-urlencoded"},
+		{name: "malformed content type passes through", contentType: "application/json; =broken"},
+		{name: "form encoded with forceJSON", contentType: "application/x-www-form-urlencoded", forceJSON: true, wantDecorated: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{"text": "Be concise."},
+				"forceJSON":             tt.forceJSON,
+			})
+			if got, want := p.Mode().RequestHeaderMode == policy.HeaderModeProcess, !tt.forceJSON; got != want {
+				t.Fatalf("expected request header processing %v, got %v", want, got)
+			}
+
+			ctx := newRequestContextWithBody(body)
+			if tt.contentType != "" {
+				ctx.Headers = policy.NewHeaders(map[string][]string{"Content-Type": {tt.contentType}})
+			}
+			mods := mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+			if (mods.Body != nil) != tt.wantDecorated {
+				t.Fatalf("expected decorated=%v, got body %s", tt.wantDecorated, string(mods.Body))
+			}
+		})
+	}
+}
+
+func TestPromptDecoratorPolicy_OnRequest_HeaderPlaceholders(t *testing.T) {
+	tests := []struct {
+		name         string
+		onUnresolved string
+		headers      map[string][]string
+		wantContent  string
+		wantErr      string
+	}{
+		{
+			name:        "header resolved",
+			headers:     map[string][]string{"x-tenant": {"acme"}},
+			wantContent: "Tenant acme. hello",
+		},
+		{
+			name:        "missing header kept by default",
+			wantContent: "Tenant [[header:X-Tenant]]. hello",
+		},
+		{
+			name:         "missing header emptied",
+			onUnresolved: "empty",
+			wantContent:  "Tenant . hello",
+		},
+		{
+			name:         "missing header errors",
+			onUnresolved: "error",
+			wantErr:      "Unresolved header placeholders: missing request headers: X-Tenant",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{"text": "Tenant [[header:X-Tenant]]."},
+			}
+			if tt.onUnresolved != "" {
+				params["onUnresolvedPlaceholder"] = tt.onUnresolved
+			}
+			p := mustGetPromptDecoratorPolicy(t, params)
+
+			ctx := newRequestContextWithBody(`{"messages":[{"role":"user","content":"hello"}]}`)
+			ctx.Headers = policy.NewHeaders(tt.headers)
+			action := p.OnRequestBody(context.Background(), ctx, nil)
+			if tt.wantErr != "" {
+				assertDecoratorError(t, action, ErrorCodePlaceholderUnresolved, tt.wantErr)
+				return
+			}
+			messages := mustMessages(t, decodeJSONMap(t, mustRequestMods(t, action).Body)["messages"])
+			if got := messages[0]["content"]; got != tt.wantContent {
+				t.Fatalf("unexpected content: got %q, want %q", got, tt.wantContent)
+			}
+		})
+	}
+}
+
+func TestPromptDecoratorPolicy_OnRequest_Deduplicate(t *testing.T) {
+	for _, appendMode := range []bool{false, true} {
+		p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+			"promptDecoratorConfig": map[string]interface{}{
+				"messages": []interface{}{
+					map[string]interface{}{"role": "system", "content": "You are concise."},
+					map[string]interface{}{"role": "system", "content": "Answer in English."},
+				},
+			},
+			"append":      appendMode,
+			"deduplicate": true,
+		})
+
+		ctx := newRequestContextWithBody(`{"messages":[
+			{"role":"System","content":"  You are concise. "},
+			{"role":"user","content":"hello"}
+		]}`)
+		mods := mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+
+		messages := mustMessages(t, decodeJSONMap(t, mods.Body)["messages"])
+		if len(messages) != 3 {
+			t.Fatalf("append=%v: expected 3 messages after deduplication, got %d", appendMode, len(messages))
+		}
+		injected := messages[0]
+		if appendMode {
+			injected = messages[2]
+		}
+		if got := injected["content"]; got != "Answer in English." {
+			t.Fatalf("append=%v: unexpected injected content: %v", appendMode, got)
+		}
+	}
+}
+
+func TestJSONPointerToJSONPath(t *testing.T) {
+	tests := []struct {
+		pointer string
+		want    string
+		wantErr bool
+	}{
+		{pointer: "/messages/-1/content", want: "$.messages[-1].content"},
+		{pointer: "/messages/-/content", want: "$.messages[-1].content"},
+		{pointer: "/messages/0", want: "$.messages[0]"},
+		{pointer: "/input", want: "$.input"},
+		{pointer: "", wantErr: true},
+		{pointer: "/", wantErr: true},
+		{pointer: "/0", wantErr: true},
+		{pointer: "/a~1b", want: "$.a/b"},
+		{pointer: "/messages/0/content/", wantErr: true},
+		// Keys may contain any character the dotted JSONPath form allows.
+		{pointer: "/x-meta/foo", want: "$.x-meta.foo"},
+		{pointer: "/x-meta/a b~0c~1d", want: "$.x-meta.a b~c/d"},
+		{pointer: "/a.b", wantErr: true},
+		{pointer: "/a[0]", wantErr: true},
+		// Only keys of letters, digits and underscores can be indexed.
+		{pointer: "/x-items/0", wantErr: true},
+		// Numeric tokens always index an array, never an object key.
+		{pointer: "/metadata/2024", want: "$.metadata[2024]"},
+	}
+
+	for _, tt := range tests {
+		got, err := jsonPointerToJSONPath(tt.pointer)
+		if tt.wantErr {
+			if err == nil {
+				t.Fatalf("%q: expected error, got %q", tt.pointer, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Fatalf("%q: got %q, %v; want %q", tt.pointer, got, err, tt.want)
+		}
+	}
+}
+
+func TestPromptDecoratorPolicy_OnRequest_JSONPointer(t *testing.T) {
+	p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+		"promptDecoratorConfig": []interface{}{
+			map[string]interface{}{"text": "Be brief.", "jsonPath": "/messages/-/content"},
+			map[string]interface{}{
+				"messages": []interface{}{map[string]interface{}{"role": "system", "content": "rules"}},
+				"jsonPath": "/messages",
+			},
+		},
+		"pathSyntax": "jsonpointer",
+	})
+
+	ctx := newRequestContextWithBody(`{"messages":[{"role":"user","content":"hello"}]}`)
+	mods := mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	messages := mustMessages(t, decodeJSONMap(t, mods.Body)["messages"])
+	if len(messages) != 2 || messages[0]["content"] != "rules" || messages[1]["content"] != "Be brief. hello" {
+		t.Fatalf("unexpected messages: %v", messages)
+	}
+
+	p = mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+		"promptDecoratorConfig": map[string]interface{}{"text": "Be brief."},
+		"jsonPath":              "/messages/3/content",
+		"pathSyntax":            "jsonpointer",
+	})
+	action := p.OnRequestBody(context.Background(), newRequestContextWithBody(`{"messages":[{"role":"user","content":"hello"}]}`), nil)
+	assertDecoratorError(t, action, ErrorCodeJSONPathInvalid, "Error extracting value from JSONPath")
+
+	// Keys with characters beyond letters, digits and underscores resolve.
+	p = mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+		"promptDecoratorConfig": map[string]interface{}{"text": "Be brief."},
+		"jsonPath":              "/x-meta/user-note",
+		"pathSyntax":            "jsonpointer",
+	})
+	mods = mustRequestMods(t, p.OnRequestBody(context.Background(), newRequestContextWithBody(`{"x-meta":{"user-note":"hello"}}`), nil))
+	if got := decodeJSONMap(t, mods.Body)["x-meta"]; !reflect.DeepEqual(got, map[string]interface{}{"user-note": "Be brief. hello"}) {
+		t.Fatalf("unexpected x-meta: %v", got)
+	}
+
+	// Numeric tokens index arrays, so a numeric object key is not addressable.
+	p = mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+		"promptDecoratorConfig": map[string]interface{}{"text": "Be brief."},
+		"jsonPath":              "/metadata/2024",
+		"pathSyntax":            "jsonpointer",
+	})
+	action = p.OnRequestBody(context.Background(), newRequestContextWithBody(`{"metadata":{"2024":"hello"}}`), nil)
+	assertDecoratorError(t, action, ErrorCodeJSONPathInvalid, "Error extracting value from JSONPath")
+}
+
+func TestPromptDecoratorPolicy_OnRequest_TextContentParts(t *testing.T) {
+	for _, appendMode := range []bool{false, true} {
+		p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+			"promptDecoratorConfig": map[string]interface{}{"text": "Describe briefly."},
+			"append":                appendMode,
+		})
+
+		ctx := newRequestContextWithBody(`{"messages":[{"role":"user","content":[
+			{"type":"text","text":"What is this?"},
+			{"type":"image_url","image_url":{"url":"https://example.com/a.png"}}
+		]}]}`)
+		mods := mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+
+		messages := mustMessages(t, decodeJSONMap(t, mods.Body)["messages"])
+		parts, ok := messages[0]["content"].([]interface{})
+		if !ok || len(parts) != 3 {
+			t.Fatalf("append=%v: expected three content parts, got %v", appendMode, messages[0]["content"])
+		}
+		decorationIdx, originalIdx := 0, 1
+		if appendMode {
+			decorationIdx, originalIdx = 2, 0
+		}
+		want := map[string]interface{}{"type": "text", "text": "Describe briefly."}
+		if !reflect.DeepEqual(parts[decorationIdx], want) {
+			t.Fatalf("append=%v: unexpected decoration part: %v", appendMode, parts[decorationIdx])
+		}
+		if got := parts[originalIdx].(map[string]interface{})["text"]; got != "What is this?" {
+			t.Fatalf("append=%v: expected original text part to be unchanged, got %v", appendMode, got)
+		}
+		if got := ctx.Metadata[MetadataKeyDecorations].([]map[string]interface{})[0]["mode"]; got != "content_parts" {
+			t.Fatalf("append=%v: unexpected mode %v", appendMode, got)
+		}
+	}
+}
+
+func TestPromptDecoratorPolicy_OnRequest_RecordsDecorations(t *testing.T) {
+	p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+		"promptDecoratorConfig": []interface{}{
+			map[string]interface{}{"text": "Be brief.", "jsonPath": "$.messages[-1].content", "append": true},
+			map[string]interface{}{
+				"messages": []interface{}{
+					map[string]interface{}{"role": "system", "content": "rules"},
+					map[string]interface{}{"role": "system", "content": "more rules"},
+				},
+				"jsonPath": "$.messages",
+			},
+			map[string]interface{}{"text": "Note:", "jsonPath": "$.prompts"},
+		},
+	})
+
+	ctx := newRequestContextWithBody(`{"messages":[{"role":"user","content":"hello"}],"prompts":["a","b"]}`)
+	mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+
+	summary, ok := ctx.Metadata[MetadataKeyDecorations].([]map[string]interface{})
+	if !ok || len(summary) != 3 {
+		t.Fatalf("expected a summary of three decorations, got %#v", ctx.Metadata[MetadataKeyDecorations])
+	}
+	want := []map[string]interface{}{
+		{"jsonPath": "$.messages[-1].content", "mode": "string", "append": true, "messagesAdded": 0},
+		{"jsonPath": "$.messages", "mode": "array", "append": false, "messagesAdded": 2},
+		{"jsonPath": "$.prompts", "mode": "string_array", "append": false, "messagesAdded": 0},
+	}
+	if !reflect.DeepEqual(summary, want) {
+		t.Fatalf("unexpected summary:\n got %v\nwant %v", summary, want)
+	}
+
+	ctx = newRequestContextWithBody(`{"messages":"not an array","prompts":["a"]}`)
+	assertDecoratorError(t, p.OnRequestBody(context.Background(), ctx, nil), ErrorCodeJSONPathInvalid, "Error extracting value from JSONPath")
+	if _, exists := ctx.Metadata[MetadataKeyDecorations]; exists {
+		t.Fatalf("did not expect a decoration summary for an error response")
+	}
+}
+
+func TestPromptDecoratorPolicy_OnRequest_CreateMissing(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        map[string]interface{}
+		jsonPath      string
+		createMissing bool
+		body          string
+		want          string
+		wantErr       string
+	}{
+		{
+			name:          "text creates intermediate objects",
+			config:        map[string]interface{}{"text": "Be brief."},
+			jsonPath:      "$.metadata.guidance.note",
+			createMissing: true,
+			body:          `{"messages":[]}`,
+			want:          `{"messages":[],"metadata":{"guidance":{"note":"Be brief."}}}`,
+		},
+		{
+			name:          "messages creates array",
+			config:        map[string]interface{}{"messages": []interface{}{map[string]interface{}{"role": "system", "content": "rules"}}},
+			jsonPath:      "$.messages",
+			createMissing: true,
+			body:          `{"input":"hi"}`,
+			want:          `{"input":"hi","messages":[{"content":"rules","role":"system"}]}`,
+		},
+		{
+			name:          "existing target is decorated as before",
+			config:        map[string]interface{}{"text": "Be brief."},
+			jsonPath:      "$.input",
+			createMissing: true,
+			body:          `{"input":"hi"}`,
+			want:          `{"input":"Be brief. hi"}`,
+		},
+		{
+			name:          "array elements are not created",
+			config:        map[string]interface{}{"text": "Be brief."},
+			jsonPath:      "$.messages[0].content",
+			createMissing: true,
+			body:          `{"messages":[]}`,
+			wantErr:       "Error extracting value from JSONPath",
+		},
+		{
+			name:          "non-object parent is an error",
+			config:        map[string]interface{}{"text": "Be brief."},
+			jsonPath:      "$.input.note",
+			createMissing: true,
+			body:          `{"input":"hi"}`,
+			wantErr:       "Error extracting value from JSONPath",
+		},
+		{
+			name:     "disabled by default",
+			config:   map[string]interface{}{"text": "Be brief."},
+			jsonPath: "$.metadata.note",
+			body:     `{"messages":[]}`,
+			wantErr:  "Error extracting value from JSONPath",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+				"promptDecoratorConfig": tt.config,
+				"jsonPath":              tt.jsonPath,
+				"createMissing":         tt.createMissing,
+			})
+
+			action := p.OnRequestBody(context.Background(), newRequestContextWithBody(tt.body), nil)
+			if tt.wantErr != "" {
+				assertDecoratorError(t, action, ErrorCodeJSONPathInvalid, tt.wantErr)
+				return
+			}
+			mods := mustRequestMods(t, action)
+			if got := string(mods.Body); got != tt.want {
+				t.Fatalf("unexpected body: got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromptDecoratorPolicy_OnRequest_GroupByRole(t *testing.T) {
+	decorations := []interface{}{
+		map[string]interface{}{"role": "assistant", "content": "a1"},
+		map[string]interface{}{"role": "developer", "content": "d1"},
+		map[string]interface{}{"role": "system", "content": "s1"},
+		map[string]interface{}{"role": "user", "content": "u1"},
+		map[string]interface{}{"role": "system", "content": "s2"},
+	}
+	tests := []struct {
+		groupByRole bool
+		want        []string
+	}{
+		{groupByRole: false, want: []string{"a1", "d1", "s1", "u1", "s2", "hello"}},
+		{groupByRole: true, want: []string{"s1", "s2", "u1", "a1", "d1", "hello"}},
+	}
+
+	for _, tt := range tests {
+		p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+			"promptDecoratorConfig": map[string]interface{}{"messages": decorations},
+			"allowedRoles":          []interface{}{"system", "user", "assistant", "developer"},
+			"groupByRole":           tt.groupByRole,
+		})
+
+		ctx := newRequestContextWithBody(`{"messages":[{"role":"user","content":"hello"}]}`)
+		mods := mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+		messages := mustMessages(t, decodeJSONMap(t, mods.Body)["messages"])
+		got := make([]string, 0, len(messages))
+		for _, msg := range messages {
+			got = append(got, msg["content"].(string))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("groupByRole=%v: got order %v, want %v", tt.groupByRole, got, tt.want)
+		}
+	}
+}
+
+func TestPromptDecoratorPolicy_OnRequest_RemoveRoles(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		wantRoles []string
+	}{
+		{
+			name: "prior system messages replaced",
+			body: `{"messages":[
+				{"role":"System","content":"old rules"},
+				{"role":"user","content":"hello"},
+				{"role":" system ","content":"more rules"},
+				{"role":"assistant","content":"hi"}
+			]}`,
+			wantRoles: []string{"system", "user", "assistant"},
+		},
+		{
+			name:      "array becomes empty",
+			body:      `{"messages":[{"role":"system","content":"old rules"}]}`,
+			wantRoles: []string{"system"},
+		},
+		{
+			name:      "empty array",
+			body:      `{"messages":[]}`,
+			wantRoles: []string{"system"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{
+					"messages": []interface{}{
+						map[string]interface{}{"role": "system", "content": "canonical rules"},
+					},
+				},
+				"removeRoles": []interface{}{"SYSTEM"},
+			})
+
+			ctx := newRequestContextWithBody(tt.body)
+			mods := mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+
+			messages := mustMessages(t, decodeJSONMap(t, mods.Body)["messages"])
+			if len(messages) != len(tt.wantRoles) {
+				t.Fatalf("expected %d messages, got %d: %v", len(tt.wantRoles), len(messages), messages)
+			}
+			for i, role := range tt.wantRoles {
+				if got := strings.TrimSpace(strings.ToLower(messages[i]["role"].(string))); got != role {
+					t.Fatalf("message %d: expected role %q, got %q", i, role, got)
+				}
+			}
+			if got := messages[0]["content"]; got != "canonical rules" {
+				t.Fatalf("expected canonical system prompt first, got %v", got)
+			}
+		})
+	}
+}
+
+func TestPromptDecoratorPolicy_OnRequest_InsertIndex(t *testing.T) {
+	tests := []struct {
+		name        string
+		insertIndex int
+		wantRoles   []string
+		wantErr     bool
+	}{
+		{name: "after first message", insertIndex: 1, wantRoles: []string{"system", "tool", "user", "assistant"}},
+		{name: "at end", insertIndex: 3, wantRoles: []string{"system", "user", "assistant", "tool"}},
+		{name: "negative index", insertIndex: -1, wantRoles: []string{"system", "user", "tool", "assistant"}},
+		{name: "beyond end", insertIndex: 4, wantErr: true},
+		{name: "negative beyond start", insertIndex: -4, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{
+					"messages": []interface{}{
+						map[string]interface{}{"role": "tool", "content": "context", "tool_call_id": "call_1"},
+					},
+				},
+				"insertIndex": tt.insertIndex,
+			})
+
+			ctx := newRequestContextWithBody(`{"messages":[
+				{"role":"system","content":"rules"},
+				{"role":"user","content":"hello"},
+				{"role":"assistant","content":"hi"}
+			]}`)
+			action := p.OnRequestBody(context.Background(), ctx, nil)
+			if tt.wantErr {
+				assertDecoratorError(t, action, ErrorCodeInsertIndexOutOfRange, "Insert index out of range")
+				return
+			}
+			messages := mustMessages(t, decodeJSONMap(t, mustRequestMods(t, action).Body)["messages"])
+			if len(messages) != len(tt.wantRoles) {
+				t.Fatalf("expected %d messages, got %d", len(tt.wantRoles), len(messages))
+			}
+			for i, role := range tt.wantRoles {
+				if messages[i]["role"] != role {
+					t.Fatalf("unexpected role at %d: got %v, want %q", i, messages[i]["role"], role)
+				}
+			}
+		})
+	}
+}
+
+func TestPromptDecoratorPolicy_OnRequest_PathTokens(t *testing.T) {
+	p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+		"promptDecoratorConfig": map[string]interface{}{
+			"messages": []interface{}{
+				map[string]interface{}{"role": "system", "content": "Reply in {{ $.metadata.language }}; options {{$.metadata.options}}; n={{$.n}}."},
+			},
+		},
+	})
+
+	ctx := newRequestContextWithBody(`{
+		"metadata":{"language":"French","options":{"tone":"formal","brief":true}},
+		"n":2,
+		"messages":[{"role":"user","content":"hello"}]
+	}`)
+	mods := mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+
+	messages := mustMessages(t, decodeJSONMap(t, mods.Body)["messages"])
+	want := `Reply in French; options {"brief":true,"tone":"formal"}; n=2.`
+	if got := messages[0]["content"]; got != want {
+		t.Fatalf("unexpected content: got %q, want %q", got, want)
+	}
+}
+
+func TestPromptDecoratorPolicy_OnRequest_PathTokensMissingPath(t *testing.T) {
+	tests := []struct {
+		name          string
+		onMissingPath string
+		wantContent   string
+		wantErr       string
+	}{
+		{name: "error by default", wantErr: "Unresolved JSONPath tokens: paths not found in request payload: $.metadata.language"},
+		{name: "empty", onMissingPath: "empty", wantContent: "Language: . hello"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{"text": "Language: {{$.metadata.language}}."},
+			}
+			if tt.onMissingPath != "" {
+				params["onMissingPath"] = tt.onMissingPath
+			}
+			p := mustGetPromptDecoratorPolicy(t, params)
+
+			ctx := newRequestContextWithBody(`{"messages":[{"role":"user","content":"hello"}]}`)
+			action := p.OnRequestBody(context.Background(), ctx, nil)
+			if tt.wantErr != "" {
+				assertDecoratorError(t, action, ErrorCodePlaceholderUnresolved, tt.wantErr)
+				return
+			}
+			messages := mustMessages(t, decodeJSONMap(t, mustRequestMods(t, action).Body)["messages"])
+			if got := messages[0]["content"]; got != tt.wantContent {
+				t.Fatalf("unexpected content: got %q, want %q", got, tt.wantContent)
+			}
+		})
+	}
+}
+
+func TestPromptDecoratorPolicy_OnResponse_ApplyToResponse(t *testing.T) {
 	p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
 		"promptDecoratorConfig": map[string]interface{}{
-			"text": "in bullet points.",
+			"text": "AI-generated content may be inaccurate.",
 		},
-		"append": true,
+		"append":          true,
+		"applyToResponse": true,
 	})
+	if got := p.Mode().ResponseBodyMode; got != policy.BodyModeBuffer {
+		t.Fatalf("expected buffered response body mode, got %v", got)
+	}
+	if got := p.params.ResponseJsonPath; got != defaultResponseTextJSONPath {
+		t.Fatalf("unexpected default responseJsonPath: got %q", got)
+	}
 
-	ctx := newRequestContextWithBody(`{"messages":[{"role":"user","content":"Explain TCP"}]}`)
-	action := p.OnRequestBody(context.Background(), ctx, nil)
-	mods := mustRequestMods(t, action)
-
+	respCtx := &policy.ResponseContext{
+		SharedContext:  &policy.SharedContext{Metadata: map[string]interface{}{}},
+		ResponseStatus: 200,
+		ResponseBody: &policy.Body{
+			Content: []byte(`{"choices":[{"message":{"role":"assistant","content":"Paris."}}]}`),
+			Present: true,
+		},
+	}
+	mods, ok := p.OnResponseBody(context.Background(), respCtx, nil).(policy.DownstreamResponseModifications)
+	if !ok {
+		t.Fatalf("expected DownstreamResponseModifications")
+	}
 	payload := decodeJSONMap(t, mods.Body)
-	messages := mustMessages(t, payload["messages"])
-	if got := messages[0]["content"]; got != "Explain TCP in bullet points." {
-		t.Fatalf("unexpected decorated content: got %q", got)
+	message := payload["choices"].([]interface{})[0].(map[string]interface{})["message"].(map[string]interface{})
+	if got, want := message["content"], "Paris. AI-generated content may be inaccurate."; got != want {
+		t.Fatalf("unexpected response content: got %q, want %q", got, want)
+	}
+
+	respCtx.ResponseStatus = 502
+	if mods := p.OnResponseBody(context.Background(), respCtx, nil).(policy.DownstreamResponseModifications); mods.Body != nil {
+		t.Fatalf("expected error responses to pass through, got %s", string(mods.Body))
 	}
 }
 
-func TestPromptDecoratorPolicy_OnRequest_TextCustomPath(t *testing.T) {
+func TestPromptDecoratorPolicy_OnResponse_PathTokensResolveAgainstRequest(t *testing.T) {
+	newResponseContext := func(metadata map[string]interface{}) *policy.ResponseContext {
+		return &policy.ResponseContext{
+			SharedContext:  &policy.SharedContext{Metadata: metadata},
+			ResponseStatus: 200,
+			ResponseBody: &policy.Body{
+				Content: []byte(`{"model":"response-model","choices":[{"message":{"role":"assistant","content":"Paris."}}]}`),
+				Present: true,
+			},
+		}
+	}
+	responseContent := func(t *testing.T, action policy.ResponseAction) interface{} {
+		t.Helper()
+		mods, ok := action.(policy.DownstreamResponseModifications)
+		if !ok {
+			t.Fatalf("expected DownstreamResponseModifications, got %T", action)
+		}
+		payload := decodeJSONMap(t, mods.Body)
+		return payload["choices"].([]interface{})[0].(map[string]interface{})["message"].(map[string]interface{})["content"]
+	}
+
 	p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
-		"promptDecoratorConfig": map[string]interface{}{
-			"text": "Use JSON output.",
-		},
-		"jsonPath": "$.input.prompt",
+		"promptDecoratorConfig": map[string]interface{}{"text": "Answered by {{$.model}}."},
+		"append":                true,
+		"applyToResponse":       true,
+		"onMissingPath":         "empty",
 	})
 
-	ctx := newRequestContextWithBody(`{
-		"input":{"prompt":"Create a plan"},
-		"messages":[{"role":"user","content":"unchanged"}]
-	}`)
-	action := p.OnRequestBody(context.Background(), ctx, nil)
-	mods := mustRequestMods(t, action)
+	reqCtx := newRequestContextWithBody(`{"model":"request-model","messages":[{"role":"user","content":"Capital of France?"}]}`)
+	mustRequestMods(t, p.OnRequestBody(context.Background(), reqCtx, nil))
 
-	payload := decodeJSONMap(t, mods.Body)
-	input, ok := payload["input"].(map[string]interface{})
-	if !ok {
-		t.Fatalf("expected input object, got %T", payload["input"])
+	got := responseContent(t, p.OnResponseBody(context.Background(), newResponseContext(reqCtx.Metadata), nil))
+	if want := "Paris. Answered by request-model."; got != want {
+		t.Fatalf("unexpected response content: got %q, want %q", got, want)
 	}
-	if got := input["prompt"]; got != "Use JSON output. Create a plan" {
-		t.Fatalf("unexpected prompt: got %v", got)
+
+	// Without captured request values the token is missing, even though the
+	// response payload has the path.
+	got = responseContent(t, p.OnResponseBody(context.Background(), newResponseContext(map[string]interface{}{}), nil))
+	if want := "Paris. Answered by ."; got != want {
+		t.Fatalf("unexpected response content: got %q, want %q", got, want)
 	}
 }
 
-func TestPromptDecoratorPolicy_OnRequest_MessagesDefaultPath_Prepend(t *testing.T) {
+func TestPromptDecoratorPolicy_OnResponse_DisabledByDefault(t *testing.T) {
 	p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
-		"promptDecoratorConfig": map[string]interface{}{
-			"messages": []interface{}{
-				map[string]interface{}{"role": "system", "content": "You are concise."},
-				map[string]interface{}{"role": "assistant", "content": "Acknowledge briefly."},
-			},
-		},
+		"promptDecoratorConfig": map[string]interface{}{"text": "x"},
 	})
-
-	ctx := newRequestContextWithBody(`{
-		"messages":[
-			{"role":"user","content":"Hello"},
-			{"role":"assistant","content":"Hi"}
-		]
-	}`)
-	action := p.OnRequestBody(context.Background(), ctx, nil)
-	mods := mustRequestMods(t, action)
-
-	payload := decodeJSONMap(t, mods.Body)
-	messages := mustMessages(t, payload["messages"])
-	if len(messages) != 4 {
-		t.Fatalf("expected 4 messages after prepend, got %d", len(messages))
+	respCtx := &policy.ResponseContext{
+		SharedContext: &policy.SharedContext{},
+		ResponseBody:  &policy.Body{Content: []byte(`{"choices":[{"message":{"content":"y"}}]}`), Present: true},
 	}
-	if got := messages[0]["role"]; got != "system" {
-		t.Fatalf("unexpected first role: %v", got)
+	if mods := p.OnResponseBody(context.Background(), respCtx, nil).(policy.DownstreamResponseModifications); mods.Body != nil {
+		t.Fatalf("expected response untouched, got %s", string(mods.Body))
 	}
-	if got := messages[0]["content"]; got != "You are concise." {
-		t.Fatalf("unexpected first content: %v", got)
+}
+
+func TestPromptDecoratorPolicy_OnRequest_MaxMessages(t *testing.T) {
+	tests := []struct {
+		name         string
+		append       bool
+		maxMessages  int
+		wantContents []string
+		wantErr      bool
+	}{
+		{
+			name:         "prepend trims oldest",
+			maxMessages:  3,
+			wantContents: []string{"rules", "second", "third"},
+		},
+		{
+			name:         "append trims oldest",
+			append:       true,
+			maxMessages:  3,
+			wantContents: []string{"second", "third", "rules"},
+		},
+		{
+			name:         "within limit",
+			maxMessages:  4,
+			wantContents: []string{"rules", "first", "second", "third"},
+		},
+		{
+			name:        "decorations alone exceed limit",
+			maxMessages: 1,
+			wantErr:     true,
+		},
 	}
-	if got := messages[2]["content"]; got != "Hello" {
-		t.Fatalf("expected original messages to remain in order after prepended decorations")
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decorations := []interface{}{
+				map[string]interface{}{"role": "system", "content": "rules"},
+			}
+			if tt.wantErr {
+				decorations = append(decorations, map[string]interface{}{"role": "system", "content": "more rules"})
+			}
+			p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{"messages": decorations},
+				"append":                tt.append,
+				"maxMessages":           tt.maxMessages,
+			})
+
+			ctx := newRequestContextWithBody(`{"messages":[
+				{"role":"user","content":"first"},
+				{"role":"assistant","content":"second"},
+				{"role":"user","content":"third"}
+			]}`)
+			action := p.OnRequestBody(context.Background(), ctx, nil)
+			if tt.wantErr {
+				assertDecoratorError(t, action, ErrorCodeMessageLimitExceeded, "Message limit exceeded")
+				return
+			}
+			messages := mustMessages(t, decodeJSONMap(t, mustRequestMods(t, action).Body)["messages"])
+			if len(messages) != len(tt.wantContents) {
+				t.Fatalf("expected %d messages, got %d", len(tt.wantContents), len(messages))
+			}
+			for i, want := range tt.wantContents {
+				if messages[i]["content"] != want {
+					t.Fatalf("unexpected content at %d: got %v, want %q", i, messages[i]["content"], want)
+				}
+			}
+		})
 	}
 }
 
-func TestPromptDecoratorPolicy_OnRequest_MessagesDefaultPath_Append(t *testing.T) {
+func TestPromptDecoratorPolicy_OnRequest_MultipleDecorationSpecs(t *testing.T) {
 	p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
-		"promptDecoratorConfig": map[string]interface{}{
-			"messages": []interface{}{
-				map[string]interface{}{"role": "tool", "content": "trace-id=123"},
-			},
-		},
-		"append": true,
+		"promptDecoratorConfig": `[
+			{"messages":[{"role":"SYSTEM","content":"You are concise."}]},
+			{"text":"Summary:","jsonPath":"$.summary"},
+			{"text":"(end)","jsonPath":"$.messages[-1].content","append":true}
+		]`,
 	})
 
-	ctx := newRequestContextWithBody(`{"messages":[{"role":"user","content":"hello"}]}`)
-	action := p.OnRequestBody(context.Background(), ctx, nil)
-	mods := mustRequestMods(t, action)
+	ctx := newRequestContextWithBody(`{
+		"summary":"short text",
+		"messages":[{"role":"user","content":"hello"}]
+	}`)
+	mods := mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
 
 	payload := decodeJSONMap(t, mods.Body)
+	if got := payload["summary"]; got != "Summary: short text" {
+		t.Fatalf("unexpected summary: %v", got)
+	}
 	messages := mustMessages(t, payload["messages"])
 	if len(messages) != 2 {
-		t.Fatalf("expected 2 messages after append, got %d", len(messages))
+		t.Fatalf("expected 2 messages, got %d", len(messages))
 	}
-	if got := messages[1]["role"]; got != "tool" {
-		t.Fatalf("unexpected appended role: %v", got)
+	if messages[0]["role"] != "system" || messages[0]["content"] != "You are concise." {
+		t.Fatalf("unexpected injected message: %v", messages[0])
 	}
-	if got := messages[1]["content"]; got != "trace-id=123" {
-		t.Fatalf("unexpected appended content: %v", got)
+	if got := messages[1]["content"]; got != "hello (end)" {
+		t.Fatalf("unexpected last message content: %v", got)
 	}
 }
 
-func TestPromptDecoratorPolicy_OnRequest_MessagesCustomPath(t *testing.T) {
+func TestPromptDecoratorPolicy_OnRequest_MultipleDecorationSpecsFailsAtomically(t *testing.T) {
 	p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
-		"promptDecoratorConfig": map[string]interface{}{
-			"messages": []interface{}{
-				map[string]interface{}{"role": "system", "content": "Only decorate history."},
-			},
+		"promptDecoratorConfig": []interface{}{
+			map[string]interface{}{"text": "Summary:", "jsonPath": "$.summary"},
+			map[string]interface{}{"text": "x", "jsonPath": "$.missing"},
 		},
-		"jsonPath": "$.conversation.history",
 	})
 
-	ctx := newRequestContextWithBody(`{
-		"conversation":{"history":[{"role":"user","content":"hello"}]},
-		"messages":[{"role":"user","content":"keep me"}]
-	}`)
+	ctx := newRequestContextWithBody(`{"summary":"short text"}`)
 	action := p.OnRequestBody(context.Background(), ctx, nil)
-	mods := mustRequestMods(t, action)
-
-	payload := decodeJSONMap(t, mods.Body)
-	conversation := payload["conversation"].(map[string]interface{})
-	history := mustMessages(t, conversation["history"])
-	if len(history) != 2 {
-		t.Fatalf("expected history length 2, got %d", len(history))
-	}
-	if got := history[0]["content"]; got != "Only decorate history." {
-		t.Fatalf("unexpected history decoration: %v", got)
-	}
-	messages := mustMessages(t, payload["messages"])
-	if got := messages[0]["content"]; got != "keep me" {
-		t.Fatalf("non-target array should remain unchanged, got %v", got)
-	}
+	assertDecoratorError(t, action, ErrorCodeJSONPathInvalid, "Error extracting value from JSONPath")
 }
 
 func TestPromptDecoratorPolicy_OnRequest_EmptyBodyReturnsError(t *testing.T) {
@@ -408,7 +1788,104 @@ func TestPromptDecoratorPolicy_OnRequest_EmptyBodyReturnsError(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			action := p.OnRequestBody(context.Background(), tt.ctx, nil)
-			assertDecoratorError(t, action, "Empty request body")
+			assertDecoratorError(t, action, ErrorCodeBodyEmpty, "Empty request body")
+
+			passthrough := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{
+					"text": "x",
+				},
+				"onEmptyBody": "passthrough",
+			})
+			mods := mustRequestMods(t, passthrough.OnRequestBody(context.Background(), tt.ctx, nil))
+			if mods.Body != nil {
+				t.Fatalf("expected request to pass through unmodified, got %s", string(mods.Body))
+			}
+		})
+	}
+}
+
+func TestPromptDecoratorPolicy_OnRequest_MaxBodyBytes(t *testing.T) {
+	body := `{"messages":[{"role":"user","content":"hello"}]}`
+	tests := []struct {
+		name           string
+		maxBodyBytes   int
+		onBodyTooLarge string
+		wantErr        bool
+		wantDecorated  bool
+	}{
+		{name: "within limit", maxBodyBytes: len(body), wantDecorated: true},
+		{name: "over limit rejects", maxBodyBytes: len(body) - 1, wantErr: true},
+		{name: "over limit passthrough", maxBodyBytes: len(body) - 1, onBodyTooLarge: "passthrough"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{"text": "x"},
+				"maxBodyBytes":          tt.maxBodyBytes,
+			}
+			if tt.onBodyTooLarge != "" {
+				params["onBodyTooLarge"] = tt.onBodyTooLarge
+			}
+			p := mustGetPromptDecoratorPolicy(t, params)
+
+			action := p.OnRequestBody(context.Background(), newRequestContextWithBody(body), nil)
+			if tt.wantErr {
+				assertDecoratorError(t, action, ErrorCodeBodyTooLarge, "Request body too large")
+				return
+			}
+			mods := mustRequestMods(t, action)
+			if (mods.Body != nil) != tt.wantDecorated {
+				t.Fatalf("expected decorated=%v, got body %s", tt.wantDecorated, string(mods.Body))
+			}
+		})
+	}
+}
+
+func TestPromptDecoratorPolicy_OnRequest_BodyFormat(t *testing.T) {
+	tests := []struct {
+		name       string
+		bodyFormat string
+		body       string
+		want       string
+		wantErr    string
+	}{
+		{
+			name:       "json-array decorates each element",
+			bodyFormat: "json-array",
+			body:       `[{"messages":[{"role":"user","content":"a"}]},{"messages":[{"role":"user","content":"b"}]}]`,
+			want:       `[{"messages":[{"content":"Be brief. a","role":"user"}]},{"messages":[{"content":"Be brief. b","role":"user"}]}]`,
+		},
+		{
+			name:       "ndjson decorates each line and keeps blank lines",
+			bodyFormat: "ndjson",
+			body:       "{\"messages\":[{\"role\":\"user\",\"content\":\"a\"}]}\n\n{\"messages\":[{\"role\":\"user\",\"content\":\"b\"}]}\n",
+			want:       "{\"messages\":[{\"content\":\"Be brief. a\",\"role\":\"user\"}]}\n\n{\"messages\":[{\"content\":\"Be brief. b\",\"role\":\"user\"}]}\n",
+		},
+		{
+			name:       "json-array rejects non-array body",
+			bodyFormat: "json-array",
+			body:       `{"messages":[{"role":"user","content":"a"}]}`,
+			wantErr:    "Error parsing JSON payload: body is not a JSON array",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+				"promptDecoratorConfig": map[string]interface{}{"text": "Be brief."},
+				"bodyFormat":            tt.bodyFormat,
+			})
+
+			action := p.OnRequestBody(context.Background(), newRequestContextWithBody(tt.body), nil)
+			if tt.wantErr != "" {
+				assertDecoratorError(t, action, ErrorCodePayloadInvalid, tt.wantErr)
+				return
+			}
+			mods := mustRequestMods(t, action)
+			if got := string(mods.Body); got != tt.want {
+				t.Fatalf("unexpected body: got %q, want %q", got, tt.want)
+			}
 		})
 	}
 }
@@ -422,7 +1899,7 @@ func TestPromptDecoratorPolicy_OnRequest_InvalidJSONReturnsError(t *testing.T) {
 
 	ctx := newRequestContextWithBody(`{"messages":[`)
 	action := p.OnRequestBody(context.Background(), ctx, nil)
-	assertDecoratorError(t, action, "Error parsing JSON payload")
+	assertDecoratorError(t, action, ErrorCodePayloadInvalid, "Error parsing JSON payload")
 }
 
 func TestPromptDecoratorPolicy_OnRequest_JSONPathNotFoundReturnsError(t *testing.T) {
@@ -435,7 +1912,7 @@ func TestPromptDecoratorPolicy_OnRequest_JSONPathNotFoundReturnsError(t *testing
 
 	ctx := newRequestContextWithBody(`{"messages":[{"role":"user","content":"hello"}]}`)
 	action := p.OnRequestBody(context.Background(), ctx, nil)
-	assertDecoratorError(t, action, "Error extracting value from JSONPath")
+	assertDecoratorError(t, action, ErrorCodeJSONPathInvalid, "Error extracting value from JSONPath")
 }
 
 func TestPromptDecoratorPolicy_OnRequest_TargetTypeMismatch_StringPathWithMessagesConfig(t *testing.T) {
@@ -450,7 +1927,7 @@ func TestPromptDecoratorPolicy_OnRequest_TargetTypeMismatch_StringPathWithMessag
 
 	ctx := newRequestContextWithBody(`{"messages":[{"role":"user","content":"hello"}]}`)
 	action := p.OnRequestBody(context.Background(), ctx, nil)
-	assertDecoratorError(t, action, "Invalid configuration for string target")
+	assertDecoratorError(t, action, ErrorCodeTargetMismatch, "Invalid configuration for string target")
 }
 
 func TestPromptDecoratorPolicy_OnRequest_TargetTypeMismatch_ArrayPathWithTextConfig(t *testing.T) {
@@ -463,7 +1940,7 @@ func TestPromptDecoratorPolicy_OnRequest_TargetTypeMismatch_ArrayPathWithTextCon
 
 	ctx := newRequestContextWithBody(`{"messages":[{"role":"user","content":"hello"}]}`)
 	action := p.OnRequestBody(context.Background(), ctx, nil)
-	assertDecoratorError(t, action, "Invalid configuration for messages target")
+	assertDecoratorError(t, action, ErrorCodeTargetMismatch, "Invalid configuration for messages target")
 }
 
 func TestPromptDecoratorPolicy_OnRequest_ArrayContainsNonMapElementReturnsError(t *testing.T) {
@@ -478,7 +1955,65 @@ func TestPromptDecoratorPolicy_OnRequest_ArrayContainsNonMapElementReturnsError(
 
 	ctx := newRequestContextWithBody(`{"messages":[1,{"role":"user","content":"hello"}]}`)
 	action := p.OnRequestBody(context.Background(), ctx, nil)
-	assertDecoratorError(t, action, "Array contains non-map elements")
+	assertDecoratorError(t, action, ErrorCodeTargetTypeInvalid, "Array contains non-map elements")
+}
+
+func TestPromptDecoratorPolicy_OnRequest_StringArrayTarget(t *testing.T) {
+	for _, appendMode := range []bool{false, true} {
+		p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+			"promptDecoratorConfig": map[string]interface{}{
+				"text": "Be brief.",
+			},
+			"jsonPath": "$.prompts",
+			"append":   appendMode,
+		})
+
+		ctx := newRequestContextWithBody(`{"prompts":["first","second"]}`)
+		mods := mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+
+		payload := decodeJSONMap(t, mods.Body)
+		prompts, ok := payload["prompts"].([]interface{})
+		if !ok || len(prompts) != 2 {
+			t.Fatalf("expected two prompts, got %v", payload["prompts"])
+		}
+		want := []string{"Be brief. first", "Be brief. second"}
+		if appendMode {
+			want = []string{"first Be brief.", "second Be brief."}
+		}
+		for i := range want {
+			if prompts[i] != want[i] {
+				t.Fatalf("append=%v: unexpected prompt %d: got %v, want %q", appendMode, i, prompts[i], want[i])
+			}
+		}
+	}
+}
+
+func TestPromptDecoratorPolicy_OnRequest_StringArrayTargetWithMessagesConfig(t *testing.T) {
+	p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+		"promptDecoratorConfig": map[string]interface{}{
+			"messages": []interface{}{
+				map[string]interface{}{"role": "system", "content": "x"},
+			},
+		},
+		"jsonPath": "$.prompts",
+	})
+
+	ctx := newRequestContextWithBody(`{"prompts":["first"]}`)
+	action := p.OnRequestBody(context.Background(), ctx, nil)
+	assertDecoratorError(t, action, ErrorCodeTargetMismatch, "Invalid configuration for string array target")
+}
+
+func TestPromptDecoratorPolicy_OnRequest_MixedArrayReturnsError(t *testing.T) {
+	p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+		"promptDecoratorConfig": map[string]interface{}{
+			"text": "x",
+		},
+		"jsonPath": "$.prompts",
+	})
+
+	ctx := newRequestContextWithBody(`{"prompts":["first",{"role":"user","content":"hello"}]}`)
+	action := p.OnRequestBody(context.Background(), ctx, nil)
+	assertDecoratorError(t, action, ErrorCodeTargetTypeInvalid, "Array contains mixed element types")
 }
 
 func TestPromptDecoratorPolicy_OnRequest_ExtractedValueWrongTypeReturnsError(t *testing.T) {
@@ -491,7 +2026,7 @@ func TestPromptDecoratorPolicy_OnRequest_ExtractedValueWrongTypeReturnsError(t *
 
 	ctx := newRequestContextWithBody(`{"temperature":0.7}`)
 	action := p.OnRequestBody(context.Background(), ctx, nil)
-	assertDecoratorError(t, action, "Extracted value must be a string or an array of message objects")
+	assertDecoratorError(t, action, ErrorCodeTargetTypeInvalid, "Extracted value must be a string or an array of message objects")
 }
 
 func TestPromptDecoratorPolicy_OnRequest_JSONPathWithArrayIndex_TextTarget(t *testing.T) {
@@ -521,6 +2056,82 @@ func TestPromptDecoratorPolicy_OnRequest_JSONPathWithArrayIndex_TextTarget(t *te
 	}
 }
 
+func TestPromptDecoratorPolicy_OnRequest_JSONPathArrayElementTargets(t *testing.T) {
+	tests := []struct {
+		name     string
+		jsonPath string
+		config   map[string]interface{}
+		check    func(t *testing.T, payload map[string]interface{})
+	}{
+		{
+			name:     "string element addressed by negative index",
+			jsonPath: "$.prompts[-1]",
+			config:   map[string]interface{}{"text": "Decorate"},
+			check: func(t *testing.T, payload map[string]interface{}) {
+				prompts := payload["prompts"].([]interface{})
+				if prompts[0] != "first" || prompts[1] != "Decorate second" {
+					t.Fatalf("unexpected prompts: %v", prompts)
+				}
+			},
+		},
+		{
+			name:     "string field under a hyphenated key",
+			jsonPath: "$.x-meta.note",
+			config:   map[string]interface{}{"text": "Decorate"},
+			check: func(t *testing.T, payload map[string]interface{}) {
+				if got := payload["x-meta"].(map[string]interface{})["note"]; got != "Decorate hello" {
+					t.Fatalf("unexpected note: %v", got)
+				}
+			},
+		},
+		{
+			name:     "messages array nested under an indexed element",
+			jsonPath: "$.threads[1].messages",
+			config: map[string]interface{}{
+				"messages": []interface{}{
+					map[string]interface{}{"role": "system", "content": "Thread rules"},
+				},
+			},
+			check: func(t *testing.T, payload map[string]interface{}) {
+				threads := payload["threads"].([]interface{})
+				messages := mustMessages(t, threads[1].(map[string]interface{})["messages"])
+				if len(messages) != 2 || messages[0]["content"] != "Thread rules" {
+					t.Fatalf("unexpected thread messages: %v", messages)
+				}
+				if untouched := mustMessages(t, threads[0].(map[string]interface{})["messages"]); len(untouched) != 1 {
+					t.Fatalf("expected first thread untouched, got %v", untouched)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+				"promptDecoratorConfig": tt.config,
+				"jsonPath":              tt.jsonPath,
+			})
+
+			ctx := newRequestContextWithBody(`{
+				"prompts":["first","second"],
+				"x-meta":{"note":"hello"},
+				"threads":[
+					{"messages":[{"role":"user","content":"a"}]},
+					{"messages":[{"role":"user","content":"b"}]}
+				]
+			}`)
+			mods := mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+			tt.check(t, decodeJSONMap(t, mods.Body))
+		})
+	}
+
+	// String targets keep the navigation and update error codes.
+	p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{"promptDecoratorConfig": map[string]interface{}{"text": "Decorate"}})
+	payload := map[string]interface{}{"prompts": []interface{}{"first"}}
+	assertDecoratorError(t, p.updateStringAtPath(payload, "$.missing.note", "x"), ErrorCodeJSONPathInvalid, "Error navigating JSONPath")
+	assertDecoratorError(t, p.updateStringAtPath(payload, "$.prompts[3]", "x"), ErrorCodeJSONPathUpdate, "Error updating JSONPath")
+}
+
 func TestPromptDecoratorPolicy_OnRequest_JSONPathNavigationFailure(t *testing.T) {
 	p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
 		"promptDecoratorConfig": map[string]interface{}{
@@ -535,7 +2146,7 @@ func TestPromptDecoratorPolicy_OnRequest_JSONPathNavigationFailure(t *testing.T)
 		"messages":{"0":{"content":"hello"}}
 	}`)
 	action := p.OnRequestBody(context.Background(), ctx, nil)
-	assertDecoratorError(t, action, "Error extracting value from JSONPath")
+	assertDecoratorError(t, action, ErrorCodeJSONPathInvalid, "Error extracting value from JSONPath")
 }
 
 func TestPromptDecoratorPolicy_OnRequest_JSONPathEmptyStringUsesDefaultByConfigType(t *testing.T) {
@@ -634,7 +2245,7 @@ func mustRequestMods(t *testing.T, action policy.RequestAction) policy.UpstreamR
 	return mods
 }
 
-func assertDecoratorError(t *testing.T, action policy.RequestAction, wantMessagePrefix string) policy.ImmediateResponse {
+func assertDecoratorError(t *testing.T, action policy.RequestAction, wantCode string, wantMessagePrefix string) policy.ImmediateResponse {
 	t.Helper()
 
 	resp, ok := action.(policy.ImmediateResponse)
@@ -652,6 +2263,9 @@ func assertDecoratorError(t *testing.T, action policy.RequestAction, wantMessage
 	if got := body["type"]; got != "PROMPT_DECORATOR_ERROR" {
 		t.Fatalf("unexpected error type: got %v, want %q", got, "PROMPT_DECORATOR_ERROR")
 	}
+	if got := body["code"]; got != wantCode {
+		t.Fatalf("unexpected error code: got %v, want %q", got, wantCode)
+	}
 	msg, ok := body["message"].(string)
 	if !ok {
 		t.Fatalf("expected string error message, got %T", body["message"])
@@ -717,3 +2331,34 @@ func newRequestContextWithBody(body string) *policy.RequestContext {
 		},
 	}
 }
+
+func TestPromptDecoratorPolicy_Validate_ValidParams(t *testing.T) {
+	params := map[string]interface{}{
+		"promptDecoratorConfig": map[string]interface{}{
+			"text": "Be concise.",
+		},
+	}
+	if err := (&PromptDecoratorPolicy{}).Validate(params); err != nil {
+		t.Fatalf("expected valid params, got %v", err)
+	}
+}
+
+func TestPromptDecoratorPolicy_OnRequest_EscapesSubstitutedValues(t *testing.T) {
+	p := mustGetPromptDecoratorPolicy(t, map[string]interface{}{
+		"promptDecoratorConfig": map[string]interface{}{"text": "{{$.value}}"},
+	})
+
+	for _, tt := range promptutiltest.EscapingCases {
+		t.Run(tt.Name, func(t *testing.T) {
+			value, err := json.Marshal(tt.Value)
+			if err != nil {
+				t.Fatalf("failed to encode value: %v", err)
+			}
+			body := `{"value":` + string(value) + `,"messages":[{"role":"user","content":"hi"}]}`
+			mods := mustRequestMods(t, p.OnRequestBody(context.Background(), newRequestContextWithBody(body), nil))
+			if want := `"content":"` + tt.Want + ` hi"`; !strings.Contains(string(mods.Body), want) {
+				t.Fatalf("expected body to contain %s, got %s", want, mods.Body)
+			}
+		})
+	}
+}
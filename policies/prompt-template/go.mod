@@ -2,4 +2,9 @@ module github.com/wso2/gateway-controllers/policies/prompt-template
 
 go 1.26.1
 
-require github.com/wso2/api-platform/sdk/core v0.2.4
+require (
+	github.com/wso2/api-platform/sdk/core v0.2.4
+	github.com/wso2/gateway-controllers/common v0.0.0
+)
+
+replace github.com/wso2/gateway-controllers/common => ../../common
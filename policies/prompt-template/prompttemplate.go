@@ -18,29 +18,57 @@
 package prompttemplate
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/url"
+	"os"
+	"reflect"
 	"regexp"
 	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	policy "github.com/wso2/api-platform/sdk/core/policy/v1alpha2"
 	utils "github.com/wso2/api-platform/sdk/core/utils"
+	"github.com/wso2/gateway-controllers/common/bodyformat"
+	"github.com/wso2/gateway-controllers/common/promptutil"
 )
 
 var (
-	// promptTemplateRegex matches template://<template-name>?<params> patterns
+	// promptTemplateRegex matches <scheme>://<template-name>?<params> patterns.
+	// Only matches whose scheme is listed in schemes are template references.
 	// Example: template://translate?from=english&to=spanish or template://translate
-	promptTemplateRegex = regexp.MustCompile(`template://[a-zA-Z0-9_-]+(?:\?[^\s"']*)?`)
-	// unresolvedPlaceholderRegex matches [[parameter]] placeholders.
-	unresolvedPlaceholderRegex = regexp.MustCompile(`\[\[([a-zA-Z0-9_-]+)\]\]`)
+	promptTemplateRegex = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[a-zA-Z0-9_-]+(?:\?[^\s"']*)?`)
+	// schemeRegex validates the URI schemes accepted in schemes.
+	schemeRegex = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*$`)
+	// unicodeEscapeMarker starts a JSON \uXXXX escape, which could encode any
+	// character of a reference.
+	unicodeEscapeMarker = []byte(`\u`)
+	// placeholderRegex matches [[parameter]] placeholders, [[parameter[]]] list
+	// placeholders, which join every value of a repeated query parameter, and
+	// [[parameter|default]] placeholders. Group 2 is set for list placeholders
+	// and group 3 holds the default text. A literal pipe or backslash in the
+	// default text is escaped with a backslash.
+	placeholderRegex = regexp.MustCompile(`\[\[([a-zA-Z0-9_-]+)(?:(\[\])|\|((?:\\.|[^\]\\])*))?\]\]`)
+	// defaultEscapeRegex matches escaped characters in placeholder default text.
+	defaultEscapeRegex = regexp.MustCompile(`\\(.)`)
 	// textCleanRegex removes leading and trailing quotes from JSON-escaped strings
 	textCleanRegex = regexp.MustCompile(`^"|"$`)
+	// whitespaceRegex matches runs of whitespace collapsed by collapseWhitespace.
+	whitespaceRegex = regexp.MustCompile(`\s+`)
 	// templateNameRegex validates template names.
 	templateNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+	// selectorTemplateNameRegex validates <selector>:<name> template names used
+	// with templateSelectorHeader.
+	selectorTemplateNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+:[a-zA-Z0-9_-]+$`)
+	// identifierRegex matches object keys written in dot notation in error paths.
+	identifierRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
 )
 
 const (
@@ -49,27 +77,138 @@ const (
 	OnUnresolvedPlaceholderKeep  = "keep"
 	OnUnresolvedPlaceholderEmpty = "empty"
 	OnUnresolvedPlaceholderError = "error"
+	DefaultMaxRecursionDepth     = 1
+	DefaultErrorStatusCode       = 500
+	DefaultListSeparator         = ", "
+	DefaultTemplateScheme        = "template"
+
+	// ListSeparatorQueryParam is the reserved query parameter that overrides
+	// DefaultListSeparator for a single template reference.
+	ListSeparatorQueryParam = "_separator"
+
+	// MetadataKeyAppliedTemplates holds the sorted names of templates resolved
+	// for a request.
+	MetadataKeyAppliedTemplates = "prompttemplate:applied"
+	// MetadataKeyResolutionDurationMs holds the template resolution time in
+	// milliseconds when metricsEnabled is set.
+	MetadataKeyResolutionDurationMs = "prompttemplate:resolution_ms"
+	// MetadataKeyResolvedReferences holds the number of template references
+	// replaced when metricsEnabled is set.
+	MetadataKeyResolvedReferences = "prompttemplate:resolved_references"
+)
+
+// Error codes carried in the "code" field of PROMPT_TEMPLATE_ERROR responses.
+// They are stable identifiers for each failure class, so clients need not
+// match on the human-readable message.
+const (
+	ErrorCodeTemplateMissing          = "TEMPLATE_MISSING"
+	ErrorCodeTemplateReferenceInvalid = "TEMPLATE_REFERENCE_INVALID"
+	ErrorCodeRequiredParamMissing     = "REQUIRED_PARAM_MISSING"
+	ErrorCodeUnknownParam             = "UNKNOWN_PARAM"
+	ErrorCodePlaceholderUnresolved    = "PLACEHOLDER_UNRESOLVED"
+	ErrorCodeRecursionDepthExceeded   = "RECURSION_DEPTH_EXCEEDED"
+	ErrorCodeTemplateResolution       = "TEMPLATE_RESOLUTION_FAILED"
+	ErrorCodeJSONPathInvalid          = "JSONPATH_INVALID"
+	ErrorCodeJSONPathUpdate           = "JSONPATH_UPDATE_FAILED"
+	ErrorCodePayloadInvalid           = "PAYLOAD_INVALID"
+	ErrorCodePayloadMarshal           = "PAYLOAD_MARSHAL_FAILED"
+	ErrorCodePreservedKeyConflict     = "PRESERVED_KEY_CONFLICT"
+	ErrorCodeParamsInvalid            = "INVALID_PARAMETERS"
+	ErrorCodeOutputTooLarge           = "OUTPUT_TOO_LARGE"
 )
 
+// resolutionError is a template resolution failure tagged with its error code.
+type resolutionError struct {
+	code string
+	err  error
+}
+
+func (e *resolutionError) Error() string {
+	return e.err.Error()
+}
+
+func (e *resolutionError) Unwrap() error {
+	return e.err
+}
+
+// newResolutionError formats a resolution failure with the given error code.
+func newResolutionError(code string, format string, args ...interface{}) error {
+	return &resolutionError{code: code, err: fmt.Errorf(format, args...)}
+}
+
+// resolutionErrorCode returns the error code of a resolution failure, or
+// ErrorCodeTemplateResolution when err carries none.
+func resolutionErrorCode(err error) string {
+	var resolveErr *resolutionError
+	if errors.As(err, &resolveErr) {
+		return resolveErr.code
+	}
+	return ErrorCodeTemplateResolution
+}
+
 // PromptTemplatePolicy implements prompt templating by applying custom templates
 type PromptTemplatePolicy struct {
 	params PromptTemplatePolicyParams
+	// configured holds the overridable parameters the policy was created
+	// with, so that invocation parameters equal to them are not parsed again
+	configured map[string]interface{}
 }
 
 type TemplateConfig struct {
-	Name     string `json:"name"`
-	Template string `json:"template"`
+	Name     string   `json:"name"`
+	Template string   `json:"template"`
+	Required []string `json:"required,omitempty"`
 }
 
 type PromptTemplatePolicyParams struct {
 	Templates []TemplateConfig
-	JsonPath  string
+	// Path of the JSON file templates were loaded from, if any
+	TemplatesFile string
+	JsonPath      string
+	// JSONPaths to resolve in order; populated from either a single jsonPath
+	// string or an array of paths
+	JsonPaths []string
 	// error or passthrough
 	OnMissingTemplate string
 	// keep, empty, or error
 	OnUnresolvedPlaceholder string
+	// Resolve template references in the response body as well
+	ApplyToResponse bool
+	// Number of expansion levels for templates that reference other templates;
+	// 1 disables nested expansion
+	MaxRecursionDepth int
+	// Reject references whose query parameters are not used by the template
+	RejectUnknownParams bool
+	// HTTP status code of PROMPT_TEMPLATE_ERROR responses
+	ErrorStatusCode int
+	// Lower-cased request header whose value selects <value>:<name> templates
+	TemplateSelectorHeader string
+	// Collapse whitespace runs in resolved template output into single spaces
+	CollapseWhitespace bool
+	// Record resolution duration and reference count in metadata
+	MetricsEnabled bool
+	// json-object, json-array, or ndjson; selects the documents jsonPath is
+	// applied to
+	BodyFormat string
+	// Suffix of the key that keeps the original value of each resolved object
+	// field when jsonPath is empty; disabled when empty
+	PreserveOriginalSuffix string
+	// Maximum size in bytes of a modified body; 0 means unlimited
+	MaxOutputBytes int
+	// URI schemes treated as template references
+	Schemes []string
+	// Regular expression for tokens, such as placeholders written by other
+	// policies, that are never treated as references or placeholders
+	IgnorePattern string
+	ignoreRegex   *regexp.Regexp
+	// <scheme>:// prefixes of template references, plain and with JSON-escaped
+	// slashes, used to skip payloads without references
+	referenceMarkers        [][]byte
+	escapedReferenceMarkers [][]byte
 	// Templates map for quick lookup by name
 	templates map[string]string
+	// Required query parameters by template name
+	required map[string][]string
 }
 
 // GetPolicy is the v1alpha2 factory entry point (loaded by v1alpha2 kernels).
@@ -85,57 +224,75 @@ func GetPolicy(
 		return nil, fmt.Errorf("invalid parameters: %w", err)
 	}
 	p.params = policyParams
+	p.configured = make(map[string]interface{}, len(overridableParamNames))
+	for _, name := range overridableParamNames {
+		if value, ok := params[name]; ok {
+			p.configured[name] = value
+		}
+	}
 
 	return p, nil
 }
 
-
 // Mode returns the processing mode for the prompt template policy.
 func (p *PromptTemplatePolicy) Mode() policy.ProcessingMode {
+	responseBodyMode := policy.BodyModeSkip
+	if p.params.ApplyToResponse {
+		responseBodyMode = policy.BodyModeBuffer
+	}
+	requestHeaderMode := policy.HeaderModeSkip
+	if p.params.TemplateSelectorHeader != "" {
+		requestHeaderMode = policy.HeaderModeProcess
+	}
 	return policy.ProcessingMode{
-		RequestHeaderMode:  policy.HeaderModeSkip,
+		RequestHeaderMode:  requestHeaderMode,
 		RequestBodyMode:    policy.BodyModeBuffer,
 		ResponseHeaderMode: policy.HeaderModeSkip,
-		ResponseBodyMode:   policy.BodyModeSkip,
+		ResponseBodyMode:   responseBodyMode,
 	}
 }
 
+// Validate validates the policy configuration parameters without constructing
+// a policy instance. It runs the same checks as GetPolicy.
+func (p *PromptTemplatePolicy) Validate(params map[string]interface{}) error {
+	_, err := parseParams(params)
+	return err
+}
+
 // parseParams parses and validates parameters from map to struct
 func parseParams(params map[string]interface{}) (PromptTemplatePolicyParams, error) {
 	var result PromptTemplatePolicyParams
 
-	// Extract required templates parameter.
-	templatesRaw, ok := params["templates"]
-	if !ok {
-		return result, fmt.Errorf("'templates' parameter is required")
+	// Extract templates from either the inline 'templates' parameter or a
+	// 'templatesFile' on disk; exactly one must be provided.
+	templatesRaw, hasTemplates := params["templates"]
+	templatesFileRaw, hasTemplatesFile := params["templatesFile"]
+	if hasTemplates && hasTemplatesFile {
+		return result, fmt.Errorf("only one of 'templates' or 'templatesFile' can be specified")
 	}
 
 	var templateConfigs []TemplateConfig
-	switch v := templatesRaw.(type) {
-	case string:
-		if err := json.Unmarshal([]byte(v), &templateConfigs); err != nil {
-			return result, fmt.Errorf("error unmarshaling templates: %w", err)
+	if hasTemplatesFile {
+		templatesFile, ok := templatesFileRaw.(string)
+		templatesFile = strings.TrimSpace(templatesFile)
+		if !ok || templatesFile == "" {
+			return result, fmt.Errorf("'templatesFile' must be a non-empty string")
 		}
-	case []interface{}:
-		// Convert array of interfaces to TemplateConfig array.
-		templateConfigs = make([]TemplateConfig, 0, len(v))
-		for idx, item := range v {
-			if itemMap, ok := item.(map[string]interface{}); ok {
-				var templateConfig TemplateConfig
-				jsonBytes, err := json.Marshal(itemMap)
-				if err != nil {
-					return result, fmt.Errorf("error marshaling templates[%d]: %w", idx, err)
-				}
-				if err := json.Unmarshal(jsonBytes, &templateConfig); err != nil {
-					return result, fmt.Errorf("error unmarshaling templates[%d]: %w", idx, err)
-				}
-				templateConfigs = append(templateConfigs, templateConfig)
-			} else {
-				return result, fmt.Errorf("'templates[%d]' must be an object", idx)
-			}
+		configs, err := loadTemplatesFile(templatesFile)
+		if err != nil {
+			return result, err
 		}
-	default:
-		return result, fmt.Errorf("'templates' must be an array or JSON string")
+		templateConfigs = configs
+		result.TemplatesFile = templatesFile
+	} else {
+		if !hasTemplates {
+			return result, fmt.Errorf("'templates' parameter is required unless 'templatesFile' is specified")
+		}
+		configs, err := parseTemplateConfigs(templatesRaw)
+		if err != nil {
+			return result, err
+		}
+		templateConfigs = configs
 	}
 
 	if len(templateConfigs) == 0 {
@@ -143,15 +300,28 @@ func parseParams(params map[string]interface{}) (PromptTemplatePolicyParams, err
 	}
 	result.Templates = templateConfigs
 
+	// Extract optional templateSelectorHeader parameter. It is read before the
+	// templates map is built since it allows <selector>:<name> template names.
+	if headerRaw, ok := params["templateSelectorHeader"]; ok {
+		header, ok := headerRaw.(string)
+		if !ok {
+			return result, fmt.Errorf("'templateSelectorHeader' must be a string")
+		}
+		result.TemplateSelectorHeader = strings.ToLower(strings.TrimSpace(header))
+	}
+
 	// Build templates map for quick lookup by name.
 	result.templates = make(map[string]string)
+	result.required = make(map[string][]string)
 	for i, templateConfig := range templateConfigs {
 		name := strings.TrimSpace(templateConfig.Name)
 		if name == "" {
 			return result, fmt.Errorf("'templates[%d].name' cannot be empty", i)
 		}
 		if !templateNameRegex.MatchString(name) {
-			return result, fmt.Errorf("'templates[%d].name' must match ^[a-zA-Z0-9_-]+$", i)
+			if result.TemplateSelectorHeader == "" || !selectorTemplateNameRegex.MatchString(name) {
+				return result, fmt.Errorf("'templates[%d].name' must match ^[a-zA-Z0-9_-]+$", i)
+			}
 		}
 		templateText := strings.TrimSpace(templateConfig.Template)
 		if templateText == "" {
@@ -163,250 +333,1376 @@ func parseParams(params map[string]interface{}) (PromptTemplatePolicyParams, err
 		result.templates[name] = templateText
 		result.Templates[i].Name = name
 		result.Templates[i].Template = templateText
+
+		if len(templateConfig.Required) > 0 {
+			placeholders := templatePlaceholders(templateText)
+			required := make([]string, 0, len(templateConfig.Required))
+			for j, param := range templateConfig.Required {
+				param = strings.TrimSpace(param)
+				if param == "" {
+					return result, fmt.Errorf("'templates[%d].required[%d]' cannot be empty", i, j)
+				}
+				if _, ok := placeholders[param]; !ok {
+					return result, fmt.Errorf("'templates[%d].required[%d]' %q is not a placeholder in the template", i, j, param)
+				}
+				required = append(required, param)
+			}
+			result.required[name] = required
+			result.Templates[i].Required = required
+		}
 	}
 
-	// Extract optional jsonPath parameter.
-	if jsonPathRaw, ok := params["jsonPath"]; ok {
-		jsonPath, ok := jsonPathRaw.(string)
+	// Parameters that may be overridden per invocation start from their defaults.
+	result.OnMissingTemplate = OnMissingTemplateError
+	result.OnUnresolvedPlaceholder = OnUnresolvedPlaceholderKeep
+	result.MaxRecursionDepth = DefaultMaxRecursionDepth
+	result.ErrorStatusCode = DefaultErrorStatusCode
+	result.BodyFormat = bodyformat.JSONObject
+	if err := parseOverridableParams(params, &result); err != nil {
+		return result, err
+	}
+
+	// Extract optional applyToResponse parameter.
+	if applyRaw, ok := params["applyToResponse"]; ok {
+		apply, ok := applyRaw.(bool)
+		if !ok {
+			return result, fmt.Errorf("'applyToResponse' must be a boolean")
+		}
+		result.ApplyToResponse = apply
+	}
+
+	// Extract optional metricsEnabled parameter.
+	if metricsRaw, ok := params["metricsEnabled"]; ok {
+		metricsEnabled, ok := metricsRaw.(bool)
 		if !ok {
-			return result, fmt.Errorf("'jsonPath' must be a string")
+			return result, fmt.Errorf("'metricsEnabled' must be a boolean")
+		}
+		result.MetricsEnabled = metricsEnabled
+	}
+
+	// Extract optional maxOutputBytes parameter.
+	if maxRaw, ok := params["maxOutputBytes"]; ok {
+		maxOutputBytes, err := extractInt(maxRaw)
+		if err != nil {
+			return result, fmt.Errorf("'maxOutputBytes' must be an integer: %w", err)
+		}
+		if maxOutputBytes < 0 {
+			return result, fmt.Errorf("'maxOutputBytes' cannot be negative")
+		}
+		result.MaxOutputBytes = maxOutputBytes
+	}
+
+	// Extract optional ignorePattern parameter.
+	if ignoreRaw, ok := params["ignorePattern"]; ok {
+		ignorePattern, ok := ignoreRaw.(string)
+		if !ok {
+			return result, fmt.Errorf("'ignorePattern' must be a string")
+		}
+		if ignorePattern == "" {
+			return result, fmt.Errorf("'ignorePattern' cannot be empty")
+		}
+		ignoreRegex, err := regexp.Compile(ignorePattern)
+		if err != nil {
+			return result, fmt.Errorf("'ignorePattern' must be a valid regular expression: %w", err)
+		}
+		result.IgnorePattern = ignorePattern
+		result.ignoreRegex = ignoreRegex
+	}
+
+	// Extract optional schemes parameter.
+	result.Schemes = []string{DefaultTemplateScheme}
+	if schemesRaw, ok := params["schemes"]; ok {
+		schemes, ok := schemesRaw.([]interface{})
+		if !ok {
+			return result, fmt.Errorf("'schemes' must be an array of strings")
+		}
+		if len(schemes) == 0 {
+			return result, fmt.Errorf("'schemes' cannot be empty")
+		}
+		result.Schemes = make([]string, 0, len(schemes))
+		for idx, item := range schemes {
+			scheme, ok := item.(string)
+			if !ok {
+				return result, fmt.Errorf("'schemes[%d]' must be a string", idx)
+			}
+			if !schemeRegex.MatchString(scheme) {
+				return result, fmt.Errorf("'schemes[%d]' must be a valid URI scheme", idx)
+			}
+			if !slices.Contains(result.Schemes, scheme) {
+				result.Schemes = append(result.Schemes, scheme)
+			}
+		}
+	}
+	for _, scheme := range result.Schemes {
+		result.referenceMarkers = append(result.referenceMarkers, []byte(scheme+"://"))
+		result.escapedReferenceMarkers = append(result.escapedReferenceMarkers, []byte(scheme+`:\/\/`))
+	}
+
+	// Collect template names for logging
+	templateNames := make([]string, 0, len(result.templates))
+	for name := range result.templates {
+		templateNames = append(templateNames, name)
+	}
+	slog.Debug("PromptTemplate: Policy initialized",
+		"templateCount", len(result.templates),
+		"templateNames", templateNames,
+		"jsonPaths", result.JsonPaths,
+		"onMissingTemplate", result.OnMissingTemplate,
+		"onUnresolvedPlaceholder", result.OnUnresolvedPlaceholder,
+		"applyToResponse", result.ApplyToResponse,
+		"maxRecursionDepth", result.MaxRecursionDepth,
+		"rejectUnknownParams", result.RejectUnknownParams,
+		"errorStatusCode", result.ErrorStatusCode,
+		"templateSelectorHeader", result.TemplateSelectorHeader,
+		"collapseWhitespace", result.CollapseWhitespace,
+		"metricsEnabled", result.MetricsEnabled,
+		"maxOutputBytes", result.MaxOutputBytes,
+		"schemes", result.Schemes,
+		"ignorePattern", result.IgnorePattern,
+	)
+
+	return result, nil
+}
+
+// overridableParamNames are the parameters read by parseOverridableParams.
+var overridableParamNames = []string{
+	"jsonPath",
+	"onMissingTemplate",
+	"onUnresolvedPlaceholder",
+	"maxRecursionDepth",
+	"rejectUnknownParams",
+	"errorStatusCode",
+	"collapseWhitespace",
+	"bodyFormat",
+	"preserveOriginalSuffix",
+}
+
+// parseOverridableParams parses the optional parameters that may also be
+// supplied per invocation to override the policy configuration. Only keys
+// present in params are applied, so result keeps its current values for the
+// rest.
+func parseOverridableParams(params map[string]interface{}, result *PromptTemplatePolicyParams) error {
+	// Extract optional jsonPath parameter. Accepts a single path or an array of paths.
+	if jsonPathRaw, ok := params["jsonPath"]; ok {
+		switch v := jsonPathRaw.(type) {
+		case string:
+			result.JsonPath = strings.TrimSpace(v)
+			result.JsonPaths = nil
+			if result.JsonPath != "" {
+				result.JsonPaths = []string{result.JsonPath}
+			}
+		case []interface{}:
+			if len(v) == 0 {
+				return fmt.Errorf("'jsonPath' cannot be an empty array")
+			}
+			result.JsonPath = ""
+			result.JsonPaths = make([]string, 0, len(v))
+			for idx, item := range v {
+				jsonPath, ok := item.(string)
+				if !ok {
+					return fmt.Errorf("'jsonPath[%d]' must be a string", idx)
+				}
+				jsonPath = strings.TrimSpace(jsonPath)
+				if jsonPath == "" {
+					return fmt.Errorf("'jsonPath[%d]' cannot be empty", idx)
+				}
+				result.JsonPaths = append(result.JsonPaths, jsonPath)
+			}
+		default:
+			return fmt.Errorf("'jsonPath' must be a string or an array of strings")
 		}
-		result.JsonPath = strings.TrimSpace(jsonPath)
 	}
 
 	// Extract optional onMissingTemplate parameter.
-	result.OnMissingTemplate = OnMissingTemplateError
 	if valRaw, ok := params["onMissingTemplate"]; ok {
 		val, ok := valRaw.(string)
 		if !ok {
-			return result, fmt.Errorf("'onMissingTemplate' must be a string")
+			return fmt.Errorf("'onMissingTemplate' must be a string")
 		}
 		val = strings.ToLower(strings.TrimSpace(val))
 		switch val {
 		case OnMissingTemplateError, OnMissingTemplatePassthrough:
 			result.OnMissingTemplate = val
 		default:
-			return result, fmt.Errorf("'onMissingTemplate' must be one of [error,passthrough]")
+			return fmt.Errorf("'onMissingTemplate' must be one of [error,passthrough]")
 		}
 	}
 
 	// Extract optional onUnresolvedPlaceholder parameter.
-	result.OnUnresolvedPlaceholder = OnUnresolvedPlaceholderKeep
 	if valRaw, ok := params["onUnresolvedPlaceholder"]; ok {
 		val, ok := valRaw.(string)
 		if !ok {
-			return result, fmt.Errorf("'onUnresolvedPlaceholder' must be a string")
+			return fmt.Errorf("'onUnresolvedPlaceholder' must be a string")
 		}
 		val = strings.ToLower(strings.TrimSpace(val))
 		switch val {
 		case OnUnresolvedPlaceholderKeep, OnUnresolvedPlaceholderEmpty, OnUnresolvedPlaceholderError:
 			result.OnUnresolvedPlaceholder = val
 		default:
-			return result, fmt.Errorf("'onUnresolvedPlaceholder' must be one of [keep,empty,error]")
+			return fmt.Errorf("'onUnresolvedPlaceholder' must be one of [keep,empty,error]")
 		}
 	}
 
-	// Collect template names for logging
-	templateNames := make([]string, 0, len(result.templates))
-	for name := range result.templates {
-		templateNames = append(templateNames, name)
+	// Extract optional maxRecursionDepth parameter.
+	if depthRaw, ok := params["maxRecursionDepth"]; ok {
+		depth, err := extractInt(depthRaw)
+		if err != nil {
+			return fmt.Errorf("'maxRecursionDepth' must be an integer: %w", err)
+		}
+		if depth < 1 {
+			return fmt.Errorf("'maxRecursionDepth' must be at least 1")
+		}
+		result.MaxRecursionDepth = depth
 	}
-	slog.Debug("PromptTemplate: Policy initialized",
-		"templateCount", len(result.templates),
-		"templateNames", templateNames,
-		"jsonPath", result.JsonPath,
-		"onMissingTemplate", result.OnMissingTemplate,
-		"onUnresolvedPlaceholder", result.OnUnresolvedPlaceholder,
-	)
 
-	return result, nil
+	// Extract optional rejectUnknownParams parameter.
+	if rejectRaw, ok := params["rejectUnknownParams"]; ok {
+		reject, ok := rejectRaw.(bool)
+		if !ok {
+			return fmt.Errorf("'rejectUnknownParams' must be a boolean")
+		}
+		result.RejectUnknownParams = reject
+	}
+
+	// Extract optional errorStatusCode parameter.
+	if statusRaw, ok := params["errorStatusCode"]; ok {
+		statusCode, err := extractInt(statusRaw)
+		if err != nil {
+			return fmt.Errorf("'errorStatusCode' must be an integer: %w", err)
+		}
+		if statusCode < 400 || statusCode > 599 {
+			return fmt.Errorf("'errorStatusCode' must be between 400 and 599")
+		}
+		result.ErrorStatusCode = statusCode
+	}
+
+	// Extract optional collapseWhitespace parameter.
+	if collapseRaw, ok := params["collapseWhitespace"]; ok {
+		collapse, ok := collapseRaw.(bool)
+		if !ok {
+			return fmt.Errorf("'collapseWhitespace' must be a boolean")
+		}
+		result.CollapseWhitespace = collapse
+	}
+
+	// Extract optional bodyFormat parameter.
+	if _, ok := params["bodyFormat"]; ok {
+		bodyFormat, err := bodyformat.Parse(params)
+		if err != nil {
+			return err
+		}
+		result.BodyFormat = bodyFormat
+	}
+
+	// Extract optional preserveOriginalSuffix parameter.
+	if suffixRaw, ok := params["preserveOriginalSuffix"]; ok {
+		suffix, ok := suffixRaw.(string)
+		if !ok || suffix == "" {
+			return fmt.Errorf("'preserveOriginalSuffix' must be a non-empty string")
+		}
+		result.PreserveOriginalSuffix = suffix
+	}
+	if result.PreserveOriginalSuffix != "" && len(result.JsonPaths) > 0 {
+		return fmt.Errorf("'preserveOriginalSuffix' is only supported when 'jsonPath' is empty")
+	}
+
+	return nil
+}
+
+// parseTemplateConfigs converts the inline 'templates' parameter into template configs.
+func parseTemplateConfigs(templatesRaw interface{}) ([]TemplateConfig, error) {
+	var templateConfigs []TemplateConfig
+	switch v := templatesRaw.(type) {
+	case string:
+		if err := json.Unmarshal([]byte(v), &templateConfigs); err != nil {
+			return nil, fmt.Errorf("error unmarshaling templates: %w", err)
+		}
+	case []interface{}:
+		// Convert array of interfaces to TemplateConfig array.
+		templateConfigs = make([]TemplateConfig, 0, len(v))
+		for idx, item := range v {
+			if itemMap, ok := item.(map[string]interface{}); ok {
+				var templateConfig TemplateConfig
+				jsonBytes, err := json.Marshal(itemMap)
+				if err != nil {
+					return nil, fmt.Errorf("error marshaling templates[%d]: %w", idx, err)
+				}
+				if err := json.Unmarshal(jsonBytes, &templateConfig); err != nil {
+					return nil, fmt.Errorf("error unmarshaling templates[%d]: %w", idx, err)
+				}
+				templateConfigs = append(templateConfigs, templateConfig)
+			} else {
+				return nil, fmt.Errorf("'templates[%d]' must be an object", idx)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("'templates' must be an array or JSON string")
+	}
+	return templateConfigs, nil
 }
 
-func (p *PromptTemplatePolicy) resolveTemplatesInText(content string, escapeForJSON bool) (string, error) {
-	matches := promptTemplateRegex.FindAllString(content, -1)
-	if len(matches) == 0 {
-		return content, nil
+// loadTemplatesFile reads template configs from a JSON file containing the same
+// array structure as the inline 'templates' parameter.
+func loadTemplatesFile(path string) ([]TemplateConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read templatesFile %q: %w", path, err)
 	}
+	var templateConfigs []TemplateConfig
+	if err := json.Unmarshal(data, &templateConfigs); err != nil {
+		return nil, fmt.Errorf("error unmarshaling templatesFile %q: %w", path, err)
+	}
+	return templateConfigs, nil
+}
 
-	updatedContent := content
-	for _, matched := range matches {
-		resolvedPrompt, shouldReplace, err := p.resolveTemplateReference(matched)
+// extractInt safely extracts an integer from various types
+func extractInt(value interface{}) (int, error) {
+	switch v := value.(type) {
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case float64:
+		if v != float64(int(v)) {
+			return 0, fmt.Errorf("expected an integer but got %v", v)
+		}
+		return int(v), nil
+	case string:
+		parsed, err := strconv.ParseFloat(v, 64)
 		if err != nil {
-			return "", err
+			return 0, err
 		}
-		if !shouldReplace {
-			continue
+		if parsed != float64(int(parsed)) {
+			return 0, fmt.Errorf("expected an integer but got %v", v)
 		}
+		return int(parsed), nil
+	default:
+		return 0, fmt.Errorf("cannot convert %T to int", value)
+	}
+}
 
-		replacement := resolvedPrompt
-		if escapeForJSON {
-			escaped, err := p.escapeForJSONString(replacement)
+// resolvedReference is a memoized result of resolving a single template reference.
+type resolvedReference struct {
+	value   string
+	replace bool
+	// unresolved is set when the reference left placeholders unresolved under
+	// onUnresolvedPlaceholder=error.
+	unresolved bool
+}
+
+// resolutionState holds request-scoped state used while resolving templates.
+// It is never shared across requests.
+type resolutionState struct {
+	cache   map[string]resolvedReference
+	applied map[string]struct{}
+	// selector is the templateSelectorHeader value for the request, if any.
+	selector string
+	// fieldPath is the path of the payload field being resolved, reported in
+	// unresolved placeholder errors. It is empty for non-JSON payloads.
+	fieldPath string
+	// resolvedReferences counts replaced references for metricsEnabled.
+	resolvedReferences int
+	// unresolvedNames, unresolvedTemplates and unresolvedPaths collect the
+	// placeholders left unresolved across every reference of the payload under
+	// onUnresolvedPlaceholder=error, so a single error reports all of them.
+	unresolvedNames     map[string]struct{}
+	unresolvedTemplates map[string]struct{}
+	unresolvedPaths     map[string]struct{}
+	// unresolvedReferences counts references that left placeholders unresolved.
+	unresolvedReferences int
+	// elapsed is the time spent resolving references for metricsEnabled.
+	elapsed time.Duration
+}
+
+func newResolutionState() *resolutionState {
+	return &resolutionState{
+		cache:               make(map[string]resolvedReference),
+		applied:             make(map[string]struct{}),
+		unresolvedNames:     make(map[string]struct{}),
+		unresolvedTemplates: make(map[string]struct{}),
+		unresolvedPaths:     make(map[string]struct{}),
+	}
+}
+
+// newResolutionStateForHeaders creates resolution state, capturing the template
+// selector from the request headers when templateSelectorHeader is configured.
+func (p *PromptTemplatePolicy) newResolutionStateForHeaders(headers *policy.Headers) *resolutionState {
+	state := newResolutionState()
+	if p.params.TemplateSelectorHeader != "" {
+		if values := headers.Get(p.params.TemplateSelectorHeader); len(values) > 0 {
+			state.selector = strings.TrimSpace(values[0])
+		}
+	}
+	return state
+}
+
+// recordUnresolved records placeholders left unresolved by a template at the
+// current field path.
+func (s *resolutionState) recordUnresolved(templateName string, names []string) {
+	for _, name := range names {
+		s.unresolvedNames[name] = struct{}{}
+	}
+	s.unresolvedTemplates[templateName] = struct{}{}
+	s.unresolvedReferences++
+	s.recordUnresolvedPath()
+}
+
+// recordUnresolvedPath records the current field path as one that referenced a
+// template with unresolved placeholders. It is a no-op for non-JSON payloads.
+func (s *resolutionState) recordUnresolvedPath() {
+	if s.fieldPath != "" {
+		s.unresolvedPaths[s.fieldPath] = struct{}{}
+	}
+}
+
+// unresolvedError returns an error listing every recorded unresolved
+// placeholder in sorted order, or nil when there are none. Template names and
+// field paths are listed in sorted order too, so the message does not depend
+// on the order references appear in the payload.
+func (s *resolutionState) unresolvedError() error {
+	if len(s.unresolvedNames) == 0 {
+		return nil
+	}
+	names := strings.Join(sortedKeys(s.unresolvedNames), ",")
+	templates := sortedKeys(s.unresolvedTemplates)
+	paths := sortedKeys(s.unresolvedPaths)
+
+	location := fmt.Sprintf("template %q", templates[0])
+	if len(templates) > 1 {
+		location = "templates " + joinQuoted(templates)
+	}
+	switch len(paths) {
+	case 0:
+	case 1:
+		location += fmt.Sprintf(" at %q", paths[0])
+	default:
+		location += " at " + joinQuoted(paths)
+	}
+	return newResolutionError(ErrorCodePlaceholderUnresolved, "unresolved placeholders in %s: %s", location, names)
+}
+
+// sortedKeys returns the keys of set in sorted order.
+func sortedKeys(set map[string]struct{}) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// joinQuoted joins values as comma-separated quoted strings.
+func joinQuoted(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = strconv.Quote(value)
+	}
+	return strings.Join(quoted, ",")
+}
+
+// appliedTemplates returns the de-duplicated, sorted names of resolved templates.
+func (s *resolutionState) appliedTemplates() []string {
+	names := make([]string, 0, len(s.applied))
+	for name := range s.applied {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (p *PromptTemplatePolicy) resolveTemplatesInText(content string, state *resolutionState) (string, error) {
+	return p.replaceTemplateReferences(content, func(matched string) (string, error) {
+		// Identical references resolve once per request; errors are never cached
+		// since they abort resolution immediately.
+		resolved, cached := state.cache[matched]
+		if !cached {
+			unresolvedBefore := state.unresolvedReferences
+			resolvedPrompt, shouldReplace, err := p.expandTemplateReference(matched, 1, state)
 			if err != nil {
 				return "", err
 			}
-			replacement = escaped
+			resolved = resolvedReference{
+				value:      resolvedPrompt,
+				replace:    shouldReplace,
+				unresolved: state.unresolvedReferences != unresolvedBefore,
+			}
+			state.cache[matched] = resolved
+		} else if resolved.unresolved {
+			state.recordUnresolvedPath()
+		}
+		if !resolved.replace {
+			return matched, nil
+		}
+		state.resolvedReferences++
+
+		replacement := resolved.value
+		if p.params.CollapseWhitespace {
+			replacement, _ = p.mapOutsideIgnored(replacement, func(segment string) (string, error) {
+				return whitespaceRegex.ReplaceAllString(segment, " "), nil
+			})
+		}
+		return replacement, nil
+	})
+}
+
+// replaceTemplateReferences replaces each template reference in content, in
+// order of appearance, with the result of replace. URIs whose scheme is not
+// listed in schemes, and tokens matched by ignorePattern, are left untouched.
+// It stops at the first error.
+func (p *PromptTemplatePolicy) replaceTemplateReferences(content string, replace func(reference string) (string, error)) (string, error) {
+	return p.mapOutsideIgnored(content, func(segment string) (string, error) {
+		var replaceErr error
+		updatedSegment := promptTemplateRegex.ReplaceAllStringFunc(segment, func(matched string) string {
+			if replaceErr != nil || !p.isTemplateReference(matched) {
+				return matched
+			}
+			replacement, err := replace(matched)
+			if err != nil {
+				replaceErr = err
+				return matched
+			}
+			return replacement
+		})
+		if replaceErr != nil {
+			return "", replaceErr
 		}
-		updatedContent = strings.ReplaceAll(updatedContent, matched, replacement)
+		return updatedSegment, nil
+	})
+}
+
+// mapOutsideIgnored applies fn to each span of text between tokens matched by
+// ignorePattern and joins the results with the matched tokens unchanged. A
+// reference or placeholder never spans an ignored token. Without ignorePattern
+// fn is applied to the whole text.
+func (p *PromptTemplatePolicy) mapOutsideIgnored(text string, fn func(segment string) (string, error)) (string, error) {
+	if p.params.ignoreRegex == nil {
+		return fn(text)
+	}
+	var builder strings.Builder
+	last := 0
+	for _, loc := range p.params.ignoreRegex.FindAllStringIndex(text, -1) {
+		segment, err := fn(text[last:loc[0]])
+		if err != nil {
+			return "", err
+		}
+		builder.WriteString(segment)
+		builder.WriteString(text[loc[0]:loc[1]])
+		last = loc[1]
 	}
+	segment, err := fn(text[last:])
+	if err != nil {
+		return "", err
+	}
+	builder.WriteString(segment)
+	return builder.String(), nil
+}
+
+// isTemplateReference reports whether a promptTemplateRegex match uses one of
+// the configured schemes.
+func (p *PromptTemplatePolicy) isTemplateReference(matched string) bool {
+	scheme, _, _ := strings.Cut(matched, "://")
+	return slices.Contains(p.params.Schemes, scheme)
+}
 
-	return updatedContent, nil
+// expandTemplateReference resolves a reference and, when maxRecursionDepth allows,
+// re-scans the output for nested references. depth is the level of reference
+// being expanded, starting at 1.
+func (p *PromptTemplatePolicy) expandTemplateReference(reference string, depth int, state *resolutionState) (string, bool, error) {
+	resolvedPrompt, shouldReplace, err := p.resolveTemplateReference(reference, state)
+	if err != nil || !shouldReplace || p.params.MaxRecursionDepth <= 1 {
+		return resolvedPrompt, shouldReplace, err
+	}
+
+	resolvedPrompt, err = p.replaceTemplateReferences(resolvedPrompt, func(nested string) (string, error) {
+		if depth >= p.params.MaxRecursionDepth {
+			// Only references that would actually expand count against the depth;
+			// passthrough references are left in place.
+			if _, nestedReplace, err := p.resolveTemplateReference(nested, state); err == nil && !nestedReplace {
+				return nested, nil
+			}
+			return "", newResolutionError(ErrorCodeRecursionDepthExceeded, "template reference %q exceeds maximum recursion depth %d", reference, p.params.MaxRecursionDepth)
+		}
+		nestedPrompt, nestedReplace, err := p.expandTemplateReference(nested, depth+1, state)
+		if err != nil {
+			return "", err
+		}
+		if !nestedReplace {
+			return nested, nil
+		}
+		return nestedPrompt, nil
+	})
+	if err != nil {
+		return "", false, err
+	}
+	return resolvedPrompt, true, nil
+}
+
+// templatePlaceholders returns the set of placeholder names used in a template,
+// including placeholders that declare a default value.
+func templatePlaceholders(templateText string) map[string]struct{} {
+	placeholders := make(map[string]struct{})
+	for _, match := range placeholderRegex.FindAllStringSubmatch(templateText, -1) {
+		placeholders[match[1]] = struct{}{}
+	}
+	return placeholders
+}
+
+// lookupTemplate finds a template by name. When a selector is present, a
+// <selector>:<name> template takes precedence over the bare name.
+func (p *PromptTemplatePolicy) lookupTemplate(name string, selector string) (string, string, bool) {
+	if selector != "" {
+		qualifiedName := selector + ":" + name
+		if templateText, exists := p.params.templates[qualifiedName]; exists {
+			return qualifiedName, templateText, true
+		}
+	}
+	templateText, exists := p.params.templates[name]
+	return name, templateText, exists
 }
 
-func (p *PromptTemplatePolicy) resolveTemplateReference(reference string) (string, bool, error) {
+func (p *PromptTemplatePolicy) resolveTemplateReference(reference string, state *resolutionState) (string, bool, error) {
 	parsedURL, err := url.Parse(reference)
 	if err != nil {
-		return "", false, fmt.Errorf("invalid template reference %q: %w", reference, err)
+		return "", false, newResolutionError(ErrorCodeTemplateReferenceInvalid, "invalid template reference %q: %w", reference, err)
 	}
 
-	templateName := parsedURL.Host
-	templateText, exists := p.params.templates[templateName]
+	templateName, templateText, exists := p.lookupTemplate(parsedURL.Host, state.selector)
 	if !exists {
 		if p.params.OnMissingTemplate == OnMissingTemplatePassthrough {
 			return "", false, nil
 		}
-		return "", false, fmt.Errorf("template %q not found", templateName)
+		return "", false, newResolutionError(ErrorCodeTemplateMissing, "template %q not found", templateName)
 	}
 
-	// Parse query parameters for placeholder replacement.
+	// Parse query parameters for placeholder replacement. Single-value
+	// placeholders use the first value; list placeholders join all of them.
+	// A reference without a query and one with a bare trailing "?" both have
+	// an empty RawQuery, so they resolve identically with no parameters and
+	// every placeholder is subject to onUnresolvedPlaceholder.
 	paramsMap := make(map[string]string)
-	if parsedURL.RawQuery != "" {
-		queryParams, err := url.ParseQuery(parsedURL.RawQuery)
-		if err == nil {
-			for key, values := range queryParams {
-				if len(values) > 0 {
-					paramsMap[key] = values[0]
-				}
+	listParams := make(map[string][]string)
+	listSeparator := DefaultListSeparator
+	if queryParams, err := url.ParseQuery(parsedURL.RawQuery); err == nil {
+		for key, values := range queryParams {
+			if len(values) == 0 {
+				continue
 			}
+			if key == ListSeparatorQueryParam {
+				listSeparator = values[0]
+				continue
+			}
+			paramsMap[key] = values[0]
+			listParams[key] = values
 		}
 	}
 
-	resolvedPrompt := templateText
-	for key, value := range paramsMap {
-		placeholder := "[[" + key + "]]"
-		resolvedPrompt = strings.ReplaceAll(resolvedPrompt, placeholder, value)
+	// Required parameters must always be supplied, regardless of
+	// onUnresolvedPlaceholder or any inline default.
+	var missing []string
+	for _, param := range p.params.required[templateName] {
+		if _, ok := paramsMap[param]; !ok {
+			missing = append(missing, param)
+		}
+	}
+	if len(missing) > 0 {
+		slices.Sort(missing)
+		return "", false, newResolutionError(ErrorCodeRequiredParamMissing, "missing required parameters for template %q: %s", templateName, strings.Join(missing, ","))
 	}
 
-	unresolvedMatches := unresolvedPlaceholderRegex.FindAllStringSubmatch(resolvedPrompt, -1)
-	if len(unresolvedMatches) > 0 {
-		switch p.params.OnUnresolvedPlaceholder {
-		case OnUnresolvedPlaceholderKeep:
-			// Keep unresolved placeholders as-is.
-		case OnUnresolvedPlaceholderEmpty:
-			resolvedPrompt = unresolvedPlaceholderRegex.ReplaceAllString(resolvedPrompt, "")
-		case OnUnresolvedPlaceholderError:
-			names := make([]string, 0, len(unresolvedMatches))
-			for _, match := range unresolvedMatches {
-				if len(match) > 1 {
-					names = append(names, match[1])
-				}
+	if p.params.RejectUnknownParams {
+		placeholders := templatePlaceholders(templateText)
+		var unknown []string
+		for key := range paramsMap {
+			if _, ok := placeholders[key]; !ok {
+				unknown = append(unknown, key)
 			}
-			slices.Sort(names)
-			names = slices.Compact(names)
-			return "", false, fmt.Errorf("unresolved placeholders in template %q: %s", templateName, strings.Join(names, ","))
+		}
+		if len(unknown) > 0 {
+			slices.Sort(unknown)
+			return "", false, newResolutionError(ErrorCodeUnknownParam, "unknown query parameters for template %q: %s", templateName, strings.Join(unknown, ","))
 		}
 	}
 
+	// Placeholders are substituted in a single pass over the template text, so
+	// substituted values are never themselves treated as placeholders. Tokens
+	// matched by ignorePattern are never substituted, emptied or reported as
+	// unresolved.
+	emptyUnresolved := p.params.OnUnresolvedPlaceholder == OnUnresolvedPlaceholderEmpty
+	var unresolved []string
+	resolvedPrompt, _ := p.mapOutsideIgnored(templateText, func(segment string) (string, error) {
+		substituted, names := substitutePlaceholders(segment, paramsMap, listParams, listSeparator, emptyUnresolved)
+		unresolved = append(unresolved, names...)
+		return substituted, nil
+	})
+	if len(unresolved) > 0 && p.params.OnUnresolvedPlaceholder == OnUnresolvedPlaceholderError {
+		// Resolution continues so that every reference in the payload is
+		// checked; resolvePayload reports all of them in one error.
+		state.recordUnresolved(templateName, unresolved)
+	}
+
+	state.applied[templateName] = struct{}{}
 	return resolvedPrompt, true, nil
 }
 
-func (p *PromptTemplatePolicy) escapeForJSONString(value string) (string, error) {
-	escapedPromptBytes, err := json.Marshal(value)
-	if err != nil {
-		return "", fmt.Errorf("error marshaling resolved prompt to JSON: %w", err)
+// substitutePlaceholders replaces the placeholders in text with query
+// parameter values in a single pass and returns the names of placeholders left
+// unresolved. Placeholders with a default are never unresolved: the query value
+// is used when supplied, otherwise the default text. Unresolved placeholders are
+// removed when emptyUnresolved is set and kept as-is otherwise.
+func substitutePlaceholders(text string, paramsMap map[string]string, listParams map[string][]string, listSeparator string, emptyUnresolved bool) (string, []string) {
+	var unresolved []string
+	substituted := promptutil.SubstituteMatches(text, placeholderRegex, func(loc []int) (string, bool) {
+		name := text[loc[2]:loc[3]]
+		isList := loc[4] >= 0
+		hasDefault := loc[6] >= 0
+		switch {
+		case isList:
+			if values, ok := listParams[name]; ok {
+				return strings.Join(values, listSeparator), true
+			}
+		case hasDefault:
+			if value, ok := paramsMap[name]; ok {
+				return value, true
+			}
+			return defaultEscapeRegex.ReplaceAllString(text[loc[6]:loc[7]], "$1"), true
+		default:
+			if value, ok := paramsMap[name]; ok {
+				return value, true
+			}
+		}
+		unresolved = append(unresolved, name)
+		return "", emptyUnresolved
+	})
+	return substituted, unresolved
+}
+
+// stringifyJSONValue converts a string or number JSON value into the text that
+// template references are resolved in.
+func stringifyJSONValue(value interface{}) (string, bool) {
+	var extractedValue string
+	switch v := value.(type) {
+	case string:
+		extractedValue = v
+	case float64:
+		extractedValue = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return "", false
 	}
-	escapedPrompt := string(escapedPromptBytes)
-	escapedPrompt = textCleanRegex.ReplaceAllString(escapedPrompt, "")
-	return escapedPrompt, nil
+	// Normalize quoted JSON strings.
+	return textCleanRegex.ReplaceAllString(extractedValue, ""), true
 }
 
-func (p *PromptTemplatePolicy) extractStringAtPath(payload []byte, jsonPath string) (string, error) {
-	extractedValue, err := utils.ExtractStringValueFromJsonpath(payload, jsonPath)
+// resolveAtPath resolves template references in the value at jsonPath and writes
+// the result back into payloadData. Array targets have each element resolved
+// independently and updated in place. It reports whether the payload changed.
+func (p *PromptTemplatePolicy) resolveAtPath(payloadData map[string]interface{}, jsonPath string, state *resolutionState) (bool, *policy.ImmediateResponse) {
+	value, err := utils.ExtractValueFromJsonpath(payloadData, jsonPath)
 	if err != nil {
-		return "", err
+		return false, p.buildErrorResponse(ErrorCodeJSONPathInvalid, "Error extracting value from JSONPath", err)
 	}
-	// Normalize quoted JSON strings.
-	extractedValue = textCleanRegex.ReplaceAllString(extractedValue, "")
-	return extractedValue, nil
+
+	// Wildcard paths yield a detached slice, so only direct array targets can be
+	// updated in place.
+	if elements, ok := value.([]interface{}); ok && !strings.Contains(jsonPath, "*") {
+		resolvedElements := make([]string, len(elements))
+		changed := false
+		for i, element := range elements {
+			extractedValue, ok := stringifyJSONValue(element)
+			if !ok {
+				return false, p.buildErrorResponse(ErrorCodeJSONPathInvalid, "Error extracting value from JSONPath",
+					fmt.Errorf("value at JSONPath index %d is not a string or number", i))
+			}
+			state.fieldPath = fmt.Sprintf("%s[%d]", jsonPath, i)
+			updatedValue, err := p.resolveTemplatesInText(extractedValue, state)
+			if err != nil {
+				return false, p.buildErrorResponse(resolutionErrorCode(err), "Error resolving templates", err)
+			}
+			resolvedElements[i] = updatedValue
+			if updatedValue != extractedValue {
+				changed = true
+			}
+		}
+		if !changed {
+			return false, nil
+		}
+		// Write back only once every element resolved so errors leave the payload untouched.
+		for i, resolved := range resolvedElements {
+			if original, _ := stringifyJSONValue(elements[i]); original != resolved {
+				elements[i] = resolved
+			}
+		}
+		return true, nil
+	}
+
+	extractedValue, ok := stringifyJSONValue(value)
+	if !ok {
+		return false, p.buildErrorResponse(ErrorCodeJSONPathInvalid, "Error extracting value from JSONPath",
+			fmt.Errorf("value at JSONPath is not a string or number"))
+	}
+
+	state.fieldPath = jsonPath
+	updatedValue, err := p.resolveTemplatesInText(extractedValue, state)
+	if err != nil {
+		return false, p.buildErrorResponse(resolutionErrorCode(err), "Error resolving templates", err)
+	}
+	if updatedValue == extractedValue {
+		return false, nil
+	}
+
+	if err := utils.SetValueAtJSONPath(payloadData, jsonPath, updatedValue); err != nil {
+		return false, p.buildErrorResponse(ErrorCodeJSONPathUpdate, "Error updating JSONPath", err)
+	}
+	return true, nil
+}
+
+// OnRequestHeaders implements RequestHeaderPolicy. Headers are only processed so
+// that templateSelectorHeader is available; the selector itself is read in
+// OnRequestBody.
+func (p *PromptTemplatePolicy) OnRequestHeaders(ctx context.Context, reqCtx *policy.RequestHeaderContext, _ map[string]interface{}) policy.RequestHeaderAction {
+	return policy.UpstreamRequestHeaderModifications{}
 }
 
-// OnRequestBody applies the configured template to the request body.
-func (p *PromptTemplatePolicy) OnRequestBody(ctx context.Context, reqCtx *policy.RequestContext, _ map[string]interface{}) policy.RequestAction {
+// withOverrides returns the policy to use for a single invocation. Keys in
+// params accepted by parseOverridableParams take precedence over the
+// construction-time configuration and are validated the same way. Keys whose
+// values equal the configured ones are skipped, since the kernel passes the
+// full configuration on every invocation; the receiver itself is returned
+// when no key differs.
+func (p *PromptTemplatePolicy) withOverrides(params map[string]interface{}) (*PromptTemplatePolicy, error) {
+	var overrides map[string]interface{}
+	for _, name := range overridableParamNames {
+		value, ok := params[name]
+		if !ok {
+			continue
+		}
+		if configured, ok := p.configured[name]; ok && reflect.DeepEqual(configured, value) {
+			continue
+		}
+		if overrides == nil {
+			overrides = make(map[string]interface{})
+		}
+		overrides[name] = value
+	}
+	if overrides == nil {
+		return p, nil
+	}
+	merged := p.params
+	if err := parseOverridableParams(overrides, &merged); err != nil {
+		return nil, err
+	}
+	return &PromptTemplatePolicy{params: merged, configured: p.configured}, nil
+}
+
+// OnRequestBody applies the configured template to the request body. params
+// may override jsonPath and the other per-invocation parameters.
+func (p *PromptTemplatePolicy) OnRequestBody(ctx context.Context, reqCtx *policy.RequestContext, params map[string]interface{}) policy.RequestAction {
+	overridden, err := p.withOverrides(params)
+	if err != nil {
+		return *p.buildErrorResponse(ErrorCodeParamsInvalid, "Invalid parameter overrides", err)
+	}
+	p = overridden
+
 	var content []byte
 	if reqCtx.Body != nil {
 		content = reqCtx.Body.Content
 	}
 
-	if len(content) == 0 {
+	state := p.newResolutionStateForHeaders(reqCtx.Headers)
+	updatedPayload, errResp := p.resolvePayload(content, state)
+	if errResp == nil {
+		errResp = p.checkOutputSize(updatedPayload)
+	}
+	if errResp != nil {
+		return *errResp
+	}
+
+	if appliedTemplates := state.appliedTemplates(); len(appliedTemplates) > 0 && reqCtx.SharedContext != nil {
+		if reqCtx.Metadata == nil {
+			reqCtx.Metadata = make(map[string]interface{})
+		}
+		reqCtx.Metadata[MetadataKeyAppliedTemplates] = appliedTemplates
+	}
+	p.recordMetrics(reqCtx.SharedContext, state)
+
+	if updatedPayload == nil {
 		return policy.UpstreamRequestModifications{}
 	}
+	return policy.UpstreamRequestModifications{
+		Body: updatedPayload,
+	}
+}
 
-	// If jsonPath is empty, resolve template references across the whole payload
-	// string (legacy behavior).
-	if p.params.JsonPath == "" {
-		updatedContent, err := p.resolveTemplatesInText(string(content), true)
-		if err != nil {
-			return p.buildErrorResponse("Error resolving templates", err)
+// OnResponseBody applies the configured template to the response body when
+// applyToResponse is enabled, honouring the same per-invocation overrides as
+// OnRequestBody.
+func (p *PromptTemplatePolicy) OnResponseBody(ctx context.Context, respCtx *policy.ResponseContext, params map[string]interface{}) policy.ResponseAction {
+	if !p.params.ApplyToResponse {
+		return policy.DownstreamResponseModifications{}
+	}
+	overridden, err := p.withOverrides(params)
+	if err != nil {
+		return *p.buildErrorResponse(ErrorCodeParamsInvalid, "Invalid parameter overrides", err)
+	}
+	p = overridden
+
+	var content []byte
+	if respCtx.ResponseBody != nil {
+		content = respCtx.ResponseBody.Content
+	}
+
+	state := p.newResolutionStateForHeaders(respCtx.RequestHeaders)
+	updatedPayload, errResp := p.resolvePayload(content, state)
+	if errResp == nil {
+		errResp = p.checkOutputSize(updatedPayload)
+	}
+	if errResp != nil {
+		return *errResp
+	}
+	p.recordMetrics(respCtx.SharedContext, state)
+	if updatedPayload == nil {
+		return policy.DownstreamResponseModifications{}
+	}
+	return policy.DownstreamResponseModifications{
+		Body: updatedPayload,
+	}
+}
+
+// checkOutputSize returns an error response when a modified payload exceeds
+// maxOutputBytes. Unmodified payloads are never rejected.
+func (p *PromptTemplatePolicy) checkOutputSize(updatedPayload []byte) *policy.ImmediateResponse {
+	if p.params.MaxOutputBytes == 0 || len(updatedPayload) <= p.params.MaxOutputBytes {
+		return nil
+	}
+	return p.buildErrorResponse(ErrorCodeOutputTooLarge, "Resolved payload too large",
+		fmt.Errorf("%d bytes exceeds 'maxOutputBytes' limit of %d bytes", len(updatedPayload), p.params.MaxOutputBytes))
+}
+
+// mayContainTemplateReference cheaply scans the raw payload for a template
+// reference of any configured scheme before any JSON parsing. With jsonPath
+// configured, references are matched against decoded JSON strings, so escaped
+// forms ("template:\/\/" or any \u escape) are treated as possible references.
+func (p *PromptTemplatePolicy) mayContainTemplateReference(content []byte) bool {
+	for _, marker := range p.params.referenceMarkers {
+		if bytes.Contains(content, marker) {
+			return true
 		}
-		if updatedContent == string(content) {
-			return policy.UpstreamRequestModifications{}
+	}
+	if len(p.params.JsonPaths) == 0 {
+		return false
+	}
+	for _, marker := range p.params.escapedReferenceMarkers {
+		if bytes.Contains(content, marker) {
+			return true
 		}
-		return policy.UpstreamRequestModifications{
-			Body: []byte(updatedContent),
+	}
+	return bytes.Contains(content, unicodeEscapeMarker)
+}
+
+// resolvePayload resolves template references in a request or response payload.
+// It returns a nil payload when nothing changed, or an error response when
+// resolution fails. Under onUnresolvedPlaceholder=error, the placeholders left
+// unresolved by every reference are reported together once the whole payload
+// has been resolved.
+func (p *PromptTemplatePolicy) resolvePayload(content []byte, state *resolutionState) ([]byte, *policy.ImmediateResponse) {
+	updatedPayload, errResp := p.resolvePayloadContent(content, state)
+	if errResp != nil {
+		return nil, errResp
+	}
+	if err := state.unresolvedError(); err != nil {
+		return nil, p.buildErrorResponse(resolutionErrorCode(err), "Error resolving templates", err)
+	}
+	return updatedPayload, nil
+}
+
+// resolvePayloadContent resolves the references of a payload for resolvePayload.
+func (p *PromptTemplatePolicy) resolvePayloadContent(content []byte, state *resolutionState) ([]byte, *policy.ImmediateResponse) {
+	if len(content) == 0 {
+		return nil, nil
+	}
+
+	// Most payloads carry no template references; skip parsing and walking them.
+	if !p.mayContainTemplateReference(content) {
+		return nil, nil
+	}
+
+	// If jsonPath is empty, resolve template references in every string of
+	// each JSON document, or across the whole payload string when it is not
+	// JSON.
+	resolve := p.resolveDocument
+	format := p.params.BodyFormat
+	if len(p.params.JsonPaths) == 0 {
+		resolve = p.resolveWholeDocument
+		if format == bodyformat.JSONObject {
+			if json.Valid(content) {
+				return p.resolveAllStrings(content, state)
+			}
+			// Newline-delimited JSON sent without bodyFormat is still JSON;
+			// splicing resolved text into it unescaped would break each line.
+			if !isNDJSON(content) {
+				return p.resolvePlainText(content, state)
+			}
+			format = bodyformat.NDJSON
 		}
 	}
 
-	// jsonPath configured: resolve template references in the extracted string only.
-	var payloadData map[string]interface{}
-	if err := json.Unmarshal(content, &payloadData); err != nil {
-		return p.buildErrorResponse("Error parsing JSON payload", err)
+	// Resolve template references in each document of the body.
+	if format == bodyformat.JSONObject {
+		return resolve(content, state)
+	}
+	documents, err := bodyformat.Split(content, format)
+	if err != nil {
+		return nil, p.buildErrorResponse(ErrorCodePayloadInvalid, "Error parsing JSON payload", err)
+	}
+	modified := false
+	for i, document := range documents {
+		if bodyformat.IsBlank(document) {
+			continue
+		}
+		updatedDocument, errResp := resolve(document, state)
+		if errResp != nil {
+			return nil, errResp
+		}
+		if updatedDocument != nil {
+			documents[i] = updatedDocument
+			modified = true
+		}
 	}
+	if !modified {
+		return nil, nil
+	}
+	return bodyformat.Join(documents, format), nil
+}
 
-	extractedValue, err := p.extractStringAtPath(content, p.params.JsonPath)
+// resolveWholeDocument resolves template references in every string value of
+// a single JSON document of a body split by bodyFormat.
+func (p *PromptTemplatePolicy) resolveWholeDocument(document []byte, state *resolutionState) ([]byte, *policy.ImmediateResponse) {
+	var raw json.RawMessage
+	if err := json.Unmarshal(document, &raw); err != nil {
+		return nil, p.buildErrorResponse(ErrorCodePayloadInvalid, "Error parsing JSON payload", err)
+	}
+	return p.resolveAllStrings(document, state)
+}
+
+// resolvePlainText resolves template references across a payload that is not
+// JSON. The resolved text is spliced in as is, without JSON escaping.
+func (p *PromptTemplatePolicy) resolvePlainText(content []byte, state *resolutionState) ([]byte, *policy.ImmediateResponse) {
+	start := p.startTimer()
+	updatedContent, err := p.resolveTemplatesInText(string(content), state)
+	p.stopTimer(start, state)
 	if err != nil {
-		return p.buildErrorResponse("Error extracting value from JSONPath", err)
+		return nil, p.buildErrorResponse(resolutionErrorCode(err), "Error resolving templates", err)
+	}
+	if updatedContent == string(content) {
+		return nil, nil
+	}
+	return []byte(updatedContent), nil
+}
+
+// isNDJSON reports whether content holds more than one line and every
+// non-blank line is a JSON document on its own.
+func isNDJSON(content []byte) bool {
+	lines, _ := bodyformat.Split(content, bodyformat.NDJSON)
+	if len(lines) < 2 {
+		return false
+	}
+	for _, line := range lines {
+		if !bodyformat.IsBlank(line) && !json.Valid(line) {
+			return false
+		}
 	}
+	return true
+}
 
-	updatedValue, err := p.resolveTemplatesInText(extractedValue, false)
+// resolveAllStrings resolves template references in every string value of a
+// JSON payload and splices the resolved values into content, so that keys,
+// whitespace and all other values are kept byte for byte. It returns nil when
+// nothing changed.
+func (p *PromptTemplatePolicy) resolveAllStrings(content []byte, state *resolutionState) ([]byte, *policy.ImmediateResponse) {
+	edits, err := p.resolveStringLeaves(content, state)
 	if err != nil {
-		return p.buildErrorResponse("Error resolving templates", err)
+		return nil, p.buildErrorResponse(resolutionErrorCode(err), "Error resolving templates", err)
 	}
-	if updatedValue == extractedValue {
-		return policy.UpstreamRequestModifications{}
+	if len(edits) == 0 {
+		return nil, nil
+	}
+
+	slices.SortStableFunc(edits, func(a, b jsonEdit) int { return a.start - b.start })
+	var updatedPayload bytes.Buffer
+	last := 0
+	for _, edit := range edits {
+		updatedPayload.Write(content[last:edit.start])
+		updatedPayload.Write(edit.text)
+		last = edit.end
+	}
+	updatedPayload.Write(content[last:])
+	return updatedPayload.Bytes(), nil
+}
+
+// jsonEdit replaces content[start:end] of a JSON payload with text. An edit
+// with start equal to end inserts text.
+type jsonEdit struct {
+	start, end int
+	text       []byte
+}
+
+// jsonContainer is an object or array that is open while resolveStringLeaves
+// walks a JSON payload.
+type jsonContainer struct {
+	path      string
+	object    bool
+	expectKey bool
+	key       string
+	index     int
+	keys      map[string]bool
+	// preserved holds the resolved string fields of an object whose original
+	// values are kept under the suffixed key once the object is complete.
+	preserved []preservedField
+}
+
+// preservedField is a resolved object field whose original value, the raw
+// JSON string original, is inserted after the field at offset end.
+type preservedField struct {
+	key      string
+	end      int
+	original []byte
+}
+
+// childPath returns the JSONPath of the value that follows in the container.
+func (c *jsonContainer) childPath() string {
+	if c.object {
+		return childFieldPath(c.path, c.key)
+	}
+	return fmt.Sprintf("%s[%d]", c.path, c.index)
+}
+
+// next moves the container past a completed value.
+func (c *jsonContainer) next() {
+	if c.object {
+		c.expectKey = true
+		return
+	}
+	c.index++
+}
+
+// resolveStringLeaves walks the tokens of a valid JSON payload and resolves
+// template references in every string value, visiting object values and array
+// elements at any depth in document order. When preserveOriginalSuffix is set,
+// each resolved string field of an object also keeps its original value under
+// the suffixed key, inserted after the field. It returns the edits that apply
+// the resolved values to content.
+func (p *PromptTemplatePolicy) resolveStringLeaves(content []byte, state *resolutionState) ([]jsonEdit, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	var edits []jsonEdit
+	var stack []*jsonContainer
+	offset := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return edits, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		tokenStart := skipJSONSeparators(content, offset)
+		offset = int(decoder.InputOffset())
+
+		var parent *jsonContainer
+		if len(stack) > 0 {
+			parent = stack[len(stack)-1]
+		}
+		if delim, ok := token.(json.Delim); ok && (delim == '}' || delim == ']') {
+			stack = stack[:len(stack)-1]
+			preservedEdits, err := p.preserveOriginals(parent)
+			if err != nil {
+				return nil, err
+			}
+			edits = append(edits, preservedEdits...)
+			if len(stack) > 0 {
+				stack[len(stack)-1].next()
+			}
+			continue
+		}
+		if parent != nil && parent.object && parent.expectKey {
+			parent.key, _ = token.(string)
+			parent.keys[parent.key] = true
+			parent.expectKey = false
+			continue
+		}
+
+		fieldPath := "$"
+		if parent != nil {
+			fieldPath = parent.childPath()
+		}
+		switch v := token.(type) {
+		case json.Delim:
+			stack = append(stack, &jsonContainer{
+				path:      fieldPath,
+				object:    v == '{',
+				expectKey: v == '{',
+				keys:      make(map[string]bool),
+			})
+			continue
+		case string:
+			state.fieldPath = fieldPath
+			// Time only the resolution itself, not the token walk.
+			start := p.startTimer()
+			resolved, err := p.resolveTemplatesInText(v, state)
+			p.stopTimer(start, state)
+			if err != nil {
+				return nil, err
+			}
+			if resolved != v {
+				encoded, err := promptutil.MarshalJSON(resolved)
+				if err != nil {
+					return nil, err
+				}
+				edits = append(edits, jsonEdit{start: tokenStart, end: offset, text: encoded})
+				if parent != nil && parent.object && p.params.PreserveOriginalSuffix != "" {
+					parent.preserved = append(parent.preserved, preservedField{
+						key:      parent.key,
+						end:      offset,
+						original: content[tokenStart:offset],
+					})
+				}
+			}
+		}
+		if parent != nil {
+			parent.next()
+		}
 	}
+}
 
-	if err := utils.SetValueAtJSONPath(payloadData, p.params.JsonPath, updatedValue); err != nil {
-		return p.buildErrorResponse("Error updating JSONPath", err)
+// preserveOriginals returns the edits inserting the original values of the
+// resolved fields of a completed object under their suffixed keys.
+func (p *PromptTemplatePolicy) preserveOriginals(container *jsonContainer) ([]jsonEdit, error) {
+	edits := make([]jsonEdit, 0, len(container.preserved))
+	for _, field := range container.preserved {
+		preservedKey := field.key + p.params.PreserveOriginalSuffix
+		if container.keys[preservedKey] {
+			return nil, newResolutionError(ErrorCodePreservedKeyConflict,
+				"cannot preserve original value of %q: key %q already exists", field.key, preservedKey)
+		}
+		encodedKey, err := promptutil.MarshalJSON(preservedKey)
+		if err != nil {
+			return nil, err
+		}
+		text := append([]byte(","), encodedKey...)
+		text = append(text, ':')
+		text = append(text, field.original...)
+		edits = append(edits, jsonEdit{start: field.end, end: field.end, text: text})
 	}
+	return edits, nil
+}
 
-	updatedPayload, err := json.Marshal(payloadData)
+// skipJSONSeparators returns the offset of the first byte at or after offset
+// that is not whitespace or a ',' or ':' separator, where the next JSON token
+// starts.
+func skipJSONSeparators(content []byte, offset int) int {
+	for offset < len(content) {
+		switch content[offset] {
+		case ' ', '\t', '\n', '\r', ',', ':':
+			offset++
+		default:
+			return offset
+		}
+	}
+	return offset
+}
+
+// childFieldPath returns the JSONPath of key within the object at parent, using
+// bracket notation for keys that are not plain identifiers.
+func childFieldPath(parent, key string) string {
+	if identifierRegex.MatchString(key) {
+		return parent + "." + key
+	}
+	return fmt.Sprintf("%s[%q]", parent, key)
+}
+
+// resolveDocument resolves template references at the configured JSONPaths of
+// a single JSON object document, applying each path in order. It returns nil
+// when nothing changed, or an error response when resolution fails.
+func (p *PromptTemplatePolicy) resolveDocument(content []byte, state *resolutionState) ([]byte, *policy.ImmediateResponse) {
+	var payloadData map[string]interface{}
+	if err := json.Unmarshal(content, &payloadData); err != nil {
+		return nil, p.buildErrorResponse(ErrorCodePayloadInvalid, "Error parsing JSON payload", err)
+	}
+
+	modified := false
+	start := p.startTimer()
+	for _, jsonPath := range p.params.JsonPaths {
+		changed, errResp := p.resolveAtPath(payloadData, jsonPath, state)
+		if errResp != nil {
+			return nil, errResp
+		}
+		if changed {
+			modified = true
+		}
+	}
+	p.stopTimer(start, state)
+	if !modified {
+		return nil, nil
+	}
+
+	updatedPayload, err := promptutil.MarshalJSON(payloadData)
 	if err != nil {
-		return p.buildErrorResponse("Error marshaling updated JSON payload", err)
+		return nil, p.buildErrorResponse(ErrorCodePayloadMarshal, "Error marshaling updated JSON payload", err)
 	}
 
-	return policy.UpstreamRequestModifications{
-		Body: updatedPayload,
+	return updatedPayload, nil
+}
+
+// startTimer returns the current time when metricsEnabled is set, and the zero
+// time otherwise so that disabled metrics avoid reading the clock.
+func (p *PromptTemplatePolicy) startTimer() time.Time {
+	if !p.params.MetricsEnabled {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// stopTimer adds the time elapsed since start to the resolution state.
+func (p *PromptTemplatePolicy) stopTimer(start time.Time, state *resolutionState) {
+	if !p.params.MetricsEnabled {
+		return
 	}
+	state.elapsed += time.Since(start)
 }
 
-// buildV1ErrorResponse builds an error response for the v1alpha OnRequest method.
-func (p *PromptTemplatePolicy) buildErrorResponse(reason string, validationError error) policy.RequestAction {
+// recordMetrics stores resolution metrics in the shared metadata when
+// metricsEnabled is set.
+func (p *PromptTemplatePolicy) recordMetrics(shared *policy.SharedContext, state *resolutionState) {
+	if !p.params.MetricsEnabled || shared == nil {
+		return
+	}
+	if shared.Metadata == nil {
+		shared.Metadata = make(map[string]interface{})
+	}
+	shared.Metadata[MetadataKeyResolutionDurationMs] = float64(state.elapsed) / float64(time.Millisecond)
+	shared.Metadata[MetadataKeyResolvedReferences] = state.resolvedReferences
+}
+
+// buildErrorResponse builds the PROMPT_TEMPLATE_ERROR immediate response with
+// the given error code.
+func (p *PromptTemplatePolicy) buildErrorResponse(code string, reason string, validationError error) *policy.ImmediateResponse {
 	errorMessage := reason
 	if validationError != nil {
 		errorMessage = fmt.Sprintf("%s: %v", reason, validationError)
 	}
 	responseBody := map[string]interface{}{
 		"type":    "PROMPT_TEMPLATE_ERROR",
+		"code":    code,
 		"message": errorMessage,
 	}
 	bodyBytes, err := json.Marshal(responseBody)
 	if err != nil {
-		bodyBytes = []byte(`{"type":"PROMPT_TEMPLATE_ERROR","message":"Internal error"}`)
+		bodyBytes = []byte(`{"type":"PROMPT_TEMPLATE_ERROR","code":"` + code + `","message":"Internal error"}`)
 	}
-	return policy.ImmediateResponse{
-		StatusCode: 500,
+	return &policy.ImmediateResponse{
+		StatusCode: p.params.ErrorStatusCode,
 		Headers: map[string]string{
 			"Content-Type": "application/json",
 		},
 		Body: bodyBytes,
 	}
-}
\ No newline at end of file
+}
@@ -1,14 +1,20 @@
 package prompttemplate
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"sync"
 	"testing"
 
 	policy "github.com/wso2/api-platform/sdk/core/policy/v1alpha2"
+	"github.com/wso2/gateway-controllers/common/promptutil/promptutiltest"
 )
 
 func TestPromptTemplatePolicy_GetPolicy_MinimalSuccess(t *testing.T) {
@@ -127,6 +133,22 @@ func TestPromptTemplatePolicy_GetPolicy_InvalidParams(t *testing.T) {
 			},
 			wantErrContain: "'jsonPath' must be a string",
 		},
+		{
+			name: "jsonPath array with non-string entry",
+			params: map[string]interface{}{
+				"templates": baseTemplatesArray(),
+				"jsonPath":  []interface{}{"$.a", 1},
+			},
+			wantErrContain: "'jsonPath[1]' must be a string",
+		},
+		{
+			name: "jsonPath empty array",
+			params: map[string]interface{}{
+				"templates": baseTemplatesArray(),
+				"jsonPath":  []interface{}{},
+			},
+			wantErrContain: "'jsonPath' cannot be an empty array",
+		},
 		{
 			name: "onMissingTemplate invalid value",
 			params: map[string]interface{}{
@@ -143,6 +165,191 @@ func TestPromptTemplatePolicy_GetPolicy_InvalidParams(t *testing.T) {
 			},
 			wantErrContain: "'onUnresolvedPlaceholder' must be one of [keep,empty,error]",
 		},
+		{
+			name: "templates and templatesFile both set",
+			params: map[string]interface{}{
+				"templates":     baseTemplatesArray(),
+				"templatesFile": "/tmp/templates.json",
+			},
+			wantErrContain: "only one of 'templates' or 'templatesFile' can be specified",
+		},
+		{
+			name: "templatesFile wrong type",
+			params: map[string]interface{}{
+				"templatesFile": 1,
+			},
+			wantErrContain: "'templatesFile' must be a non-empty string",
+		},
+		{
+			name: "templatesFile missing",
+			params: map[string]interface{}{
+				"templatesFile": "/nonexistent/templates.json",
+			},
+			wantErrContain: "failed to read templatesFile",
+		},
+		{
+			name: "applyToResponse wrong type",
+			params: map[string]interface{}{
+				"templates":       baseTemplatesArray(),
+				"applyToResponse": "true",
+			},
+			wantErrContain: "'applyToResponse' must be a boolean",
+		},
+		{
+			name: "maxRecursionDepth below minimum",
+			params: map[string]interface{}{
+				"templates":         baseTemplatesArray(),
+				"maxRecursionDepth": 0,
+			},
+			wantErrContain: "'maxRecursionDepth' must be at least 1",
+		},
+		{
+			name: "maxRecursionDepth not an integer",
+			params: map[string]interface{}{
+				"templates":         baseTemplatesArray(),
+				"maxRecursionDepth": 1.5,
+			},
+			wantErrContain: "'maxRecursionDepth' must be an integer",
+		},
+		{
+			name: "rejectUnknownParams wrong type",
+			params: map[string]interface{}{
+				"templates":           baseTemplatesArray(),
+				"rejectUnknownParams": "yes",
+			},
+			wantErrContain: "'rejectUnknownParams' must be a boolean",
+		},
+		{
+			name: "errorStatusCode out of range",
+			params: map[string]interface{}{
+				"templates":       baseTemplatesArray(),
+				"errorStatusCode": 302,
+			},
+			wantErrContain: "'errorStatusCode' must be between 400 and 599",
+		},
+		{
+			name: "errorStatusCode wrong type",
+			params: map[string]interface{}{
+				"templates":       baseTemplatesArray(),
+				"errorStatusCode": true,
+			},
+			wantErrContain: "'errorStatusCode' must be an integer",
+		},
+		{
+			name: "selector template name without templateSelectorHeader",
+			params: map[string]interface{}{
+				"templates": []interface{}{
+					map[string]interface{}{"name": "acme:greet", "template": "Hi"},
+				},
+			},
+			wantErrContain: "'templates[0].name' must match ^[a-zA-Z0-9_-]+$",
+		},
+		{
+			name: "collapseWhitespace wrong type",
+			params: map[string]interface{}{
+				"templates":          baseTemplatesArray(),
+				"collapseWhitespace": 1,
+			},
+			wantErrContain: "'collapseWhitespace' must be a boolean",
+		},
+		{
+			name: "required entry not a placeholder",
+			params: map[string]interface{}{
+				"templates": []interface{}{
+					map[string]interface{}{"name": "greet", "template": "Hello [[name]]", "required": []interface{}{"age"}},
+				},
+			},
+			wantErrContain: "'templates[0].required[0]' \"age\" is not a placeholder in the template",
+		},
+		{
+			name: "maxOutputBytes negative",
+			params: map[string]interface{}{
+				"templates":      baseTemplatesArray(),
+				"maxOutputBytes": -1,
+			},
+			wantErrContain: "'maxOutputBytes' cannot be negative",
+		},
+		{
+			name: "maxOutputBytes not an integer",
+			params: map[string]interface{}{
+				"templates":      baseTemplatesArray(),
+				"maxOutputBytes": "1kb",
+			},
+			wantErrContain: "'maxOutputBytes' must be an integer",
+		},
+		{
+			name: "preserveOriginalSuffix empty",
+			params: map[string]interface{}{
+				"templates":              baseTemplatesArray(),
+				"preserveOriginalSuffix": "",
+			},
+			wantErrContain: "'preserveOriginalSuffix' must be a non-empty string",
+		},
+		{
+			name: "preserveOriginalSuffix with jsonPath",
+			params: map[string]interface{}{
+				"templates":              baseTemplatesArray(),
+				"jsonPath":               "$.prompt",
+				"preserveOriginalSuffix": "_raw",
+			},
+			wantErrContain: "'preserveOriginalSuffix' is only supported when 'jsonPath' is empty",
+		},
+		{
+			name: "bodyFormat invalid value",
+			params: map[string]interface{}{
+				"templates":  baseTemplatesArray(),
+				"bodyFormat": "xml",
+			},
+			wantErrContain: "'bodyFormat' must be one of [json-object,json-array,ndjson]",
+		},
+		{
+			name: "metricsEnabled wrong type",
+			params: map[string]interface{}{
+				"templates":      baseTemplatesArray(),
+				"metricsEnabled": "yes",
+			},
+			wantErrContain: "'metricsEnabled' must be a boolean",
+		},
+		{
+			name: "schemes empty",
+			params: map[string]interface{}{
+				"templates": baseTemplatesArray(),
+				"schemes":   []interface{}{},
+			},
+			wantErrContain: "'schemes' cannot be empty",
+		},
+		{
+			name: "schemes wrong type",
+			params: map[string]interface{}{
+				"templates": baseTemplatesArray(),
+				"schemes":   "template",
+			},
+			wantErrContain: "'schemes' must be an array of strings",
+		},
+		{
+			name: "schemes entry not a valid scheme",
+			params: map[string]interface{}{
+				"templates": baseTemplatesArray(),
+				"schemes":   []interface{}{"template", "tpl://"},
+			},
+			wantErrContain: "'schemes[1]' must be a valid URI scheme",
+		},
+		{
+			name: "ignorePattern not a string",
+			params: map[string]interface{}{
+				"templates":     baseTemplatesArray(),
+				"ignorePattern": 1,
+			},
+			wantErrContain: "'ignorePattern' must be a string",
+		},
+		{
+			name: "ignorePattern invalid regex",
+			params: map[string]interface{}{
+				"templates":     baseTemplatesArray(),
+				"ignorePattern": "[EMAIL_",
+			},
+			wantErrContain: "'ignorePattern' must be a valid regular expression",
+		},
 		{
 			name: "legacy config only should fail",
 			params: map[string]interface{}{
@@ -161,6 +368,14 @@ func TestPromptTemplatePolicy_GetPolicy_InvalidParams(t *testing.T) {
 			if !strings.Contains(err.Error(), tt.wantErrContain) {
 				t.Fatalf("error mismatch: got %q, want contain %q", err.Error(), tt.wantErrContain)
 			}
+
+			err = (&PromptTemplatePolicy{}).Validate(tt.params)
+			if err == nil {
+				t.Fatalf("expected Validate error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.wantErrContain) {
+				t.Fatalf("Validate error mismatch: got %q, want contain %q", err.Error(), tt.wantErrContain)
+			}
 		})
 	}
 }
@@ -184,6 +399,45 @@ func TestPromptTemplatePolicy_GetPolicy_TemplatesJSONStringWorks(t *testing.T) {
 	}
 }
 
+func TestPromptTemplatePolicy_GetPolicy_TemplatesFile(t *testing.T) {
+	writeFile := func(t *testing.T, content string) string {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), "templates.json")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("failed to write templates file: %v", err)
+		}
+		return path
+	}
+
+	t.Run("loads templates", func(t *testing.T) {
+		path := writeFile(t, `[{"name":"greet","template":"Hi [[name]]"}]`)
+		p := mustGetPromptTemplatePolicy(t, map[string]interface{}{"templatesFile": path})
+
+		ctx := newRequestContextWithBody(`{"prompt":"template://greet?name=Sam"}`)
+		mods := mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+		body := decodeJSONMap(t, mods.Body)
+		if got := body["prompt"]; got != "Hi Sam" {
+			t.Fatalf("unexpected prompt: got %v, want %q", got, "Hi Sam")
+		}
+	})
+
+	t.Run("malformed file", func(t *testing.T) {
+		path := writeFile(t, `[{"name":"greet"`)
+		_, err := GetPolicy(policy.PolicyMetadata{}, map[string]interface{}{"templatesFile": path})
+		if err == nil || !strings.Contains(err.Error(), "error unmarshaling templatesFile") {
+			t.Fatalf("expected unmarshal error, got %v", err)
+		}
+	})
+
+	t.Run("same validation as inline templates", func(t *testing.T) {
+		path := writeFile(t, `[{"name":"bad name","template":"Hi"}]`)
+		_, err := GetPolicy(policy.PolicyMetadata{}, map[string]interface{}{"templatesFile": path})
+		if err == nil || !strings.Contains(err.Error(), "'templates[0].name' must match ^[a-zA-Z0-9_-]+$") {
+			t.Fatalf("expected name validation error, got %v", err)
+		}
+	})
+}
+
 func TestPromptTemplatePolicy_OnRequestBody_NoBodyOrEmptyBody(t *testing.T) {
 	p := mustGetPromptTemplatePolicy(t, baseParams())
 
@@ -281,6 +535,116 @@ func TestPromptTemplatePolicy_OnRequestBody_FullPayloadMultipleReplacements(t *t
 	}
 }
 
+func TestPromptTemplatePolicy_OnRequestBody_FullPayloadNestedArrays(t *testing.T) {
+	p := mustGetPromptTemplatePolicy(t, baseParams())
+
+	body := `{
+		"conversations": [
+			{"turns": [
+				{"parts": [{"text": "template://greet?name=Ann", "weight": 12345678901234567890}, "template://greet?name=Bo"]},
+				{"parts": [true, null, 1.50, "plain", "\u00e9"]}
+			]}
+		],
+		"note": "a <b> & c"
+	}`
+	ctx := newRequestContextWithBody(body)
+	mods := mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+
+	// Only the resolved strings change; key order, whitespace, numbers and
+	// escapes elsewhere are kept as sent.
+	want := strings.NewReplacer(
+		`"template://greet?name=Ann"`, `"Hello Ann"`,
+		`"template://greet?name=Bo"`, `"Hello Bo"`,
+	).Replace(body)
+	if got := string(mods.Body); got != want {
+		t.Fatalf("unexpected body:\ngot  %s\nwant %s", got, want)
+	}
+}
+
+func TestPromptTemplatePolicy_OnRequestBody_PreserveOriginalSuffix(t *testing.T) {
+	params := baseParams()
+	params["preserveOriginalSuffix"] = "_raw"
+	p := mustGetPromptTemplatePolicy(t, params)
+
+	ctx := newRequestContextWithBody(`{"prompt":"template://greet?name=Ann","items":["template://greet?name=Bo"],"other":"plain"}`)
+	mods := mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	want := `{"prompt":"Hello Ann","prompt_raw":"template://greet?name=Ann","items":["Hello Bo"],"other":"plain"}`
+	if got := string(mods.Body); got != want {
+		t.Fatalf("unexpected body:\ngot  %s\nwant %s", got, want)
+	}
+
+	ctx = newRequestContextWithBody(`{"prompt":"template://greet?name=Ann","prompt_raw":"kept"}`)
+	assertTemplateError(t, p.OnRequestBody(context.Background(), ctx, nil), ErrorCodePreservedKeyConflict,
+		`Error resolving templates: cannot preserve original value of "prompt": key "prompt_raw" already exists`)
+}
+
+func TestPromptTemplatePolicy_OnRequestBody_FullPayloadNonJSON(t *testing.T) {
+	p := mustGetPromptTemplatePolicy(t, baseParams())
+
+	ctx := newRequestContextWithBody(`say template://greet?name=Ann`)
+	mods := mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	if got := string(mods.Body); got != "say Hello Ann" {
+		t.Fatalf("unexpected body: got %q", got)
+	}
+
+	// Non-JSON payloads are not JSON-escaped.
+	ctx = newRequestContextWithBody(`say template://greet?name=%22Ann%22%0A`)
+	mods = mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	if got := string(mods.Body); got != "say Hello \"Ann\"\n" {
+		t.Fatalf("unexpected body: got %q", got)
+	}
+}
+
+func TestPromptTemplatePolicy_OnRequestBody_FullPayloadEscapesValuesPerDocument(t *testing.T) {
+	templates := []interface{}{
+		map[string]interface{}{"name": "quote", "template": "He said \"[[word]]\"\nnew line [[n]]"},
+	}
+	reference := "template://quote?word=hi&n=1"
+	want := "He said \"hi\"\nnew line 1"
+
+	tests := []struct {
+		name       string
+		bodyFormat string
+		body       string
+	}{
+		{name: "ndjson without bodyFormat", body: "{\"p\":\"" + reference + "\"}\n{\"p\":\"" + reference + "\"}\n"},
+		{name: "ndjson", bodyFormat: "ndjson", body: "{\"p\":\"" + reference + "\"}\n{\"p\":\"" + reference + "\"}\n"},
+		{name: "json-array", bodyFormat: "json-array", body: `[{"p":"` + reference + `"},{"p":"` + reference + `"}]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]interface{}{"templates": templates}
+			if tt.bodyFormat != "" {
+				params["bodyFormat"] = tt.bodyFormat
+			}
+			p := mustGetPromptTemplatePolicy(t, params)
+
+			mods := mustRequestMods(t, p.OnRequestBody(context.Background(), newRequestContextWithBody(tt.body), nil))
+			var documents [][]byte
+			if tt.bodyFormat == "json-array" {
+				var elements []json.RawMessage
+				if err := json.Unmarshal(mods.Body, &elements); err != nil {
+					t.Fatalf("body is not a JSON array: %v (%s)", err, mods.Body)
+				}
+				for _, element := range elements {
+					documents = append(documents, element)
+				}
+			} else {
+				documents = bytes.Split(bytes.TrimSuffix(mods.Body, []byte("\n")), []byte("\n"))
+			}
+			if len(documents) != 2 {
+				t.Fatalf("unexpected document count %d in %q", len(documents), mods.Body)
+			}
+			for _, document := range documents {
+				if got := decodeJSONMap(t, document)["p"]; got != want {
+					t.Fatalf("unexpected value: got %q, want %q", got, want)
+				}
+			}
+		})
+	}
+}
+
 func TestPromptTemplatePolicy_OnRequestBody_URLQueryDecoding(t *testing.T) {
 	params := map[string]interface{}{
 		"templates": []interface{}{
@@ -321,12 +685,112 @@ func TestPromptTemplatePolicy_OnRequestBody_EscapesQuotesAndNewlines(t *testing.
 	}
 }
 
+func TestPromptTemplatePolicy_OnRequestBody_RequiredParams(t *testing.T) {
+	params := map[string]interface{}{
+		"templates": []interface{}{
+			map[string]interface{}{
+				"name":     "greet",
+				"template": "Hello [[name]] from [[city|Paris]] aged [[age]]",
+				"required": []interface{}{"name", "city"},
+			},
+		},
+		"onUnresolvedPlaceholder": "empty",
+	}
+	p := mustGetPromptTemplatePolicy(t, params)
+
+	ctx := newRequestContextWithBody(`{"prompt":"template://greet?name=Ann&city=Rome"}`)
+	mods := mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	body := decodeJSONMap(t, mods.Body)
+	if got, want := body["prompt"], "Hello Ann from Rome aged "; got != want {
+		t.Fatalf("unexpected prompt: got %q, want %q", got, want)
+	}
+
+	ctx = newRequestContextWithBody(`{"prompt":"template://greet?age=30"}`)
+	action := p.OnRequestBody(context.Background(), ctx, nil)
+	assertTemplateError(t, action, ErrorCodeRequiredParamMissing, "Error resolving templates: missing required parameters for template \"greet\": city,name")
+}
+
+func TestPromptTemplatePolicy_OnRequestBody_Metrics(t *testing.T) {
+	params := map[string]interface{}{
+		"templates": []interface{}{
+			map[string]interface{}{"name": "greet", "template": "Hello [[name]]"},
+		},
+		"jsonPath":          []interface{}{"$.a", "$.b", "$.c"},
+		"onMissingTemplate": "passthrough",
+	}
+	body := `{"a":"template://greet?name=Ann","b":"template://greet?name=Ann","c":"template://unknown"}`
+
+	p := mustGetPromptTemplatePolicy(t, params)
+	ctx := newRequestContextWithBody(body)
+	mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	if _, exists := ctx.Metadata[MetadataKeyResolutionDurationMs]; exists {
+		t.Fatalf("expected no metrics metadata when metricsEnabled is false")
+	}
+
+	params["metricsEnabled"] = true
+	p = mustGetPromptTemplatePolicy(t, params)
+	ctx = newRequestContextWithBody(body)
+	mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+
+	if got, ok := ctx.Metadata[MetadataKeyResolvedReferences].(int); !ok || got != 2 {
+		t.Fatalf("unexpected resolved references metadata: %v", ctx.Metadata[MetadataKeyResolvedReferences])
+	}
+	if got, ok := ctx.Metadata[MetadataKeyResolutionDurationMs].(float64); !ok || got < 0 {
+		t.Fatalf("unexpected resolution duration metadata: %v", ctx.Metadata[MetadataKeyResolutionDurationMs])
+	}
+}
+
+func TestPromptTemplatePolicy_OnRequestBody_CollapseWhitespace(t *testing.T) {
+	params := map[string]interface{}{
+		"templates": []interface{}{
+			map[string]interface{}{
+				"name":     "multi",
+				"template": "Line1  [[text]]\n\tLine2 \"quoted\"",
+			},
+		},
+		"collapseWhitespace": true,
+		"onMissingTemplate":  "passthrough",
+	}
+	p := mustGetPromptTemplatePolicy(t, params)
+
+	ctx := newRequestContextWithBody(`{"prompt":"template://multi?text=hello%0Aworld","other":"keep\n  template://unknown"}`)
+	mods := mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	body := decodeJSONMap(t, mods.Body)
+
+	if got, want := body["prompt"], "Line1 hello world Line2 \"quoted\""; got != want {
+		t.Fatalf("unexpected prompt: got %q, want %q", got, want)
+	}
+	if got, want := body["other"], "keep\n  template://unknown"; got != want {
+		t.Fatalf("expected untouched value to keep whitespace: got %q, want %q", got, want)
+	}
+}
+
 func TestPromptTemplatePolicy_OnRequestBody_MissingTemplate_DefaultError(t *testing.T) {
 	p := mustGetPromptTemplatePolicy(t, baseParams())
 
 	ctx := newRequestContextWithBody(`{"prompt":"template://unknown?name=Ann"}`)
 	action := p.OnRequestBody(context.Background(), ctx, nil)
-	assertTemplateError(t, action, "Error resolving templates")
+	assertTemplateError(t, action, ErrorCodeTemplateMissing, "Error resolving templates")
+}
+
+func TestPromptTemplatePolicy_OnRequestBody_CustomErrorStatusCode(t *testing.T) {
+	params := baseParams()
+	params["errorStatusCode"] = float64(400)
+	p := mustGetPromptTemplatePolicy(t, params)
+
+	ctx := newRequestContextWithBody(`{"prompt":"template://unknown?name=Ann"}`)
+	action := p.OnRequestBody(context.Background(), ctx, nil)
+	resp, ok := action.(policy.ImmediateResponse)
+	if !ok {
+		t.Fatalf("expected ImmediateResponse, got %T", action)
+	}
+	if resp.StatusCode != 400 {
+		t.Fatalf("expected status 400, got %d", resp.StatusCode)
+	}
+	body := decodeJSONMap(t, resp.Body)
+	if got := body["type"]; got != "PROMPT_TEMPLATE_ERROR" {
+		t.Fatalf("unexpected error type: got %v", got)
+	}
 }
 
 func TestPromptTemplatePolicy_OnRequestBody_MissingTemplate_Passthrough(t *testing.T) {
@@ -354,6 +818,48 @@ func TestPromptTemplatePolicy_OnRequestBody_MissingTemplate_Passthrough(t *testi
 	}
 }
 
+func TestPromptTemplatePolicy_OnRequestBody_AppliedTemplatesMetadata(t *testing.T) {
+	params := map[string]interface{}{
+		"templates": []interface{}{
+			map[string]interface{}{"name": "greet", "template": "Hello [[name]]"},
+			map[string]interface{}{"name": "bye", "template": "Bye [[name]]"},
+		},
+		"onMissingTemplate": "passthrough",
+	}
+	p := mustGetPromptTemplatePolicy(t, params)
+
+	ctx := newRequestContextWithBody(`{
+		"a":"template://greet?name=Ann",
+		"b":"template://bye?name=Bob",
+		"c":"template://greet?name=Cat",
+		"d":"template://unknown"
+	}`)
+	action := p.OnRequestBody(context.Background(), ctx, nil)
+	mustRequestMods(t, action)
+
+	applied, ok := ctx.Metadata[MetadataKeyAppliedTemplates].([]string)
+	if !ok {
+		t.Fatalf("expected applied templates metadata, got %T", ctx.Metadata[MetadataKeyAppliedTemplates])
+	}
+	if strings.Join(applied, ",") != "bye,greet" {
+		t.Fatalf("unexpected applied templates: %v", applied)
+	}
+
+	ctx = newRequestContextWithBody(`{"prompt":"plain prompt text"}`)
+	p.OnRequestBody(context.Background(), ctx, nil)
+	if _, exists := ctx.Metadata[MetadataKeyAppliedTemplates]; exists {
+		t.Fatalf("expected no applied templates metadata when nothing was resolved")
+	}
+
+	// Without a shared context the body is still resolved.
+	ctx = newRequestContextWithBody(`{"prompt":"template://greet?name=Ann"}`)
+	ctx.SharedContext = nil
+	mods := mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	if got := decodeJSONMap(t, mods.Body)["prompt"]; got != "Hello Ann" {
+		t.Fatalf("unexpected prompt without shared context: %v", got)
+	}
+}
+
 func TestPromptTemplatePolicy_OnRequestBody_UnresolvedPlaceholder_DefaultKeep(t *testing.T) {
 	params := map[string]interface{}{
 		"templates": []interface{}{
@@ -402,7 +908,7 @@ func TestPromptTemplatePolicy_OnRequestBody_UnresolvedPlaceholder_ErrorDetermini
 
 	ctx := newRequestContextWithBody(`{"prompt":"template://greet"}`)
 	action := p.OnRequestBody(context.Background(), ctx, nil)
-	resp := assertTemplateError(t, action, "Error resolving templates")
+	resp := assertTemplateError(t, action, ErrorCodePlaceholderUnresolved, "Error resolving templates")
 
 	var body map[string]interface{}
 	if err := json.Unmarshal(resp.Body, &body); err != nil {
@@ -414,16 +920,364 @@ func TestPromptTemplatePolicy_OnRequestBody_UnresolvedPlaceholder_ErrorDetermini
 	}
 }
 
-func TestPromptTemplatePolicy_OnRequestBody_JSONPath_UpdatesOnlyTarget(t *testing.T) {
-	params := map[string]interface{}{
-		"templates": []interface{}{
-			map[string]interface{}{"name": "greet", "template": "Hello [[name]]"},
-		},
-		"jsonPath": "$.target",
+func TestPromptTemplatePolicy_OnRequestBody_ReferenceWithoutQuery(t *testing.T) {
+	templates := []interface{}{
+		map[string]interface{}{"name": "greet", "template": "Hi [[name]] from [[city]] [[tags[]]] [[mood|fine]]"},
+		map[string]interface{}{"name": "plain", "template": "Hello"},
 	}
-	p := mustGetPromptTemplatePolicy(t, params)
 
-	ctx := newRequestContextWithBody(`{
+	tests := []struct {
+		name         string
+		onUnresolved string
+		want         string
+	}{
+		{name: "keep", onUnresolved: "keep", want: "Hi [[name]] from [[city]] [[tags[]]] fine"},
+		{name: "empty", onUnresolved: "empty", want: "Hi  from   fine"},
+	}
+
+	for _, tt := range tests {
+		for _, reference := range []string{"template://greet", "template://greet?"} {
+			t.Run(tt.name+" "+reference, func(t *testing.T) {
+				p := mustGetPromptTemplatePolicy(t, map[string]interface{}{
+					"templates":               templates,
+					"onUnresolvedPlaceholder": tt.onUnresolved,
+				})
+				ctx := newRequestContextWithBody(`{"prompt":"` + reference + `","other":"template://plain?"}`)
+				body := decodeJSONMap(t, mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil)).Body)
+				if got := body["prompt"]; got != tt.want {
+					t.Fatalf("unexpected prompt: got %q, want %q", got, tt.want)
+				}
+				if got := body["other"]; got != "Hello" {
+					t.Fatalf("unexpected other: got %q, want %q", got, "Hello")
+				}
+			})
+		}
+	}
+
+	for _, reference := range []string{"template://greet", "template://greet?"} {
+		t.Run("error "+reference, func(t *testing.T) {
+			p := mustGetPromptTemplatePolicy(t, map[string]interface{}{
+				"templates":               templates,
+				"onUnresolvedPlaceholder": "error",
+			})
+			ctx := newRequestContextWithBody(`{"prompt":"` + reference + `"}`)
+			assertTemplateError(t, p.OnRequestBody(context.Background(), ctx, nil), ErrorCodePlaceholderUnresolved,
+				`Error resolving templates: unresolved placeholders in template "greet" at "$.prompt": city,name,tags`)
+		})
+	}
+}
+
+func TestPromptTemplatePolicy_OnRequestBody_Schemes(t *testing.T) {
+	templates := []interface{}{
+		map[string]interface{}{"name": "greet", "template": "Hello [[name]]"},
+	}
+
+	tests := []struct {
+		name    string
+		schemes []interface{}
+		body    string
+		want    map[string]interface{}
+	}{
+		{
+			name: "default scheme only",
+			body: `{"a":"template://greet?name=Ann","b":"prompt://greet?name=Bob","c":"mytemplate://greet?name=Cy"}`,
+			want: map[string]interface{}{"a": "Hello Ann", "b": "prompt://greet?name=Bob", "c": "mytemplate://greet?name=Cy"},
+		},
+		{
+			name:    "configured schemes replace the default",
+			schemes: []interface{}{"prompt"},
+			body:    `{"a":"template://greet?name=Ann","b":"prompt://greet?name=Bob"}`,
+			want:    map[string]interface{}{"a": "template://greet?name=Ann", "b": "Hello Bob"},
+		},
+		{
+			name:    "several schemes",
+			schemes: []interface{}{"template", "prompt"},
+			body:    `{"a":"template://greet?name=Ann and prompt://greet?name=Bob"}`,
+			want:    map[string]interface{}{"a": "Hello Ann and Hello Bob"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]interface{}{"templates": templates}
+			if tt.schemes != nil {
+				params["schemes"] = tt.schemes
+			}
+			p := mustGetPromptTemplatePolicy(t, params)
+			ctx := newRequestContextWithBody(tt.body)
+			body := decodeJSONMap(t, mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil)).Body)
+			if !reflect.DeepEqual(body, tt.want) {
+				t.Fatalf("unexpected body: got %v, want %v", body, tt.want)
+			}
+		})
+	}
+
+	p := mustGetPromptTemplatePolicy(t, map[string]interface{}{
+		"templates": templates,
+		"schemes":   []interface{}{"prompt"},
+	})
+	ctx := newRequestContextWithBody(`{"a":"template://greet?name=Ann"}`)
+	if mods := mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil)); mods.Body != nil {
+		t.Fatalf("expected unlisted scheme to be left untouched, got %s", mods.Body)
+	}
+}
+
+func TestPromptTemplatePolicy_OnRequestBody_UnresolvedPlaceholderFieldPath(t *testing.T) {
+	templates := []interface{}{
+		map[string]interface{}{"name": "greet", "template": "Hi [[name]] from [[city]]"},
+	}
+
+	tests := []struct {
+		name   string
+		params map[string]interface{}
+		body   string
+		want   string
+	}{
+		{
+			name:   "configured jsonPath",
+			params: map[string]interface{}{"jsonPath": "$.messages[0].content"},
+			body:   `{"messages":[{"content":"template://greet?name=Ann"}]}`,
+			want:   `unresolved placeholders in template "greet" at "$.messages[0].content": city`,
+		},
+		{
+			name:   "array element at jsonPath",
+			params: map[string]interface{}{"jsonPath": "$.prompts"},
+			body:   `{"prompts":["plain","template://greet"]}`,
+			want:   `unresolved placeholders in template "greet" at "$.prompts[1]": city,name`,
+		},
+		{
+			name:   "discovered key path",
+			params: map[string]interface{}{},
+			body:   `{"messages":[{"content":"hello"},{"content":"template://greet?city=Oslo"}]}`,
+			want:   `unresolved placeholders in template "greet" at "$.messages[1].content": name`,
+		},
+		{
+			name:   "bracketed key in discovered path",
+			params: map[string]interface{}{},
+			body:   `{"input":{"user prompt":"template://greet"}}`,
+			want:   `unresolved placeholders in template "greet" at "$.input[\"user prompt\"]": city,name`,
+		},
+		{
+			name:   "non-JSON payload has no path",
+			params: map[string]interface{}{},
+			body:   `say template://greet`,
+			want:   `unresolved placeholders in template "greet": city,name`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]interface{}{
+				"templates":               templates,
+				"onUnresolvedPlaceholder": "error",
+			}
+			for k, v := range tt.params {
+				params[k] = v
+			}
+			p := mustGetPromptTemplatePolicy(t, params)
+			ctx := newRequestContextWithBody(tt.body)
+			assertTemplateError(t, p.OnRequestBody(context.Background(), ctx, nil), ErrorCodePlaceholderUnresolved,
+				"Error resolving templates: "+tt.want)
+		})
+	}
+}
+
+func TestPromptTemplatePolicy_OnRequestBody_PlaceholderDefaults(t *testing.T) {
+	params := map[string]interface{}{
+		"templates": []interface{}{
+			map[string]interface{}{"name": "greet", "template": `Hi [[name]] from [[city|New York]] ([[note|a\|b]])`},
+		},
+		"onUnresolvedPlaceholder": "error",
+	}
+	p := mustGetPromptTemplatePolicy(t, params)
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "default used when parameter absent",
+			body: `{"prompt":"template://greet?name=Ann"}`,
+			want: "Hi Ann from New York (a|b)",
+		},
+		{
+			name: "query value overrides default",
+			body: `{"prompt":"template://greet?name=Ann&city=San%20Jose&note=x"}`,
+			want: "Hi Ann from San Jose (x)",
+		},
+		{
+			name: "placeholder in query value is not re-expanded",
+			body: `{"prompt":"template://greet?name=%5B%5Bcity%5D%5D&city=Oslo"}`,
+			want: "Hi [[city]] from Oslo (a|b)",
+		},
+		{
+			name: "default placeholder in query value is not re-expanded",
+			body: `{"prompt":"template://greet?name=%5B%5Bnote%7Cz%5D%5D"}`,
+			want: "Hi [[note|z]] from New York (a|b)",
+		},
+		{
+			name: "placeholder in query value is not reported as unresolved",
+			body: `{"prompt":"template://greet?name=%5B%5Bmissing%5D%5D"}`,
+			want: "Hi [[missing]] from New York (a|b)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action := p.OnRequestBody(context.Background(), newRequestContextWithBody(tt.body), nil)
+			mods := mustRequestMods(t, action)
+			body := decodeJSONMap(t, mods.Body)
+			if got := body["prompt"]; got != tt.want {
+				t.Fatalf("unexpected prompt: got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromptTemplatePolicy_OnRequestBody_NestedReferences(t *testing.T) {
+	templates := []interface{}{
+		map[string]interface{}{"name": "outer", "template": "Say: template://greet?name=Ann"},
+		map[string]interface{}{"name": "greet", "template": "Hello [[name]]"},
+		map[string]interface{}{"name": "loop", "template": "again template://loop"},
+	}
+
+	tests := []struct {
+		name      string
+		depth     interface{}
+		body      string
+		want      string
+		wantError bool
+	}{
+		{
+			name: "default depth leaves nested reference untouched",
+			body: `{"prompt":"template://outer"}`,
+			want: "Say: template://greet?name=Ann",
+		},
+		{
+			name:  "nested reference expanded within depth",
+			depth: 2,
+			body:  `{"prompt":"template://outer"}`,
+			want:  "Say: Hello Ann",
+		},
+		{
+			name:      "cyclic reference exceeds depth",
+			depth:     3,
+			body:      `{"prompt":"template://loop"}`,
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]interface{}{
+				"templates": templates,
+				"jsonPath":  "$.prompt",
+			}
+			if tt.depth != nil {
+				params["maxRecursionDepth"] = tt.depth
+			}
+			p := mustGetPromptTemplatePolicy(t, params)
+
+			action := p.OnRequestBody(context.Background(), newRequestContextWithBody(tt.body), nil)
+			if tt.wantError {
+				resp := assertTemplateError(t, action, ErrorCodeRecursionDepthExceeded, "Error resolving templates")
+				if !strings.Contains(string(resp.Body), "exceeds maximum recursion depth 3") {
+					t.Fatalf("expected recursion depth error, got %s", string(resp.Body))
+				}
+				return
+			}
+			mods := mustRequestMods(t, action)
+			body := decodeJSONMap(t, mods.Body)
+			if got := body["prompt"]; got != tt.want {
+				t.Fatalf("unexpected prompt: got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromptTemplatePolicy_OnRequestBody_RejectUnknownParams(t *testing.T) {
+	params := map[string]interface{}{
+		"templates": []interface{}{
+			map[string]interface{}{"name": "greet", "template": "Hello [[name]] from [[city|Paris]]"},
+		},
+		"rejectUnknownParams": true,
+	}
+	p := mustGetPromptTemplatePolicy(t, params)
+
+	ctx := newRequestContextWithBody(`{"prompt":"template://greet?name=Ann&city=Rome"}`)
+	mods := mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	body := decodeJSONMap(t, mods.Body)
+	if got := body["prompt"]; got != "Hello Ann from Rome" {
+		t.Fatalf("unexpected prompt: got %v", got)
+	}
+
+	ctx = newRequestContextWithBody(`{"prompt":"template://greet?name=Ann&zeta=1&typo=x"}`)
+	resp := assertTemplateError(t, p.OnRequestBody(context.Background(), ctx, nil), ErrorCodeUnknownParam, "Error resolving templates")
+	if !strings.Contains(string(resp.Body), `unknown query parameters for template \"greet\": typo,zeta`) {
+		t.Fatalf("expected sorted unknown parameter list, got %s", string(resp.Body))
+	}
+}
+
+func TestPromptTemplatePolicy_OnRequestBody_TemplateSelectorHeader(t *testing.T) {
+	params := map[string]interface{}{
+		"templates": []interface{}{
+			map[string]interface{}{"name": "greet", "template": "Hello [[name]]"},
+			map[string]interface{}{"name": "acme:greet", "template": "Welcome to Acme, [[name]]"},
+		},
+		"templateSelectorHeader": "X-Tenant",
+	}
+	p := mustGetPromptTemplatePolicy(t, params)
+
+	if got := p.Mode().RequestHeaderMode; got != policy.HeaderModeProcess {
+		t.Fatalf("expected request header mode PROCESS, got %s", got)
+	}
+
+	tests := []struct {
+		name    string
+		headers map[string][]string
+		want    string
+	}{
+		{
+			name:    "selector-specific template",
+			headers: map[string][]string{"X-Tenant": {"acme"}},
+			want:    "Welcome to Acme, Ann",
+		},
+		{
+			name:    "falls back to bare name",
+			headers: map[string][]string{"X-Tenant": {"globex"}},
+			want:    "Hello Ann",
+		},
+		{
+			name: "header absent",
+			want: "Hello Ann",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := newRequestContextWithBody(`{"prompt":"template://greet?name=Ann"}`)
+			ctx.Headers = policy.NewHeaders(tt.headers)
+
+			mods := mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+			body := decodeJSONMap(t, mods.Body)
+			if got := body["prompt"]; got != tt.want {
+				t.Fatalf("unexpected prompt: got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromptTemplatePolicy_OnRequestBody_JSONPath_UpdatesOnlyTarget(t *testing.T) {
+	params := map[string]interface{}{
+		"templates": []interface{}{
+			map[string]interface{}{"name": "greet", "template": "Hello [[name]]"},
+		},
+		"jsonPath": "$.target",
+	}
+	p := mustGetPromptTemplatePolicy(t, params)
+
+	ctx := newRequestContextWithBody(`{
 		"target":"template://greet?name=Ann",
 		"other":"template://greet?name=Bob",
 		"nested":{"value":"template://greet?name=Cat"}
@@ -447,6 +1301,162 @@ func TestPromptTemplatePolicy_OnRequestBody_JSONPath_UpdatesOnlyTarget(t *testin
 	}
 }
 
+func TestPromptTemplatePolicy_OnRequestBody_InvocationOverrides(t *testing.T) {
+	params := baseParams()
+	params["jsonPath"] = "$.prompt"
+	p := mustGetPromptTemplatePolicy(t, params)
+	body := `{"prompt":"template://greet?name=ai","other":"template://greet?name=go"}`
+
+	// nil params keep the construction-time configuration.
+	got := decodeJSONMap(t, mustRequestMods(t, p.OnRequestBody(context.Background(), newRequestContextWithBody(body), nil)).Body)
+	if got["prompt"] != "Hello ai" || got["other"] != "template://greet?name=go" {
+		t.Fatalf("unexpected body without overrides: %v", got)
+	}
+
+	// An invocation jsonPath takes precedence over the configured one.
+	overrides := map[string]interface{}{"jsonPath": "$.other", "templates": "ignored"}
+	got = decodeJSONMap(t, mustRequestMods(t, p.OnRequestBody(context.Background(), newRequestContextWithBody(body), overrides)).Body)
+	if got["prompt"] != "template://greet?name=ai" || got["other"] != "Hello go" {
+		t.Fatalf("unexpected body with jsonPath override: %v", got)
+	}
+
+	// An empty jsonPath override resolves every string.
+	got = decodeJSONMap(t, mustRequestMods(t, p.OnRequestBody(context.Background(), newRequestContextWithBody(body), map[string]interface{}{"jsonPath": ""})).Body)
+	if got["prompt"] != "Hello ai" || got["other"] != "Hello go" {
+		t.Fatalf("unexpected body with empty jsonPath override: %v", got)
+	}
+
+	// Overrides apply to a single invocation only.
+	if len(p.params.JsonPaths) != 1 || p.params.JsonPaths[0] != "$.prompt" {
+		t.Fatalf("expected configured jsonPath to be unchanged, got %v", p.params.JsonPaths)
+	}
+
+	// Parameters equal to the configuration, as passed by the kernel on every
+	// invocation, reuse the policy instead of parsing them again.
+	overridden, err := p.withOverrides(params)
+	if err != nil || overridden != p {
+		t.Fatalf("expected configured parameters to reuse the policy, got %p (%v), want %p", overridden, err, p)
+	}
+	configured := baseParams()
+	configured["jsonPath"] = "$.other"
+	got = decodeJSONMap(t, mustRequestMods(t, p.OnRequestBody(context.Background(), newRequestContextWithBody(body), configured)).Body)
+	if got["prompt"] != "template://greet?name=ai" || got["other"] != "Hello go" {
+		t.Fatalf("unexpected body with changed configured jsonPath: %v", got)
+	}
+
+	// Overrides are validated like the construction-time parameters.
+	action := p.OnRequestBody(context.Background(), newRequestContextWithBody(body), map[string]interface{}{"onMissingTemplate": "ignore"})
+	assertTemplateError(t, action, ErrorCodeParamsInvalid, "Invalid parameter overrides: 'onMissingTemplate' must be one of [error,passthrough]")
+}
+
+func TestPromptTemplatePolicy_OnRequestBody_MaxOutputBytes(t *testing.T) {
+	params := map[string]interface{}{
+		"templates": []interface{}{
+			map[string]interface{}{"name": "big", "template": "[[text|" + strings.Repeat("x", 100) + "]]"},
+		},
+		"maxOutputBytes": 64,
+	}
+	p := mustGetPromptTemplatePolicy(t, params)
+
+	// A small resolved body and an unmodified body are within the limit.
+	mustRequestMods(t, p.OnRequestBody(context.Background(), newRequestContextWithBody(`{"prompt":"template://big?text=hi"}`), nil))
+	mustRequestMods(t, p.OnRequestBody(context.Background(), newRequestContextWithBody(`{"prompt":"`+strings.Repeat("y", 100)+`"}`), nil))
+
+	action := p.OnRequestBody(context.Background(), newRequestContextWithBody(`{"prompt":"template://big"}`), nil)
+	assertTemplateError(t, action, ErrorCodeOutputTooLarge, "Resolved payload too large: 113 bytes exceeds 'maxOutputBytes' limit of 64 bytes")
+
+	// The default is unlimited.
+	delete(params, "maxOutputBytes")
+	p = mustGetPromptTemplatePolicy(t, params)
+	mustRequestMods(t, p.OnRequestBody(context.Background(), newRequestContextWithBody(`{"prompt":"template://big"}`), nil))
+}
+
+func TestPromptTemplatePolicy_OnRequestBody_MultipleJSONPaths(t *testing.T) {
+	params := map[string]interface{}{
+		"templates": []interface{}{
+			map[string]interface{}{"name": "greet", "template": "Hello [[name]]"},
+		},
+		"jsonPath": []interface{}{"$.system", "$.messages[-1].content"},
+	}
+	p := mustGetPromptTemplatePolicy(t, params)
+
+	ctx := newRequestContextWithBody(`{
+		"system":"template://greet?name=Ann",
+		"messages":[{"content":"template://greet?name=Bob"}],
+		"other":"template://greet?name=Cat"
+	}`)
+	action := p.OnRequestBody(context.Background(), ctx, nil)
+	mods := mustRequestMods(t, action)
+	body := decodeJSONMap(t, mods.Body)
+
+	if got := body["system"]; got != "Hello Ann" {
+		t.Fatalf("unexpected system value: got %v", got)
+	}
+	messages, ok := body["messages"].([]interface{})
+	if !ok || len(messages) != 1 {
+		t.Fatalf("expected one message, got %v", body["messages"])
+	}
+	if got := messages[0].(map[string]interface{})["content"]; got != "Hello Bob" {
+		t.Fatalf("unexpected message content: got %v", got)
+	}
+	if got := body["other"]; got != "template://greet?name=Cat" {
+		t.Fatalf("expected non-target field to remain unchanged, got %v", got)
+	}
+}
+
+func TestPromptTemplatePolicy_OnRequestBody_MultipleJSONPaths_NonStringTargetReturnsError(t *testing.T) {
+	params := map[string]interface{}{
+		"templates": []interface{}{
+			map[string]interface{}{"name": "greet", "template": "Hello [[name]]"},
+		},
+		"jsonPath": []interface{}{"$.a", "$.b"},
+	}
+	p := mustGetPromptTemplatePolicy(t, params)
+
+	ctx := newRequestContextWithBody(`{"a":"template://greet?name=Ann","b":{"x":1}}`)
+	action := p.OnRequestBody(context.Background(), ctx, nil)
+	assertTemplateError(t, action, ErrorCodeJSONPathInvalid, "Error extracting value from JSONPath")
+}
+
+func TestPromptTemplatePolicy_OnRequestBody_JSONPath_ArrayTarget(t *testing.T) {
+	params := map[string]interface{}{
+		"templates": []interface{}{
+			map[string]interface{}{"name": "greet", "template": "Hello [[name]]"},
+		},
+		"jsonPath": "$.prompts",
+	}
+	p := mustGetPromptTemplatePolicy(t, params)
+
+	ctx := newRequestContextWithBody(`{"prompts":["template://greet?name=Ann","plain","template://greet?name=Bob"]}`)
+	mods := mustRequestMods(t, p.OnRequestBody(context.Background(), ctx, nil))
+	body := decodeJSONMap(t, mods.Body)
+
+	prompts, ok := body["prompts"].([]interface{})
+	if !ok || len(prompts) != 3 {
+		t.Fatalf("expected three prompts, got %v", body["prompts"])
+	}
+	want := []string{"Hello Ann", "plain", "Hello Bob"}
+	for i, w := range want {
+		if prompts[i] != w {
+			t.Fatalf("unexpected prompt at index %d: got %v, want %q", i, prompts[i], w)
+		}
+	}
+}
+
+func TestPromptTemplatePolicy_OnRequestBody_JSONPath_ArrayTargetNonStringElement(t *testing.T) {
+	params := map[string]interface{}{
+		"templates": []interface{}{
+			map[string]interface{}{"name": "greet", "template": "Hello [[name]]"},
+		},
+		"jsonPath": "$.prompts",
+	}
+	p := mustGetPromptTemplatePolicy(t, params)
+
+	ctx := newRequestContextWithBody(`{"prompts":["template://greet?name=Ann",{"x":1}]}`)
+	action := p.OnRequestBody(context.Background(), ctx, nil)
+	assertTemplateError(t, action, ErrorCodeJSONPathInvalid, "Error extracting value from JSONPath: value at JSONPath index 1 is not a string or number")
+}
+
 func TestPromptTemplatePolicy_OnRequestBody_JSONPath_InvalidPathReturnsError(t *testing.T) {
 	params := map[string]interface{}{
 		"templates": []interface{}{
@@ -458,7 +1468,7 @@ func TestPromptTemplatePolicy_OnRequestBody_JSONPath_InvalidPathReturnsError(t *
 
 	ctx := newRequestContextWithBody(`{"target":"template://greet?name=Ann"}`)
 	action := p.OnRequestBody(context.Background(), ctx, nil)
-	assertTemplateError(t, action, "Error extracting value from JSONPath")
+	assertTemplateError(t, action, ErrorCodeJSONPathInvalid, "Error extracting value from JSONPath")
 }
 
 func TestPromptTemplatePolicy_OnRequestBody_JSONPath_NonStringTargetReturnsError(t *testing.T) {
@@ -472,7 +1482,7 @@ func TestPromptTemplatePolicy_OnRequestBody_JSONPath_NonStringTargetReturnsError
 
 	ctx := newRequestContextWithBody(`{"target":{"value":"template://greet?name=Ann"}}`)
 	action := p.OnRequestBody(context.Background(), ctx, nil)
-	assertTemplateError(t, action, "Error extracting value from JSONPath")
+	assertTemplateError(t, action, ErrorCodeJSONPathInvalid, "Error extracting value from JSONPath")
 }
 
 func TestPromptTemplatePolicy_OnRequestBody_JSONPath_InvalidJSONReturnsError(t *testing.T) {
@@ -486,7 +1496,7 @@ func TestPromptTemplatePolicy_OnRequestBody_JSONPath_InvalidJSONReturnsError(t *
 
 	ctx := newRequestContextWithBody(`{"target":"template://greet?name=Ann"`)
 	action := p.OnRequestBody(context.Background(), ctx, nil)
-	assertTemplateError(t, action, "Error parsing JSON payload")
+	assertTemplateError(t, action, ErrorCodePayloadInvalid, "Error parsing JSON payload")
 }
 
 func TestPromptTemplatePolicy_OnRequestBody_StressManyTemplatesAndReferences(t *testing.T) {
@@ -526,10 +1536,121 @@ func TestPromptTemplatePolicy_OnRequestBody_StressManyTemplatesAndReferences(t *
 	}
 }
 
+func TestPromptTemplatePolicy_Mode_ApplyToResponse(t *testing.T) {
+	p := mustGetPromptTemplatePolicy(t, baseParams())
+	if got := p.Mode().ResponseBodyMode; got != policy.BodyModeSkip {
+		t.Fatalf("expected response body mode SKIP by default, got %s", got)
+	}
+
+	params := baseParams()
+	params["applyToResponse"] = true
+	p = mustGetPromptTemplatePolicy(t, params)
+	if got := p.Mode().ResponseBodyMode; got != policy.BodyModeBuffer {
+		t.Fatalf("expected response body mode BUFFER with applyToResponse, got %s", got)
+	}
+}
+
+func TestPromptTemplatePolicy_OnResponseBody(t *testing.T) {
+	newResponseContext := func(body string) *policy.ResponseContext {
+		return &policy.ResponseContext{
+			SharedContext: &policy.SharedContext{
+				RequestID: "test-request-id",
+				Metadata:  map[string]interface{}{},
+			},
+			ResponseBody: &policy.Body{
+				Content: []byte(body),
+				Present: body != "",
+			},
+		}
+	}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		p := mustGetPromptTemplatePolicy(t, baseParams())
+		action := p.OnResponseBody(context.Background(), newResponseContext(`{"answer":"template://greet?name=Ann"}`), nil)
+		mods, ok := action.(policy.DownstreamResponseModifications)
+		if !ok {
+			t.Fatalf("expected DownstreamResponseModifications, got %T", action)
+		}
+		if mods.Body != nil {
+			t.Fatalf("expected no body changes, got %s", string(mods.Body))
+		}
+	})
+
+	t.Run("resolves references when enabled", func(t *testing.T) {
+		params := baseParams()
+		params["applyToResponse"] = true
+		params["jsonPath"] = "$.answer"
+		p := mustGetPromptTemplatePolicy(t, params)
+
+		action := p.OnResponseBody(context.Background(), newResponseContext(`{"answer":"template://greet?name=Ann"}`), nil)
+		mods, ok := action.(policy.DownstreamResponseModifications)
+		if !ok {
+			t.Fatalf("expected DownstreamResponseModifications, got %T", action)
+		}
+		body := decodeJSONMap(t, mods.Body)
+		if got := body["answer"]; got != "Hello Ann" {
+			t.Fatalf("unexpected answer: got %v", got)
+		}
+	})
+
+	t.Run("missing template returns error", func(t *testing.T) {
+		params := baseParams()
+		params["applyToResponse"] = true
+		p := mustGetPromptTemplatePolicy(t, params)
+
+		action := p.OnResponseBody(context.Background(), newResponseContext(`{"answer":"template://unknown"}`), nil)
+		resp, ok := action.(policy.ImmediateResponse)
+		if !ok {
+			t.Fatalf("expected ImmediateResponse, got %T", action)
+		}
+		if resp.StatusCode != 500 {
+			t.Fatalf("expected status 500, got %d", resp.StatusCode)
+		}
+	})
+}
+
+func TestPromptTemplatePolicy_ResolveTemplatesInText_ReusesCachedReferences(t *testing.T) {
+	p := mustGetPromptTemplatePolicy(t, baseParams())
+
+	state := newResolutionState()
+	got, err := p.resolveTemplatesInText("template://greet?name=Ann and template://greet?name=Ann", state)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "Hello Ann and Hello Ann" {
+		t.Fatalf("unexpected resolved text: %q", got)
+	}
+	if len(state.cache) != 1 {
+		t.Fatalf("expected one cached reference, got %d", len(state.cache))
+	}
+
+	// A cached entry is reused instead of resolving the reference again.
+	state.cache["template://greet?name=Ann"] = resolvedReference{value: "cached", replace: true}
+	got, err = p.resolveTemplatesInText("template://greet?name=Ann", state)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "cached" {
+		t.Fatalf("expected cached value to be used, got %q", got)
+	}
+}
+
+func TestPromptTemplatePolicy_ResolveTemplatesInText_ErrorsAreNotCached(t *testing.T) {
+	p := mustGetPromptTemplatePolicy(t, baseParams())
+
+	state := newResolutionState()
+	if _, err := p.resolveTemplatesInText("template://unknown", state); err == nil {
+		t.Fatalf("expected error for missing template")
+	}
+	if len(state.cache) != 0 {
+		t.Fatalf("expected failed references not to be cached, got %d entries", len(state.cache))
+	}
+}
+
 func TestPromptTemplatePolicy_ResolveTemplateReference_MalformedURI(t *testing.T) {
 	p := mustGetPromptTemplatePolicy(t, baseParams())
 
-	_, _, err := p.resolveTemplateReference("template://%zz")
+	_, _, err := p.resolveTemplateReference("template://%zz", newResolutionState())
 	if err == nil {
 		t.Fatalf("expected parse error for malformed URI")
 	}
@@ -605,7 +1726,7 @@ func mustRequestMods(t *testing.T, action policy.RequestAction) policy.UpstreamR
 	return mods
 }
 
-func assertTemplateError(t *testing.T, action policy.RequestAction, wantMessagePrefix string) policy.ImmediateResponse {
+func assertTemplateError(t *testing.T, action policy.RequestAction, wantCode string, wantMessagePrefix string) policy.ImmediateResponse {
 	t.Helper()
 
 	resp, ok := action.(policy.ImmediateResponse)
@@ -623,6 +1744,9 @@ func assertTemplateError(t *testing.T, action policy.RequestAction, wantMessageP
 	if got := body["type"]; got != "PROMPT_TEMPLATE_ERROR" {
 		t.Fatalf("unexpected error type: got %v, want %q", got, "PROMPT_TEMPLATE_ERROR")
 	}
+	if got := body["code"]; got != wantCode {
+		t.Fatalf("unexpected error code: got %v, want %q", got, wantCode)
+	}
 	msg, ok := body["message"].(string)
 	if !ok {
 		t.Fatalf("expected string message, got %T", body["message"])
@@ -679,3 +1803,445 @@ func baseParams() map[string]interface{} {
 		"templates": baseTemplatesArray(),
 	}
 }
+
+func TestPromptTemplatePolicy_Validate_ValidParams(t *testing.T) {
+	if err := (&PromptTemplatePolicy{}).Validate(baseParams()); err != nil {
+		t.Fatalf("expected valid params, got %v", err)
+	}
+}
+
+func TestPromptTemplatePolicy_OnRequestBody_JSONPath_NoReferenceSkipsParsing(t *testing.T) {
+	params := map[string]interface{}{
+		"templates": []interface{}{
+			map[string]interface{}{"name": "greet", "template": "Hello [[name]]"},
+		},
+		"jsonPath": "$.target",
+	}
+	p := mustGetPromptTemplatePolicy(t, params)
+
+	// The body is not valid JSON, but with no reference present it is never parsed.
+	ctx := newRequestContextWithBody(`{"target":"plain text"`)
+	action := p.OnRequestBody(context.Background(), ctx, nil)
+	mods := mustRequestMods(t, action)
+
+	if mods.Body != nil {
+		t.Fatalf("expected no body changes, got %s", string(mods.Body))
+	}
+}
+
+func TestPromptTemplatePolicy_OnRequestBody_BodyFormat(t *testing.T) {
+	tests := []struct {
+		name       string
+		bodyFormat string
+		jsonPath   string
+		body       string
+		want       string
+		wantErr    string
+	}{
+		{
+			name:       "json-array resolves each element",
+			bodyFormat: "json-array",
+			jsonPath:   "$.prompt",
+			body:       `[{"prompt":"template://greet?name=Ann"},{"prompt":"plain"},{"prompt":"template://greet?name=Bo"}]`,
+			want:       `[{"prompt":"Hello Ann"},{"prompt":"plain"},{"prompt":"Hello Bo"}]`,
+		},
+		{
+			name:       "ndjson resolves each line and keeps blank lines",
+			bodyFormat: "ndjson",
+			jsonPath:   "$.prompt",
+			body:       "{\"prompt\":\"template://greet?name=Ann\"}\n\n{\"prompt\":\"template://greet?name=Bo\"}\n",
+			want:       "{\"prompt\":\"Hello Ann\"}\n\n{\"prompt\":\"Hello Bo\"}\n",
+		},
+		{
+			name:       "json-array rejects non-array body",
+			bodyFormat: "json-array",
+			jsonPath:   "$.prompt",
+			body:       `{"prompt":"template://greet?name=Ann"}`,
+			wantErr:    "Error parsing JSON payload: body is not a JSON array",
+		},
+		{
+			name:       "ndjson reports invalid line",
+			bodyFormat: "ndjson",
+			jsonPath:   "$.prompt",
+			body:       "{\"prompt\":\"template://greet?name=Ann\"}\nnot json",
+			wantErr:    "Error parsing JSON payload",
+		},
+		{
+			name:       "json-array without jsonPath resolves every string of each element",
+			bodyFormat: "json-array",
+			body:       `[{"prompt":"template://greet?name=Ann","note":"template://greet?name=Cy"}, "template://greet?name=Bo"]`,
+			want:       `[{"prompt":"Hello Ann","note":"Hello Cy"},"Hello Bo"]`,
+		},
+		{
+			name:       "ndjson without jsonPath resolves every string of each line",
+			bodyFormat: "ndjson",
+			body:       "{\"prompt\":\"template://greet?name=Ann\"}\n\n[\"template://greet?name=Bo\"]\n",
+			want:       "{\"prompt\":\"Hello Ann\"}\n\n[\"Hello Bo\"]\n",
+		},
+		{
+			name:       "json-array without jsonPath rejects non-array body",
+			bodyFormat: "json-array",
+			body:       `{"prompt":"template://greet?name=Ann"}`,
+			wantErr:    "Error parsing JSON payload: body is not a JSON array",
+		},
+		{
+			name:       "ndjson without jsonPath reports invalid line",
+			bodyFormat: "ndjson",
+			body:       "{\"prompt\":\"template://greet?name=Ann\"}\nsay template://greet?name=Bo",
+			wantErr:    "Error parsing JSON payload",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]interface{}{
+				"templates":  baseTemplatesArray(),
+				"bodyFormat": tt.bodyFormat,
+			}
+			if tt.jsonPath != "" {
+				params["jsonPath"] = tt.jsonPath
+			}
+			p := mustGetPromptTemplatePolicy(t, params)
+
+			action := p.OnRequestBody(context.Background(), newRequestContextWithBody(tt.body), nil)
+			if tt.wantErr != "" {
+				assertTemplateError(t, action, ErrorCodePayloadInvalid, tt.wantErr)
+				return
+			}
+			mods := mustRequestMods(t, action)
+			if got := string(mods.Body); got != tt.want {
+				t.Fatalf("unexpected body: got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromptTemplatePolicy_OnRequestBody_JSONPath_ReferenceOutsideTargetIgnored(t *testing.T) {
+	params := map[string]interface{}{
+		"templates": []interface{}{
+			map[string]interface{}{"name": "greet", "template": "Hello [[name]]"},
+		},
+		"jsonPath": "$.target",
+	}
+	p := mustGetPromptTemplatePolicy(t, params)
+
+	ctx := newRequestContextWithBody(`{"target":"plain text","other":"template://greet?name=Ann"}`)
+	action := p.OnRequestBody(context.Background(), ctx, nil)
+	mods := mustRequestMods(t, action)
+
+	if mods.Body != nil {
+		t.Fatalf("expected no body changes, got %s", string(mods.Body))
+	}
+}
+
+func TestPromptTemplatePolicy_OnRequestBody_JSONPath_EscapedReferenceResolved(t *testing.T) {
+	params := map[string]interface{}{
+		"templates": []interface{}{
+			map[string]interface{}{"name": "greet", "template": "Hello [[name]]"},
+		},
+		"jsonPath": "$.target",
+	}
+	p := mustGetPromptTemplatePolicy(t, params)
+
+	// JSON encoders may escape '/' or use \u escapes; the decoded value is still a reference.
+	for _, raw := range []string{
+		`{"target":"template:\/\/greet?name=Ann"}`,
+		`{"target":"template:\u002f/greet?name=Ann"}`,
+	} {
+		ctx := newRequestContextWithBody(raw)
+		action := p.OnRequestBody(context.Background(), ctx, nil)
+		mods := mustRequestMods(t, action)
+
+		body := decodeJSONMap(t, mods.Body)
+		if got := body["target"]; got != "Hello Ann" {
+			t.Fatalf("unexpected target for %s: got %v, want %q", raw, got, "Hello Ann")
+		}
+	}
+}
+
+func BenchmarkPromptTemplatePolicy_OnRequestBody(b *testing.B) {
+	messages := make([]interface{}, 0, 50)
+	for i := 0; i < 50; i++ {
+		messages = append(messages, map[string]interface{}{
+			"role":    "user",
+			"content": fmt.Sprintf("message %d with some ordinary prompt text that carries no references", i),
+		})
+	}
+	plainPayload, err := json.Marshal(map[string]interface{}{"messages": messages})
+	if err != nil {
+		b.Fatalf("failed to marshal payload: %v", err)
+	}
+	referencePayload := append([]byte(nil), plainPayload...)
+	referencePayload = append(referencePayload[:len(referencePayload)-1], []byte(`,"prompt":"template://greet?name=Ann"}`)...)
+
+	for _, jsonPath := range []string{"", "$.messages[-1].content"} {
+		params := map[string]interface{}{
+			"templates": []interface{}{
+				map[string]interface{}{"name": "greet", "template": "Hello [[name]]"},
+			},
+		}
+		name := "WholePayload"
+		if jsonPath != "" {
+			params["jsonPath"] = jsonPath
+			name = "JSONPath"
+		}
+		policyInstance, err := GetPolicy(policy.PolicyMetadata{}, params)
+		if err != nil {
+			b.Fatalf("failed to create policy: %v", err)
+		}
+		p := policyInstance.(*PromptTemplatePolicy)
+
+		for _, payload := range []struct {
+			name    string
+			content []byte
+		}{
+			{name: "NoReferences", content: plainPayload},
+			{name: "WithReference", content: referencePayload},
+		} {
+			b.Run(name+"/"+payload.name, func(b *testing.B) {
+				ctx := &policy.RequestContext{
+					SharedContext: &policy.SharedContext{Metadata: map[string]interface{}{}},
+					Body:          &policy.Body{Content: payload.content, Present: true},
+				}
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					p.OnRequestBody(context.Background(), ctx, params)
+				}
+			})
+		}
+	}
+}
+
+func TestPromptTemplatePolicy_OnRequestBody_ListPlaceholders(t *testing.T) {
+	params := map[string]interface{}{
+		"templates": []interface{}{
+			map[string]interface{}{"name": "list", "template": "Items: [[item[]]]; first: [[item]]"},
+		},
+	}
+	p := mustGetPromptTemplatePolicy(t, params)
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "repeated values joined with default separator",
+			body: `{"prompt":"template://list?item=a&item=b&item=c"}`,
+			want: "Items: a, b, c; first: a",
+		},
+		{
+			name: "single value",
+			body: `{"prompt":"template://list?item=a"}`,
+			want: "Items: a; first: a",
+		},
+		{
+			name: "per-reference separator override",
+			body: `{"prompt":"template://list?item=a&item=b&_separator=%20%7C%20"}`,
+			want: "Items: a | b; first: a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action := p.OnRequestBody(context.Background(), newRequestContextWithBody(tt.body), nil)
+			mods := mustRequestMods(t, action)
+			body := decodeJSONMap(t, mods.Body)
+			if got := body["prompt"]; got != tt.want {
+				t.Fatalf("unexpected prompt: got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromptTemplatePolicy_OnRequestBody_ListPlaceholderUnresolved(t *testing.T) {
+	templates := []interface{}{
+		map[string]interface{}{"name": "list", "template": "Items: [[item[]]]"},
+	}
+
+	p := mustGetPromptTemplatePolicy(t, map[string]interface{}{
+		"templates":               templates,
+		"onUnresolvedPlaceholder": "empty",
+	})
+	action := p.OnRequestBody(context.Background(), newRequestContextWithBody(`{"prompt":"template://list"}`), nil)
+	body := decodeJSONMap(t, mustRequestMods(t, action).Body)
+	if got := body["prompt"]; got != "Items: " {
+		t.Fatalf("unexpected prompt: got %q, want %q", got, "Items: ")
+	}
+
+	p = mustGetPromptTemplatePolicy(t, map[string]interface{}{
+		"templates":               templates,
+		"onUnresolvedPlaceholder": "error",
+	})
+	action = p.OnRequestBody(context.Background(), newRequestContextWithBody(`{"prompt":"template://list"}`), nil)
+	resp := assertTemplateError(t, action, ErrorCodePlaceholderUnresolved, "Error resolving templates")
+	if !strings.Contains(string(resp.Body), "item") {
+		t.Fatalf("expected unresolved list placeholder in message, got %s", string(resp.Body))
+	}
+}
+
+func TestPromptTemplatePolicy_OnRequestBody_ListPlaceholderRejectUnknownParams(t *testing.T) {
+	p := mustGetPromptTemplatePolicy(t, map[string]interface{}{
+		"templates": []interface{}{
+			map[string]interface{}{"name": "list", "template": "Items: [[item[]]]"},
+		},
+		"rejectUnknownParams": true,
+	})
+
+	action := p.OnRequestBody(context.Background(), newRequestContextWithBody(`{"prompt":"template://list?item=a&item=b&_separator=%3B"}`), nil)
+	body := decodeJSONMap(t, mustRequestMods(t, action).Body)
+	if got := body["prompt"]; got != "Items: a;b" {
+		t.Fatalf("unexpected prompt: got %q, want %q", got, "Items: a;b")
+	}
+}
+
+func TestPromptTemplatePolicy_OnRequestBody_IgnorePattern(t *testing.T) {
+	templates := []interface{}{
+		map[string]interface{}{"name": "mask", "template": "Reply to [[who]] at [EMAIL_0000]   [[[tag]]]"},
+		map[string]interface{}{"name": "literal", "template": "Keep [[KEEP_name]] for [[name]]"},
+		map[string]interface{}{"name": "outer", "template": "Wrap template://inner"},
+		map[string]interface{}{"name": "inner", "template": "inner"},
+	}
+
+	tests := []struct {
+		name   string
+		params map[string]interface{}
+		body   string
+		want   string
+	}{
+		{
+			name: "single-bracket tokens are never placeholders",
+			params: map[string]interface{}{
+				"onUnresolvedPlaceholder": "error",
+			},
+			body: `{"prompt":"template://mask?who=Ann&tag=x"}`,
+			want: "Reply to Ann at [EMAIL_0000]   [x]",
+		},
+		{
+			name: "ignored placeholder-like token kept with empty",
+			params: map[string]interface{}{
+				"ignorePattern":           `\[\[KEEP_[a-z]+\]\]`,
+				"onUnresolvedPlaceholder": "empty",
+			},
+			body: `{"prompt":"template://literal"}`,
+			want: "Keep [[KEEP_name]] for ",
+		},
+		{
+			name: "ignored placeholder-like token is not reported as unresolved",
+			params: map[string]interface{}{
+				"ignorePattern":           `\[\[KEEP_[a-z]+\]\]`,
+				"onUnresolvedPlaceholder": "error",
+			},
+			body: `{"prompt":"template://literal?name=Bob"}`,
+			want: "Keep [[KEEP_name]] for Bob",
+		},
+		{
+			name: "ignored token kept through whitespace collapsing",
+			params: map[string]interface{}{
+				"ignorePattern":      `\[EMAIL_[0-9a-f]+\]   `,
+				"collapseWhitespace": true,
+			},
+			body: `{"prompt":"template://mask?who=Ann&tag=x"}`,
+			want: "Reply to Ann at [EMAIL_0000]   [x]",
+		},
+		{
+			name: "ignored reference in payload left untouched",
+			params: map[string]interface{}{
+				"ignorePattern": `template://inner`,
+			},
+			body: `{"prompt":"template://inner"}`,
+			want: "template://inner",
+		},
+		{
+			name: "ignored reference inside resolved template not expanded",
+			params: map[string]interface{}{
+				"ignorePattern":     `template://inner`,
+				"maxRecursionDepth": 2,
+			},
+			body: `{"prompt":"template://outer"}`,
+			want: "Wrap template://inner",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]interface{}{"templates": templates}
+			for key, value := range tt.params {
+				params[key] = value
+			}
+			p := mustGetPromptTemplatePolicy(t, params)
+			action := p.OnRequestBody(context.Background(), newRequestContextWithBody(tt.body), nil)
+			mods := mustRequestMods(t, action)
+			if mods.Body == nil {
+				mods.Body = []byte(tt.body)
+			}
+			body := decodeJSONMap(t, mods.Body)
+			if got := body["prompt"]; got != tt.want {
+				t.Fatalf("unexpected prompt: got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromptTemplatePolicy_OnRequestBody_UnresolvedPlaceholdersAcrossReferences(t *testing.T) {
+	p := mustGetPromptTemplatePolicy(t, map[string]interface{}{
+		"templates": []interface{}{
+			map[string]interface{}{"name": "first", "template": "First [[a]]"},
+			map[string]interface{}{"name": "second", "template": "Second [[b]]"},
+		},
+		"onUnresolvedPlaceholder": "error",
+	})
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "placeholders from every reference reported together",
+			body: `{"messages":[{"content":"template://first"},{"content":"template://second"}]}`,
+			want: `unresolved placeholders in templates "first","second" at "$.messages[0].content","$.messages[1].content": a,b`,
+		},
+		{
+			name: "report does not depend on reference order",
+			body: `{"messages":[{"content":"template://second"},{"content":"template://first"}]}`,
+			want: `unresolved placeholders in templates "first","second" at "$.messages[0].content","$.messages[1].content": a,b`,
+		},
+		{
+			name: "repeated reference reports each field once",
+			body: `{"messages":[{"content":"template://first"},{"content":"template://first"}]}`,
+			want: `unresolved placeholders in template "first" at "$.messages[0].content","$.messages[1].content": a`,
+		},
+		{
+			name: "non-JSON payload lists templates without paths",
+			body: `template://second then template://first`,
+			want: `unresolved placeholders in templates "first","second": a,b`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action := p.OnRequestBody(context.Background(), newRequestContextWithBody(tt.body), nil)
+			assertTemplateError(t, action, ErrorCodePlaceholderUnresolved, "Error resolving templates: "+tt.want)
+		})
+	}
+}
+
+func TestPromptTemplatePolicy_OnRequestBody_EscapesSubstitutedValues(t *testing.T) {
+	p := mustGetPromptTemplatePolicy(t, map[string]interface{}{
+		"templates": []interface{}{
+			map[string]interface{}{"name": "echo", "template": "[[value]]"},
+		},
+	})
+
+	for _, tt := range promptutiltest.EscapingCases {
+		t.Run(tt.Name, func(t *testing.T) {
+			body := `{"prompt":"template://echo?value=` + url.QueryEscape(tt.Value) + `"}`
+			mods := mustRequestMods(t, p.OnRequestBody(context.Background(), newRequestContextWithBody(body), nil))
+			if want := `{"prompt":"` + tt.Want + `"}`; string(mods.Body) != want {
+				t.Fatalf("unexpected body: got %s, want %s", mods.Body, want)
+			}
+		})
+	}
+}
@@ -20,25 +20,64 @@ package removeheaders
 import (
 	"context"
 	"fmt"
+	"regexp"
+	"sort"
 	"strings"
 
 	policy "github.com/wso2/api-platform/sdk/core/policy/v1alpha2"
 )
 
+// hopByHopHeaders is the standard set of hop-by-hop headers (RFC 9110
+// Section 7.6.1, plus the legacy Keep-Alive and Proxy-Connection) removed when
+// removeHopByHop is enabled. Names are lowercase and matched case-insensitively.
+var hopByHopHeaders = []string{
+	"connection",
+	"keep-alive",
+	"proxy-authenticate",
+	"proxy-authorization",
+	"proxy-connection",
+	"te",
+	"trailer",
+	"transfer-encoding",
+	"upgrade",
+}
+
 // RemoveHeadersPolicy implements header removal for both request and response
-type RemoveHeadersPolicy struct{}
+type RemoveHeadersPolicy struct {
+	request  phaseConfig
+	response phaseConfig
+}
+
+// phaseConfig is the parsed header configuration for a single phase.
+type phaseConfig struct {
+	configured     bool
+	keepOnly       bool
+	removeHopByHop bool
+	remove         []headerEntry
+	keep           []headerEntry
+	add            map[string]string
+	renames        []renameEntry
+}
 
-var ins = &RemoveHeadersPolicy{}
+// renameEntry is a single parsed {from, to} header rename.
+type renameEntry struct {
+	from      string
+	to        string
+	overwrite bool
+}
 
 // GetPolicy is the v1alpha2 factory entry point (loaded by v1alpha2 kernels).
+// The header configuration is parsed once here and reused for every request.
 func GetPolicy(
 	metadata policy.PolicyMetadata,
 	params map[string]interface{},
 ) (policy.Policy, error) {
-	return ins, nil
+	p := &RemoveHeadersPolicy{}
+	p.request = p.parsePhaseConfig(params, "request", "requestHeaders")
+	p.response = p.parsePhaseConfig(params, "response", "responseHeaders")
+	return p, nil
 }
 
-
 func (p *RemoveHeadersPolicy) Mode() policy.ProcessingMode {
 	return policy.ProcessingMode{
 		RequestHeaderMode:  policy.HeaderModeProcess,
@@ -51,66 +90,123 @@ func (p *RemoveHeadersPolicy) Mode() policy.ProcessingMode {
 // Validate validates the policy configuration parameters
 func (p *RemoveHeadersPolicy) Validate(params map[string]interface{}) error {
 	// At least one of request.headers or response.headers must be specified.
+	// A phase may use a keep-only allowlist instead of a removal list.
 	// Legacy flat keys are also accepted for runtime compatibility.
-	requestHeadersRaw, hasRequestHeaders, err := p.getPhaseHeaders(params, "request", "requestHeaders")
+	requestSettings, err := p.getPhaseSettings(params, "request", "requestHeaders")
 	if err != nil {
 		return err
 	}
-	responseHeadersRaw, hasResponseHeaders, err := p.getPhaseHeaders(params, "response", "responseHeaders")
+	responseSettings, err := p.getPhaseSettings(params, "response", "responseHeaders")
 	if err != nil {
 		return err
 	}
 
-	if !hasRequestHeaders && !hasResponseHeaders {
+	removeHopByHop := false
+	if removeHopByHopRaw, ok := params["removeHopByHop"]; ok {
+		removeHopByHop, ok = removeHopByHopRaw.(bool)
+		if !ok {
+			return fmt.Errorf("removeHopByHop must be a boolean")
+		}
+	}
+
+	if !requestSettings.configured() && !responseSettings.configured() && !removeHopByHop {
 		return fmt.Errorf("at least one of 'request.headers' or 'response.headers' must be specified")
 	}
 
-	// Validate request headers if present
-	if hasRequestHeaders {
-		if err := p.validateHeaderNames(requestHeadersRaw, "request.headers"); err != nil {
-			return err
-		}
+	// Validate request settings if present
+	if err := p.validatePhaseSettings(requestSettings, "request"); err != nil {
+		return err
 	}
 
-	// Validate response headers if present
-	if hasResponseHeaders {
-		if err := p.validateHeaderNames(responseHeadersRaw, "response.headers"); err != nil {
-			return err
-		}
+	// Validate response settings if present
+	if err := p.validatePhaseSettings(responseSettings, "response"); err != nil {
+		return err
 	}
 
 	return nil
 }
 
-// getPhaseHeaders extracts headers for a phase, supporting both nested
-// (`request.headers`/`response.headers`) and legacy flat keys.
-func (p *RemoveHeadersPolicy) getPhaseHeaders(
+// phaseSettings holds the raw header configuration for a single phase.
+type phaseSettings struct {
+	headersRaw interface{}
+	hasHeaders bool
+	keepRaw    interface{}
+	hasKeep    bool
+	addRaw     interface{}
+	hasAdd     bool
+	renameRaw  interface{}
+	hasRename  bool
+}
+
+func (s phaseSettings) configured() bool {
+	return s.hasHeaders || s.hasKeep || s.hasAdd || s.hasRename
+}
+
+// getPhaseSettings extracts the removal list, keep-only allowlist, headers to
+// add and headers to rename for a phase, supporting both nested
+// (`request.headers`/`request.keep`/`request.add`/`request.rename`) and legacy
+// flat keys.
+func (p *RemoveHeadersPolicy) getPhaseSettings(
 	params map[string]interface{},
 	phaseKey string,
 	legacyKey string,
-) (interface{}, bool, error) {
+) (phaseSettings, error) {
+	var settings phaseSettings
+
 	if phaseRaw, ok := params[phaseKey]; ok {
 		phaseMap, ok := phaseRaw.(map[string]interface{})
 		if !ok {
-			return nil, false, fmt.Errorf("%s must be an object", phaseKey)
+			return settings, fmt.Errorf("%s must be an object", phaseKey)
 		}
-		headersRaw, ok := phaseMap["headers"]
-		if !ok {
-			return nil, false, fmt.Errorf("%s.headers must be specified", phaseKey)
+		settings.headersRaw, settings.hasHeaders = phaseMap["headers"]
+		settings.keepRaw, settings.hasKeep = phaseMap["keep"]
+		settings.addRaw, settings.hasAdd = phaseMap["add"]
+		settings.renameRaw, settings.hasRename = phaseMap["rename"]
+		if settings.hasHeaders && settings.hasKeep {
+			return settings, fmt.Errorf("%s.headers and %s.keep are mutually exclusive", phaseKey, phaseKey)
+		}
+		if !settings.configured() {
+			return settings, fmt.Errorf("%s must specify at least one of 'headers', 'keep', 'add' or 'rename'", phaseKey)
 		}
-		return headersRaw, true, nil
+		return settings, nil
 	}
 
 	if headersRaw, ok := params[legacyKey]; ok {
-		return headersRaw, true, nil
+		settings.headersRaw, settings.hasHeaders = headersRaw, true
 	}
 
-	return nil, false, nil
+	return settings, nil
 }
 
-// validateHeaderNames validates a list of header name objects
-func (p *RemoveHeadersPolicy) validateHeaderNames(headersRaw interface{}, fieldName string) error {
-	headers, ok := headersRaw.([]interface{})
+// validatePhaseSettings validates the removal list or keep-only allowlist and
+// the headers to add configured for a phase.
+func (p *RemoveHeadersPolicy) validatePhaseSettings(settings phaseSettings, phaseKey string) error {
+	if settings.hasHeaders {
+		if err := p.validateHeaderNames(settings.headersRaw, phaseKey+".headers", true); err != nil {
+			return err
+		}
+	}
+	if settings.hasKeep {
+		if err := p.validateHeaderNames(settings.keepRaw, phaseKey+".keep", false); err != nil {
+			return err
+		}
+	}
+	if settings.hasAdd {
+		if err := p.validateAddEntries(settings.addRaw, phaseKey+".add"); err != nil {
+			return err
+		}
+	}
+	if settings.hasRename {
+		if err := p.validateRenameEntries(settings.renameRaw, phaseKey+".rename"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateAddEntries validates a list of header name/value objects to add
+func (p *RemoveHeadersPolicy) validateAddEntries(addRaw interface{}, fieldName string) error {
+	headers, ok := addRaw.([]interface{})
 	if !ok {
 		return fmt.Errorf("%s must be an array", fieldName)
 	}
@@ -122,89 +218,565 @@ func (p *RemoveHeadersPolicy) validateHeaderNames(headersRaw interface{}, fieldN
 	for i, headerRaw := range headers {
 		headerMap, ok := headerRaw.(map[string]interface{})
 		if !ok {
-			return fmt.Errorf("%s[%d] must be an object with 'name' field", fieldName, i)
+			return fmt.Errorf("%s[%d] must be an object with 'name' and 'value' fields", fieldName, i)
 		}
 
-		// Validate name field
+		// Validate name
 		nameRaw, ok := headerMap["name"]
 		if !ok {
 			return fmt.Errorf("%s[%d] missing required 'name' field", fieldName, i)
 		}
+		name, ok := nameRaw.(string)
+		if !ok {
+			return fmt.Errorf("%s[%d].name must be a string", fieldName, i)
+		}
+		if len(strings.TrimSpace(name)) == 0 {
+			return fmt.Errorf("%s[%d].name cannot be empty", fieldName, i)
+		}
+		if strings.Contains(name, "*") {
+			return fmt.Errorf("%s[%d].name cannot contain wildcards", fieldName, i)
+		}
+		if !isValidHeaderToken(strings.TrimSpace(name)) {
+			return fmt.Errorf("%s[%d].name %q is not a valid HTTP header name", fieldName, i, name)
+		}
+
+		// Validate value
+		valueRaw, ok := headerMap["value"]
+		if !ok {
+			return fmt.Errorf("%s[%d] missing required 'value' field", fieldName, i)
+		}
+		value, ok := valueRaw.(string)
+		if !ok {
+			return fmt.Errorf("%s[%d].value must be a string", fieldName, i)
+		}
+		if len(value) == 0 {
+			return fmt.Errorf("%s[%d].value cannot be empty", fieldName, i)
+		}
+	}
+
+	return nil
+}
+
+// validateHeaderNames validates a list of header name objects. Value
+// conditions are only meaningful for removal lists, so keep lists reject them.
+func (p *RemoveHeadersPolicy) validateHeaderNames(headersRaw interface{}, fieldName string, allowConditions bool) error {
+	headers, ok := headersRaw.([]interface{})
+	if !ok {
+		return fmt.Errorf("%s must be an array", fieldName)
+	}
+
+	if len(headers) == 0 {
+		return fmt.Errorf("%s cannot be empty", fieldName)
+	}
+
+	for i, headerRaw := range headers {
+		headerMap, ok := headerRaw.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s[%d] must be an object with 'name' field", fieldName, i)
+		}
+
+		if err := p.validateValueCondition(headerMap, fieldName, i, allowConditions); err != nil {
+			return err
+		}
+
+		nameRaw, hasName := headerMap["name"]
+		regexRaw, hasRegex := headerMap["nameRegex"]
+		if hasName && hasRegex {
+			return fmt.Errorf("%s[%d] 'name' and 'nameRegex' are mutually exclusive", fieldName, i)
+		}
+
+		// Validate nameRegex field
+		if hasRegex {
+			pattern, ok := regexRaw.(string)
+			if !ok {
+				return fmt.Errorf("%s[%d].nameRegex must be a string", fieldName, i)
+			}
+			if len(strings.TrimSpace(pattern)) == 0 {
+				return fmt.Errorf("%s[%d].nameRegex cannot be empty or whitespace-only", fieldName, i)
+			}
+			if _, err := compileNameRegex(pattern); err != nil {
+				return fmt.Errorf("%s[%d].nameRegex is not a valid regular expression: %w", fieldName, i, err)
+			}
+			continue
+		}
+
+		// Validate name field
+		if !hasName {
+			return fmt.Errorf("%s[%d] missing required 'name' field (or 'nameRegex')", fieldName, i)
+		}
 
 		headerName, ok := nameRaw.(string)
 		if !ok {
 			return fmt.Errorf("%s[%d].name must be a string", fieldName, i)
 		}
 
-		if len(strings.TrimSpace(headerName)) == 0 {
+		trimmedName := strings.TrimSpace(headerName)
+		if len(trimmedName) == 0 {
 			return fmt.Errorf("%s[%d].name cannot be empty or whitespace-only", fieldName, i)
 		}
+
+		// A '*' is only allowed as a trailing prefix wildcard, e.g. "x-internal-*"
+		if idx := strings.Index(trimmedName, "*"); idx != -1 {
+			if idx != len(trimmedName)-1 {
+				return fmt.Errorf("%s[%d].name may only contain '*' as a trailing wildcard", fieldName, i)
+			}
+			if idx == 0 {
+				return fmt.Errorf("%s[%d].name wildcard must have a non-empty prefix", fieldName, i)
+			}
+		}
+
+		if !isValidHeaderToken(strings.TrimSuffix(trimmedName, "*")) {
+			return fmt.Errorf("%s[%d].name %q is not a valid HTTP header name", fieldName, i, headerName)
+		}
 	}
 
 	return nil
 }
 
-// parseHeaderNames parses header names from config
-func (p *RemoveHeadersPolicy) parseHeaderNames(headersRaw interface{}) []string {
+// validateValueCondition validates the optional valueEquals/valueMatches
+// condition on a header entry.
+func (p *RemoveHeadersPolicy) validateValueCondition(headerMap map[string]interface{}, fieldName string, index int, allowConditions bool) error {
+	equalsRaw, hasEquals := headerMap["valueEquals"]
+	matchesRaw, hasMatches := headerMap["valueMatches"]
+	if !hasEquals && !hasMatches {
+		return nil
+	}
+	if !allowConditions {
+		return fmt.Errorf("%s[%d] does not support 'valueEquals' or 'valueMatches'", fieldName, index)
+	}
+	if hasEquals && hasMatches {
+		return fmt.Errorf("%s[%d] 'valueEquals' and 'valueMatches' are mutually exclusive", fieldName, index)
+	}
+
+	if hasEquals {
+		if _, ok := equalsRaw.(string); !ok {
+			return fmt.Errorf("%s[%d].valueEquals must be a string", fieldName, index)
+		}
+		return nil
+	}
+
+	pattern, ok := matchesRaw.(string)
+	if !ok {
+		return fmt.Errorf("%s[%d].valueMatches must be a string", fieldName, index)
+	}
+	if len(strings.TrimSpace(pattern)) == 0 {
+		return fmt.Errorf("%s[%d].valueMatches cannot be empty or whitespace-only", fieldName, index)
+	}
+	if _, err := regexp.Compile(pattern); err != nil {
+		return fmt.Errorf("%s[%d].valueMatches is not a valid regular expression: %w", fieldName, index, err)
+	}
+	return nil
+}
+
+// isValidHeaderToken reports whether name is a non-empty RFC 9110 token, the
+// grammar HTTP field names must follow.
+func isValidHeaderToken(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) != -1:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// compileNameRegex compiles a nameRegex pattern for case-insensitive matching.
+func compileNameRegex(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("(?i)" + pattern)
+}
+
+// headerEntry is a single configured header name, prefix wildcard or regex,
+// optionally guarded by a condition on the header's current value.
+type headerEntry struct {
+	name      string // lowercase name, possibly ending in '*'
+	nameRegex *regexp.Regexp
+
+	valueEquals  *string
+	valueMatches *regexp.Regexp
+}
+
+// isExact reports whether the entry names a single header verbatim.
+func (e headerEntry) isExact() bool {
+	return e.nameRegex == nil && !strings.HasSuffix(e.name, "*")
+}
+
+// matches reports whether a lowercase header name satisfies the entry.
+func (e headerEntry) matches(headerName string) bool {
+	if e.nameRegex != nil {
+		return e.nameRegex.MatchString(headerName)
+	}
+	if prefix, isWildcard := strings.CutSuffix(e.name, "*"); isWildcard {
+		return strings.HasPrefix(headerName, prefix)
+	}
+	return e.name == headerName
+}
+
+// hasCondition reports whether the entry carries a value condition.
+func (e headerEntry) hasCondition() bool {
+	return e.valueEquals != nil || e.valueMatches != nil
+}
+
+// valueSatisfied reports whether any of the header's values satisfies the
+// entry's value condition. Entries without a condition always match.
+func (e headerEntry) valueSatisfied(values []string) bool {
+	if !e.hasCondition() {
+		return true
+	}
+	for _, value := range values {
+		if e.valueEquals != nil && value == *e.valueEquals {
+			return true
+		}
+		if e.valueMatches != nil && e.valueMatches.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHeaderEntries parses header entries from config
+func (p *RemoveHeadersPolicy) parseHeaderEntries(headersRaw interface{}) []headerEntry {
 	headers, ok := headersRaw.([]interface{})
 	if !ok {
 		return nil
 	}
 
-	headerNames := make([]string, 0, len(headers))
+	entries := make([]headerEntry, 0, len(headers))
 	for _, headerRaw := range headers {
 		headerMap, ok := headerRaw.(map[string]interface{})
 		if !ok {
 			continue
 		}
 
-		// Extract name from the header object
-		nameRaw, ok := headerMap["name"]
-		if !ok {
+		var entry headerEntry
+		if valueEquals, ok := headerMap["valueEquals"].(string); ok {
+			entry.valueEquals = &valueEquals
+		} else if pattern, ok := headerMap["valueMatches"].(string); ok {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				continue
+			}
+			entry.valueMatches = re
+		}
+
+		if pattern, ok := headerMap["nameRegex"].(string); ok {
+			re, err := compileNameRegex(pattern)
+			if err != nil {
+				continue
+			}
+			entry.nameRegex = re
+			entries = append(entries, entry)
 			continue
 		}
 
-		headerName, ok := nameRaw.(string)
+		// Extract name from the header object
+		headerName, ok := headerMap["name"].(string)
 		if !ok {
 			continue
 		}
 
 		// Normalize to lowercase and trim whitespace
-		normalizedName := strings.ToLower(strings.TrimSpace(headerName))
+		entry.name = strings.ToLower(strings.TrimSpace(headerName))
+		if entry.name != "" {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// expandHeaderNames resolves wildcard and regex entries against the headers
+// present on the message. Unconditional exact names are passed through
+// unchanged so that removing a non-existent header remains a no-op; pattern
+// matches are emitted in sorted order for deterministic output. Entries with a
+// value condition only produce headers whose current value satisfies it.
+func (p *RemoveHeadersPolicy) expandHeaderNames(entries []headerEntry, headers *policy.Headers) []string {
+	expanded := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.isExact() {
+			if entry.valueSatisfied(headers.Get(entry.name)) {
+				expanded = append(expanded, entry.name)
+			}
+			continue
+		}
+
+		var matched []string
+		headers.Iterate(func(headerName string, values []string) {
+			lowerName := strings.ToLower(headerName)
+			if entry.matches(lowerName) && entry.valueSatisfied(values) {
+				matched = append(matched, lowerName)
+			}
+		})
+		sort.Strings(matched)
+		expanded = append(expanded, matched...)
+	}
+
+	return expanded
+}
+
+// headersNotKept returns every header present on the message that does not
+// match an entry in the keep list. Pseudo-headers (":path", ":authority", ...)
+// are never removed.
+func (p *RemoveHeadersPolicy) headersNotKept(keepEntries []headerEntry, headers *policy.Headers) []string {
+	var removed []string
+	headers.Iterate(func(headerName string, _ []string) {
+		lowerName := strings.ToLower(headerName)
+		if strings.HasPrefix(lowerName, ":") {
+			return
+		}
+		for _, entry := range keepEntries {
+			if entry.matches(lowerName) {
+				return
+			}
+		}
+		removed = append(removed, lowerName)
+	})
+	sort.Strings(removed)
+	return removed
+}
+
+// validateRenameEntries validates a list of {from, to} header rename objects
+func (p *RemoveHeadersPolicy) validateRenameEntries(renameRaw interface{}, fieldName string) error {
+	renames, ok := renameRaw.([]interface{})
+	if !ok {
+		return fmt.Errorf("%s must be an array", fieldName)
+	}
+
+	if len(renames) == 0 {
+		return fmt.Errorf("%s cannot be empty", fieldName)
+	}
+
+	for i, renameEntryRaw := range renames {
+		renameMap, ok := renameEntryRaw.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s[%d] must be an object with 'from' and 'to' fields", fieldName, i)
+		}
+
+		names := make(map[string]string, 2)
+		for _, key := range []string{"from", "to"} {
+			nameRaw, ok := renameMap[key]
+			if !ok {
+				return fmt.Errorf("%s[%d] missing required '%s' field", fieldName, i, key)
+			}
+			name, ok := nameRaw.(string)
+			if !ok {
+				return fmt.Errorf("%s[%d].%s must be a string", fieldName, i, key)
+			}
+			if len(strings.TrimSpace(name)) == 0 {
+				return fmt.Errorf("%s[%d].%s cannot be empty", fieldName, i, key)
+			}
+			if strings.Contains(name, "*") {
+				return fmt.Errorf("%s[%d].%s cannot contain wildcards", fieldName, i, key)
+			}
+			if !isValidHeaderToken(strings.TrimSpace(name)) {
+				return fmt.Errorf("%s[%d].%s %q is not a valid HTTP header name", fieldName, i, key, name)
+			}
+			names[key] = strings.ToLower(strings.TrimSpace(name))
+		}
+		if names["from"] == names["to"] {
+			return fmt.Errorf("%s[%d] 'from' and 'to' must be different headers", fieldName, i)
+		}
+
+		if overwriteRaw, ok := renameMap["overwrite"]; ok {
+			if _, ok := overwriteRaw.(bool); !ok {
+				return fmt.Errorf("%s[%d].overwrite must be a boolean", fieldName, i)
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseAddEntries parses the headers to add from config. Names are normalized
+// to lowercase; when a name repeats, the last value wins.
+func (p *RemoveHeadersPolicy) parseAddEntries(addRaw interface{}) map[string]string {
+	headers, ok := addRaw.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	headersToSet := make(map[string]string, len(headers))
+	for _, headerRaw := range headers {
+		headerMap, ok := headerRaw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, ok := headerMap["name"].(string)
+		if !ok {
+			continue
+		}
+		value, ok := headerMap["value"].(string)
+		if !ok {
+			continue
+		}
+		normalizedName := strings.ToLower(strings.TrimSpace(name))
 		if normalizedName != "" {
-			headerNames = append(headerNames, normalizedName)
+			headersToSet[normalizedName] = value
+		}
+	}
+
+	return headersToSet
+}
+
+// parseRenameEntries parses the {from, to} header renames from config
+func (p *RemoveHeadersPolicy) parseRenameEntries(renameRaw interface{}) []renameEntry {
+	renames, ok := renameRaw.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	entries := make([]renameEntry, 0, len(renames))
+	for _, renameEntryRaw := range renames {
+		renameMap, ok := renameEntryRaw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		from, _ := renameMap["from"].(string)
+		to, _ := renameMap["to"].(string)
+		overwrite, _ := renameMap["overwrite"].(bool)
+		from = strings.ToLower(strings.TrimSpace(from))
+		to = strings.ToLower(strings.TrimSpace(to))
+		if from == "" || to == "" {
+			continue
+		}
+		entries = append(entries, renameEntry{from: from, to: to, overwrite: overwrite})
+	}
+
+	return entries
+}
+
+// parsePhaseConfig parses the header configuration for a phase. Malformed
+// phase settings leave the phase unconfigured so it passes headers through.
+// With removeHopByHop enabled, a phase without settings of its own is still
+// configured so that hop-by-hop headers are removed from it.
+func (p *RemoveHeadersPolicy) parsePhaseConfig(params map[string]interface{}, phaseKey string, legacyKey string) phaseConfig {
+	removeHopByHop, _ := params["removeHopByHop"].(bool)
+	settings, err := p.getPhaseSettings(params, phaseKey, legacyKey)
+	if err != nil {
+		return phaseConfig{}
+	}
+	if !settings.configured() {
+		return phaseConfig{configured: removeHopByHop, removeHopByHop: removeHopByHop}
+	}
+
+	return phaseConfig{
+		configured:     true,
+		keepOnly:       settings.hasKeep,
+		removeHopByHop: removeHopByHop,
+		remove:         p.parseHeaderEntries(settings.headersRaw),
+		keep:           p.parseHeaderEntries(settings.keepRaw),
+		add:            p.parseAddEntries(settings.addRaw),
+		renames:        p.parseRenameEntries(settings.renameRaw),
+	}
+}
+
+// mergeHopByHopHeaders appends the hop-by-hop headers present on the message
+// to headersToRemove, skipping names that are already listed.
+func (p *RemoveHeadersPolicy) mergeHopByHopHeaders(headersToRemove []string, headers *policy.Headers) []string {
+	listed := make(map[string]struct{}, len(headersToRemove))
+	for _, name := range headersToRemove {
+		listed[name] = struct{}{}
+	}
+	for _, name := range hopByHopHeaders {
+		if _, ok := listed[name]; ok || !headers.Has(name) {
+			continue
 		}
+		listed[name] = struct{}{}
+		headersToRemove = append(headersToRemove, name)
 	}
+	return headersToRemove
+}
+
+// applyRenames copies the current value of each present `from` header onto its
+// `to` header and marks `from` for removal. Renames of absent headers are
+// no-ops, and an existing `to` header is only replaced when overwrite is set.
+func (p *RemoveHeadersPolicy) applyRenames(renames []renameEntry, headers *policy.Headers, headersToSet map[string]string, headersToRemove []string) []string {
+	for _, rename := range renames {
+		values := headers.Get(rename.from)
+		if len(values) == 0 {
+			continue
+		}
+		if headers.Has(rename.to) && !rename.overwrite {
+			continue
+		}
 
-	return headerNames
+		headersToSet[rename.to] = strings.Join(values, ", ")
+		headersToRemove = append(headersToRemove, rename.from)
+	}
+
+	return headersToRemove
+}
+
+// resolveHeaderChanges produces the concrete headers to remove and to set for
+// a phase. Hop-by-hop headers are merged into the removals when enabled.
+// Renames are applied after removals, and added headers take precedence over
+// both: a header that is set by add or rename is never also removed.
+func (p *RemoveHeadersPolicy) resolveHeaderChanges(config phaseConfig, headers *policy.Headers) ([]string, map[string]string) {
+	var headersToRemove []string
+	if config.keepOnly {
+		headersToRemove = p.headersNotKept(config.keep, headers)
+	} else {
+		headersToRemove = p.expandHeaderNames(config.remove, headers)
+	}
+	if config.removeHopByHop {
+		headersToRemove = p.mergeHopByHopHeaders(headersToRemove, headers)
+	}
+
+	headersToSet := make(map[string]string)
+	headersToRemove = p.applyRenames(config.renames, headers, headersToSet, headersToRemove)
+	for name, value := range config.add {
+		headersToSet[name] = value
+	}
+	if len(headersToSet) == 0 {
+		return headersToRemove, nil
+	}
+
+	filtered := headersToRemove[:0]
+	for _, name := range headersToRemove {
+		if _, added := headersToSet[name]; !added {
+			filtered = append(filtered, name)
+		}
+	}
+	return filtered, headersToSet
 }
 
-// OnRequestHeaders removes headers from the request in the header phase.
+// OnRequestHeaders removes and adds request headers in the header phase.
 func (p *RemoveHeadersPolicy) OnRequestHeaders(ctx context.Context, reqCtx *policy.RequestHeaderContext, params map[string]interface{}) policy.RequestHeaderAction {
-	requestHeadersRaw, ok, err := p.getPhaseHeaders(params, "request", "requestHeaders")
-	if err != nil || !ok {
+	if !p.request.configured {
 		return policy.UpstreamRequestHeaderModifications{}
 	}
-	headerNames := p.parseHeaderNames(requestHeadersRaw)
-	if len(headerNames) == 0 {
+	headersToRemove, headersToSet := p.resolveHeaderChanges(p.request, reqCtx.Headers)
+	if len(headersToRemove) == 0 && len(headersToSet) == 0 {
 		return policy.UpstreamRequestHeaderModifications{}
 	}
-	return policy.UpstreamRequestHeaderModifications{
-		HeadersToRemove: headerNames,
+	mods := policy.UpstreamRequestHeaderModifications{
+		HeadersToSet: headersToSet,
+	}
+	if len(headersToRemove) > 0 {
+		mods.HeadersToRemove = headersToRemove
 	}
+	return mods
 }
 
-// OnResponseHeaders removes headers from the response in the header phase.
+// OnResponseHeaders removes and adds response headers in the header phase.
 func (p *RemoveHeadersPolicy) OnResponseHeaders(ctx context.Context, respCtx *policy.ResponseHeaderContext, params map[string]interface{}) policy.ResponseHeaderAction {
-	responseHeadersRaw, ok, err := p.getPhaseHeaders(params, "response", "responseHeaders")
-	if err != nil || !ok {
+	if !p.response.configured {
 		return policy.DownstreamResponseHeaderModifications{}
 	}
-	headerNames := p.parseHeaderNames(responseHeadersRaw)
-	if len(headerNames) == 0 {
+	headersToRemove, headersToSet := p.resolveHeaderChanges(p.response, respCtx.ResponseHeaders)
+	if len(headersToRemove) == 0 && len(headersToSet) == 0 {
 		return policy.DownstreamResponseHeaderModifications{}
 	}
-	return policy.DownstreamResponseHeaderModifications{
-		HeadersToRemove: headerNames,
+	mods := policy.DownstreamResponseHeaderModifications{
+		HeadersToSet: headersToSet,
+	}
+	if len(headersToRemove) > 0 {
+		mods.HeadersToRemove = headersToRemove
 	}
+	return mods
 }
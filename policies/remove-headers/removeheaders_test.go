@@ -34,6 +34,16 @@ func createTestHeaders(headers map[string]string) *policy.Headers {
 	return policy.NewHeaders(headerMap)
 }
 
+// Helper function to create a policy instance configured with params
+func newTestPolicy(t *testing.T, params map[string]interface{}) *RemoveHeadersPolicy {
+	t.Helper()
+	p, err := GetPolicy(policy.PolicyMetadata{}, params)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return p.(*RemoveHeadersPolicy)
+}
+
 func TestGetPolicy(t *testing.T) {
 	metadata := policy.PolicyMetadata{}
 	params := map[string]interface{}{}
@@ -53,7 +63,6 @@ func TestGetPolicy(t *testing.T) {
 }
 
 func TestRemoveHeadersPolicy_OnRequestHeaders_NoHeaders(t *testing.T) {
-	p := &RemoveHeadersPolicy{}
 	ctx := &policy.RequestHeaderContext{
 		SharedContext: &policy.SharedContext{
 			RequestID: "req-1",
@@ -67,6 +76,7 @@ func TestRemoveHeadersPolicy_OnRequestHeaders_NoHeaders(t *testing.T) {
 
 	// No requestHeaders parameter
 	params := map[string]interface{}{}
+	p := newTestPolicy(t, params)
 	result := p.OnRequestHeaders(context.Background(), ctx, params)
 
 	// Should return empty modifications
@@ -81,7 +91,6 @@ func TestRemoveHeadersPolicy_OnRequestHeaders_NoHeaders(t *testing.T) {
 }
 
 func TestRemoveHeadersPolicy_OnRequestHeaders_SingleHeader(t *testing.T) {
-	p := &RemoveHeadersPolicy{}
 	ctx := &policy.RequestHeaderContext{
 		SharedContext: &policy.SharedContext{
 			RequestID: "req-1",
@@ -102,6 +111,7 @@ func TestRemoveHeadersPolicy_OnRequestHeaders_SingleHeader(t *testing.T) {
 		},
 	}
 
+	p := newTestPolicy(t, params)
 	result := p.OnRequestHeaders(context.Background(), ctx, params)
 
 	mods, ok := result.(policy.UpstreamRequestHeaderModifications)
@@ -121,7 +131,6 @@ func TestRemoveHeadersPolicy_OnRequestHeaders_SingleHeader(t *testing.T) {
 }
 
 func TestRemoveHeadersPolicy_OnRequestHeaders_MultipleHeaders(t *testing.T) {
-	p := &RemoveHeadersPolicy{}
 	ctx := &policy.RequestHeaderContext{
 		SharedContext: &policy.SharedContext{
 			RequestID: "req-1",
@@ -150,6 +159,7 @@ func TestRemoveHeadersPolicy_OnRequestHeaders_MultipleHeaders(t *testing.T) {
 		},
 	}
 
+	p := newTestPolicy(t, params)
 	result := p.OnRequestHeaders(context.Background(), ctx, params)
 
 	mods, ok := result.(policy.UpstreamRequestHeaderModifications)
@@ -180,7 +190,6 @@ func TestRemoveHeadersPolicy_OnRequestHeaders_MultipleHeaders(t *testing.T) {
 }
 
 func TestRemoveHeadersPolicy_OnRequestHeaders_HeaderNameNormalization(t *testing.T) {
-	p := &RemoveHeadersPolicy{}
 	ctx := &policy.RequestHeaderContext{
 		SharedContext: &policy.SharedContext{
 			RequestID: "req-1",
@@ -199,6 +208,7 @@ func TestRemoveHeadersPolicy_OnRequestHeaders_HeaderNameNormalization(t *testing
 		},
 	}
 
+	p := newTestPolicy(t, params)
 	result := p.OnRequestHeaders(context.Background(), ctx, params)
 
 	mods, ok := result.(policy.UpstreamRequestHeaderModifications)
@@ -214,7 +224,6 @@ func TestRemoveHeadersPolicy_OnRequestHeaders_HeaderNameNormalization(t *testing
 }
 
 func TestRemoveHeadersPolicy_OnResponseHeaders_NoHeaders(t *testing.T) {
-	p := &RemoveHeadersPolicy{}
 	ctx := &policy.ResponseHeaderContext{
 		SharedContext: &policy.SharedContext{
 			RequestID: "req-1",
@@ -228,6 +237,7 @@ func TestRemoveHeadersPolicy_OnResponseHeaders_NoHeaders(t *testing.T) {
 
 	// No responseHeaders parameter
 	params := map[string]interface{}{}
+	p := newTestPolicy(t, params)
 	result := p.OnResponseHeaders(context.Background(), ctx, params)
 
 	// Should return empty modifications
@@ -242,7 +252,6 @@ func TestRemoveHeadersPolicy_OnResponseHeaders_NoHeaders(t *testing.T) {
 }
 
 func TestRemoveHeadersPolicy_OnResponseHeaders_SingleHeader(t *testing.T) {
-	p := &RemoveHeadersPolicy{}
 	ctx := &policy.ResponseHeaderContext{
 		SharedContext: &policy.SharedContext{
 			RequestID: "req-1",
@@ -263,6 +272,7 @@ func TestRemoveHeadersPolicy_OnResponseHeaders_SingleHeader(t *testing.T) {
 		},
 	}
 
+	p := newTestPolicy(t, params)
 	result := p.OnResponseHeaders(context.Background(), ctx, params)
 
 	mods, ok := result.(policy.DownstreamResponseHeaderModifications)
@@ -282,7 +292,6 @@ func TestRemoveHeadersPolicy_OnResponseHeaders_SingleHeader(t *testing.T) {
 }
 
 func TestRemoveHeadersPolicy_OnResponseHeaders_MultipleHeaders(t *testing.T) {
-	p := &RemoveHeadersPolicy{}
 	ctx := &policy.ResponseHeaderContext{
 		SharedContext: &policy.SharedContext{
 			RequestID: "req-1",
@@ -311,6 +320,7 @@ func TestRemoveHeadersPolicy_OnResponseHeaders_MultipleHeaders(t *testing.T) {
 		},
 	}
 
+	p := newTestPolicy(t, params)
 	result := p.OnResponseHeaders(context.Background(), ctx, params)
 
 	mods, ok := result.(policy.DownstreamResponseHeaderModifications)
@@ -341,8 +351,6 @@ func TestRemoveHeadersPolicy_OnResponseHeaders_MultipleHeaders(t *testing.T) {
 }
 
 func TestRemoveHeadersPolicy_BothRequestAndResponse(t *testing.T) {
-	p := &RemoveHeadersPolicy{}
-
 	// Test request phase
 	reqCtx := &policy.RequestHeaderContext{
 		SharedContext: &policy.SharedContext{
@@ -367,6 +375,7 @@ func TestRemoveHeadersPolicy_BothRequestAndResponse(t *testing.T) {
 		},
 	}
 
+	p := newTestPolicy(t, params)
 	reqResult := p.OnRequestHeaders(context.Background(), reqCtx, params)
 	reqMods, ok := reqResult.(policy.UpstreamRequestHeaderModifications)
 	if !ok {
@@ -400,7 +409,6 @@ func TestRemoveHeadersPolicy_BothRequestAndResponse(t *testing.T) {
 }
 
 func TestRemoveHeadersPolicy_EmptyHeadersList(t *testing.T) {
-	p := &RemoveHeadersPolicy{}
 	ctx := &policy.RequestHeaderContext{
 		SharedContext: &policy.SharedContext{
 			RequestID: "req-1",
@@ -413,6 +421,7 @@ func TestRemoveHeadersPolicy_EmptyHeadersList(t *testing.T) {
 		"requestHeaders": []interface{}{}, // Empty array
 	}
 
+	p := newTestPolicy(t, params)
 	result := p.OnRequestHeaders(context.Background(), ctx, params)
 
 	mods, ok := result.(policy.UpstreamRequestHeaderModifications)
@@ -426,7 +435,6 @@ func TestRemoveHeadersPolicy_EmptyHeadersList(t *testing.T) {
 }
 
 func TestRemoveHeadersPolicy_InvalidHeadersType(t *testing.T) {
-	p := &RemoveHeadersPolicy{}
 	ctx := &policy.RequestHeaderContext{
 		SharedContext: &policy.SharedContext{
 			RequestID: "req-1",
@@ -439,6 +447,7 @@ func TestRemoveHeadersPolicy_InvalidHeadersType(t *testing.T) {
 		"requestHeaders": "not-an-array", // Invalid type
 	}
 
+	p := newTestPolicy(t, params)
 	result := p.OnRequestHeaders(context.Background(), ctx, params)
 
 	mods, ok := result.(policy.UpstreamRequestHeaderModifications)
@@ -452,7 +461,6 @@ func TestRemoveHeadersPolicy_InvalidHeadersType(t *testing.T) {
 }
 
 func TestRemoveHeadersPolicy_InvalidHeaderEntry(t *testing.T) {
-	p := &RemoveHeadersPolicy{}
 	ctx := &policy.RequestHeaderContext{
 		SharedContext: &policy.SharedContext{
 			RequestID: "req-1",
@@ -470,6 +478,7 @@ func TestRemoveHeadersPolicy_InvalidHeaderEntry(t *testing.T) {
 		},
 	}
 
+	p := newTestPolicy(t, params)
 	result := p.OnRequestHeaders(context.Background(), ctx, params)
 
 	mods, ok := result.(policy.UpstreamRequestHeaderModifications)
@@ -488,7 +497,6 @@ func TestRemoveHeadersPolicy_InvalidHeaderEntry(t *testing.T) {
 }
 
 func TestRemoveHeadersPolicy_DuplicateHeaders(t *testing.T) {
-	p := &RemoveHeadersPolicy{}
 	ctx := &policy.RequestHeaderContext{
 		SharedContext: &policy.SharedContext{
 			RequestID: "req-1",
@@ -511,6 +519,7 @@ func TestRemoveHeadersPolicy_DuplicateHeaders(t *testing.T) {
 		},
 	}
 
+	p := newTestPolicy(t, params)
 	result := p.OnRequestHeaders(context.Background(), ctx, params)
 
 	mods, ok := result.(policy.UpstreamRequestHeaderModifications)
@@ -681,7 +690,6 @@ func TestRemoveHeadersPolicy_Validate_MissingNameField(t *testing.T) {
 }
 
 func TestRemoveHeadersPolicy_OnRequestHeaders_NestedHeaders(t *testing.T) {
-	p := &RemoveHeadersPolicy{}
 	ctx := &policy.RequestHeaderContext{
 		SharedContext: &policy.SharedContext{
 			RequestID: "req-1",
@@ -702,6 +710,7 @@ func TestRemoveHeadersPolicy_OnRequestHeaders_NestedHeaders(t *testing.T) {
 		},
 	}
 
+	p := newTestPolicy(t, params)
 	result := p.OnRequestHeaders(context.Background(), ctx, params)
 	mods, ok := result.(policy.UpstreamRequestHeaderModifications)
 	if !ok {
@@ -714,7 +723,6 @@ func TestRemoveHeadersPolicy_OnRequestHeaders_NestedHeaders(t *testing.T) {
 }
 
 func TestRemoveHeadersPolicy_OnResponseHeaders_NestedHeaders(t *testing.T) {
-	p := &RemoveHeadersPolicy{}
 	ctx := &policy.ResponseHeaderContext{
 		SharedContext: &policy.SharedContext{
 			RequestID: "req-1",
@@ -735,6 +743,7 @@ func TestRemoveHeadersPolicy_OnResponseHeaders_NestedHeaders(t *testing.T) {
 		},
 	}
 
+	p := newTestPolicy(t, params)
 	result := p.OnResponseHeaders(context.Background(), ctx, params)
 	mods, ok := result.(policy.DownstreamResponseHeaderModifications)
 	if !ok {
@@ -771,3 +780,990 @@ func TestRemoveHeadersPolicy_Validate_NestedConfiguration(t *testing.T) {
 		t.Errorf("Expected no error for nested configuration, got: %v", err)
 	}
 }
+
+func TestRemoveHeadersPolicy_OnRequestHeaders_WildcardPrefix(t *testing.T) {
+	ctx := &policy.RequestHeaderContext{
+		SharedContext: &policy.SharedContext{
+			RequestID: "req-1",
+			Metadata:  map[string]interface{}{},
+		},
+		Headers: createTestHeaders(map[string]string{
+			"X-Internal-Trace": "abc",
+			"x-internal-user":  "alice",
+			"x-external-id":    "keep-me",
+			"authorization":    "Bearer token123",
+		}),
+	}
+
+	params := map[string]interface{}{
+		"request": map[string]interface{}{
+			"headers": []interface{}{
+				map[string]interface{}{"name": "Authorization"},
+				map[string]interface{}{"name": "X-Internal-*"},
+			},
+		},
+	}
+
+	p := newTestPolicy(t, params)
+	result := p.OnRequestHeaders(context.Background(), ctx, params)
+	mods, ok := result.(policy.UpstreamRequestHeaderModifications)
+	if !ok {
+		t.Fatalf("Expected UpstreamRequestHeaderModifications, got %T", result)
+	}
+
+	expected := []string{"authorization", "x-internal-trace", "x-internal-user"}
+	if len(mods.HeadersToRemove) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, mods.HeadersToRemove)
+	}
+	for i, name := range expected {
+		if mods.HeadersToRemove[i] != name {
+			t.Errorf("Expected HeadersToRemove[%d] = %q, got %q", i, name, mods.HeadersToRemove[i])
+		}
+	}
+}
+
+func TestRemoveHeadersPolicy_OnResponseHeaders_WildcardPrefix(t *testing.T) {
+	ctx := &policy.ResponseHeaderContext{
+		SharedContext: &policy.SharedContext{
+			RequestID: "req-1",
+			Metadata:  map[string]interface{}{},
+		},
+		ResponseHeaders: createTestHeaders(map[string]string{
+			"x-backend-host":    "node-1",
+			"x-backend-version": "1.2.3",
+			"content-type":      "application/json",
+		}),
+	}
+
+	params := map[string]interface{}{
+		"response": map[string]interface{}{
+			"headers": []interface{}{
+				map[string]interface{}{"name": "x-backend-*"},
+				map[string]interface{}{"name": "x-missing-*"},
+			},
+		},
+	}
+
+	p := newTestPolicy(t, params)
+	result := p.OnResponseHeaders(context.Background(), ctx, params)
+	mods, ok := result.(policy.DownstreamResponseHeaderModifications)
+	if !ok {
+		t.Fatalf("Expected DownstreamResponseHeaderModifications, got %T", result)
+	}
+
+	expected := []string{"x-backend-host", "x-backend-version"}
+	if len(mods.HeadersToRemove) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, mods.HeadersToRemove)
+	}
+	for i, name := range expected {
+		if mods.HeadersToRemove[i] != name {
+			t.Errorf("Expected HeadersToRemove[%d] = %q, got %q", i, name, mods.HeadersToRemove[i])
+		}
+	}
+}
+
+func TestRemoveHeadersPolicy_Validate_InvalidWildcard(t *testing.T) {
+	p := &RemoveHeadersPolicy{}
+
+	tests := []struct {
+		name        string
+		headerName  string
+		expectedErr string
+	}{
+		{name: "bare wildcard", headerName: "*", expectedErr: "wildcard must have a non-empty prefix"},
+		{name: "leading wildcard", headerName: "*-internal", expectedErr: "'*' as a trailing wildcard"},
+		{name: "embedded wildcard", headerName: "x-*-internal", expectedErr: "'*' as a trailing wildcard"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]interface{}{
+				"request": map[string]interface{}{
+					"headers": []interface{}{
+						map[string]interface{}{"name": tt.headerName},
+					},
+				},
+			}
+
+			err := p.Validate(params)
+			if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+				t.Errorf("Expected error containing %q, got: %v", tt.expectedErr, err)
+			}
+		})
+	}
+
+	valid := map[string]interface{}{
+		"request": map[string]interface{}{
+			"headers": []interface{}{
+				map[string]interface{}{"name": "x-internal-*"},
+			},
+		},
+	}
+	if err := p.Validate(valid); err != nil {
+		t.Errorf("Expected trailing wildcard to be valid, got: %v", err)
+	}
+}
+
+func TestRemoveHeadersPolicy_OnRequestHeaders_KeepOnly(t *testing.T) {
+	ctx := &policy.RequestHeaderContext{
+		SharedContext: &policy.SharedContext{
+			RequestID: "req-1",
+			Metadata:  map[string]interface{}{},
+		},
+		Headers: createTestHeaders(map[string]string{
+			":authority":    "example.com",
+			":path":         "/v1/pets",
+			"Content-Type":  "application/json",
+			"authorization": "Bearer token123",
+			"x-trace-id":    "abc",
+			"x-debug":       "true",
+			"cookie":        "session=1",
+		}),
+	}
+
+	params := map[string]interface{}{
+		"request": map[string]interface{}{
+			"keep": []interface{}{
+				map[string]interface{}{"name": "content-type"},
+				map[string]interface{}{"name": "Authorization"},
+				map[string]interface{}{"name": "x-trace-*"},
+			},
+		},
+	}
+
+	p := newTestPolicy(t, params)
+	result := p.OnRequestHeaders(context.Background(), ctx, params)
+	mods, ok := result.(policy.UpstreamRequestHeaderModifications)
+	if !ok {
+		t.Fatalf("Expected UpstreamRequestHeaderModifications, got %T", result)
+	}
+
+	expected := []string{"cookie", "x-debug"}
+	if len(mods.HeadersToRemove) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, mods.HeadersToRemove)
+	}
+	for i, name := range expected {
+		if mods.HeadersToRemove[i] != name {
+			t.Errorf("Expected HeadersToRemove[%d] = %q, got %q", i, name, mods.HeadersToRemove[i])
+		}
+	}
+}
+
+func TestRemoveHeadersPolicy_OnResponseHeaders_KeepOnly(t *testing.T) {
+	ctx := &policy.ResponseHeaderContext{
+		SharedContext: &policy.SharedContext{
+			RequestID: "req-1",
+			Metadata:  map[string]interface{}{},
+		},
+		ResponseHeaders: createTestHeaders(map[string]string{
+			":status":      "200",
+			"content-type": "application/json",
+			"server":       "nginx",
+		}),
+	}
+
+	params := map[string]interface{}{
+		"response": map[string]interface{}{
+			"keep": []interface{}{
+				map[string]interface{}{"name": "content-type"},
+			},
+		},
+	}
+
+	p := newTestPolicy(t, params)
+	result := p.OnResponseHeaders(context.Background(), ctx, params)
+	mods, ok := result.(policy.DownstreamResponseHeaderModifications)
+	if !ok {
+		t.Fatalf("Expected DownstreamResponseHeaderModifications, got %T", result)
+	}
+
+	if len(mods.HeadersToRemove) != 1 || mods.HeadersToRemove[0] != "server" {
+		t.Errorf("Expected only 'server' to be removed, got %v", mods.HeadersToRemove)
+	}
+}
+
+func TestRemoveHeadersPolicy_Validate_KeepConfiguration(t *testing.T) {
+	p := &RemoveHeadersPolicy{}
+
+	valid := map[string]interface{}{
+		"request": map[string]interface{}{
+			"keep": []interface{}{
+				map[string]interface{}{"name": "content-type"},
+			},
+		},
+	}
+	if err := p.Validate(valid); err != nil {
+		t.Errorf("Expected keep-only configuration to be valid, got: %v", err)
+	}
+
+	both := map[string]interface{}{
+		"request": map[string]interface{}{
+			"headers": []interface{}{
+				map[string]interface{}{"name": "x-debug"},
+			},
+			"keep": []interface{}{
+				map[string]interface{}{"name": "content-type"},
+			},
+		},
+	}
+	err := p.Validate(both)
+	if err == nil || !strings.Contains(err.Error(), "request.headers and request.keep are mutually exclusive") {
+		t.Errorf("Expected mutual exclusion error, got: %v", err)
+	}
+
+	empty := map[string]interface{}{
+		"response": map[string]interface{}{
+			"keep": []interface{}{},
+		},
+	}
+	err = p.Validate(empty)
+	if err == nil || !strings.Contains(err.Error(), "response.keep cannot be empty") {
+		t.Errorf("Expected 'response.keep cannot be empty' error, got: %v", err)
+	}
+
+	neither := map[string]interface{}{
+		"request": map[string]interface{}{},
+	}
+	err = p.Validate(neither)
+	if err == nil || !strings.Contains(err.Error(), "request must specify at least one of 'headers', 'keep', 'add' or 'rename'") {
+		t.Errorf("Expected missing settings error, got: %v", err)
+	}
+}
+
+func TestRemoveHeadersPolicy_OnRequestHeaders_NameRegex(t *testing.T) {
+	ctx := &policy.RequestHeaderContext{
+		SharedContext: &policy.SharedContext{
+			RequestID: "req-1",
+			Metadata:  map[string]interface{}{},
+		},
+		Headers: createTestHeaders(map[string]string{
+			"X-Trace-Id":    "abc",
+			"x-span-parent": "def",
+			"x-tracer":      "keep-me",
+			"content-type":  "application/json",
+		}),
+	}
+
+	params := map[string]interface{}{
+		"request": map[string]interface{}{
+			"headers": []interface{}{
+				map[string]interface{}{"nameRegex": "^X-(TRACE|SPAN)-.*"},
+			},
+		},
+	}
+
+	p := newTestPolicy(t, params)
+	result := p.OnRequestHeaders(context.Background(), ctx, params)
+	mods, ok := result.(policy.UpstreamRequestHeaderModifications)
+	if !ok {
+		t.Fatalf("Expected UpstreamRequestHeaderModifications, got %T", result)
+	}
+
+	expected := []string{"x-span-parent", "x-trace-id"}
+	if len(mods.HeadersToRemove) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, mods.HeadersToRemove)
+	}
+	for i, name := range expected {
+		if mods.HeadersToRemove[i] != name {
+			t.Errorf("Expected HeadersToRemove[%d] = %q, got %q", i, name, mods.HeadersToRemove[i])
+		}
+	}
+}
+
+func TestRemoveHeadersPolicy_Validate_NameRegex(t *testing.T) {
+	p := &RemoveHeadersPolicy{}
+
+	tests := []struct {
+		name        string
+		entry       map[string]interface{}
+		expectedErr string
+	}{
+		{
+			name:        "valid regex",
+			entry:       map[string]interface{}{"nameRegex": "^x-(trace|span)-.*"},
+			expectedErr: "",
+		},
+		{
+			name:        "invalid regex",
+			entry:       map[string]interface{}{"nameRegex": "^x-(trace"},
+			expectedErr: "nameRegex is not a valid regular expression",
+		},
+		{
+			name:        "non-string regex",
+			entry:       map[string]interface{}{"nameRegex": 42},
+			expectedErr: "nameRegex must be a string",
+		},
+		{
+			name:        "name and nameRegex together",
+			entry:       map[string]interface{}{"name": "x-trace-id", "nameRegex": "^x-trace-.*"},
+			expectedErr: "'name' and 'nameRegex' are mutually exclusive",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := map[string]interface{}{
+				"response": map[string]interface{}{
+					"headers": []interface{}{tt.entry},
+				},
+			}
+
+			err := p.Validate(params)
+			if tt.expectedErr == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+				t.Errorf("Expected error containing %q, got: %v", tt.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestRemoveHeadersPolicy_OnRequestHeaders_ValueConditions(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		entry    map[string]interface{}
+		expected []string
+	}{
+		{
+			name:     "valueMatches satisfied",
+			headers:  map[string]string{"authorization": "Bearer internal-abc"},
+			entry:    map[string]interface{}{"name": "Authorization", "valueMatches": "^Bearer internal-"},
+			expected: []string{"authorization"},
+		},
+		{
+			name:     "valueMatches not satisfied",
+			headers:  map[string]string{"authorization": "Bearer customer-xyz"},
+			entry:    map[string]interface{}{"name": "Authorization", "valueMatches": "^Bearer internal-"},
+			expected: nil,
+		},
+		{
+			name:     "valueEquals satisfied",
+			headers:  map[string]string{"x-debug": "true"},
+			entry:    map[string]interface{}{"name": "x-debug", "valueEquals": "true"},
+			expected: []string{"x-debug"},
+		},
+		{
+			name:     "valueEquals on missing header",
+			headers:  map[string]string{"content-type": "application/json"},
+			entry:    map[string]interface{}{"name": "x-debug", "valueEquals": "true"},
+			expected: nil,
+		},
+		{
+			name: "condition with wildcard",
+			headers: map[string]string{
+				"x-env-a": "staging",
+				"x-env-b": "production",
+			},
+			entry:    map[string]interface{}{"name": "x-env-*", "valueEquals": "staging"},
+			expected: []string{"x-env-a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := &policy.RequestHeaderContext{
+				SharedContext: &policy.SharedContext{
+					RequestID: "req-1",
+					Metadata:  map[string]interface{}{},
+				},
+				Headers: createTestHeaders(tt.headers),
+			}
+			params := map[string]interface{}{
+				"request": map[string]interface{}{
+					"headers": []interface{}{tt.entry},
+				},
+			}
+
+			p := newTestPolicy(t, params)
+			result := p.OnRequestHeaders(context.Background(), ctx, params)
+			mods, ok := result.(policy.UpstreamRequestHeaderModifications)
+			if !ok {
+				t.Fatalf("Expected UpstreamRequestHeaderModifications, got %T", result)
+			}
+			if len(mods.HeadersToRemove) != len(tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, mods.HeadersToRemove)
+			}
+			for i, name := range tt.expected {
+				if mods.HeadersToRemove[i] != name {
+					t.Errorf("Expected HeadersToRemove[%d] = %q, got %q", i, name, mods.HeadersToRemove[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRemoveHeadersPolicy_Validate_ValueConditions(t *testing.T) {
+	p := &RemoveHeadersPolicy{}
+
+	tests := []struct {
+		name        string
+		phase       map[string]interface{}
+		expectedErr string
+	}{
+		{
+			name: "both conditions",
+			phase: map[string]interface{}{
+				"headers": []interface{}{
+					map[string]interface{}{"name": "authorization", "valueEquals": "a", "valueMatches": "^b"},
+				},
+			},
+			expectedErr: "'valueEquals' and 'valueMatches' are mutually exclusive",
+		},
+		{
+			name: "invalid valueMatches",
+			phase: map[string]interface{}{
+				"headers": []interface{}{
+					map[string]interface{}{"name": "authorization", "valueMatches": "(unclosed"},
+				},
+			},
+			expectedErr: "valueMatches is not a valid regular expression",
+		},
+		{
+			name: "non-string valueEquals",
+			phase: map[string]interface{}{
+				"headers": []interface{}{
+					map[string]interface{}{"name": "authorization", "valueEquals": 1},
+				},
+			},
+			expectedErr: "valueEquals must be a string",
+		},
+		{
+			name: "condition on keep entry",
+			phase: map[string]interface{}{
+				"keep": []interface{}{
+					map[string]interface{}{"name": "authorization", "valueEquals": "a"},
+				},
+			},
+			expectedErr: "does not support 'valueEquals' or 'valueMatches'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Validate(map[string]interface{}{"request": tt.phase})
+			if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+				t.Errorf("Expected error containing %q, got: %v", tt.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestRemoveHeadersPolicy_OnRequestHeaders_AddHeaders(t *testing.T) {
+	ctx := &policy.RequestHeaderContext{
+		SharedContext: &policy.SharedContext{
+			RequestID: "req-1",
+			Metadata:  map[string]interface{}{},
+		},
+		Headers: createTestHeaders(map[string]string{
+			"authorization": "Bearer token123",
+			"x-tenant":      "old",
+		}),
+	}
+
+	params := map[string]interface{}{
+		"request": map[string]interface{}{
+			"headers": []interface{}{
+				map[string]interface{}{"name": "Authorization"},
+				map[string]interface{}{"name": "X-Tenant"},
+			},
+			"add": []interface{}{
+				map[string]interface{}{"name": "X-Tenant", "value": "acme"},
+				map[string]interface{}{"name": "X-Gateway", "value": "wso2"},
+			},
+		},
+	}
+
+	p := newTestPolicy(t, params)
+	result := p.OnRequestHeaders(context.Background(), ctx, params)
+	mods, ok := result.(policy.UpstreamRequestHeaderModifications)
+	if !ok {
+		t.Fatalf("Expected UpstreamRequestHeaderModifications, got %T", result)
+	}
+
+	// Added headers take precedence over removal of the same name
+	if len(mods.HeadersToRemove) != 1 || mods.HeadersToRemove[0] != "authorization" {
+		t.Errorf("Expected only 'authorization' to be removed, got %v", mods.HeadersToRemove)
+	}
+	if len(mods.HeadersToSet) != 2 || mods.HeadersToSet["x-tenant"] != "acme" || mods.HeadersToSet["x-gateway"] != "wso2" {
+		t.Errorf("Expected x-tenant and x-gateway to be set, got %v", mods.HeadersToSet)
+	}
+}
+
+func TestRemoveHeadersPolicy_OnResponseHeaders_AddOnly(t *testing.T) {
+	ctx := &policy.ResponseHeaderContext{
+		SharedContext: &policy.SharedContext{
+			RequestID: "req-1",
+			Metadata:  map[string]interface{}{},
+		},
+		ResponseHeaders: createTestHeaders(map[string]string{
+			"content-type": "application/json",
+		}),
+	}
+
+	params := map[string]interface{}{
+		"response": map[string]interface{}{
+			"add": []interface{}{
+				map[string]interface{}{"name": "X-Served-By", "value": "gateway"},
+			},
+		},
+	}
+
+	p := newTestPolicy(t, params)
+	result := p.OnResponseHeaders(context.Background(), ctx, params)
+	mods, ok := result.(policy.DownstreamResponseHeaderModifications)
+	if !ok {
+		t.Fatalf("Expected DownstreamResponseHeaderModifications, got %T", result)
+	}
+
+	if len(mods.HeadersToRemove) != 0 {
+		t.Errorf("Expected no headers to be removed, got %v", mods.HeadersToRemove)
+	}
+	if mods.HeadersToSet["x-served-by"] != "gateway" {
+		t.Errorf("Expected x-served-by to be set, got %v", mods.HeadersToSet)
+	}
+}
+
+func TestRemoveHeadersPolicy_Validate_AddHeaders(t *testing.T) {
+	p := &RemoveHeadersPolicy{}
+
+	tests := []struct {
+		name        string
+		add         interface{}
+		expectedErr string
+	}{
+		{
+			name:        "valid",
+			add:         []interface{}{map[string]interface{}{"name": "x-gateway", "value": "wso2"}},
+			expectedErr: "",
+		},
+		{
+			name:        "not an array",
+			add:         "x-gateway",
+			expectedErr: "request.add must be an array",
+		},
+		{
+			name:        "missing value",
+			add:         []interface{}{map[string]interface{}{"name": "x-gateway"}},
+			expectedErr: "missing required 'value' field",
+		},
+		{
+			name:        "empty value",
+			add:         []interface{}{map[string]interface{}{"name": "x-gateway", "value": ""}},
+			expectedErr: "request.add[0].value cannot be empty",
+		},
+		{
+			name:        "empty name",
+			add:         []interface{}{map[string]interface{}{"name": " ", "value": "wso2"}},
+			expectedErr: "request.add[0].name cannot be empty",
+		},
+		{
+			name:        "wildcard name",
+			add:         []interface{}{map[string]interface{}{"name": "x-*", "value": "wso2"}},
+			expectedErr: "cannot contain wildcards",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Validate(map[string]interface{}{
+				"request": map[string]interface{}{"add": tt.add},
+			})
+			if tt.expectedErr == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+				t.Errorf("Expected error containing %q, got: %v", tt.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestRemoveHeadersPolicy_OnRequestHeaders_RemoveHopByHop(t *testing.T) {
+	ctx := &policy.RequestHeaderContext{
+		SharedContext: &policy.SharedContext{
+			RequestID: "req-1",
+			Metadata:  map[string]interface{}{},
+		},
+		Headers: createTestHeaders(map[string]string{
+			"Connection":        "keep-alive",
+			"Keep-Alive":        "timeout=5",
+			"Transfer-Encoding": "chunked",
+			"x-internal-id":     "42",
+			"content-type":      "application/json",
+		}),
+	}
+
+	params := map[string]interface{}{
+		"removeHopByHop": true,
+		"request": map[string]interface{}{
+			"headers": []interface{}{
+				map[string]interface{}{"name": "X-Internal-Id"},
+				map[string]interface{}{"name": "CONNECTION"},
+			},
+		},
+	}
+
+	p := newTestPolicy(t, params)
+	result := p.OnRequestHeaders(context.Background(), ctx, params)
+	mods, ok := result.(policy.UpstreamRequestHeaderModifications)
+	if !ok {
+		t.Fatalf("Expected UpstreamRequestHeaderModifications, got %T", result)
+	}
+
+	// Configured headers come first; hop-by-hop headers are merged without
+	// duplicating "connection", and absent ones are not listed.
+	expected := []string{"x-internal-id", "connection", "keep-alive", "transfer-encoding"}
+	if strings.Join(mods.HeadersToRemove, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected headers to remove %v, got %v", expected, mods.HeadersToRemove)
+	}
+}
+
+func TestRemoveHeadersPolicy_OnResponseHeaders_RemoveHopByHopOnly(t *testing.T) {
+	ctx := &policy.ResponseHeaderContext{
+		SharedContext: &policy.SharedContext{
+			RequestID: "req-1",
+			Metadata:  map[string]interface{}{},
+		},
+		ResponseHeaders: createTestHeaders(map[string]string{
+			"Upgrade":      "websocket",
+			"TE":           "trailers",
+			"content-type": "application/json",
+		}),
+	}
+
+	params := map[string]interface{}{"removeHopByHop": true}
+	p := newTestPolicy(t, params)
+	if err := p.Validate(params); err != nil {
+		t.Fatalf("Expected removeHopByHop alone to be valid, got: %v", err)
+	}
+
+	result := p.OnResponseHeaders(context.Background(), ctx, params)
+	mods, ok := result.(policy.DownstreamResponseHeaderModifications)
+	if !ok {
+		t.Fatalf("Expected DownstreamResponseHeaderModifications, got %T", result)
+	}
+	expected := []string{"te", "upgrade"}
+	if strings.Join(mods.HeadersToRemove, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected headers to remove %v, got %v", expected, mods.HeadersToRemove)
+	}
+
+	// Disabled by default: the same headers pass through untouched.
+	p = newTestPolicy(t, map[string]interface{}{
+		"response": map[string]interface{}{
+			"headers": []interface{}{map[string]interface{}{"name": "x-debug"}},
+		},
+	})
+	result = p.OnResponseHeaders(context.Background(), ctx, nil)
+	mods, ok = result.(policy.DownstreamResponseHeaderModifications)
+	if !ok {
+		t.Fatalf("Expected DownstreamResponseHeaderModifications, got %T", result)
+	}
+	if strings.Join(mods.HeadersToRemove, ",") != "x-debug" {
+		t.Errorf("Expected only 'x-debug' to be removed, got %v", mods.HeadersToRemove)
+	}
+}
+
+func TestRemoveHeadersPolicy_Validate_RemoveHopByHop(t *testing.T) {
+	p := &RemoveHeadersPolicy{}
+
+	if err := p.Validate(map[string]interface{}{"removeHopByHop": "yes"}); err == nil || !strings.Contains(err.Error(), "removeHopByHop must be a boolean") {
+		t.Errorf("Expected boolean type error, got: %v", err)
+	}
+	if err := p.Validate(map[string]interface{}{"removeHopByHop": false}); err == nil || !strings.Contains(err.Error(), "at least one of") {
+		t.Errorf("Expected missing configuration error, got: %v", err)
+	}
+}
+
+func TestRemoveHeadersPolicy_OnRequestHeaders_Rename(t *testing.T) {
+	tests := []struct {
+		name           string
+		headers        map[string]string
+		rename         map[string]interface{}
+		expectedRemove []string
+		expectedSet    map[string]string
+	}{
+		{
+			name:           "rename present header",
+			headers:        map[string]string{"X-Old-Auth": "secret"},
+			rename:         map[string]interface{}{"from": "X-Old-Auth", "to": "X-New-Auth"},
+			expectedRemove: []string{"x-old-auth"},
+			expectedSet:    map[string]string{"x-new-auth": "secret"},
+		},
+		{
+			name:    "from absent is a no-op",
+			headers: map[string]string{"content-type": "application/json"},
+			rename:  map[string]interface{}{"from": "X-Old-Auth", "to": "X-New-Auth"},
+		},
+		{
+			name:    "to exists without overwrite",
+			headers: map[string]string{"x-old-auth": "old", "x-new-auth": "new"},
+			rename:  map[string]interface{}{"from": "X-Old-Auth", "to": "X-New-Auth"},
+		},
+		{
+			name:           "to exists with overwrite",
+			headers:        map[string]string{"x-old-auth": "old", "x-new-auth": "new"},
+			rename:         map[string]interface{}{"from": "X-Old-Auth", "to": "X-New-Auth", "overwrite": true},
+			expectedRemove: []string{"x-old-auth"},
+			expectedSet:    map[string]string{"x-new-auth": "old"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := &policy.RequestHeaderContext{
+				SharedContext: &policy.SharedContext{
+					RequestID: "req-1",
+					Metadata:  map[string]interface{}{},
+				},
+				Headers: createTestHeaders(tt.headers),
+			}
+			params := map[string]interface{}{
+				"request": map[string]interface{}{
+					"rename": []interface{}{tt.rename},
+				},
+			}
+
+			p := newTestPolicy(t, params)
+			result := p.OnRequestHeaders(context.Background(), ctx, params)
+			mods, ok := result.(policy.UpstreamRequestHeaderModifications)
+			if !ok {
+				t.Fatalf("Expected UpstreamRequestHeaderModifications, got %T", result)
+			}
+			if len(mods.HeadersToRemove) != len(tt.expectedRemove) {
+				t.Fatalf("Expected headers to remove %v, got %v", tt.expectedRemove, mods.HeadersToRemove)
+			}
+			for i, name := range tt.expectedRemove {
+				if mods.HeadersToRemove[i] != name {
+					t.Errorf("Expected HeadersToRemove[%d] = %q, got %q", i, name, mods.HeadersToRemove[i])
+				}
+			}
+			if len(mods.HeadersToSet) != len(tt.expectedSet) {
+				t.Fatalf("Expected headers to set %v, got %v", tt.expectedSet, mods.HeadersToSet)
+			}
+			for name, value := range tt.expectedSet {
+				if mods.HeadersToSet[name] != value {
+					t.Errorf("Expected header %q = %q, got %q", name, value, mods.HeadersToSet[name])
+				}
+			}
+		})
+	}
+}
+
+func TestRemoveHeadersPolicy_OnResponseHeaders_Rename(t *testing.T) {
+	ctx := &policy.ResponseHeaderContext{
+		SharedContext: &policy.SharedContext{
+			RequestID: "req-1",
+			Metadata:  map[string]interface{}{},
+		},
+		ResponseHeaders: createTestHeaders(map[string]string{
+			"x-upstream-version": "2.1.0",
+			"x-api-version":      "1.0.0",
+		}),
+	}
+
+	params := map[string]interface{}{
+		"response": map[string]interface{}{
+			"rename": []interface{}{
+				map[string]interface{}{"from": "x-upstream-version", "to": "x-api-version", "overwrite": true},
+			},
+		},
+	}
+
+	p := newTestPolicy(t, params)
+	result := p.OnResponseHeaders(context.Background(), ctx, params)
+	mods, ok := result.(policy.DownstreamResponseHeaderModifications)
+	if !ok {
+		t.Fatalf("Expected DownstreamResponseHeaderModifications, got %T", result)
+	}
+
+	if len(mods.HeadersToRemove) != 1 || mods.HeadersToRemove[0] != "x-upstream-version" {
+		t.Errorf("Expected 'x-upstream-version' to be removed, got %v", mods.HeadersToRemove)
+	}
+	if mods.HeadersToSet["x-api-version"] != "2.1.0" {
+		t.Errorf("Expected x-api-version to be set to '2.1.0', got %v", mods.HeadersToSet)
+	}
+}
+
+func TestRemoveHeadersPolicy_Validate_Rename(t *testing.T) {
+	p := &RemoveHeadersPolicy{}
+
+	tests := []struct {
+		name        string
+		rename      interface{}
+		expectedErr string
+	}{
+		{
+			name:        "valid",
+			rename:      []interface{}{map[string]interface{}{"from": "x-old", "to": "x-new", "overwrite": true}},
+			expectedErr: "",
+		},
+		{
+			name:        "missing to",
+			rename:      []interface{}{map[string]interface{}{"from": "x-old"}},
+			expectedErr: "response.rename[0] missing required 'to' field",
+		},
+		{
+			name:        "same header",
+			rename:      []interface{}{map[string]interface{}{"from": "X-Old", "to": "x-old"}},
+			expectedErr: "'from' and 'to' must be different headers",
+		},
+		{
+			name:        "non-boolean overwrite",
+			rename:      []interface{}{map[string]interface{}{"from": "x-old", "to": "x-new", "overwrite": "yes"}},
+			expectedErr: "response.rename[0].overwrite must be a boolean",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Validate(map[string]interface{}{
+				"response": map[string]interface{}{"rename": tt.rename},
+			})
+			if tt.expectedErr == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+				t.Errorf("Expected error containing %q, got: %v", tt.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestGetPolicy_InstancesAreIndependent(t *testing.T) {
+	first := newTestPolicy(t, map[string]interface{}{
+		"request": map[string]interface{}{
+			"headers": []interface{}{
+				map[string]interface{}{"name": "x-first"},
+			},
+		},
+	})
+	second := newTestPolicy(t, map[string]interface{}{
+		"request": map[string]interface{}{
+			"headers": []interface{}{
+				map[string]interface{}{"name": "x-second"},
+			},
+		},
+	})
+
+	if first == second {
+		t.Fatal("Expected GetPolicy to return distinct instances")
+	}
+
+	ctx := &policy.RequestHeaderContext{
+		SharedContext: &policy.SharedContext{
+			RequestID: "req-1",
+			Metadata:  map[string]interface{}{},
+		},
+		Headers: createTestHeaders(map[string]string{}),
+	}
+
+	// Configuration is parsed at construction; per-call params are not consulted.
+	firstMods := first.OnRequestHeaders(context.Background(), ctx, nil).(policy.UpstreamRequestHeaderModifications)
+	secondMods := second.OnRequestHeaders(context.Background(), ctx, nil).(policy.UpstreamRequestHeaderModifications)
+
+	if len(firstMods.HeadersToRemove) != 1 || firstMods.HeadersToRemove[0] != "x-first" {
+		t.Errorf("Expected first instance to remove 'x-first', got %v", firstMods.HeadersToRemove)
+	}
+	if len(secondMods.HeadersToRemove) != 1 || secondMods.HeadersToRemove[0] != "x-second" {
+		t.Errorf("Expected second instance to remove 'x-second', got %v", secondMods.HeadersToRemove)
+	}
+}
+
+func TestRemoveHeadersPolicy_Validate_InvalidHeaderToken(t *testing.T) {
+	p := &RemoveHeadersPolicy{}
+
+	tests := []struct {
+		name        string
+		params      map[string]interface{}
+		expectedErr string
+	}{
+		{
+			name: "request name with space",
+			params: map[string]interface{}{
+				"request": map[string]interface{}{
+					"headers": []interface{}{
+						map[string]interface{}{"name": "Authorization"},
+						map[string]interface{}{"name": "Content Type"},
+					},
+				},
+			},
+			expectedErr: `request.headers[1].name "Content Type" is not a valid HTTP header name`,
+		},
+		{
+			name: "response name with colon",
+			params: map[string]interface{}{
+				"response": map[string]interface{}{
+					"headers": []interface{}{
+						map[string]interface{}{"name": "x-a:b"},
+					},
+				},
+			},
+			expectedErr: `response.headers[0].name "x-a:b" is not a valid HTTP header name`,
+		},
+		{
+			name: "response keep with control character",
+			params: map[string]interface{}{
+				"response": map[string]interface{}{
+					"keep": []interface{}{
+						map[string]interface{}{"name": "x-bad\x01*"},
+					},
+				},
+			},
+			expectedErr: `response.keep[0].name "x-bad\x01*" is not a valid HTTP header name`,
+		},
+		{
+			name: "request add name with space",
+			params: map[string]interface{}{
+				"request": map[string]interface{}{
+					"add": []interface{}{
+						map[string]interface{}{"name": "x bad", "value": "v"},
+					},
+				},
+			},
+			expectedErr: `request.add[0].name "x bad" is not a valid HTTP header name`,
+		},
+		{
+			name: "response rename target with space",
+			params: map[string]interface{}{
+				"response": map[string]interface{}{
+					"rename": []interface{}{
+						map[string]interface{}{"from": "x-old", "to": "x new"},
+					},
+				},
+			},
+			expectedErr: `response.rename[0].to "x new" is not a valid HTTP header name`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Validate(tt.params)
+			if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+				t.Errorf("Expected error containing %q, got: %v", tt.expectedErr, err)
+			}
+		})
+	}
+
+	valid := map[string]interface{}{
+		"request": map[string]interface{}{
+			"headers": []interface{}{
+				map[string]interface{}{"name": " X-Request.ID "},
+				map[string]interface{}{"name": "x_custom~header"},
+			},
+		},
+	}
+	if err := p.Validate(valid); err != nil {
+		t.Errorf("Expected token header names to be valid, got: %v", err)
+	}
+}